@@ -0,0 +1,287 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// accessTokenPrefix marks personal access tokens so the middleware can tell
+// them apart from session tokens without a database round trip.
+const accessTokenPrefix = "tsm_pat_"
+
+const accessTokenTouchInterval = time.Minute
+
+// Scopes grantable to personal access tokens. scopeSessionOnly is used by
+// routes that must never be reachable with an access token.
+const (
+	scopeSessionOnly  = ""
+	scopeSeriesRead   = "series:read"
+	scopeSeriesWrite  = "series:write"
+	scopeWatchedRead  = "watched:read"
+	scopeWatchedWrite = "watched:write"
+	scopeRatingsRead  = "ratings:read"
+	scopeRatingsWrite = "ratings:write"
+	scopeListsRead    = "lists:read"
+	scopeListsWrite   = "lists:write"
+	scopeProfileWrite = "profile:write"
+)
+
+var accessTokenScopes = []string{scopeSeriesRead, scopeSeriesWrite, scopeWatchedRead, scopeWatchedWrite, scopeRatingsRead, scopeRatingsWrite, scopeListsRead, scopeListsWrite, scopeProfileWrite}
+
+type CreateAccessTokenInput struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+type AccessToken struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"createdAt"`
+	LastUsedAt string   `json:"lastUsedAt,omitempty"`
+	ExpiresAt  string   `json:"expiresAt,omitempty"`
+}
+
+type CreateAccessTokenResponse struct {
+	AccessToken
+	Token string `json:"token"`
+}
+
+func ensureAccessTokensTable(tx *sql.Tx) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS personal_access_tokens (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        prefix TEXT NOT NULL,
+        token_hash TEXT NOT NULL UNIQUE,
+        scopes TEXT NOT NULL,
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        last_used_at DATETIME,
+        expires_at DATETIME
+    );
+    `
+
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed creating personal_access_tokens table: %w", err)
+	}
+
+	return nil
+}
+
+func normalizeScopes(raw []string) ([]string, error) {
+	scopes := make([]string, 0, len(raw))
+	for _, scope := range raw {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !slices.Contains(accessTokenScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	slices.Sort(scopes)
+	return scopes, nil
+}
+
+func (a *App) lookupAccessToken(r *http.Request, token string) (authInfo, bool, error) {
+	var (
+		info   authInfo
+		scopes string
+	)
+
+	err := a.db.QueryRow(
+		`SELECT id, user_id, scopes FROM personal_access_tokens
+         WHERE token_hash = ? AND (expires_at IS NULL OR expires_at > ?) LIMIT 1`,
+		hashToken(token),
+		time.Now().UTC().Format(dbTimeLayout),
+	).Scan(&info.AccessTokenID, &info.UserID, &scopes)
+	if err == sql.ErrNoRows {
+		return info, false, nil
+	}
+	if err != nil {
+		return info, false, err
+	}
+
+	user, err := a.repos.Users.ByID(info.UserID)
+	if errors.Is(err, errNotFound) {
+		return info, false, nil
+	}
+	if err != nil {
+		return info, false, err
+	}
+	info.EmailVerified = user.EmailVerified
+	info.Scopes = strings.Fields(scopes)
+
+	now := time.Now().UTC()
+	if _, err := a.db.Exec(
+		`UPDATE personal_access_tokens SET last_used_at = ?
+         WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`,
+		now.Format(dbTimeLayout),
+		info.AccessTokenID,
+		now.Add(-accessTokenTouchInterval).Format(dbTimeLayout),
+	); err != nil {
+		log.Printf("failed touching access token %d: %v", info.AccessTokenID, err)
+	}
+
+	return info, true, nil
+}
+
+func (a *App) handleCreateAccessToken(w http.ResponseWriter, r *http.Request) {
+	var in CreateAccessTokenInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if len(in.Name) > 80 {
+		writeError(w, http.StatusBadRequest, "name is too long")
+		return
+	}
+	scopes, err := normalizeScopes(in.Scopes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if in.ExpiresInDays < 0 || in.ExpiresInDays > 3650 {
+		writeError(w, http.StatusBadRequest, "expiresInDays must be between 0 and 3650")
+		return
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create token")
+		return
+	}
+	token := accessTokenPrefix + secret
+
+	now := time.Now().UTC()
+	out := CreateAccessTokenResponse{
+		AccessToken: AccessToken{
+			Name:      in.Name,
+			Prefix:    token[:len(accessTokenPrefix)+6],
+			Scopes:    scopes,
+			CreatedAt: now.Format(time.RFC3339),
+		},
+		Token: token,
+	}
+
+	var expiresAt any
+	if in.ExpiresInDays > 0 {
+		expiry := now.AddDate(0, 0, in.ExpiresInDays)
+		expiresAt = expiry.Format(dbTimeLayout)
+		out.ExpiresAt = expiry.Format(time.RFC3339)
+	}
+
+	result, err := a.db.Exec(
+		`INSERT INTO personal_access_tokens (user_id, name, prefix, token_hash, scopes, created_at, expires_at)
+         VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		authUserID(r),
+		in.Name,
+		out.Prefix,
+		hashToken(token),
+		strings.Join(scopes, " "),
+		now.Format(dbTimeLayout),
+		expiresAt,
+	)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create token")
+		return
+	}
+	out.ID, _ = result.LastInsertId()
+
+	writeJSON(w, http.StatusCreated, out)
+}
+
+func (a *App) handleListAccessTokens(w http.ResponseWriter, r *http.Request) {
+	rows, err := a.db.Query(
+		`SELECT id, name, prefix, scopes, created_at, last_used_at, expires_at
+         FROM personal_access_tokens
+         WHERE user_id = ?
+         ORDER BY created_at DESC, id DESC`,
+		authUserID(r),
+	)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list tokens")
+		return
+	}
+	defer rows.Close()
+
+	out := make([]AccessToken, 0)
+	for rows.Next() {
+		var (
+			item       AccessToken
+			scopes     string
+			createdAt  time.Time
+			lastUsedAt sql.NullTime
+			expiresAt  sql.NullTime
+		)
+		if scanErr := rows.Scan(
+			&item.ID,
+			&item.Name,
+			&item.Prefix,
+			&scopes,
+			&createdAt,
+			&lastUsedAt,
+			&expiresAt,
+		); scanErr != nil {
+			writeError(w, http.StatusInternalServerError, "failed reading tokens")
+			return
+		}
+		item.Scopes = strings.Fields(scopes)
+		item.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		if lastUsedAt.Valid {
+			item.LastUsedAt = lastUsedAt.Time.UTC().Format(time.RFC3339)
+		}
+		if expiresAt.Valid {
+			item.ExpiresAt = expiresAt.Time.UTC().Format(time.RFC3339)
+		}
+		out = append(out, item)
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (a *App) handleDeleteAccessToken(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := strconv.ParseInt(strings.TrimSpace(r.PathValue("id")), 10, 64)
+	if err != nil || tokenID <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid token id")
+		return
+	}
+
+	result, err := a.db.Exec(
+		"DELETE FROM personal_access_tokens WHERE id = ? AND user_id = ?",
+		tokenID,
+		authUserID(r),
+	)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to revoke token")
+		return
+	}
+
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		writeError(w, http.StatusNotFound, "token not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeScopes(t *testing.T) {
+	for _, tc := range []struct {
+		in   []string
+		want []string
+	}{
+		{[]string{"watched:read"}, []string{"watched:read"}},
+		{[]string{" Watched:Write ", "watched:read", "watched:write"}, []string{"watched:read", "watched:write"}},
+		{[]string{"profile:write", "lists:read"}, []string{"lists:read", "profile:write"}},
+		{nil, nil},
+		{[]string{"admin"}, nil},
+		{[]string{"watched:read", ""}, nil},
+	} {
+		got, err := normalizeScopes(tc.in)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%q: got %q, want an error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("%q: got %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestAccessTokens(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "scripts@example.com", "password123")
+	other := createPasswordUser(t, a, "other@example.com", "password123")
+	session := loginSession(t, a, user.ID)
+	otherSession := loginSession(t, a, other.ID)
+
+	create := func(in CreateAccessTokenInput) (CreateAccessTokenResponse, int) {
+		t.Helper()
+		rec := serve(a.requireAuth(scopeSessionOnly, a.handleCreateAccessToken), jsonRequest(t, http.MethodPost, "/api/auth/tokens", session, in))
+		var out CreateAccessTokenResponse
+		if rec.Code == http.StatusCreated {
+			decodeBody(t, rec, &out)
+		}
+		return out, rec.Code
+	}
+	readWatched := func(token string) int {
+		return serve(a.requireAuth(scopeWatchedRead, a.handleListWatched), jsonRequest(t, http.MethodGet, "/api/user/watched?mediaType=all", token, nil)).Code
+	}
+	revoke := func(session string, id int64) int {
+		req := jsonRequest(t, http.MethodDelete, "/", session, nil)
+		req.SetPathValue("id", fmt.Sprint(id))
+		return serve(a.requireAuth(scopeSessionOnly, a.handleDeleteAccessToken), req).Code
+	}
+
+	for name, in := range map[string]CreateAccessTokenInput{
+		"no name":       {Scopes: []string{scopeWatchedRead}},
+		"long name":     {Name: strings.Repeat("x", 81), Scopes: []string{scopeWatchedRead}},
+		"no scopes":     {Name: "script"},
+		"unknown scope": {Name: "script", Scopes: []string{"admin"}},
+		"negative days": {Name: "script", Scopes: []string{scopeWatchedRead}, ExpiresInDays: -1},
+		"too many days": {Name: "script", Scopes: []string{scopeWatchedRead}, ExpiresInDays: 3651},
+	} {
+		if _, status := create(in); status != http.StatusBadRequest {
+			t.Fatalf("%s: status %d", name, status)
+		}
+	}
+
+	reader, status := create(CreateAccessTokenInput{Name: "backup", Scopes: []string{scopeWatchedRead}})
+	if status != http.StatusCreated || !strings.HasPrefix(reader.Token, accessTokenPrefix) || !strings.HasPrefix(reader.Token, reader.Prefix) || reader.ExpiresAt != "" {
+		t.Fatalf("create: status %d, %+v", status, reader)
+	}
+	var stored int
+	if err := a.db.QueryRow("SELECT COUNT(1) FROM personal_access_tokens WHERE token_hash = ?", reader.Token).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Fatal("token stored in plain text")
+	}
+
+	// Tokens reach only the routes their scopes name, never session-only ones.
+	if status := readWatched(reader.Token); status != http.StatusOK {
+		t.Fatalf("read with watched:read: status %d", status)
+	}
+	write := a.requireAuth(scopeWatchedWrite, a.handleUpsertWatched)
+	if rec := serve(write, jsonRequest(t, http.MethodPost, "/api/user/watched", reader.Token, WatchedInput{MediaType: "movie", TmdbID: 603})); rec.Code != http.StatusForbidden {
+		t.Fatalf("write with watched:read: status %d", rec.Code)
+	}
+	if _, status := create(CreateAccessTokenInput{Name: "everything", Scopes: accessTokenScopes}); status != http.StatusCreated {
+		t.Fatalf("create with every scope: status %d", status)
+	}
+	rec := serve(a.requireAuth(scopeSessionOnly, a.handleCreateAccessToken), jsonRequest(t, http.MethodPost, "/api/auth/tokens", reader.Token, CreateAccessTokenInput{Name: "escalate", Scopes: accessTokenScopes}))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("create a token with a token: status %d", rec.Code)
+	}
+
+	rec = serve(a.requireAuth(scopeSessionOnly, a.handleListAccessTokens), jsonRequest(t, http.MethodGet, "/api/auth/tokens", session, nil))
+	var tokens []AccessToken
+	decodeBody(t, rec, &tokens)
+	i := slices.IndexFunc(tokens, func(token AccessToken) bool { return token.ID == reader.ID })
+	if len(tokens) != 2 || i < 0 || tokens[i].LastUsedAt == "" || tokens[i].Prefix != reader.Prefix {
+		t.Fatalf("list: %+v", tokens)
+	}
+	rec = serve(a.requireAuth(scopeSessionOnly, a.handleListAccessTokens), jsonRequest(t, http.MethodGet, "/api/auth/tokens", otherSession, nil))
+	decodeBody(t, rec, &tokens)
+	if len(tokens) != 0 {
+		t.Fatalf("another user's tokens: %+v", tokens)
+	}
+
+	expiring, _ := create(CreateAccessTokenInput{Name: "temporary", Scopes: []string{scopeWatchedRead}, ExpiresInDays: 1})
+	if expiring.ExpiresAt == "" {
+		t.Fatalf("token with expiresInDays has no expiresAt: %+v", expiring)
+	}
+	if status := readWatched(expiring.Token); status != http.StatusOK {
+		t.Fatalf("unexpired token: status %d", status)
+	}
+	if _, err := a.db.Exec("UPDATE personal_access_tokens SET expires_at = ? WHERE id = ?", sqliteTime(time.Now().Add(-time.Minute)), expiring.ID); err != nil {
+		t.Fatal(err)
+	}
+	if status := readWatched(expiring.Token); status != http.StatusUnauthorized {
+		t.Fatalf("expired token: status %d", status)
+	}
+
+	if status := revoke(otherSession, reader.ID); status != http.StatusNotFound {
+		t.Fatalf("revoke another user's token: status %d", status)
+	}
+	if status := readWatched(reader.Token); status != http.StatusOK {
+		t.Fatalf("token after a foreign revoke: status %d", status)
+	}
+	if status := revoke(session, reader.ID); status != http.StatusNoContent {
+		t.Fatalf("revoke: status %d", status)
+	}
+	if status := readWatched(reader.Token); status != http.StatusUnauthorized {
+		t.Fatalf("revoked token: status %d", status)
+	}
+	if status := revoke(session, reader.ID); status != http.StatusNotFound {
+		t.Fatalf("revoke twice: status %d", status)
+	}
+}
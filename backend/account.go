@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// recentLoginWindow is how fresh a session must be to confirm a sensitive
+// action on an account that has no password, e.g. one created through OIDC.
+const recentLoginWindow = 10 * time.Minute
+
+// userDataTables lists every SQLite table holding per-user rows. Keep it in
+// sync when adding a table with a user_id column; the tables behind the
+// repositories are cleared through their DeleteByUser methods.
+var userDataTables = []string{
+	"series",
+	"password_reset_tokens",
+	"email_verification_tokens",
+	"totp_credentials",
+	"totp_recovery_codes",
+	"user_identities",
+	"personal_access_tokens",
+}
+
+type DeleteAccountInput struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type DeleteAccountResponse struct {
+	Status      string `json:"status"`
+	DeleteAfter string `json:"deleteAfter,omitempty"`
+}
+
+func ensureUsersDeletionColumn(tx *sql.Tx) error {
+	return addUsersColumnIfMissing(tx, "delete_after", "DATETIME")
+}
+
+// deleteUserData removes the user's rows from the SQLite-only tables.
+func deleteUserData(tx *sql.Tx, userID int64) error {
+	for _, table := range userDataTables {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE user_id = ?", userID); err != nil {
+			return fmt.Errorf("failed deleting %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// deleteUser removes an account and everything it owns. The account is first
+// marked due for deletion, so a login can no longer cancel it and the purger
+// retries the whole sequence if any step fails. Every step only deletes what
+// is left, the repositories before the SQLite-only tables and the account row
+// last.
+func (a *App) deleteUser(userID int64) error {
+	user, err := a.repos.Users.ByID(userID)
+	switch {
+	case errors.Is(err, errNotFound):
+	case err != nil:
+		return err
+	default:
+		if err := a.repos.Users.ScheduleDeletion(userID, time.Now()); err != nil {
+			return fmt.Errorf("failed scheduling deletion: %w", err)
+		}
+	}
+
+	if _, err := a.repos.Sessions.DeleteByUser(userID, 0); err != nil {
+		return fmt.Errorf("failed deleting sessions: %w", err)
+	}
+	if err := a.repos.Watched.DeleteByUser(userID); err != nil {
+		return fmt.Errorf("failed deleting watch_events: %w", err)
+	}
+	if err := a.repos.Titles.DeleteByUser(userID); err != nil {
+		return fmt.Errorf("failed deleting title statuses: %w", err)
+	}
+	if err := a.repos.Ratings.DeleteByUser(userID); err != nil {
+		return fmt.Errorf("failed deleting ratings: %w", err)
+	}
+	if err := a.repos.Lists.DeleteByUser(userID); err != nil {
+		return fmt.Errorf("failed deleting lists: %w", err)
+	}
+
+	if err := a.db.inTx(func(tx *sql.Tx) error {
+		return deleteUserData(tx, userID)
+	}); err != nil {
+		return err
+	}
+
+	if err := a.repos.Users.Delete(userID); err != nil {
+		return fmt.Errorf("failed deleting user: %w", err)
+	}
+	a.removeAvatar(userID, user.PhotoURL)
+	return nil
+}
+
+// purgeScheduledDeletions removes accounts whose grace period has ended.
+func (a *App) purgeScheduledDeletions(now time.Time) (int, error) {
+	ids, err := a.repos.Users.DueForDeletion(now)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		if err := a.deleteUser(id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+func (a *App) runDeletionPurger(interval time.Duration) {
+	for {
+		purged, err := a.purgeScheduledDeletions(time.Now())
+		if err != nil {
+			log.Printf("failed purging deleted accounts: %v", err)
+		} else if purged > 0 {
+			log.Printf("purged %d deleted account(s)", purged)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// cancelScheduledDeletion is called on every successful login: signing back
+// in during the grace period keeps the account. Once the period has ended it
+// returns errDeletionDue and the login must be refused.
+func (a *App) cancelScheduledDeletion(userID int64) (bool, error) {
+	return a.repos.Users.CancelDeletion(userID, time.Now())
+}
+
+// confirmIdentity checks password against the caller's account. Accounts
+// without a password must have signed in recently instead.
+func (a *App) confirmIdentity(r *http.Request, password string) (bool, error) {
+	info := authFromRequest(r)
+
+	user, err := a.repos.Users.ByID(info.UserID)
+	if err != nil {
+		return false, err
+	}
+	if user.PasswordHash != "" {
+		return a.checkPassword(info.UserID, password)
+	}
+
+	// Access tokens carry no session, so they can never pass this check.
+	if info.SessionID == 0 {
+		return false, nil
+	}
+	session, err := a.repos.Sessions.ByID(info.SessionID)
+	if errors.Is(err, errNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return session.CreatedAt.After(time.Now().Add(-recentLoginWindow)), nil
+}
+
+// reauthenticate confirms the caller's identity before an irreversible action,
+// including a second factor when two-factor is enabled.
+func (a *App) reauthenticate(r *http.Request, in DeleteAccountInput) (bool, error) {
+	info := authFromRequest(r)
+
+	ok, err := a.confirmIdentity(r, in.Password)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	enabled, err := a.twoFactorEnabled(info.UserID)
+	if err != nil || !enabled {
+		return err == nil, err
+	}
+	switch {
+	case strings.TrimSpace(in.Code) != "":
+		return a.consumeTOTP(info.UserID, in.Code, true)
+	case strings.TrimSpace(in.RecoveryCode) != "":
+		return a.consumeRecoveryCode(info.UserID, in.RecoveryCode)
+	}
+	return false, nil
+}
+
+func (a *App) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var in DeleteAccountInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	userID := authUserID(r)
+
+	ok, err := a.reauthenticate(r, in)
+	if wait, limited := retryAfter(err); limited {
+		writeTooManyRequests(w, wait)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete account")
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "reauthentication required")
+		return
+	}
+
+	grace := envDurationOrDefault("ACCOUNT_DELETION_GRACE", 0)
+	if grace <= 0 {
+		if err := a.deleteUser(userID); err != nil {
+			// The account is already due, so the purger finishes the deletion.
+			log.Printf("failed deleting account %d: %v", userID, err)
+			writeError(w, http.StatusInternalServerError, "failed to delete account")
+			return
+		}
+		writeJSON(w, http.StatusOK, DeleteAccountResponse{Status: "deleted"})
+		return
+	}
+
+	deleteAfter := time.Now().UTC().Add(grace)
+
+	if err := a.repos.Users.ScheduleDeletion(userID, deleteAfter); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete account")
+		return
+	}
+	// Signing out everywhere means only a fresh login can cancel the deletion.
+	if _, err := a.repos.Sessions.DeleteByUser(userID, 0); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete account")
+		return
+	}
+	if _, err := a.db.Exec("DELETE FROM personal_access_tokens WHERE user_id = ?", userID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete account")
+		return
+	}
+
+	user, err := a.repos.Users.ByID(userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete account")
+		return
+	}
+	name, email := user.Name, user.Email
+
+	a.sendMailAsync(MailMessage{
+		To:      email,
+		Subject: "Exclusao de conta - TrackSM",
+		Body: fmt.Sprintf(
+			"Ola, %s!\n\nSua conta sera excluida em %s (UTC). Para cancelar a exclusao, basta entrar novamente antes dessa data.\n",
+			name,
+			deleteAfter.Format(dbTimeLayout),
+		),
+	})
+
+	writeJSON(w, http.StatusAccepted, DeleteAccountResponse{
+		Status:      "scheduled",
+		DeleteAfter: deleteAfter.Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// failingLists makes DeleteByUser fail, standing in for a repository backend
+// that is unreachable halfway through a deletion.
+type failingLists struct {
+	ListRepository
+}
+
+func (failingLists) DeleteByUser(userID int64) error {
+	return errors.New("connection refused")
+}
+
+func TestDeleteUserIsRetriedAfterRepositoryFailure(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "gone@example.com", "password123")
+	loginSession(t, a, user.ID)
+
+	list := UserList{UserID: user.ID, Name: "Favoritos", Visibility: "private"}
+	if err := a.repos.Lists.Create(&list, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.db.Exec(
+		"INSERT INTO personal_access_tokens (user_id, name, prefix, token_hash, scopes) VALUES (?, 'script', 'tsm_', 'hash', '')",
+		user.ID,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	lists := a.repos.Lists
+	a.repos.Lists = failingLists{lists}
+	if err := a.deleteUser(user.ID); err == nil {
+		t.Fatal("deleteUser: expected the repository failure")
+	}
+
+	if ids, err := a.repos.Users.DueForDeletion(time.Now()); err != nil || len(ids) != 1 || ids[0] != user.ID {
+		t.Fatalf("half-deleted account not due: got %v, %v", ids, err)
+	}
+	if sessions, err := a.repos.Sessions.ListByUser(user.ID, time.Now()); err != nil || len(sessions) != 0 {
+		t.Fatalf("sessions left: got %d, %v", len(sessions), err)
+	}
+	rec := serve(a.handleLogin, jsonRequest(t, http.MethodPost, "/api/auth/login", "", LoginInput{Email: user.Email, Password: "password123"}))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("login into a half-deleted account: status %d %s", rec.Code, rec.Body)
+	}
+
+	a.repos.Lists = lists
+	if purged, err := a.purgeScheduledDeletions(time.Now()); err != nil || purged != 1 {
+		t.Fatalf("purge: got %d, %v", purged, err)
+	}
+
+	if _, err := a.repos.Users.ByID(user.ID); !errors.Is(err, errNotFound) {
+		t.Fatalf("user after purge: got %v, want errNotFound", err)
+	}
+	if got, err := a.repos.Lists.ByUser(user.ID, ""); err != nil || len(got) != 0 {
+		t.Fatalf("lists after purge: got %d, %v", len(got), err)
+	}
+	for _, table := range userDataTables {
+		var n int
+		if err := a.db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE user_id = ?", user.ID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Fatalf("%s: %d row(s) left", table, n)
+		}
+	}
+
+	if err := a.deleteUser(user.ID); err != nil {
+		t.Fatalf("deleting an already deleted account: %v", err)
+	}
+}
+
+func TestLoginCancelsDeletionOnlyDuringGracePeriod(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "grace@example.com", "password123")
+	login := func() *LoginResponse {
+		t.Helper()
+		rec := serve(a.handleLogin, jsonRequest(t, http.MethodPost, "/api/auth/login", "", LoginInput{Email: user.Email, Password: "password123"}))
+		if rec.Code != http.StatusOK {
+			t.Logf("login: status %d %s", rec.Code, rec.Body)
+			return nil
+		}
+		var out LoginResponse
+		decodeBody(t, rec, &out)
+		return &out
+	}
+
+	if err := a.repos.Users.ScheduleDeletion(user.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if out := login(); out == nil || !out.DeletionCancelled {
+		t.Fatalf("login during the grace period: got %+v", out)
+	}
+
+	if err := a.repos.Users.ScheduleDeletion(user.ID, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if out := login(); out != nil {
+		t.Fatalf("login after the grace period: got %+v", out)
+	}
+	if ids, err := a.repos.Users.DueForDeletion(time.Now()); err != nil || len(ids) != 1 {
+		t.Fatalf("deletion no longer due: got %v, %v", ids, err)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const dbTimeLayout = "2006-01-02 15:04:05"
+
+type contextKey string
+
+const authInfoKey contextKey = "authInfo"
+
+// sessionTouchInterval limits how often last_seen_at is written per session.
+const sessionTouchInterval = time.Minute
+
+// authInfo identifies the caller. Exactly one of SessionID and AccessTokenID
+// is set; Scopes only applies to access tokens.
+type authInfo struct {
+	UserID        int64
+	SessionID     int64
+	AccessTokenID int64
+	Scopes        []string
+	EmailVerified bool
+}
+
+// SessionSigner signs and verifies opaque session tokens. The token handed to
+// clients is "<secret>.<signature>"; only sha256(secret) is persisted, so a
+// leaked database does not leak usable tokens.
+type SessionSigner struct {
+	key []byte
+	ttl time.Duration
+}
+
+func NewSessionSigner() *SessionSigner {
+	secret := envOrDefault("SESSION_SECRET", "")
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatal(fmt.Errorf("failed generating session secret: %w", err))
+		}
+		log.Printf("SESSION_SECRET not set; using a random key, sessions will not survive restarts")
+	}
+
+	return &SessionSigner{
+		key: key,
+		ttl: envDurationOrDefault("SESSION_TTL", 30*24*time.Hour),
+	}
+}
+
+func (s *SessionSigner) sign(secret string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(secret))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newToken returns the client-facing token and the hash stored in the database.
+func (s *SessionSigner) newToken() (string, string, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	return secret + "." + s.sign(secret), hashToken(secret), nil
+}
+
+// verify checks the token signature and returns the hash to look up.
+func (s *SessionSigner) verify(token string) (string, bool) {
+	secret, signature, ok := strings.Cut(token, ".")
+	if !ok || secret == "" || signature == "" {
+		return "", false
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.sign(secret))) {
+		return "", false
+	}
+	return hashToken(secret), true
+}
+
+func randomBytes(size int) ([]byte, error) {
+	raw := make([]byte, size)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// randomToken returns size random bytes encoded as unpadded base64url.
+func randomToken(size int) (string, error) {
+	raw, err := randomBytes(size)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func ensureSessionsTable(tx *sql.Tx) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS sessions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        token_hash TEXT NOT NULL UNIQUE,
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        expires_at DATETIME NOT NULL
+    );
+    `
+
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed creating sessions table: %w", err)
+	}
+
+	return nil
+}
+
+func ensureSessionsDeviceColumns(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "sessions", "last_seen_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(tx, "sessions", "user_agent", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(tx, "sessions", "ip", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// createSession stores a new session for userID and returns the token and its
+// expiry in the same layout used for DATETIME columns. The request is used to
+// record the device the session was created from.
+func (a *App) createSession(r *http.Request, userID int64) (string, string, error) {
+	token, tokenHash, err := a.sessions.newToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	session := Session{
+		UserID:    userID,
+		TokenHash: tokenHash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(a.sessions.ttl),
+		UserAgent: truncate(r.UserAgent(), 512),
+		IP:        a.clientIP(r),
+	}
+	if err := a.repos.Sessions.Create(&session); err != nil {
+		return "", "", err
+	}
+
+	return token, session.ExpiresAt.Format(time.RFC3339), nil
+}
+
+// touchSession refreshes last_seen_at and ip, at most once per
+// sessionTouchInterval so reads do not turn into a write per request.
+func (a *App) touchSession(r *http.Request, sessionID int64) {
+	now := time.Now().UTC()
+	if err := a.repos.Sessions.Touch(sessionID, now, a.clientIP(r), now.Add(-sessionTouchInterval)); err != nil {
+		log.Printf("failed touching session %d: %v", sessionID, err)
+	}
+}
+
+// clientIP returns the caller address. X-Forwarded-For is only honoured when
+// TRUST_PROXY is enabled, since clients can set it freely otherwise.
+func (a *App) clientIP(r *http.Request) string {
+	if a.trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if ip := strings.TrimSpace(first); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func truncate(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	return value[:max]
+}
+
+func bearerToken(r *http.Request) string {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// requireAuth resolves the caller from the bearer token and stores it in the
+// request context. Handlers must read the caller through authUserID rather
+// than trusting ids sent by the client.
+//
+// Browser sessions carry every scope. Personal access tokens are only
+// accepted when scope is not scopeSessionOnly and the token was granted it.
+func (a *App) requireAuth(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			writeError(w, http.StatusUnauthorized, "missing session token")
+			return
+		}
+
+		var (
+			info authInfo
+			ok   bool
+			err  error
+		)
+		if strings.HasPrefix(token, accessTokenPrefix) {
+			info, ok, err = a.lookupAccessToken(r, token)
+		} else {
+			info, ok, err = a.lookupSession(r, token)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to validate session")
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "invalid session token")
+			return
+		}
+
+		if info.AccessTokenID > 0 && (scope == scopeSessionOnly || !slices.Contains(info.Scopes, scope)) {
+			writeError(w, http.StatusForbidden, "token lacks required scope")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authInfoKey, info)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func (a *App) lookupSession(r *http.Request, token string) (authInfo, bool, error) {
+	var info authInfo
+
+	tokenHash, ok := a.sessions.verify(token)
+	if !ok {
+		return info, false, nil
+	}
+
+	session, err := a.repos.Sessions.ByTokenHash(tokenHash, time.Now())
+	if errors.Is(err, errNotFound) {
+		return info, false, nil
+	}
+	if err != nil {
+		return info, false, err
+	}
+
+	user, err := a.repos.Users.ByID(session.UserID)
+	if errors.Is(err, errNotFound) {
+		return info, false, nil
+	}
+	if err != nil {
+		return info, false, err
+	}
+
+	info.SessionID = session.ID
+	info.UserID = user.ID
+	info.EmailVerified = user.EmailVerified
+
+	a.touchSession(r, info.SessionID)
+	return info, true, nil
+}
+
+func authFromRequest(r *http.Request) authInfo {
+	info, _ := r.Context().Value(authInfoKey).(authInfo)
+	return info
+}
+
+func authUserID(r *http.Request) int64 {
+	return authFromRequest(r).UserID
+}
+
+// resolveUserID reconciles an optional client-sent user id with the session.
+// Zero means "the caller"; any other value must match the session owner.
+func resolveUserID(r *http.Request, claimed int64) (int64, error) {
+	userID := authUserID(r)
+	if claimed != 0 && claimed != userID {
+		return 0, fmt.Errorf("userId does not match session")
+	}
+	return userID, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAPITimestampsAreRFC3339(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "times@example.com", "password123")
+
+	rec := serve(a.handleLogin, jsonRequest(t, http.MethodPost, "/api/auth/login", "", LoginInput{Email: user.Email, Password: "password123"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status %d %s", rec.Code, rec.Body)
+	}
+	var login LoginResponse
+	decodeBody(t, rec, &login)
+	session := login.Token
+
+	rec = serve(a.requireAuth(scopeSessionOnly, a.handleCreateAccessToken), jsonRequest(t, http.MethodPost, "/api/auth/tokens", session, CreateAccessTokenInput{Name: "script", Scopes: []string{scopeWatchedRead}, ExpiresInDays: 30}))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create token: status %d %s", rec.Code, rec.Body)
+	}
+	var created CreateAccessTokenResponse
+	decodeBody(t, rec, &created)
+	if rec := serve(a.requireAuth(scopeWatchedRead, a.handleListWatched), jsonRequest(t, http.MethodGet, "/api/user/watched?mediaType=all", created.Token, nil)); rec.Code != http.StatusOK {
+		t.Fatalf("use token: status %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(a.requireAuth(scopeSessionOnly, a.handleListAccessTokens), jsonRequest(t, http.MethodGet, "/api/auth/tokens", session, nil))
+	var tokens []AccessToken
+	decodeBody(t, rec, &tokens)
+	if len(tokens) != 1 {
+		t.Fatalf("tokens: got %+v", tokens)
+	}
+
+	if _, err := a.db.Exec(
+		"INSERT INTO totp_credentials (user_id, secret, enabled_at) VALUES (?, 'secret', ?)",
+		user.ID,
+		sqliteTime(time.Now()),
+	); err != nil {
+		t.Fatal(err)
+	}
+	rec = serve(a.requireAuth(scopeSessionOnly, a.handleTwoFactorStatus), jsonRequest(t, http.MethodGet, "/api/auth/profile/2fa", session, nil))
+	var twoFactor TwoFactorStatus
+	decodeBody(t, rec, &twoFactor)
+
+	for name, value := range map[string]string{
+		"login expiresAt":         login.ExpiresAt,
+		"created token createdAt": created.CreatedAt,
+		"created token expiresAt": created.ExpiresAt,
+		"listed token createdAt":  tokens[0].CreatedAt,
+		"listed token lastUsedAt": tokens[0].LastUsedAt,
+		"listed token expiresAt":  tokens[0].ExpiresAt,
+		"two-factor enabledAt":    twoFactor.EnabledAt,
+	} {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			t.Errorf("%s: %q is not RFC 3339", name, value)
+		}
+	}
+}
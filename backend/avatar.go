@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	maxAvatarBytes     = 5 << 20
+	maxAvatarDimension = 6000
+	// maxAvatarPixels bounds the decoded bitmap to about 16 MB of RGBA.
+	maxAvatarPixels = 4_000_000
+)
+
+// avatarSizes are the square renditions stored for every upload. photoUrl
+// points at the last, largest one.
+var avatarSizes = []int{64, 128, 256}
+
+var (
+	errAvatarType       = errors.New("avatar must be a jpeg, png, gif or webp image")
+	errAvatarInvalid    = errors.New("avatar is not a valid image")
+	errAvatarDimensions = errors.New("avatar dimensions are too large")
+)
+
+type AvatarResponse struct {
+	PhotoURL string         `json:"photoUrl"`
+	Sizes    map[int]string `json:"sizes"`
+}
+
+// handleUploadAvatar takes a multipart "avatar" file and replaces the user's
+// photo with resized copies of it.
+func (a *App) handleUploadAvatar(w http.ResponseWriter, r *http.Request) {
+	// The extra megabyte leaves room for the multipart framing.
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarBytes+1<<20)
+
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "avatar is too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, "avatar file is required")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarBytes+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read avatar")
+		return
+	}
+	if len(data) > maxAvatarBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, "avatar is too large")
+		return
+	}
+
+	img, format, err := decodeAvatar(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := authUserID(r)
+	user, err := a.repos.Users.ByID(userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load user profile")
+		return
+	}
+
+	// Keys embed a hash of the upload, so each URL always serves the same bytes.
+	sum := sha256.Sum256(data)
+	version := hex.EncodeToString(sum[:8])
+	orientation := 1
+	if format == "jpeg" {
+		orientation = jpegOrientation(data)
+	}
+
+	out := AvatarResponse{Sizes: make(map[int]string, len(avatarSizes))}
+	for _, size := range avatarSizes {
+		encoded, ext, err := encodeAvatar(orientSquare(renderAvatar(img, size), orientation), format)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to process avatar")
+			return
+		}
+		key := avatarKey(userID, version, size, ext)
+		if err := a.blobs.Put(key, encoded); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to store avatar")
+			return
+		}
+		out.Sizes[size] = a.mediaURL(key)
+		out.PhotoURL = out.Sizes[size]
+	}
+
+	if err := a.repos.Users.SetPhotoURL(userID, out.PhotoURL); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update profile")
+		return
+	}
+	if user.PhotoURL != out.PhotoURL {
+		a.removeAvatar(userID, user.PhotoURL)
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleMedia serves stored blobs. Their keys never change meaning, so
+// clients may cache them indefinitely.
+func (a *App) handleMedia(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	f, modTime, err := a.blobs.Open(key)
+	if errors.Is(err, errBlobNotFound) {
+		writeError(w, http.StatusNotFound, "file not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to read file")
+		return
+	}
+	defer f.Close()
+
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+path.Base(key)+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, path.Base(key), modTime, f)
+}
+
+func (a *App) mediaURL(key string) string {
+	return a.apiURL + "/media/" + key
+}
+
+func avatarKey(userID int64, version string, size int, ext string) string {
+	return fmt.Sprintf("avatars/%d/%s-%d%s", userID, version, size, ext)
+}
+
+// avatarKeysFromURL returns the blob keys behind a photoUrl produced by
+// handleUploadAvatar for userID, or nil for any other URL.
+func avatarKeysFromURL(userID int64, photoURL string) []string {
+	u, err := url.Parse(photoURL)
+	if err != nil {
+		return nil
+	}
+	name, ok := strings.CutPrefix(u.Path, fmt.Sprintf("/media/avatars/%d/", userID))
+	if !ok {
+		return nil
+	}
+	version, _, ok := strings.Cut(name, "-")
+	if !ok || version == "" {
+		return nil
+	}
+
+	ext := path.Ext(name)
+	keys := make([]string, 0, len(avatarSizes))
+	for _, size := range avatarSizes {
+		keys = append(keys, avatarKey(userID, version, size, ext))
+	}
+	return keys
+}
+
+// removeAvatar deletes the files of a replaced photo. Failures only leave
+// unreferenced files behind, so they are logged.
+func (a *App) removeAvatar(userID int64, photoURL string) {
+	for _, key := range avatarKeysFromURL(userID, photoURL) {
+		if err := a.blobs.Delete(key); err != nil {
+			log.Printf("failed deleting avatar %s: %v", key, err)
+		}
+	}
+}
+
+// decodeAvatar sniffs the content instead of trusting the client's type and
+// checks the dimensions before decoding, so a small file cannot expand into
+// a huge bitmap.
+func decodeAvatar(data []byte) (image.Image, string, error) {
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+	default:
+		return nil, "", errAvatarType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", errAvatarInvalid
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, "", errAvatarInvalid
+	}
+	if cfg.Width > maxAvatarDimension || cfg.Height > maxAvatarDimension || cfg.Width*cfg.Height > maxAvatarPixels {
+		return nil, "", errAvatarDimensions
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", errAvatarInvalid
+	}
+	return img, format, nil
+}
+
+// renderAvatar center-crops img to a square and scales it to size pixels.
+func renderAvatar(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2))
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Src, nil)
+	return dst
+}
+
+// encodeAvatar writes only pixel data, which is what strips EXIF, ICC and
+// any other metadata from the upload. Photos stay JPEG; everything else
+// becomes PNG to keep transparency.
+func encodeAvatar(img image.Image, format string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), ".jpg", err
+	}
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), ".png", err
+}
+
+// jpegOrientation reads the EXIF orientation tag (1-8) of a JPEG, defaulting
+// to 1. It is applied to the pixels since the tag itself is dropped.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		offset := ifd + 2 + e*12
+		if offset+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[offset:]) == 0x0112 {
+			if value := int(order.Uint16(tiff[offset+8:])); value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orientSquare applies an EXIF orientation to a square image. Cropping to the
+// center commutes with every orientation, so it can run after resizing.
+func orientSquare(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	n := src.Bounds().Dx()
+	dst := image.NewRGBA(src.Bounds())
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = n-1-x, y
+			case 3:
+				dx, dy = n-1-x, n-1-y
+			case 4:
+				dx, dy = x, n-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = n-1-y, x
+			case 7:
+				dx, dy = n-1-y, n-1-x
+			case 8:
+				dx, dy = y, n-1-x
+			}
+			dst.SetRGBA(dx, dy, src.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
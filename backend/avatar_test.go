@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"net/http"
+	"testing"
+)
+
+// pngHeader returns the signature and IHDR chunk of a width x height PNG.
+// DecodeConfig only needs these, so huge dimensions cost nothing to build.
+func pngHeader(width, height int) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 2 // truecolor
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestDecodeAvatarPixelBudget(t *testing.T) {
+	cases := []struct {
+		name          string
+		width, height int
+		rejected      bool
+	}{
+		{"small", 64, 64, false},
+		{"exactly the budget", 2000, 2000, false},
+		{"over the budget", 2001, 2000, true},
+		{"over the budget within the side limit", 3000, 3000, true},
+		{"thin strip", maxAvatarDimension + 1, 1, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The headers carry no pixel data, so accepted sizes fail later
+			// with errAvatarInvalid instead of errAvatarDimensions.
+			_, _, err := decodeAvatar(pngHeader(tc.width, tc.height))
+			if got := errors.Is(err, errAvatarDimensions); got != tc.rejected {
+				t.Fatalf("%dx%d: got %v, rejected %v", tc.width, tc.height, err, tc.rejected)
+			}
+		})
+	}
+}
+
+func TestUpdateProfileOnlyKeepsOrClearsPhoto(t *testing.T) {
+	a, _ := newTestApp(t)
+	blobs, err := NewDiskBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.blobs = blobs
+	user := createPasswordUser(t, a, "photo@example.com", "password123")
+	session := loginSession(t, a, user.ID)
+	update := a.requireAuth(scopeProfileWrite, a.handleUpdateProfile)
+
+	const uploaded = "http://api.test/media/avatars/1/v1-256.png"
+	cases := []struct {
+		name     string
+		photoURL string
+		status   int
+		want     string
+	}{
+		{"data url", "data:image/png;base64,iVBORw0KGgo=", http.StatusBadRequest, uploaded},
+		{"external url", "https://images.example/me.png", http.StatusBadRequest, uploaded},
+		{"other upload", "http://api.test/media/avatars/2/v1-256.png", http.StatusBadRequest, uploaded},
+		{"kept", uploaded, http.StatusOK, uploaded},
+		{"cleared", "", http.StatusOK, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := a.repos.Users.SetPhotoURL(user.ID, uploaded); err != nil {
+				t.Fatal(err)
+			}
+			in := UpdateProfileInput{Name: "Test", Username: user.Username, PhotoURL: tc.photoURL}
+			rec := serve(update, jsonRequest(t, http.MethodPatch, "/api/auth/profile", session, in))
+			if rec.Code != tc.status {
+				t.Fatalf("status %d %s", rec.Code, rec.Body)
+			}
+			got, err := a.repos.Users.ByID(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.PhotoURL != tc.want {
+				t.Fatalf("photoUrl: got %q, want %q", got.PhotoURL, tc.want)
+			}
+		})
+	}
+}
+
+// exifJPEG builds the start of a JPEG whose APP1 segment holds an EXIF block
+// with a single orientation entry, after an APP0 segment like most cameras
+// write.
+func exifJPEG(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3) // SHORT
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x04, 0x00, 0x00, 0xFF, 0xE1}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(app1)+2))
+	data = append(data, app1...)
+	return append(data, 0xFF, 0xDA, 0x00, 0x02)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for orientation := uint16(0); orientation <= 9; orientation++ {
+			want := int(orientation)
+			if orientation < 1 || orientation > 8 {
+				want = 1
+			}
+			if got := jpegOrientation(exifJPEG(order, orientation)); got != want {
+				t.Errorf("%v orientation %d: got %d, want %d", order, orientation, got, want)
+			}
+		}
+	}
+
+	valid := exifJPEG(binary.BigEndian, 6)
+	// The APP1 segment ends 4 bytes before the end, at the SOS marker.
+	for n := range len(valid) - 4 {
+		if got := jpegOrientation(valid[:n]); got != 1 {
+			t.Errorf("truncated to %d bytes: got %d, want 1", n, got)
+		}
+	}
+
+	corrupt := func(edit func(data []byte)) []byte {
+		data := exifJPEG(binary.BigEndian, 6)
+		edit(data)
+		return data
+	}
+	const app1 = 8  // offset of the APP1 marker
+	const tiff = 18 // offset of the TIFF header
+	for name, data := range map[string][]byte{
+		"not a jpeg":           corrupt(func(d []byte) { d[1] = 0xD9 }),
+		"segment length 0":     corrupt(func(d []byte) { d[app1+2], d[app1+3] = 0, 0 }),
+		"segment length 1":     corrupt(func(d []byte) { d[app1+2], d[app1+3] = 0, 1 }),
+		"segment past the end": corrupt(func(d []byte) { d[app1+2], d[app1+3] = 0xFF, 0xFF }),
+		"APP0 past the end":    corrupt(func(d []byte) { d[4], d[5] = 0xFF, 0xFF }),
+		"missing marker byte":  corrupt(func(d []byte) { d[app1] = 0x00 }),
+		"scan before EXIF":     corrupt(func(d []byte) { d[app1+1] = 0xDA }),
+		"not EXIF":             corrupt(func(d []byte) { d[app1+4] = 'X' }),
+		"unknown byte order":   corrupt(func(d []byte) { d[tiff] = 'X' }),
+		"IFD inside header":    corrupt(func(d []byte) { binary.BigEndian.PutUint32(d[tiff+4:], 4) }),
+		"IFD past the end":     corrupt(func(d []byte) { binary.BigEndian.PutUint32(d[tiff+4:], 1<<31) }),
+		"entries past the end": corrupt(func(d []byte) {
+			binary.BigEndian.PutUint16(d[tiff+8:], 40)
+			binary.BigEndian.PutUint16(d[tiff+10:], 0x0110)
+		}),
+		"no orientation tag": corrupt(func(d []byte) { binary.BigEndian.PutUint16(d[tiff+10:], 0x0110) }),
+	} {
+		if got := jpegOrientation(data); got != 1 {
+			t.Errorf("%s: got %d, want 1", name, got)
+		}
+	}
+}
+
+func TestOrientSquare(t *testing.T) {
+	// a b
+	// c d
+	a, b, c, d := color.RGBA{R: 1, A: 255}, color.RGBA{R: 2, A: 255}, color.RGBA{R: 3, A: 255}, color.RGBA{R: 4, A: 255}
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	src.SetRGBA(0, 0, a)
+	src.SetRGBA(1, 0, b)
+	src.SetRGBA(0, 1, c)
+	src.SetRGBA(1, 1, d)
+
+	for orientation, want := range map[int][4]color.RGBA{
+		0: {a, b, c, d},
+		1: {a, b, c, d},
+		2: {b, a, d, c}, // mirrored
+		3: {d, c, b, a}, // rotated 180
+		4: {c, d, a, b}, // flipped
+		5: {a, c, b, d}, // transposed
+		6: {c, a, d, b}, // rotated 90 clockwise
+		7: {d, b, c, a}, // transversed
+		8: {b, d, a, c}, // rotated 90 counterclockwise
+		9: {a, b, c, d},
+	} {
+		dst := orientSquare(src, orientation)
+		got := [4]color.RGBA{dst.RGBAAt(0, 0), dst.RGBAAt(1, 0), dst.RGBAAt(0, 1), dst.RGBAAt(1, 1)}
+		if got != want {
+			t.Errorf("orientation %d: got %v, want %v", orientation, got, want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Backup files are named tracksm-<UTC timestamp>.db so that sorting by name
+// sorts by age; only files matching this pattern are rotated.
+const (
+	backupPrefix     = "tracksm-"
+	backupSuffix     = ".db"
+	backupTimeLayout = "20060102T150405Z"
+)
+
+// writeBackup snapshots db into dir with VACUUM INTO, which reads a single
+// consistent transaction and so is safe while the server keeps writing.
+func writeBackup(db *sql.DB, dir string, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed creating backup directory: %w", err)
+	}
+	path := filepath.Join(dir, backupPrefix+now.UTC().Format(backupTimeLayout)+backupSuffix)
+	if err := backupDatabase(db, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// backupDatabase writes a snapshot to path. It goes through a temporary file
+// so that an interrupted backup never looks like a complete one.
+func backupDatabase(db *sql.DB, path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".backup-*")
+	if err != nil {
+		return fmt.Errorf("failed creating backup file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	// VACUUM INTO accepts an existing file only if it is empty.
+	if _, err := db.Exec("VACUUM INTO ?", tmp.Name()); err != nil {
+		return fmt.Errorf("failed writing backup: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed writing backup: %w", err)
+	}
+	return nil
+}
+
+// pruneBackups keeps the newest keep backups in dir and deletes the rest.
+func pruneBackups(dir string, keep int) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			names = append(names, name)
+		}
+	}
+	if len(names) <= keep {
+		return 0, nil
+	}
+
+	slices.Sort(names)
+	removed := 0
+	for _, name := range names[:len(names)-keep] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func (a *App) runBackupScheduler(dir string, interval time.Duration, keep int) {
+	for {
+		time.Sleep(interval)
+
+		path, err := writeBackup(a.db.pool, dir, time.Now())
+		if err != nil {
+			log.Printf("scheduled backup failed: %v", err)
+			continue
+		}
+		log.Printf("wrote backup %s", path)
+
+		if removed, err := pruneBackups(dir, keep); err != nil {
+			log.Printf("failed rotating backups: %v", err)
+		} else if removed > 0 {
+			log.Printf("removed %d old backup(s)", removed)
+		}
+	}
+}
+
+// inspectBackup checks that src is an intact TrackSM database that this
+// binary can run, and returns its schema version.
+func inspectBackup(src string) (int, error) {
+	if _, err := os.Stat(src); err != nil {
+		return 0, err
+	}
+
+	db, err := openReadOnlyDatabase(src)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var integrity string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&integrity); err != nil {
+		return 0, fmt.Errorf("failed checking %s: %w", src, err)
+	}
+	if integrity != "ok" {
+		return 0, fmt.Errorf("%s failed the integrity check: %s", src, integrity)
+	}
+
+	var tables int
+	if err := db.QueryRow(
+		"SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name IN ('users', 'schema_migrations')",
+	).Scan(&tables); err != nil {
+		return 0, fmt.Errorf("failed reading %s: %w", src, err)
+	}
+	if tables == 0 {
+		return 0, fmt.Errorf("%s is not a TrackSM database", src)
+	}
+
+	// Databases from before versioned migrations have no schema_migrations
+	// table; they count as version 0.
+	version := 0
+	if tables == 2 {
+		if err := db.QueryRow("SELECT coalesce(max(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+			return 0, fmt.Errorf("failed reading schema version of %s: %w", src, err)
+		}
+	}
+	if latest := latestSchemaVersion(); version > latest {
+		return 0, fmt.Errorf("backup schema version %d is newer than this binary supports (%d)", version, latest)
+	}
+	return version, nil
+}
+
+// restoreDatabase replaces dbPath with a copy of src. The server must be
+// stopped. The current files are renamed aside rather than deleted, and a
+// stale -wal file is never left next to the restored database.
+func restoreDatabase(dbPath string, src string, now time.Time) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(dbPath), ".restore-*")
+	if err != nil {
+		return "", fmt.Errorf("failed creating restore file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	in, err := os.Open(src)
+	if err != nil {
+		tmp.Close()
+		return "", err
+	}
+	_, err = io.Copy(tmp, in)
+	in.Close()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed copying backup: %w", err)
+	}
+
+	aside := ""
+	if _, err := os.Stat(dbPath); err == nil {
+		aside = dbPath + ".before-restore-" + now.UTC().Format(backupTimeLayout)
+	}
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if _, err := os.Stat(dbPath + suffix); err != nil {
+			continue
+		}
+		target := aside + suffix
+		if aside == "" {
+			// Only journal files are left; they cannot belong to the backup.
+			if err := os.Remove(dbPath + suffix); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if err := os.Rename(dbPath+suffix, target); err != nil {
+			return "", fmt.Errorf("failed moving current database aside: %w", err)
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), dbPath); err != nil {
+		return "", fmt.Errorf("failed replacing database: %w", err)
+	}
+	return aside, nil
+}
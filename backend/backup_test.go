@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBackupAndRestoreRoundTrip(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "backup@example.com", "password123")
+
+	// ? and # are valid in file names but special in a file: URI.
+	dir := filepath.Join(t.TempDir(), "backups?v=1#x")
+	now := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	path, err := writeBackup(a.db.pool, dir, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := filepath.Base(path), "tracksm-20260301T123000Z.db"; got != want {
+		t.Fatalf("backup name: got %q, want %q", got, want)
+	}
+	version, err := inspectBackup(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latestSchemaVersion() {
+		t.Fatalf("backup version: got %d, want %d", version, latestSchemaVersion())
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "tracksm.db")
+	for suffix, content := range map[string]string{"": "current", "-wal": "wal", "-shm": "shm"} {
+		if err := os.WriteFile(dbPath+suffix, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aside, err := restoreDatabase(dbPath, path, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := dbPath + ".before-restore-20260301T123000Z"; aside != want {
+		t.Fatalf("aside: got %q, want %q", aside, want)
+	}
+	for suffix, content := range map[string]string{"": "current", "-wal": "wal", "-shm": "shm"} {
+		if got, err := os.ReadFile(aside + suffix); err != nil || string(got) != content {
+			t.Fatalf("aside%s: got %q, %v", suffix, got, err)
+		}
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if _, err := os.Stat(dbPath + suffix); !os.IsNotExist(err) {
+			t.Fatalf("stale %s left next to the restored database: %v", suffix, err)
+		}
+	}
+
+	restored, err := openReadOnlyDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	var email string
+	if err := restored.QueryRow("SELECT email FROM users WHERE id = ?", user.ID).Scan(&email); err != nil || email != user.Email {
+		t.Fatalf("restored user: got %q, %v", email, err)
+	}
+}
+
+func TestRestoreWithoutCurrentDatabaseDropsJournals(t *testing.T) {
+	a, _ := newTestApp(t)
+	path, err := writeBackup(a.db.pool, t.TempDir(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "tracksm.db")
+	if err := os.WriteFile(dbPath+"-wal", []byte("orphan"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	aside, err := restoreDatabase(dbPath, path, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aside != "" {
+		t.Fatalf("aside: got %q, want none", aside)
+	}
+	if _, err := os.Stat(dbPath + "-wal"); !os.IsNotExist(err) {
+		t.Fatalf("orphan -wal survived the restore: %v", err)
+	}
+	if _, err := inspectBackup(dbPath); err != nil {
+		t.Fatalf("restored database: %v", err)
+	}
+}
+
+func TestInspectBackupRejects(t *testing.T) {
+	dir := t.TempDir()
+	sqliteFile := func(t *testing.T, name string, stmts ...string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		for _, stmt := range stmts {
+			if _, err := db.Exec(stmt); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return path
+	}
+
+	notSQLite := filepath.Join(dir, "notes.db")
+	if err := os.WriteFile(notSQLite, []byte("not a database, just some text that is long enough"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"missing file":   filepath.Join(dir, "missing.db"),
+		"not sqlite":     notSQLite,
+		"other database": sqliteFile(t, "other.db", "CREATE TABLE notes (id INTEGER PRIMARY KEY)"),
+		"newer schema": sqliteFile(t, "newer.db",
+			"CREATE TABLE users (id INTEGER PRIMARY KEY)",
+			"CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at DATETIME NOT NULL)",
+			"INSERT INTO schema_migrations (version, name, applied_at) VALUES (999, 'future', '2030-01-01 00:00:00')",
+		),
+	}
+	for name, path := range cases {
+		t.Run(name, func(t *testing.T) {
+			if version, err := inspectBackup(path); err == nil {
+				t.Fatalf("accepted with version %d", version)
+			}
+		})
+	}
+
+	legacy := sqliteFile(t, "legacy.db", "CREATE TABLE users (id INTEGER PRIMARY KEY)")
+	if version, err := inspectBackup(legacy); err != nil || version != 0 {
+		t.Fatalf("pre-migration database: got %d, %v", version, err)
+	}
+}
+
+func TestPruneBackupsKeepsNewest(t *testing.T) {
+	dir := t.TempDir()
+	backups := []string{
+		"tracksm-20260101T000000Z.db",
+		"tracksm-20260102T000000Z.db",
+		"tracksm-20260103T000000Z.db",
+		"tracksm-20260104T000000Z.db",
+	}
+	others := []string{"tracksm.db", "notes.txt", "tracksm-20250101T000000Z.db.part"}
+	for _, name := range append(slices.Clone(backups), others...) {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "tracksm-20200101T000000Z.db"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		keep    int
+		removed int
+	}{
+		{keep: 5, removed: 0},
+		{keep: 4, removed: 0},
+		{keep: 2, removed: 2},
+		{keep: 2, removed: 0},
+	}
+	for _, tc := range cases {
+		removed, err := pruneBackups(dir, tc.keep)
+		if err != nil || removed != tc.removed {
+			t.Fatalf("keep %d: got %d, %v, want %d", tc.keep, removed, err, tc.removed)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	want := append([]string{"tracksm-20200101T000000Z.db"}, backups[2:]...)
+	want = append(want, others...)
+	slices.Sort(want)
+	if !slices.Equal(left, want) {
+		t.Fatalf("left: got %v, want %v", left, want)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var errBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps uploaded files under slash-separated keys such as
+// "avatars/1/abc-256.png". Open returns errBlobNotFound for unknown keys.
+type BlobStore interface {
+	Put(key string, data []byte) error
+	Open(key string) (io.ReadSeekCloser, time.Time, error)
+	Delete(key string) error
+}
+
+// DiskBlobStore maps keys to files below root.
+type DiskBlobStore struct {
+	root string
+}
+
+func NewDiskBlobStore(root string) (*DiskBlobStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed creating blob directory: %w", err)
+	}
+	return &DiskBlobStore{root: root}, nil
+}
+
+// path rejects keys that are empty, absolute or contain "..", so a key can
+// never point outside root.
+func (s *DiskBlobStore) path(key string) (string, error) {
+	if !fs.ValidPath(key) || key == "." {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes to a temporary file first so readers never see a partial blob.
+func (s *DiskBlobStore) Put(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *DiskBlobStore) Open(key string) (io.ReadSeekCloser, time.Time, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, time.Time{}, errBlobNotFound
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, time.Time{}, errBlobNotFound
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, time.Time{}, errBlobNotFound
+	}
+	return f, info.ModTime(), nil
+}
+
+func (s *DiskBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
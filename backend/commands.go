@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const commandUsage = `usage:
+  backend                  start the API server
+  backend migrate status   show applied and pending schema migrations
+  backend migrate up       apply pending schema migrations and exit
+  backend seed <email>     add the demo series to a user's empty list
+  backend backup [file]    write a snapshot of the database (default: a new file in BACKUP_DIR)
+  backend restore <file>   replace the database with a backup; stop the server first`
+
+// runCommand handles the maintenance subcommands. They use the same DB_PATH
+// as the server.
+func runCommand(args []string) error {
+	switch {
+	case len(args) == 2 && args[0] == "migrate" && args[1] == "status":
+		db, err := openReadOnlyDatabase(envOrDefault("DB_PATH", "tracksm.db"))
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return printMigrationStatus(os.Stdout, db)
+	case len(args) == 2 && args[0] == "migrate" && args[1] == "up":
+		db, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if err := migrateDatabase(db); err != nil {
+			return err
+		}
+		return printMigrationStatus(os.Stdout, db)
+	case len(args) == 2 && args[0] == "seed":
+		db, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		repos, err := openRepositories(db)
+		if err != nil {
+			return err
+		}
+		defer repos.Close()
+		if err := migrateDatabase(db); err != nil {
+			return err
+		}
+		email := strings.ToLower(strings.TrimSpace(args[1]))
+		user, err := repos.Users.ByEmail(email)
+		if err != nil {
+			return fmt.Errorf("failed finding user %s: %w", email, err)
+		}
+		added, err := seedSeries(sqliteDB{db}, user.ID)
+		if err != nil {
+			return fmt.Errorf("failed seeding series: %w", err)
+		}
+		fmt.Printf("added %d series\n", added)
+		return nil
+	case len(args) <= 2 && args[0] == "backup":
+		db, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		path := ""
+		if len(args) == 2 {
+			path = args[1]
+			err = backupDatabase(db, path)
+		} else {
+			path, err = writeBackup(db, envOrDefault("BACKUP_DIR", "backups"), time.Now())
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote backup %s\n", path)
+		return nil
+	case len(args) == 2 && args[0] == "restore":
+		version, err := inspectBackup(args[1])
+		if err != nil {
+			return err
+		}
+		dbPath := envOrDefault("DB_PATH", "tracksm.db")
+		aside, err := restoreDatabase(dbPath, args[1], time.Now())
+		if err != nil {
+			return err
+		}
+		if aside != "" {
+			fmt.Printf("previous database moved to %s\n", aside)
+		}
+		fmt.Printf("restored %s (schema version %d)\n", args[1], version)
+
+		// Re-read the restored file so the report reflects what the server
+		// will open; pending migrations run on its next start.
+		db, err := openReadOnlyDatabase(dbPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return printMigrationStatus(os.Stdout, db)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", strings.Join(args, " "), commandUsage)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Unverified account policies, selected with UNVERIFIED_POLICY.
+const (
+	unverifiedAllow    = "allow"
+	unverifiedReadOnly = "read-only"
+	unverifiedBlock    = "block"
+)
+
+const (
+	emailTokenVerify = "verify"
+	emailTokenChange = "change"
+)
+
+type VerifyEmailInput struct {
+	Token string `json:"token"`
+}
+
+type ResendVerificationInput struct {
+	Email string `json:"email"`
+}
+
+type ChangeEmailInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func unverifiedPolicyFromEnv() string {
+	policy := strings.ToLower(envOrDefault("UNVERIFIED_POLICY", unverifiedReadOnly))
+	switch policy {
+	case unverifiedAllow, unverifiedReadOnly, unverifiedBlock:
+		return policy
+	default:
+		log.Printf("invalid UNVERIFIED_POLICY %q, using %s", policy, unverifiedReadOnly)
+		return unverifiedReadOnly
+	}
+}
+
+// ensureUsersEmailVerifiedColumn adds users.email_verified_at. Accounts that
+// existed before verification was introduced are treated as verified.
+func ensureUsersEmailVerifiedColumn(tx *sql.Tx) error {
+	exists, err := columnExists(tx, "users", "email_verified_at")
+	if err != nil || exists {
+		return err
+	}
+
+	if err := addUsersColumnIfMissing(tx, "email_verified_at", "DATETIME"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL"); err != nil {
+		return fmt.Errorf("failed backfilling users.email_verified_at: %w", err)
+	}
+	return nil
+}
+
+func ensureEmailVerificationTable(tx *sql.Tx) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS email_verification_tokens (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        email TEXT NOT NULL,
+        purpose TEXT NOT NULL,
+        token_hash TEXT NOT NULL UNIQUE,
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        expires_at DATETIME NOT NULL,
+        used_at DATETIME
+    );
+    `
+
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed creating email_verification_tokens table: %w", err)
+	}
+
+	return nil
+}
+
+func normalizeEmail(raw string) (string, error) {
+	email := strings.ToLower(strings.TrimSpace(raw))
+	if email == "" || len(email) > 254 {
+		return "", fmt.Errorf("valid email is required")
+	}
+
+	parsed, err := mail.ParseAddress(email)
+	if err != nil || parsed.Address != email || parsed.Name != "" {
+		return "", fmt.Errorf("valid email is required")
+	}
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" || !strings.Contains(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", fmt.Errorf("valid email is required")
+	}
+
+	return email, nil
+}
+
+// sendEmailToken replaces any pending token of the same purpose for the user
+// and emails a link to the given address.
+func (a *App) sendEmailToken(userID int64, name string, email string, purpose string) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	ttl := envDurationOrDefault("EMAIL_TOKEN_TTL", 48*time.Hour)
+
+	if _, err := a.db.Exec(
+		"DELETE FROM email_verification_tokens WHERE user_id = ? AND purpose = ? AND used_at IS NULL",
+		userID,
+		purpose,
+	); err != nil {
+		return err
+	}
+	if _, err := a.db.Exec(
+		`INSERT INTO email_verification_tokens (user_id, email, purpose, token_hash, expires_at)
+         VALUES (?, ?, ?, ?, ?)`,
+		userID,
+		email,
+		purpose,
+		hashToken(token),
+		time.Now().UTC().Add(ttl).Format(dbTimeLayout),
+	); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verificar-email?token=%s", a.appURL, url.QueryEscape(token))
+	msg := MailMessage{
+		To:      email,
+		Subject: "Confirme seu email - TrackSM",
+		Body: fmt.Sprintf(
+			"Ola, %s!\n\nConfirme seu email no TrackSM abrindo o link abaixo:\n\n%s\n\nSe voce nao criou esta conta, ignore este email.\n",
+			name,
+			link,
+		),
+	}
+	if purpose == emailTokenChange {
+		msg.Subject = "Confirme seu novo email - TrackSM"
+		msg.Body = fmt.Sprintf(
+			"Ola, %s!\n\nPara usar este endereco na sua conta do TrackSM, abra o link abaixo:\n\n%s\n\nSe voce nao pediu esta alteracao, ignore este email.\n",
+			name,
+			link,
+		)
+	}
+	a.sendMailAsync(msg)
+
+	return nil
+}
+
+// requireVerified rejects callers whose email is unverified when the policy
+// limits such accounts. It must wrap a handler already behind requireAuth.
+func (a *App) requireVerified(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.unverifiedPolicy != unverifiedAllow && !authFromRequest(r).EmailVerified {
+			writeError(w, http.StatusForbidden, "email not verified")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (a *App) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var in VerifyEmailInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	in.Token = strings.TrimSpace(in.Token)
+	if in.Token == "" {
+		writeError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	now := time.Now().UTC().Format(dbTimeLayout)
+
+	var (
+		tokenID int64
+		userID  int64
+		email   string
+		purpose string
+	)
+	err := a.db.inTx(func(tx *sql.Tx) error {
+		if err := tx.QueryRow(
+			`SELECT id, user_id, email, purpose FROM email_verification_tokens
+             WHERE token_hash = ? AND used_at IS NULL AND expires_at > ? LIMIT 1`,
+			hashToken(in.Token),
+			now,
+		).Scan(&tokenID, &userID, &email, &purpose); err != nil {
+			return err
+		}
+
+		// The used_at guard keeps the token single-use under concurrent requests.
+		claimed, err := rowsAffected(tx.Exec(
+			"UPDATE email_verification_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL",
+			now,
+			tokenID,
+		))
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusBadRequest, "invalid or expired token")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to verify email")
+		return
+	}
+
+	// Users may live in another database, so the claimed token is handed back
+	// when the account could not be updated.
+	verifiedAt := time.Now().UTC()
+	updated := true
+	if purpose == emailTokenChange {
+		err = a.repos.Users.SetEmail(userID, email, verifiedAt)
+	} else {
+		// A verify token only counts for the address it was sent to.
+		updated, err = a.repos.Users.MarkEmailVerified(userID, email, verifiedAt)
+	}
+	if err != nil {
+		a.releaseEmailToken(tokenID)
+		if errors.Is(err, errEmailTaken) {
+			writeError(w, http.StatusConflict, "email already registered")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to verify email")
+		return
+	}
+	if !updated {
+		writeError(w, http.StatusBadRequest, "invalid or expired token")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "verified", "email": email})
+}
+
+// handleResendVerification is unauthenticated so it also works when the
+// policy blocks logins, and it answers 202 regardless of the account state.
+func (a *App) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	var in ResendVerificationInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	in.Email = strings.ToLower(strings.TrimSpace(in.Email))
+	if in.Email == "" {
+		writeError(w, http.StatusBadRequest, "email is required")
+		return
+	}
+
+	if !a.allowMail(w, r, in.Email) {
+		return
+	}
+
+	accepted := map[string]string{"status": "accepted"}
+
+	user, err := a.repos.Users.ByEmail(in.Email)
+	if errors.Is(err, errNotFound) || (err == nil && user.EmailVerified) {
+		writeJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to resend verification")
+		return
+	}
+
+	if err := a.sendEmailToken(user.ID, user.Name, in.Email, emailTokenVerify); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to resend verification")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, accepted)
+}
+
+// handleChangeEmail starts an email change. The address on the account only
+// changes once the link sent to the new address is confirmed.
+func (a *App) handleChangeEmail(w http.ResponseWriter, r *http.Request) {
+	var in ChangeEmailInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	email, err := normalizeEmail(in.Email)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := authUserID(r)
+
+	user, err := a.repos.Users.ByID(userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load user profile")
+		return
+	}
+	name, currentEmail := user.Name, user.Email
+
+	ok, err := a.checkPassword(userID, in.Password)
+	if wait, limited := retryAfter(err); limited {
+		writeTooManyRequests(w, wait)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load user profile")
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+	if email == currentEmail {
+		writeError(w, http.StatusBadRequest, "email is unchanged")
+		return
+	}
+
+	if _, err := a.repos.Users.ByEmail(email); err == nil {
+		writeError(w, http.StatusConflict, "email already registered")
+		return
+	} else if !errors.Is(err, errNotFound) {
+		writeError(w, http.StatusInternalServerError, "failed to validate email")
+		return
+	}
+
+	if err := a.sendEmailToken(userID, name, email, emailTokenChange); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to start email change")
+		return
+	}
+	a.sendMailAsync(MailMessage{
+		To:      currentEmail,
+		Subject: "Alteracao de email solicitada - TrackSM",
+		Body: fmt.Sprintf(
+			"Ola, %s!\n\nFoi solicitada a troca do email da sua conta para %s. Se nao foi voce, altere sua senha.\n",
+			name,
+			email,
+		),
+	})
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "pending", "pendingEmail": email})
+}
+
+func (a *App) releaseEmailToken(tokenID int64) {
+	if _, err := a.db.Exec("UPDATE email_verification_tokens SET used_at = NULL WHERE id = ?", tokenID); err != nil {
+		log.Printf("failed releasing email token %d: %v", tokenID, err)
+	}
+}
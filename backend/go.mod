@@ -3,7 +3,9 @@ module tracksm/backend
 go 1.24.0
 
 require (
+	github.com/lib/pq v1.12.3
 	golang.org/x/crypto v0.48.0
+	golang.org/x/image v0.36.0
 	modernc.org/sqlite v1.45.0
 )
 
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// List visibilities. Public lists are readable by anyone, unlisted ones only
+// through their share link, private ones only by their owner.
+const (
+	listPublic   = "public"
+	listUnlisted = "unlisted"
+	listPrivate  = "private"
+)
+
+const (
+	maxListNameLength        = 100
+	maxListDescriptionLength = 1000
+	maxListNoteLength        = 1000
+	maxListItems             = 1000
+)
+
+type ListInput struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Visibility  *string `json:"visibility"`
+}
+
+type ListItemInput struct {
+	MediaType string  `json:"mediaType"`
+	TmdbID    int64   `json:"tmdbId"`
+	Note      *string `json:"note"`
+	// Position is 0-based; without it items are added at the end and kept in
+	// place on updates.
+	Position *int `json:"position"`
+}
+
+type ListOrderInput struct {
+	ItemIDs []int64 `json:"itemIds"`
+}
+
+type ListEntryResponse struct {
+	ID        int64  `json:"id"`
+	MediaType string `json:"mediaType"`
+	TmdbID    int64  `json:"tmdbId"`
+	Position  int    `json:"position"`
+	Note      string `json:"note"`
+	AddedAt   string `json:"addedAt"`
+}
+
+type ListResponse struct {
+	ID          int64  `json:"id"`
+	UserID      int64  `json:"userId"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Visibility  string `json:"visibility"`
+	// ShareToken and ShareURL are only shown to the owner.
+	ShareToken string              `json:"shareToken,omitempty"`
+	ShareURL   string              `json:"shareUrl,omitempty"`
+	ItemCount  int                 `json:"itemCount"`
+	CreatedAt  string              `json:"createdAt"`
+	UpdatedAt  string              `json:"updatedAt"`
+	Items      []ListEntryResponse `json:"items,omitempty"`
+}
+
+func ensureUserListsTables(tx *sql.Tx) error {
+	query := `
+    CREATE TABLE user_lists (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        name TEXT NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        visibility TEXT NOT NULL DEFAULT 'private',
+        share_token TEXT UNIQUE,
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX idx_user_lists_user ON user_lists(user_id, updated_at DESC);
+    CREATE TABLE user_list_items (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        list_id INTEGER NOT NULL REFERENCES user_lists(id) ON DELETE CASCADE,
+        media_type TEXT NOT NULL,
+        tmdb_id INTEGER NOT NULL,
+        position INTEGER NOT NULL,
+        note TEXT NOT NULL DEFAULT '',
+        added_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE(list_id, media_type, tmdb_id)
+    );
+    CREATE INDEX idx_user_list_items_position ON user_list_items(list_id, position);
+    `
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed creating list tables: %w", err)
+	}
+	return nil
+}
+
+func listEntryResponse(item UserListItem) ListEntryResponse {
+	return ListEntryResponse{
+		ID:        item.ID,
+		MediaType: item.MediaType,
+		TmdbID:    item.TmdbID,
+		Position:  item.Position,
+		Note:      item.Note,
+		AddedAt:   item.AddedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+func (a *App) listResponse(list UserList, owner bool) ListResponse {
+	out := ListResponse{
+		ID:          list.ID,
+		UserID:      list.UserID,
+		Name:        list.Name,
+		Description: list.Description,
+		Visibility:  list.Visibility,
+		ItemCount:   list.ItemCount,
+		CreatedAt:   list.CreatedAt.UTC().Format(time.RFC3339),
+		UpdatedAt:   list.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+	if owner && list.ShareToken != "" {
+		out.ShareToken = list.ShareToken
+		if list.Visibility != listPrivate {
+			out.ShareURL = a.apiURL + "/api/lists/shared/" + list.ShareToken
+		}
+	}
+	return out
+}
+
+// writeListWithItems writes list together with its items.
+func (a *App) writeListWithItems(w http.ResponseWriter, status int, list UserList, owner bool) {
+	items, err := a.repos.Lists.Items(list.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load list items")
+		return
+	}
+
+	out := a.listResponse(list, owner)
+	out.ItemCount = len(items)
+	out.Items = make([]ListEntryResponse, 0, len(items))
+	for _, item := range items {
+		out.Items = append(out.Items, listEntryResponse(item))
+	}
+	writeJSON(w, status, out)
+}
+
+// validateListInput normalizes the fields present in in and checks them.
+// create requires a name.
+func validateListInput(in *ListInput, create bool) ValidationErrors {
+	var errs ValidationErrors
+	if in.Name != nil {
+		name := strings.TrimSpace(*in.Name)
+		in.Name = &name
+	}
+	switch {
+	case in.Name == nil && create, in.Name != nil && *in.Name == "":
+		errs.add("name", codeRequired, "name is required")
+	case in.Name != nil && utf8.RuneCountInString(*in.Name) > maxListNameLength:
+		errs.add("name", codeTooLong, fmt.Sprintf("name must have at most %d characters", maxListNameLength))
+	}
+	if in.Description != nil {
+		description := strings.TrimSpace(*in.Description)
+		in.Description = &description
+		if utf8.RuneCountInString(description) > maxListDescriptionLength {
+			errs.add("description", codeTooLong, fmt.Sprintf("description must have at most %d characters", maxListDescriptionLength))
+		}
+	}
+	if in.Visibility != nil {
+		visibility := strings.ToLower(strings.TrimSpace(*in.Visibility))
+		in.Visibility = &visibility
+		if visibility != listPublic && visibility != listUnlisted && visibility != listPrivate {
+			errs.add("visibility", codeInvalidValue, "visibility must be public, unlisted or private")
+		}
+	}
+	return errs
+}
+
+// ensureShareToken gives an unlisted list the token its link needs.
+func ensureShareToken(list *UserList) error {
+	if list.Visibility != listUnlisted || list.ShareToken != "" {
+		return nil
+	}
+	token, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+	list.ShareToken = token
+	return nil
+}
+
+// ownedList loads the {id} list of the authenticated user. Other users'
+// lists are reported as missing so that private lists stay invisible.
+func (a *App) ownedList(w http.ResponseWriter, r *http.Request) (UserList, bool) {
+	id, err := strconv.ParseInt(strings.TrimSpace(r.PathValue("id")), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid list id")
+		return UserList{}, false
+	}
+	list, err := a.repos.Lists.ByID(id)
+	if errors.Is(err, errNotFound) || (err == nil && list.UserID != authUserID(r)) {
+		writeError(w, http.StatusNotFound, "list not found")
+		return UserList{}, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load list")
+		return UserList{}, false
+	}
+	return list, true
+}
+
+func listItemIDFromPath(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	itemID, err := strconv.ParseInt(strings.TrimSpace(r.PathValue("itemId")), 10, 64)
+	if err != nil || itemID <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid item id")
+		return 0, false
+	}
+	return itemID, true
+}
+
+func (a *App) handleListOwnLists(w http.ResponseWriter, r *http.Request) {
+	lists, err := a.repos.Lists.ByUser(authUserID(r), "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list lists")
+		return
+	}
+
+	out := make([]ListResponse, 0, len(lists))
+	for _, list := range lists {
+		out = append(out, a.listResponse(list, true))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (a *App) handleCreateList(w http.ResponseWriter, r *http.Request) {
+	var in ListInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if errs := validateListInput(&in, true); len(errs) > 0 {
+		writeValidationErrors(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	list := UserList{UserID: authUserID(r), Name: *in.Name, Visibility: listPrivate}
+	if in.Description != nil {
+		list.Description = *in.Description
+	}
+	if in.Visibility != nil {
+		list.Visibility = *in.Visibility
+	}
+	if err := ensureShareToken(&list); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create list")
+		return
+	}
+	if err := a.repos.Lists.Create(&list, time.Now().UTC().Truncate(time.Second)); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create list")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, a.listResponse(list, true))
+}
+
+func (a *App) handleGetOwnList(w http.ResponseWriter, r *http.Request) {
+	list, ok := a.ownedList(w, r)
+	if !ok {
+		return
+	}
+	a.writeListWithItems(w, http.StatusOK, list, true)
+}
+
+func (a *App) handleUpdateList(w http.ResponseWriter, r *http.Request) {
+	list, ok := a.ownedList(w, r)
+	if !ok {
+		return
+	}
+
+	var in ListInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if errs := validateListInput(&in, false); len(errs) > 0 {
+		writeValidationErrors(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	if in.Name != nil {
+		list.Name = *in.Name
+	}
+	if in.Description != nil {
+		list.Description = *in.Description
+	}
+	if in.Visibility != nil {
+		list.Visibility = *in.Visibility
+	}
+	if err := ensureShareToken(&list); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update list")
+		return
+	}
+	updated, err := a.repos.Lists.Update(&list, time.Now().UTC().Truncate(time.Second))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update list")
+		return
+	}
+	if !updated {
+		writeError(w, http.StatusNotFound, "list not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.listResponse(list, true))
+}
+
+func (a *App) handleDeleteList(w http.ResponseWriter, r *http.Request) {
+	list, ok := a.ownedList(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := a.repos.Lists.Delete(list.UserID, list.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete list")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRotateListShareToken replaces the share token, so links handed out
+// earlier stop working.
+func (a *App) handleRotateListShareToken(w http.ResponseWriter, r *http.Request) {
+	list, ok := a.ownedList(w, r)
+	if !ok {
+		return
+	}
+	if list.Visibility == listPrivate {
+		writeError(w, http.StatusConflict, "private lists cannot be shared")
+		return
+	}
+
+	token, err := randomToken(16)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to rotate share link")
+		return
+	}
+	list.ShareToken = token
+	if _, err := a.repos.Lists.Update(&list, time.Now().UTC().Truncate(time.Second)); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to rotate share link")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.listResponse(list, true))
+}
+
+func (a *App) handleAddListItem(w http.ResponseWriter, r *http.Request) {
+	list, ok := a.ownedList(w, r)
+	if !ok {
+		return
+	}
+
+	var in ListItemInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	var errs ValidationErrors
+	in.MediaType = strings.ToLower(strings.TrimSpace(in.MediaType))
+	if in.MediaType != "movie" && in.MediaType != "tv" {
+		errs.add("mediaType", codeInvalidValue, "mediaType must be movie or tv")
+	}
+	if in.TmdbID <= 0 {
+		errs.add("tmdbId", codeRequired, "tmdbId is required")
+	}
+	note := ""
+	if in.Note != nil {
+		note = strings.TrimSpace(*in.Note)
+		if utf8.RuneCountInString(note) > maxListNoteLength {
+			errs.add("note", codeTooLong, fmt.Sprintf("note must have at most %d characters", maxListNoteLength))
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, http.StatusBadRequest, errs)
+		return
+	}
+	if list.ItemCount >= maxListItems {
+		writeError(w, http.StatusConflict, fmt.Sprintf("lists hold at most %d items", maxListItems))
+		return
+	}
+
+	position := -1
+	if in.Position != nil {
+		position = *in.Position
+	}
+	item := UserListItem{ListID: list.ID, MediaType: in.MediaType, TmdbID: in.TmdbID, Note: note}
+	err := a.repos.Lists.AddItem(&item, position, time.Now().UTC().Truncate(time.Second))
+	if errors.Is(err, errListItemExists) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to add list item")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, listEntryResponse(item))
+}
+
+// handleUpdateListItem changes an item's note and/or moves it.
+func (a *App) handleUpdateListItem(w http.ResponseWriter, r *http.Request) {
+	list, ok := a.ownedList(w, r)
+	if !ok {
+		return
+	}
+	itemID, ok := listItemIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var in ListItemInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if in.Note == nil && in.Position == nil {
+		writeError(w, http.StatusBadRequest, "note or position is required")
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	found := true
+	if in.Note != nil {
+		note := strings.TrimSpace(*in.Note)
+		if utf8.RuneCountInString(note) > maxListNoteLength {
+			var errs ValidationErrors
+			errs.add("note", codeTooLong, fmt.Sprintf("note must have at most %d characters", maxListNoteLength))
+			writeValidationErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+		updated, err := a.repos.Lists.SetItemNote(list.ID, itemID, note, now)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to update list item")
+			return
+		}
+		found = updated
+	}
+	if found && in.Position != nil {
+		moved, err := a.repos.Lists.MoveItem(list.ID, itemID, *in.Position, now)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to move list item")
+			return
+		}
+		found = moved
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "list item not found")
+		return
+	}
+
+	items, err := a.repos.Lists.Items(list.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load list items")
+		return
+	}
+	for _, item := range items {
+		if item.ID == itemID {
+			writeJSON(w, http.StatusOK, listEntryResponse(item))
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "list item not found")
+}
+
+func (a *App) handleDeleteListItem(w http.ResponseWriter, r *http.Request) {
+	list, ok := a.ownedList(w, r)
+	if !ok {
+		return
+	}
+	itemID, ok := listItemIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	removed, err := a.repos.Lists.RemoveItem(list.ID, itemID, time.Now().UTC().Truncate(time.Second))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to remove list item")
+		return
+	}
+	if !removed {
+		writeError(w, http.StatusNotFound, "list item not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleReorderList takes the complete new order, so a drag-and-drop client
+// can send what it shows without computing moves.
+func (a *App) handleReorderList(w http.ResponseWriter, r *http.Request) {
+	list, ok := a.ownedList(w, r)
+	if !ok {
+		return
+	}
+
+	var in ListOrderInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	err := a.repos.Lists.Reorder(list.ID, in.ItemIDs, now)
+	if errors.Is(err, errListOrderMismatch) {
+		var errs ValidationErrors
+		errs.add("itemIds", codeInvalidValue, err.Error())
+		writeValidationErrors(w, http.StatusBadRequest, errs)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to reorder list")
+		return
+	}
+
+	list.UpdatedAt = now
+	a.writeListWithItems(w, http.StatusOK, list, true)
+}
+
+// handleGetPublicList serves public lists to anyone, signed in or not.
+func (a *App) handleGetPublicList(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(strings.TrimSpace(r.PathValue("id")), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid list id")
+		return
+	}
+	list, err := a.repos.Lists.ByID(id)
+	if errors.Is(err, errNotFound) || (err == nil && list.Visibility != listPublic) {
+		writeError(w, http.StatusNotFound, "list not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load list")
+		return
+	}
+	a.writeListWithItems(w, http.StatusOK, list, false)
+}
+
+// handleGetSharedList serves a list through its share link. The link stops
+// working when the list is made private or the token is rotated.
+func (a *App) handleGetSharedList(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSpace(r.PathValue("token"))
+	if token == "" {
+		writeError(w, http.StatusNotFound, "list not found")
+		return
+	}
+	list, err := a.repos.Lists.ByShareToken(token)
+	if errors.Is(err, errNotFound) || (err == nil && list.Visibility == listPrivate) {
+		writeError(w, http.StatusNotFound, "list not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load list")
+		return
+	}
+	a.writeListWithItems(w, http.StatusOK, list, false)
+}
+
+func (a *App) handleListPublicUserLists(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(strings.TrimSpace(r.PathValue("userId")), 10, 64)
+	if err != nil || userID <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid userId")
+		return
+	}
+	if _, err := a.repos.Users.ByID(userID); errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load user")
+		return
+	}
+
+	lists, err := a.repos.Lists.ByUser(userID, listPublic)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list lists")
+		return
+	}
+
+	out := make([]ListResponse, 0, len(lists))
+	for _, list := range lists {
+		out = append(out, a.listResponse(list, false))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
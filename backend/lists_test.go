@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// listFixture is a signed-in user with one list of five movies, added in
+// order A to E.
+type listFixture struct {
+	a       *App
+	session string
+	list    ListResponse
+	items   map[string]int64
+}
+
+func newListFixture(t *testing.T, a *App, email string, visibility string) listFixture {
+	t.Helper()
+	user := createPasswordUser(t, a, email, "password123")
+	f := listFixture{a: a, session: loginSession(t, a, user.ID), items: make(map[string]int64)}
+
+	name := "Favoritos"
+	rec := serve(a.requireAuth(scopeListsWrite, a.handleCreateList), jsonRequest(t, http.MethodPost, "/api/user/lists", f.session, ListInput{Name: &name, Visibility: &visibility}))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create list: status %d %s", rec.Code, rec.Body)
+	}
+	decodeBody(t, rec, &f.list)
+
+	for i, label := range []string{"A", "B", "C", "D", "E"} {
+		req := jsonRequest(t, http.MethodPost, "/", f.session, ListItemInput{MediaType: "movie", TmdbID: int64(600 + i)})
+		req.SetPathValue("id", fmt.Sprint(f.list.ID))
+		rec := serve(a.requireAuth(scopeListsWrite, a.handleAddListItem), req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("add %s: status %d %s", label, rec.Code, rec.Body)
+		}
+		var item ListEntryResponse
+		decodeBody(t, rec, &item)
+		f.items[label] = item.ID
+	}
+	return f
+}
+
+// order returns the labels of the list's items by position.
+func (f listFixture) order(t *testing.T) string {
+	t.Helper()
+	items, err := f.a.repos.Lists.Items(f.list.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := ""
+	for i, item := range items {
+		if item.Position != i {
+			t.Fatalf("item %d at position %d, want %d", item.ID, item.Position, i)
+		}
+		for label, id := range f.items {
+			if id == item.ID {
+				out += label
+			}
+		}
+	}
+	return out
+}
+
+func (f listFixture) move(t *testing.T, session string, listID int64, itemID int64, position int) int {
+	t.Helper()
+	req := jsonRequest(t, http.MethodPatch, "/", session, ListItemInput{Position: &position})
+	req.SetPathValue("id", fmt.Sprint(listID))
+	req.SetPathValue("itemId", fmt.Sprint(itemID))
+	return serve(f.a.requireAuth(scopeListsWrite, f.a.handleUpdateListItem), req).Code
+}
+
+func (f listFixture) reorder(t *testing.T, session string, listID int64, itemIDs []int64) int {
+	t.Helper()
+	req := jsonRequest(t, http.MethodPut, "/", session, ListOrderInput{ItemIDs: itemIDs})
+	req.SetPathValue("id", fmt.Sprint(listID))
+	return serve(f.a.requireAuth(scopeListsWrite, f.a.handleReorderList), req).Code
+}
+
+func TestMoveListItem(t *testing.T) {
+	for _, tc := range []struct {
+		item     string
+		position int
+		want     string
+	}{
+		{"A", 0, "ABCDE"},
+		{"A", 4, "BCDEA"},
+		{"E", 0, "EABCD"},
+		{"B", 3, "ACDBE"},
+		{"D", 1, "ADBCE"},
+		{"C", 2, "ABCDE"},
+		{"B", 99, "ACDEB"},
+		{"D", -5, "DABCE"},
+	} {
+		t.Run(fmt.Sprintf("%s to %d", tc.item, tc.position), func(t *testing.T) {
+			a, _ := newTestApp(t)
+			f := newListFixture(t, a, "move@example.com", listPrivate)
+			if status := f.move(t, f.session, f.list.ID, f.items[tc.item], tc.position); status != http.StatusOK {
+				t.Fatalf("status %d", status)
+			}
+			if got := f.order(t); got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReorderList(t *testing.T) {
+	a, _ := newTestApp(t)
+	f := newListFixture(t, a, "reorder@example.com", listPrivate)
+	ids := func(labels string) []int64 {
+		var out []int64
+		for _, label := range labels {
+			out = append(out, f.items[string(label)])
+		}
+		return out
+	}
+
+	for _, tc := range []struct {
+		name   string
+		ids    []int64
+		status int
+		want   string
+	}{
+		{"reversed", ids("EDCBA"), http.StatusOK, "EDCBA"},
+		{"shuffled", ids("CAEBD"), http.StatusOK, "CAEBD"},
+		{"missing item", ids("ABCD"), http.StatusBadRequest, "CAEBD"},
+		{"repeated item", ids("ABCDD"), http.StatusBadRequest, "CAEBD"},
+		{"extra item", append(ids("ABCDE"), 9999), http.StatusBadRequest, "CAEBD"},
+		{"empty", nil, http.StatusBadRequest, "CAEBD"},
+	} {
+		if status := f.reorder(t, f.session, f.list.ID, tc.ids); status != tc.status {
+			t.Fatalf("%s: status %d, want %d", tc.name, status, tc.status)
+		}
+		if got := f.order(t); got != tc.want {
+			t.Fatalf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestListItemsOfOtherUsers(t *testing.T) {
+	a, _ := newTestApp(t)
+	alice := newListFixture(t, a, "alice@example.com", listPublic)
+	bob := newListFixture(t, a, "bob@example.com", listPublic)
+
+	// Bob cannot touch Alice's list, even though he can read it.
+	if status := alice.move(t, bob.session, alice.list.ID, alice.items["A"], 4); status != http.StatusNotFound {
+		t.Fatalf("move in another user's list: status %d", status)
+	}
+	if status := alice.reorder(t, bob.session, alice.list.ID, []int64{alice.items["E"], alice.items["D"], alice.items["C"], alice.items["B"], alice.items["A"]}); status != http.StatusNotFound {
+		t.Fatalf("reorder another user's list: status %d", status)
+	}
+	// Nor use her item ids inside his own list.
+	if status := bob.move(t, bob.session, bob.list.ID, alice.items["A"], 4); status != http.StatusNotFound {
+		t.Fatalf("move another user's item: status %d", status)
+	}
+	mixed := []int64{alice.items["A"], bob.items["B"], bob.items["C"], bob.items["D"], bob.items["E"]}
+	if status := bob.reorder(t, bob.session, bob.list.ID, mixed); status != http.StatusBadRequest {
+		t.Fatalf("reorder with another user's item: status %d", status)
+	}
+
+	if got := alice.order(t); got != "ABCDE" {
+		t.Fatalf("alice's list: got %s", got)
+	}
+	if got := bob.order(t); got != "ABCDE" {
+		t.Fatalf("bob's list: got %s", got)
+	}
+}
+
+func TestListVisibility(t *testing.T) {
+	a, _ := newTestApp(t)
+	byID := func(id int64) int {
+		req := jsonRequest(t, http.MethodGet, "/", "", nil)
+		req.SetPathValue("id", fmt.Sprint(id))
+		return serve(a.handleGetPublicList, req).Code
+	}
+	byToken := func(token string) (ListResponse, int) {
+		req := jsonRequest(t, http.MethodGet, "/", "", nil)
+		req.SetPathValue("token", token)
+		rec := serve(a.handleGetSharedList, req)
+		var out ListResponse
+		if rec.Code == http.StatusOK {
+			decodeBody(t, rec, &out)
+		}
+		return out, rec.Code
+	}
+	update := func(f listFixture, visibility string) ListResponse {
+		t.Helper()
+		req := jsonRequest(t, http.MethodPatch, "/", f.session, ListInput{Visibility: &visibility})
+		req.SetPathValue("id", fmt.Sprint(f.list.ID))
+		rec := serve(a.requireAuth(scopeListsWrite, a.handleUpdateList), req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("make %s: status %d %s", visibility, rec.Code, rec.Body)
+		}
+		var out ListResponse
+		decodeBody(t, rec, &out)
+		return out
+	}
+
+	public := newListFixture(t, a, "public@example.com", listPublic)
+	unlisted := newListFixture(t, a, "unlisted@example.com", listUnlisted)
+	private := newListFixture(t, a, "private@example.com", listPrivate)
+
+	if status := byID(public.list.ID); status != http.StatusOK {
+		t.Fatalf("public list by id: status %d", status)
+	}
+	if status := byID(unlisted.list.ID); status != http.StatusNotFound {
+		t.Fatalf("unlisted list by id: status %d", status)
+	}
+	if status := byID(private.list.ID); status != http.StatusNotFound {
+		t.Fatalf("private list by id: status %d", status)
+	}
+	if public.list.ShareToken != "" || private.list.ShareToken != "" {
+		t.Fatalf("share tokens for public or private lists: %q, %q", public.list.ShareToken, private.list.ShareToken)
+	}
+
+	token := unlisted.list.ShareToken
+	if token == "" || unlisted.list.ShareURL == "" {
+		t.Fatalf("unlisted list without a share link: %+v", unlisted.list)
+	}
+	shared, status := byToken(token)
+	if status != http.StatusOK || len(shared.Items) != 5 || shared.ShareToken != "" || shared.ShareURL != "" {
+		t.Fatalf("unlisted list by token: status %d, %+v", status, shared)
+	}
+	if _, status := byToken(token + "x"); status != http.StatusNotFound {
+		t.Fatalf("wrong token: status %d", status)
+	}
+
+	req := jsonRequest(t, http.MethodPost, "/", unlisted.session, nil)
+	req.SetPathValue("id", fmt.Sprint(unlisted.list.ID))
+	rec := serve(a.requireAuth(scopeListsWrite, a.handleRotateListShareToken), req)
+	var rotated ListResponse
+	decodeBody(t, rec, &rotated)
+	if rotated.ShareToken == "" || rotated.ShareToken == token {
+		t.Fatalf("rotate: got %+v", rotated)
+	}
+	if _, status := byToken(token); status != http.StatusNotFound {
+		t.Fatalf("rotated-out token: status %d", status)
+	}
+	if _, status := byToken(rotated.ShareToken); status != http.StatusOK {
+		t.Fatalf("new token: status %d", status)
+	}
+
+	if made := update(unlisted, listPrivate); made.ShareURL != "" {
+		t.Fatalf("private list still has a share URL: %+v", made)
+	}
+	if _, status := byToken(rotated.ShareToken); status != http.StatusNotFound {
+		t.Fatalf("token of a private list: status %d", status)
+	}
+	if status := byID(update(private, listPublic).ID); status != http.StatusOK {
+		t.Fatalf("list made public: status %d", status)
+	}
+
+	req = jsonRequest(t, http.MethodGet, "/", "", nil)
+	req.SetPathValue("userId", fmt.Sprint(unlisted.list.UserID))
+	rec = serve(a.handleListPublicUserLists, req)
+	var listed []ListResponse
+	decodeBody(t, rec, &listed)
+	if len(listed) != 0 {
+		t.Fatalf("public lists of a user with only an unlisted one: %+v", listed)
+	}
+	req.SetPathValue("userId", fmt.Sprint(public.list.UserID))
+	rec = serve(a.handleListPublicUserLists, req)
+	decodeBody(t, rec, &listed)
+	if len(listed) != 1 || !slices.ContainsFunc(listed, func(l ListResponse) bool { return l.ID == public.list.ID }) {
+		t.Fatalf("public lists: %+v", listed)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional emails. Implementations must be safe for
+// concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg MailMessage) error
+}
+
+// NewMailerFromEnv picks the implementation named by MAILER: "smtp", "file" or
+// "log" (the default, handy for local development).
+func NewMailerFromEnv() (Mailer, error) {
+	from := envOrDefault("MAIL_FROM", "TrackSM <no-reply@tracksm.local>")
+
+	switch strings.ToLower(envOrDefault("MAILER", "log")) {
+	case "smtp":
+		host := envOrDefault("SMTP_HOST", "")
+		if host == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required when MAILER=smtp")
+		}
+		return &SMTPMailer{
+			Addr:     net.JoinHostPort(host, envOrDefault("SMTP_PORT", "587")),
+			Host:     host,
+			Username: envOrDefault("SMTP_USERNAME", ""),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	case "file":
+		return &FileMailer{Dir: envOrDefault("MAIL_DIR", "mail"), From: from}, nil
+	case "log":
+		return &LogMailer{From: from}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q", os.Getenv("MAILER"))
+	}
+}
+
+type SMTPMailer struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg MailMessage) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.Addr, auth, envelopeAddress(m.From), []string{msg.To}, formatMail(m.From, msg))
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed sending mail to %s: %w", msg.To, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FileMailer writes each message as an .eml file, so flows that depend on
+// email can be exercised offline.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg MailMessage) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed creating mail dir: %w", err)
+	}
+
+	suffix, err := randomToken(6)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), suffix)
+	if err := os.WriteFile(filepath.Join(m.Dir, name), formatMail(m.From, msg), 0o600); err != nil {
+		return fmt.Errorf("failed writing mail file: %w", err)
+	}
+	return nil
+}
+
+// linkToken matches the token parameter of reset and verification links.
+var linkToken = regexp.MustCompile(`([?&]token=)[^&\s]+`)
+
+// LogMailer prints messages for local development. Link tokens are redacted
+// because they grant access to the account and logs are kept and read far
+// more widely than inboxes; MAILER=file keeps the full links.
+type LogMailer struct {
+	From string
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg MailMessage) error {
+	body := linkToken.ReplaceAllString(msg.Body, "${1}[redacted]")
+	log.Printf("mail to=%s subject=%q\n%s", msg.To, msg.Subject, body)
+	return nil
+}
+
+func formatMail(from string, msg MailMessage) []byte {
+	var builder strings.Builder
+	builder.WriteString("From: " + headerValue(from) + "\r\n")
+	builder.WriteString("To: " + headerValue(msg.To) + "\r\n")
+	builder.WriteString("Subject: " + headerValue(msg.Subject) + "\r\n")
+	builder.WriteString("Date: " + time.Now().UTC().Format(time.RFC1123Z) + "\r\n")
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(builder.String())
+}
+
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+func envelopeAddress(from string) string {
+	if start := strings.LastIndex(from, "<"); start >= 0 {
+		if end := strings.LastIndex(from, ">"); end > start {
+			return from[start+1 : end]
+		}
+	}
+	return from
+}
+
+// sendMailAsync delivers msg in the background so request latency does not
+// reveal whether an email was actually sent.
+func (a *App) sendMailAsync(msg MailMessage) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := a.mailer.Send(ctx, msg); err != nil {
+			log.Printf("failed delivering %q: %v", msg.Subject, err)
+		}
+	}()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogMailerRedactsLinkTokens(t *testing.T) {
+	var out bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&out)
+	t.Cleanup(func() { log.SetOutput(previous) })
+
+	msg := MailMessage{
+		To:      "ana@example.com",
+		Subject: "Redefinicao de senha - TrackSM",
+		Body:    "Use o link:\n\nhttp://app.test/redefinir-senha?token=s3cr3t-Value_1\n\nou http://app.test/verificar-email?lang=pt&token=other%2Bsecret&x=1\n",
+	}
+	if err := (&LogMailer{}).Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	logged := out.String()
+	for _, secret := range []string{"s3cr3t-Value_1", "other%2Bsecret"} {
+		if strings.Contains(logged, secret) {
+			t.Fatalf("token %q logged:\n%s", secret, logged)
+		}
+	}
+	for _, kept := range []string{"ana@example.com", "/redefinir-senha?token=[redacted]", "token=[redacted]&x=1"} {
+		if !strings.Contains(logged, kept) {
+			t.Fatalf("%q missing from the log:\n%s", kept, logged)
+		}
+	}
+}
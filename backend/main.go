@@ -2,7 +2,9 @@ package main
 
 import (
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,49 +12,28 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 	_ "modernc.org/sqlite"
 )
 
-type Series struct {
-	ID       int     `json:"id"`
-	Title    string  `json:"title"`
-	Overview string  `json:"overview"`
-	Poster   string  `json:"poster"`
-	Seasons  int     `json:"seasons"`
-	Status   string  `json:"status"`
-	Rating   float64 `json:"rating"`
-}
-
-type CreateSeriesInput struct {
-	Title    string  `json:"title"`
-	Overview string  `json:"overview"`
-	Poster   string  `json:"poster"`
-	Seasons  int     `json:"seasons"`
-	Status   string  `json:"status"`
-	Rating   float64 `json:"rating"`
-}
-
-type UpdateSeriesInput struct {
-	Overview *string  `json:"overview"`
-	Poster   *string  `json:"poster"`
-	Seasons  *int     `json:"seasons"`
-	Status   *string  `json:"status"`
-	Rating   *float64 `json:"rating"`
-}
-
-type Store struct {
-	mu     sync.RWMutex
-	nextID int
-	items  []Series
-}
-
 type App struct {
-	store *Store
-	db    *sql.DB
+	store            *Store
+	db               sqliteDB
+	repos            *Repositories
+	sessions         *SessionSigner
+	mailer           Mailer
+	blobs            BlobStore
+	appURL           string
+	apiURL           string
+	trustProxy       bool
+	unverifiedPolicy string
+	bcryptCost       int
+	limits           *AuthRateLimits
+	oidc             map[string]*OIDCProvider
+	tmdb             *TMDBClient
+	ratingScale      int
 }
 
 type RegisterInput struct {
@@ -62,11 +43,14 @@ type RegisterInput struct {
 }
 
 type RegisterResponse struct {
-	ID       int64  `json:"id"`
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Username string `json:"username"`
-	PhotoURL string `json:"photoUrl"`
+	ID            int64  `json:"id"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	Username      string `json:"username"`
+	PhotoURL      string `json:"photoUrl"`
+	EmailVerified bool   `json:"emailVerified"`
+	Token         string `json:"token"`
+	ExpiresAt     string `json:"expiresAt"`
 }
 
 type LoginInput struct {
@@ -75,11 +59,15 @@ type LoginInput struct {
 }
 
 type LoginResponse struct {
-	ID       int64  `json:"id"`
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Username string `json:"username"`
-	PhotoURL string `json:"photoUrl"`
+	ID                int64  `json:"id"`
+	Name              string `json:"name"`
+	Email             string `json:"email"`
+	Username          string `json:"username"`
+	PhotoURL          string `json:"photoUrl"`
+	EmailVerified     bool   `json:"emailVerified"`
+	Token             string `json:"token,omitempty"`
+	ExpiresAt         string `json:"expiresAt,omitempty"`
+	DeletionCancelled bool   `json:"deletionCancelled,omitempty"`
 }
 
 type UpdateProfileInput struct {
@@ -96,6 +84,8 @@ type WatchedInput struct {
 	SeasonNumber  int64  `json:"seasonNumber"`
 	EpisodeNumber int64  `json:"episodeNumber"`
 	WatchedAt     string `json:"watchedAt"`
+	// Rewatch records another viewing instead of redating the latest one.
+	Rewatch bool `json:"rewatch"`
 }
 
 type WatchedItem struct {
@@ -105,80 +95,149 @@ type WatchedItem struct {
 	SeasonNumber  int64  `json:"seasonNumber"`
 	EpisodeNumber int64  `json:"episodeNumber"`
 	WatchedAt     string `json:"watchedAt"`
+	PlayCount     int    `json:"playCount"`
 }
 
+type WatchEventItem struct {
+	ID            int64  `json:"id"`
+	MediaType     string `json:"mediaType"`
+	TmdbID        int64  `json:"tmdbId"`
+	SeasonNumber  int64  `json:"seasonNumber"`
+	EpisodeNumber int64  `json:"episodeNumber"`
+	WatchedAt     string `json:"watchedAt"`
+}
+
+const (
+	defaultWatchedPageSize = 200
+	maxWatchedPageSize     = 1000
+)
+
 var usernamePattern = regexp.MustCompile(`^[a-z0-9._]{3,30}$`)
 
-func NewStore() *Store {
-	return &Store{
-		nextID: 4,
-		items: []Series{
-			{
-				ID:       1,
-				Title:    "Breaking Bad",
-				Overview: "Professor de química vira produtor de metanfetamina.",
-				Poster:   "https://image.tmdb.org/t/p/w500/ztkUQFLlC19CCMYHW9o1zWhJRNq.jpg",
-				Seasons:  5,
-				Status:   "completed",
-				Rating:   9.5,
-			},
-			{
-				ID:       2,
-				Title:    "Severance",
-				Overview: "Funcionários separam memórias pessoais e de trabalho.",
-				Poster:   "https://image.tmdb.org/t/p/w500/lF4M1taK9Q4S3mM7Qv7v6V5T4Qf.jpg",
-				Seasons:  2,
-				Status:   "watching",
-				Rating:   9.0,
-			},
-			{
-				ID:       3,
-				Title:    "Dark",
-				Overview: "Mistérios temporais em uma cidade alemã.",
-				Poster:   "https://image.tmdb.org/t/p/w500/5Lo5fY2R8xk3Q4zNwJ2Y8Q6kU2q.jpg",
-				Seasons:  3,
-				Status:   "planned",
-				Rating:   8.8,
-			},
-		},
+func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-}
 
-func main() {
-	store := NewStore()
 	db, err := openDatabase()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
-	if err := ensureUsersTable(db); err != nil {
+	repos, err := openRepositories(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer repos.Close()
+
+	if err := migrateDatabase(db); err != nil {
+		log.Fatal(err)
+	}
+
+	mailer, err := NewMailerFromEnv()
+	if err != nil {
 		log.Fatal(err)
 	}
-	if err := ensureUsersProfileColumns(db); err != nil {
+	oidcProviders, err := loadOIDCProviders()
+	if err != nil {
 		log.Fatal(err)
 	}
-	if err := ensureWatchedTable(db); err != nil {
+	blobs, err := NewDiskBlobStore(envOrDefault("BLOB_DIR", "uploads"))
+	if err != nil {
 		log.Fatal(err)
 	}
 
-	app := &App{store: store, db: db}
+	app := &App{
+		store:            NewStore(db, repos.Watched),
+		db:               sqliteDB{db},
+		repos:            repos,
+		sessions:         NewSessionSigner(),
+		mailer:           mailer,
+		blobs:            blobs,
+		appURL:           strings.TrimRight(envOrDefault("APP_URL", "http://localhost:3000"), "/"),
+		apiURL:           strings.TrimRight(envOrDefault("API_URL", "http://localhost:8080"), "/"),
+		trustProxy:       envBoolOrDefault("TRUST_PROXY", false),
+		unverifiedPolicy: unverifiedPolicyFromEnv(),
+		bcryptCost:       bcryptCostFromEnv(),
+		limits:           NewAuthRateLimits(NewMemoryAttemptStore(48 * time.Hour)),
+		oidc:             oidcProviders,
+		tmdb:             newTMDBClient(),
+		ratingScale:      ratingScaleFromEnv(),
+	}
+	go app.runDeletionPurger(envDurationOrDefault("ACCOUNT_PURGE_INTERVAL", time.Hour))
+	if interval := envDurationOrDefault("BACKUP_INTERVAL", 0); interval > 0 {
+		go app.runBackupScheduler(envOrDefault("BACKUP_DIR", "backups"), interval, envIntOrDefault("BACKUP_KEEP", 7))
+	}
+
 	mux := http.NewServeMux()
 
+	mux.HandleFunc("GET /media/{key...}", app.handleMedia)
 	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 	})
 
-	mux.HandleFunc("GET /api/series", app.store.handleListSeries)
-	mux.HandleFunc("POST /api/series", app.store.handleCreateSeries)
-	mux.HandleFunc("PATCH /api/series/", app.store.handlePatchSeries)
-	mux.HandleFunc("DELETE /api/series/", app.store.handleDeleteSeries)
+	mux.HandleFunc("GET /api/series", app.requireAuth(scopeSeriesRead, app.store.handleListSeries))
+	mux.HandleFunc("POST /api/series", app.requireAuth(scopeSeriesWrite, app.requireVerified(app.store.handleCreateSeries)))
+	mux.HandleFunc("PATCH /api/series/", app.requireAuth(scopeSeriesWrite, app.requireVerified(app.store.handlePatchSeries)))
+	mux.HandleFunc("DELETE /api/series/", app.requireAuth(scopeSeriesWrite, app.requireVerified(app.store.handleDeleteSeries)))
 	mux.HandleFunc("POST /api/auth/register", app.handleRegister)
 	mux.HandleFunc("POST /api/auth/login", app.handleLogin)
-	mux.HandleFunc("PATCH /api/auth/profile", app.handleUpdateProfile)
-	mux.HandleFunc("GET /api/user/watched", app.handleListWatched)
-	mux.HandleFunc("POST /api/user/watched", app.handleUpsertWatched)
-	mux.HandleFunc("DELETE /api/user/watched", app.handleDeleteWatched)
+	mux.HandleFunc("POST /api/auth/login/2fa", app.handleTwoFactorLogin)
+	mux.HandleFunc("GET /api/auth/oidc/providers", app.handleListOIDCProviders)
+	mux.HandleFunc("POST /api/auth/oidc/{provider}/start", app.handleStartOIDC)
+	mux.HandleFunc("POST /api/auth/oidc/{provider}/callback", app.handleOIDCCallback)
+	mux.HandleFunc("DELETE /api/auth/account", app.requireAuth(scopeSessionOnly, app.handleDeleteAccount))
+	mux.HandleFunc("POST /api/auth/password", app.requireAuth(scopeSessionOnly, app.handleChangePassword))
+	mux.HandleFunc("POST /api/auth/password/forgot", app.handleForgotPassword)
+	mux.HandleFunc("POST /api/auth/password/reset", app.handleResetPassword)
+	mux.HandleFunc("PATCH /api/auth/profile", app.requireAuth(scopeProfileWrite, app.requireVerified(app.handleUpdateProfile)))
+	mux.HandleFunc("POST /api/auth/avatar", app.requireAuth(scopeProfileWrite, app.requireVerified(app.handleUploadAvatar)))
+	mux.HandleFunc("GET /api/auth/profile/2fa", app.requireAuth(scopeSessionOnly, app.handleTwoFactorStatus))
+	mux.HandleFunc("POST /api/auth/profile/2fa/setup", app.requireAuth(scopeSessionOnly, app.handleTwoFactorSetup))
+	mux.HandleFunc("POST /api/auth/profile/2fa/enable", app.requireAuth(scopeSessionOnly, app.handleTwoFactorEnable))
+	mux.HandleFunc("POST /api/auth/profile/2fa/disable", app.requireAuth(scopeSessionOnly, app.handleTwoFactorDisable))
+	mux.HandleFunc("POST /api/auth/profile/2fa/recovery-codes", app.requireAuth(scopeSessionOnly, app.handleRegenerateRecoveryCodes))
+	mux.HandleFunc("POST /api/auth/email/verify", app.handleVerifyEmail)
+	mux.HandleFunc("POST /api/auth/email/resend", app.handleResendVerification)
+	mux.HandleFunc("POST /api/auth/email/change", app.requireAuth(scopeSessionOnly, app.handleChangeEmail))
+	mux.HandleFunc("GET /api/auth/sessions", app.requireAuth(scopeSessionOnly, app.handleListSessions))
+	mux.HandleFunc("DELETE /api/auth/sessions/{id}", app.requireAuth(scopeSessionOnly, app.handleDeleteSession))
+	mux.HandleFunc("GET /api/auth/tokens", app.requireAuth(scopeSessionOnly, app.handleListAccessTokens))
+	mux.HandleFunc("POST /api/auth/tokens", app.requireAuth(scopeSessionOnly, app.handleCreateAccessToken))
+	mux.HandleFunc("DELETE /api/auth/tokens/{id}", app.requireAuth(scopeSessionOnly, app.handleDeleteAccessToken))
+	mux.HandleFunc("POST /api/auth/logout", app.requireAuth(scopeSessionOnly, app.handleLogout))
+	mux.HandleFunc("POST /api/auth/logout-all", app.requireAuth(scopeSessionOnly, app.handleLogoutAll))
+	mux.HandleFunc("GET /api/user/watched", app.requireAuth(scopeWatchedRead, app.handleListWatched))
+	mux.HandleFunc("POST /api/user/watched", app.requireAuth(scopeWatchedWrite, app.requireVerified(app.handleUpsertWatched)))
+	mux.HandleFunc("DELETE /api/user/watched", app.requireAuth(scopeWatchedWrite, app.requireVerified(app.handleDeleteWatched)))
+	mux.HandleFunc("POST /api/user/watched/batch", app.requireAuth(scopeWatchedWrite, app.requireVerified(app.handleBatchWatched)))
+	mux.HandleFunc("GET /api/user/watched/events", app.requireAuth(scopeWatchedRead, app.handleListWatchEvents))
+	mux.HandleFunc("DELETE /api/user/watched/events/{id}", app.requireAuth(scopeWatchedWrite, app.requireVerified(app.handleDeleteWatchEvent)))
+	mux.HandleFunc("GET /api/user/titles", app.requireAuth(scopeWatchedRead, app.handleListTitleStatuses))
+	mux.HandleFunc("GET /api/user/titles/{mediaType}/{tmdbId}", app.requireAuth(scopeWatchedRead, app.handleGetTitleStatus))
+	mux.HandleFunc("PUT /api/user/titles/{mediaType}/{tmdbId}", app.requireAuth(scopeWatchedWrite, app.requireVerified(app.handleSetTitleStatus)))
+	mux.HandleFunc("DELETE /api/user/titles/{mediaType}/{tmdbId}", app.requireAuth(scopeWatchedWrite, app.requireVerified(app.handleDeleteTitleStatus)))
+	mux.HandleFunc("PUT /api/user/ratings", app.requireAuth(scopeRatingsWrite, app.requireVerified(app.handleSetRating)))
+	mux.HandleFunc("DELETE /api/user/ratings", app.requireAuth(scopeRatingsWrite, app.requireVerified(app.handleDeleteRating)))
+	mux.HandleFunc("GET /api/users/{userId}/ratings", app.requireAuth(scopeRatingsRead, app.handleListUserRatings))
+	mux.HandleFunc("GET /api/ratings/{mediaType}/{tmdbId}", app.requireAuth(scopeRatingsRead, app.handleRatingSummaries))
+	mux.HandleFunc("GET /api/user/lists", app.requireAuth(scopeListsRead, app.handleListOwnLists))
+	mux.HandleFunc("POST /api/user/lists", app.requireAuth(scopeListsWrite, app.requireVerified(app.handleCreateList)))
+	mux.HandleFunc("GET /api/user/lists/{id}", app.requireAuth(scopeListsRead, app.handleGetOwnList))
+	mux.HandleFunc("PATCH /api/user/lists/{id}", app.requireAuth(scopeListsWrite, app.requireVerified(app.handleUpdateList)))
+	mux.HandleFunc("DELETE /api/user/lists/{id}", app.requireAuth(scopeListsWrite, app.requireVerified(app.handleDeleteList)))
+	mux.HandleFunc("POST /api/user/lists/{id}/share-token", app.requireAuth(scopeListsWrite, app.requireVerified(app.handleRotateListShareToken)))
+	mux.HandleFunc("PUT /api/user/lists/{id}/order", app.requireAuth(scopeListsWrite, app.requireVerified(app.handleReorderList)))
+	mux.HandleFunc("POST /api/user/lists/{id}/items", app.requireAuth(scopeListsWrite, app.requireVerified(app.handleAddListItem)))
+	mux.HandleFunc("PATCH /api/user/lists/{id}/items/{itemId}", app.requireAuth(scopeListsWrite, app.requireVerified(app.handleUpdateListItem)))
+	mux.HandleFunc("DELETE /api/user/lists/{id}/items/{itemId}", app.requireAuth(scopeListsWrite, app.requireVerified(app.handleDeleteListItem)))
+	mux.HandleFunc("GET /api/lists/{id}", app.handleGetPublicList)
+	mux.HandleFunc("GET /api/lists/shared/{token}", app.handleGetSharedList)
+	mux.HandleFunc("GET /api/users/{userId}/lists", app.handleListPublicUserLists)
 
 	addr := ":8080"
 	log.Printf("API running on http://localhost%s", addr)
@@ -187,21 +246,7 @@ func main() {
 	}
 }
 
-func openDatabase() (*sql.DB, error) {
-	dbPath := envOrDefault("DB_PATH", "tracksm.db")
-	db, err := sql.Open("sqlite", dbPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed opening sqlite: %w", err)
-	}
-
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed pinging sqlite: %w", err)
-	}
-
-	return db, nil
-}
-
-func ensureUsersTable(db *sql.DB) error {
+func ensureUsersTable(tx *sql.Tx) error {
 	query := `
     CREATE TABLE IF NOT EXISTS users (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -212,36 +257,28 @@ func ensureUsersTable(db *sql.DB) error {
     );
     `
 
-	if _, err := db.Exec(query); err != nil {
+	if _, err := tx.Exec(query); err != nil {
 		return fmt.Errorf("failed creating users table: %w", err)
 	}
 
 	return nil
 }
 
-func ensureUsersProfileColumns(db *sql.DB) error {
-	if err := addUsersColumnIfMissing(db, "username", "TEXT"); err != nil {
+func ensureUsersProfileColumns(tx *sql.Tx) error {
+	if err := addUsersColumnIfMissing(tx, "username", "TEXT"); err != nil {
 		return err
 	}
-	if err := addUsersColumnIfMissing(db, "photo_url", "TEXT NOT NULL DEFAULT ''"); err != nil {
+	if err := addUsersColumnIfMissing(tx, "photo_url", "TEXT NOT NULL DEFAULT ''"); err != nil {
 		return err
 	}
 	return nil
 }
 
-func addUsersColumnIfMissing(db *sql.DB, columnName string, columnDef string) error {
-	query := fmt.Sprintf("ALTER TABLE users ADD COLUMN %s %s", columnName, columnDef)
-	if _, err := db.Exec(query); err != nil {
-		lower := strings.ToLower(err.Error())
-		if strings.Contains(lower, "duplicate column name") {
-			return nil
-		}
-		return fmt.Errorf("failed adding users.%s column: %w", columnName, err)
-	}
-	return nil
+func addUsersColumnIfMissing(tx *sql.Tx, columnName string, columnDef string) error {
+	return addColumnIfMissing(tx, "users", columnName, columnDef)
 }
 
-func ensureWatchedTable(db *sql.DB) error {
+func ensureWatchedTable(tx *sql.Tx) error {
 	query := `
     CREATE TABLE IF NOT EXISTS watched_items (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -255,13 +292,90 @@ func ensureWatchedTable(db *sql.DB) error {
     );
     `
 
-	if _, err := db.Exec(query); err != nil {
+	if _, err := tx.Exec(query); err != nil {
 		return fmt.Errorf("failed creating watched_items table: %w", err)
 	}
 
 	return nil
 }
 
+// ensureWatchedForeignKey rebuilds watched_items with a cascading foreign key
+// to users, which SQLite cannot add to an existing table, dropping rows left
+// behind by deleted accounts on the way.
+func ensureWatchedForeignKey(tx *sql.Tx) error {
+	result, err := tx.Exec("DELETE FROM watched_items WHERE user_id NOT IN (SELECT id FROM users)")
+	if err != nil {
+		return fmt.Errorf("failed deleting orphan watched_items: %w", err)
+	}
+	if orphans, _ := result.RowsAffected(); orphans > 0 {
+		log.Printf("deleted %d orphan watched_items row(s)", orphans)
+	}
+
+	query := `
+    CREATE TABLE watched_items_new (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        media_type TEXT NOT NULL,
+        tmdb_id INTEGER NOT NULL,
+        season_number INTEGER NOT NULL DEFAULT 0,
+        episode_number INTEGER NOT NULL DEFAULT 0,
+        watched_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE(user_id, media_type, tmdb_id, season_number, episode_number)
+    );
+    INSERT INTO watched_items_new (id, user_id, media_type, tmdb_id, season_number, episode_number, watched_at)
+        SELECT id, user_id, media_type, tmdb_id, season_number, episode_number, watched_at FROM watched_items;
+    DROP TABLE watched_items;
+    ALTER TABLE watched_items_new RENAME TO watched_items;
+    `
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed rebuilding watched_items: %w", err)
+	}
+
+	// Covers the history listing, newest first, without touching the table.
+	if _, err := tx.Exec(
+		`CREATE INDEX idx_watched_user_media_watched_at
+         ON watched_items(user_id, media_type, watched_at DESC, tmdb_id, season_number, episode_number)`,
+	); err != nil {
+		return fmt.Errorf("failed indexing watched_items: %w", err)
+	}
+	return nil
+}
+
+// ensureWatchEvents replaces watched_items, which held one row per item and
+// overwrote watched_at on a rewatch, with watch_events, which holds one row
+// per viewing. Every existing row becomes its item's only event.
+func ensureWatchEvents(tx *sql.Tx) error {
+	query := `
+    CREATE TABLE watch_events (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        media_type TEXT NOT NULL,
+        tmdb_id INTEGER NOT NULL,
+        season_number INTEGER NOT NULL DEFAULT 0,
+        episode_number INTEGER NOT NULL DEFAULT 0,
+        watched_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+    INSERT INTO watch_events (id, user_id, media_type, tmdb_id, season_number, episode_number, watched_at)
+        SELECT id, user_id, media_type, tmdb_id, season_number, episode_number, watched_at FROM watched_items;
+    DROP TABLE watched_items;
+    `
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed creating watch_events: %w", err)
+	}
+
+	// The first index serves play counts and per-item lookups, the second
+	// the viewing history, newest first.
+	if _, err := tx.Exec(`
+    CREATE INDEX idx_watch_events_item
+        ON watch_events(user_id, media_type, tmdb_id, season_number, episode_number, watched_at);
+    CREATE INDEX idx_watch_events_user_media_watched_at
+        ON watch_events(user_id, media_type, watched_at DESC);
+    `); err != nil {
+		return fmt.Errorf("failed indexing watch_events: %w", err)
+	}
+	return nil
+}
+
 func normalizeUsernameInput(raw string) (string, error) {
 	normalized := strings.ToLower(strings.TrimSpace(raw))
 	normalized = strings.TrimPrefix(normalized, "@")
@@ -271,19 +385,14 @@ func normalizeUsernameInput(raw string) (string, error) {
 	return normalized, nil
 }
 
-func usernameTaken(db *sql.DB, username string, excludeUserID int64) (bool, error) {
-	query := "SELECT COUNT(1) FROM users WHERE lower(coalesce(username, '')) = lower(?)"
-	args := []any{username}
-	if excludeUserID > 0 {
-		query += " AND id <> ?"
-		args = append(args, excludeUserID)
+func validatePassword(password string) error {
+	if len(password) < 6 {
+		return fmt.Errorf("password must have at least 6 characters")
 	}
-
-	var count int
-	if err := db.QueryRow(query, args...).Scan(&count); err != nil {
-		return false, err
+	if len(password) > 72 {
+		return fmt.Errorf("password must have at most 72 characters")
 	}
-	return count > 0, nil
+	return nil
 }
 
 func usernameBaseFromNameOrEmail(name string, email string) string {
@@ -315,7 +424,7 @@ func usernameBaseFromNameOrEmail(name string, email string) string {
 	return base
 }
 
-func generateAvailableUsername(db *sql.DB, base string, excludeUserID int64) (string, error) {
+func generateAvailableUsername(users UserRepository, base string, excludeUserID int64) (string, error) {
 	if base == "" {
 		base = "user"
 	}
@@ -323,7 +432,7 @@ func generateAvailableUsername(db *sql.DB, base string, excludeUserID int64) (st
 	candidate := base
 	suffix := 1
 	for {
-		taken, err := usernameTaken(db, candidate, excludeUserID)
+		taken, err := users.UsernameTaken(candidate, excludeUserID)
 		if err != nil {
 			return "", err
 		}
@@ -343,45 +452,54 @@ func (a *App) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	in.Name = strings.TrimSpace(in.Name)
-	in.Email = strings.ToLower(strings.TrimSpace(in.Email))
 
 	if in.Name == "" {
 		writeError(w, http.StatusBadRequest, "name is required")
 		return
 	}
 
-	if in.Email == "" || !strings.Contains(in.Email, "@") {
-		writeError(w, http.StatusBadRequest, "valid email is required")
+	email, err := normalizeEmail(in.Email)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	in.Email = email
 
-	if len(in.Password) < 6 {
-		writeError(w, http.StatusBadRequest, "password must have at least 6 characters")
+	now := time.Now()
+	ip := a.clientIP(r)
+	if wait := max(a.limits.registerEmail.Retry(in.Email, now), a.limits.registerIP.Retry(ip, now)); wait > 0 {
+		writeTooManyRequests(w, wait)
 		return
 	}
+	a.limits.registerEmail.Record(in.Email, now)
+	a.limits.registerIP.Record(ip, now)
 
-	hashBytes, err := bcrypt.GenerateFromPassword([]byte(in.Password), bcrypt.DefaultCost)
+	if err := validatePassword(in.Password); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	passwordHash, err := a.hashPassword(in.Password)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to process password")
 		return
 	}
 
 	usernameBase := usernameBaseFromNameOrEmail(in.Name, in.Email)
-	username, err := generateAvailableUsername(a.db, usernameBase, 0)
+	username, err := generateAvailableUsername(a.repos.Users, usernameBase, 0)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to prepare username")
 		return
 	}
 
-	result, err := a.db.Exec(
-		"INSERT INTO users (name, email, password_hash, username, photo_url) VALUES (?, ?, ?, ?, '')",
-		in.Name,
-		in.Email,
-		string(hashBytes),
-		username,
-	)
-	if err != nil {
-		if strings.Contains(strings.ToLower(err.Error()), "unique constraint failed: users.email") {
+	user := User{
+		Name:         in.Name,
+		Email:        in.Email,
+		PasswordHash: passwordHash,
+		Username:     username,
+	}
+	if err := a.repos.Users.Create(&user); err != nil {
+		if errors.Is(err, errEmailTaken) {
 			writeError(w, http.StatusConflict, "email already registered")
 			return
 		}
@@ -390,14 +508,29 @@ func (a *App) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, _ := result.LastInsertId()
-	writeJSON(w, http.StatusCreated, RegisterResponse{
+	id := user.ID
+	if err := a.sendEmailToken(id, in.Name, in.Email, emailTokenVerify); err != nil {
+		log.Printf("failed sending verification email to user %d: %v", id, err)
+	}
+
+	response := RegisterResponse{
 		ID:       id,
 		Name:     in.Name,
 		Email:    in.Email,
 		Username: username,
 		PhotoURL: "",
-	})
+	}
+	if a.unverifiedPolicy != unverifiedBlock {
+		token, expiresAt, err := a.createSession(r, id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to create session")
+			return
+		}
+		response.Token = token
+		response.ExpiresAt = expiresAt
+	}
+
+	writeJSON(w, http.StatusCreated, response)
 }
 
 func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -414,20 +547,20 @@ func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var (
-		id           int64
-		name         string
-		email        string
-		passwordHash string
-		username     sql.NullString
-		photoURL     sql.NullString
-	)
+	now := time.Now()
+	ip := a.clientIP(r)
+	if wait := max(a.limits.loginEmail.Retry(in.Email, now), a.limits.loginIP.Retry(ip, now)); wait > 0 {
+		writeTooManyRequests(w, wait)
+		return
+	}
+	recordFailure := func() {
+		a.limits.loginEmail.Record(in.Email, now)
+		a.limits.loginIP.Record(ip, now)
+	}
 
-	err := a.db.QueryRow(
-		"SELECT id, name, email, password_hash, username, photo_url FROM users WHERE email = ? LIMIT 1",
-		in.Email,
-	).Scan(&id, &name, &email, &passwordHash, &username, &photoURL)
-	if err == sql.ErrNoRows {
+	user, err := a.repos.Users.ByEmail(in.Email)
+	if errors.Is(err, errNotFound) {
+		recordFailure()
 		writeError(w, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
@@ -435,28 +568,84 @@ func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "failed to authenticate user")
 		return
 	}
+	id := user.ID
 
-	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(in.Password)); err != nil {
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(in.Password)); err != nil {
+		recordFailure()
 		writeError(w, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
+	a.limits.loginEmail.Reset(in.Email)
+	a.upgradePasswordHash(id, user.PasswordHash, in.Password)
+
+	if !user.EmailVerified && a.unverifiedPolicy == unverifiedBlock {
+		writeError(w, http.StatusForbidden, "email not verified")
+		return
+	}
+
+	enabled, err := a.twoFactorEnabled(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to authenticate user")
+		return
+	}
+	if enabled {
+		challenge, expiresAt := a.sessions.signChallenge(id, time.Now().UTC())
+		writeJSON(w, http.StatusOK, TwoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+			ExpiresAt:         expiresAt,
+		})
+		return
+	}
+
+	a.writeLoginSuccess(w, r, id)
+}
+
+// writeLoginSuccess opens a session for an already authenticated user and
+// writes the LoginResponse.
+func (a *App) writeLoginSuccess(w http.ResponseWriter, r *http.Request, id int64) {
+	user, err := a.repos.Users.ByID(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to authenticate user")
+		return
+	}
 
-	resolvedUsername := strings.TrimSpace(username.String)
+	cancelled, err := a.cancelScheduledDeletion(id)
+	if errors.Is(err, errDeletionDue) {
+		writeError(w, http.StatusForbidden, "account is being deleted")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to authenticate user")
+		return
+	}
+
+	resolvedUsername := strings.TrimSpace(user.Username)
 	if resolvedUsername == "" {
-		usernameBase := usernameBaseFromNameOrEmail(name, email)
-		generatedUsername, genErr := generateAvailableUsername(a.db, usernameBase, id)
+		usernameBase := usernameBaseFromNameOrEmail(user.Name, user.Email)
+		generatedUsername, genErr := generateAvailableUsername(a.repos.Users, usernameBase, id)
 		if genErr == nil {
 			resolvedUsername = generatedUsername
-			_, _ = a.db.Exec("UPDATE users SET username = ? WHERE id = ?", resolvedUsername, id)
+			_ = a.repos.Users.SetUsername(id, resolvedUsername)
 		}
 	}
 
+	token, expiresAt, err := a.createSession(r, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create session")
+		return
+	}
+
 	writeJSON(w, http.StatusOK, LoginResponse{
-		ID:       id,
-		Name:     name,
-		Email:    email,
-		Username: resolvedUsername,
-		PhotoURL: strings.TrimSpace(photoURL.String),
+		ID:                id,
+		Name:              user.Name,
+		Email:             user.Email,
+		Username:          resolvedUsername,
+		PhotoURL:          strings.TrimSpace(user.PhotoURL),
+		EmailVerified:     user.EmailVerified,
+		Token:             token,
+		ExpiresAt:         expiresAt,
+		DeletionCancelled: cancelled,
 	})
 }
 
@@ -470,10 +659,13 @@ func (a *App) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
 	in.Name = strings.TrimSpace(in.Name)
 	in.PhotoURL = strings.TrimSpace(in.PhotoURL)
 
-	if in.UserID <= 0 {
-		writeError(w, http.StatusBadRequest, "userId is required")
+	userID, err := resolveUserID(r, in.UserID)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
 		return
 	}
+	in.UserID = userID
+
 	if in.Name == "" {
 		writeError(w, http.StatusBadRequest, "name is required")
 		return
@@ -482,18 +674,13 @@ func (a *App) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "name is too long")
 		return
 	}
-	if len(in.PhotoURL) > 450000 {
-		writeError(w, http.StatusBadRequest, "photoUrl is too long")
-		return
-	}
-
 	username, err := normalizeUsernameInput(in.Username)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	taken, err := usernameTaken(a.db, username, in.UserID)
+	taken, err := a.repos.Users.UsernameTaken(username, in.UserID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to validate username")
 		return
@@ -503,36 +690,41 @@ func (a *App) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := a.db.Exec(
-		"UPDATE users SET name = ?, username = ?, photo_url = ? WHERE id = ?",
-		in.Name,
-		username,
-		in.PhotoURL,
-		in.UserID,
-	)
+	user, err := a.repos.Users.ByID(in.UserID)
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to update profile")
+		writeError(w, http.StatusInternalServerError, "failed to load user profile")
 		return
 	}
-
-	affected, _ := result.RowsAffected()
-	if affected == 0 {
-		writeError(w, http.StatusNotFound, "user not found")
+	// Photos only come from handleUploadAvatar; here they can be kept or removed.
+	if in.PhotoURL != "" && in.PhotoURL != user.PhotoURL {
+		writeError(w, http.StatusBadRequest, "photoUrl can only be kept or cleared, upload a photo to change it")
 		return
 	}
 
-	var email string
-	if queryErr := a.db.QueryRow("SELECT email FROM users WHERE id = ? LIMIT 1", in.UserID).Scan(&email); queryErr != nil {
-		writeError(w, http.StatusInternalServerError, "failed to load user profile")
+	updated, err := a.repos.Users.UpdateProfile(in.UserID, in.Name, username, in.PhotoURL)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update profile")
+		return
+	}
+	if !updated {
+		writeError(w, http.StatusNotFound, "user not found")
 		return
 	}
+	if user.PhotoURL != in.PhotoURL {
+		a.removeAvatar(in.UserID, user.PhotoURL)
+	}
 
 	writeJSON(w, http.StatusOK, LoginResponse{
-		ID:       in.UserID,
-		Name:     in.Name,
-		Email:    email,
-		Username: username,
-		PhotoURL: in.PhotoURL,
+		ID:            in.UserID,
+		Name:          in.Name,
+		Email:         user.Email,
+		Username:      username,
+		PhotoURL:      in.PhotoURL,
+		EmailVerified: user.EmailVerified,
 	})
 }
 
@@ -559,10 +751,10 @@ func normalizeWatchedInput(in *WatchedInput) error {
 	return nil
 }
 
-func normalizeWatchedAt(raw string) (string, error) {
+func normalizeWatchedAt(raw string) (time.Time, error) {
 	trimmed := strings.TrimSpace(raw)
 	if trimmed == "" {
-		return time.Now().UTC().Format("2006-01-02 15:04:05"), nil
+		return time.Now().UTC().Truncate(time.Second), nil
 	}
 
 	layouts := []string{
@@ -579,10 +771,10 @@ func normalizeWatchedAt(raw string) (string, error) {
 		if layout == "2006-01-02" {
 			parsed = time.Date(parsed.Year(), parsed.Month(), parsed.Day(), 12, 0, 0, 0, time.UTC)
 		}
-		return parsed.UTC().Format("2006-01-02 15:04:05"), nil
+		return parsed.UTC().Truncate(time.Second), nil
 	}
 
-	return "", fmt.Errorf("invalid watchedAt")
+	return time.Time{}, fmt.Errorf("invalid watchedAt")
 }
 
 func (a *App) handleUpsertWatched(w http.ResponseWriter, r *http.Request) {
@@ -592,6 +784,13 @@ func (a *App) handleUpsertWatched(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userID, err := resolveUserID(r, in.UserID)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	in.UserID = userID
+
 	if err := normalizeWatchedInput(&in); err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
@@ -602,24 +801,38 @@ func (a *App) handleUpsertWatched(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = a.db.Exec(
-		`INSERT INTO watched_items (user_id, media_type, tmdb_id, season_number, episode_number, watched_at)
-         VALUES (?, ?, ?, ?, ?, ?)
-         ON CONFLICT(user_id, media_type, tmdb_id, season_number, episode_number)
-         DO UPDATE SET watched_at = excluded.watched_at`,
-		in.UserID,
-		in.MediaType,
-		in.TmdbID,
-		in.SeasonNumber,
-		in.EpisodeNumber,
-		watchedAt,
-	)
+	ev := WatchEvent{
+		UserID:        in.UserID,
+		MediaType:     in.MediaType,
+		TmdbID:        in.TmdbID,
+		SeasonNumber:  in.SeasonNumber,
+		EpisodeNumber: in.EpisodeNumber,
+		WatchedAt:     watchedAt,
+	}
+	if in.Rewatch {
+		err = a.repos.Watched.AddEvent(&ev)
+	} else {
+		err = a.repos.Watched.SetLatest(&ev)
+	}
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to save watched status")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	records, err := a.repos.Watched.List(WatchedFilter{
+		UserID:        ev.UserID,
+		MediaType:     ev.MediaType,
+		TmdbID:        ev.TmdbID,
+		SeasonNumber:  &ev.SeasonNumber,
+		EpisodeNumber: &ev.EpisodeNumber,
+	})
+	if err != nil || len(records) == 0 {
+		writeError(w, http.StatusInternalServerError, "failed to load watched status")
+		return
+	}
+	a.inferTitleStatus(ev.UserID, ev.MediaType, ev.TmdbID, true)
+
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "eventId": ev.ID, "playCount": records[0].PlayCount})
 }
 
 func (a *App) handleDeleteWatched(w http.ResponseWriter, r *http.Request) {
@@ -629,39 +842,62 @@ func (a *App) handleDeleteWatched(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userID, err := resolveUserID(r, in.UserID)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	in.UserID = userID
+
 	if err := normalizeWatchedInput(&in); err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	_, err := a.db.Exec(
-		`DELETE FROM watched_items
-         WHERE user_id = ? AND media_type = ? AND tmdb_id = ? AND season_number = ? AND episode_number = ?`,
-		in.UserID,
-		in.MediaType,
-		in.TmdbID,
-		in.SeasonNumber,
-		in.EpisodeNumber,
-	)
+	deleted, err := a.repos.Watched.Delete(WatchedRecord{
+		UserID:        in.UserID,
+		MediaType:     in.MediaType,
+		TmdbID:        in.TmdbID,
+		SeasonNumber:  in.SeasonNumber,
+		EpisodeNumber: in.EpisodeNumber,
+	})
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to delete watched status")
 		return
 	}
+	if deleted {
+		a.inferTitleStatus(in.UserID, in.MediaType, in.TmdbID, false)
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (a *App) handleListWatched(w http.ResponseWriter, r *http.Request) {
-	userID, err := strconv.ParseInt(strings.TrimSpace(r.URL.Query().Get("userId")), 10, 64)
-	if err != nil || userID <= 0 {
-		writeError(w, http.StatusBadRequest, "userId is required")
-		return
+// watchedFilterFromQuery reads the userId, mediaType, tmdbId, seasonNumber,
+// episodeNumber, from and to query parameters shared by the watched listings.
+func watchedFilterFromQuery(w http.ResponseWriter, r *http.Request) (WatchedFilter, bool) {
+	var claimedUserID int64
+	if userIDRaw := strings.TrimSpace(r.URL.Query().Get("userId")); userIDRaw != "" {
+		parsedUserID, parseErr := strconv.ParseInt(userIDRaw, 10, 64)
+		if parseErr != nil || parsedUserID <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid userId")
+			return WatchedFilter{}, false
+		}
+		claimedUserID = parsedUserID
+	}
+	userID, err := resolveUserID(r, claimedUserID)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return WatchedFilter{}, false
 	}
 
 	mediaType := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mediaType")))
-	if mediaType != "movie" && mediaType != "tv" {
-		writeError(w, http.StatusBadRequest, "mediaType must be movie or tv")
-		return
+	switch mediaType {
+	case "movie", "tv":
+	case "all":
+		mediaType = ""
+	default:
+		writeError(w, http.StatusBadRequest, "mediaType must be movie, tv or all")
+		return WatchedFilter{}, false
 	}
 
 	tmdbRaw := strings.TrimSpace(r.URL.Query().Get("tmdbId"))
@@ -670,7 +906,7 @@ func (a *App) handleListWatched(w http.ResponseWriter, r *http.Request) {
 		parsedTmdbID, parseErr := strconv.ParseInt(tmdbRaw, 10, 64)
 		if parseErr != nil || parsedTmdbID <= 0 {
 			writeError(w, http.StatusBadRequest, "invalid tmdbId")
-			return
+			return WatchedFilter{}, false
 		}
 		tmdbID = parsedTmdbID
 	}
@@ -678,228 +914,259 @@ func (a *App) handleListWatched(w http.ResponseWriter, r *http.Request) {
 	seasonRaw := strings.TrimSpace(r.URL.Query().Get("seasonNumber"))
 	episodeRaw := strings.TrimSpace(r.URL.Query().Get("episodeNumber"))
 
-	query := `SELECT user_id, media_type, tmdb_id, season_number, episode_number, watched_at
-              FROM watched_items
-              WHERE user_id = ? AND media_type = ?`
-	args := []any{userID, mediaType}
-
-	if tmdbID > 0 {
-		query += " AND tmdb_id = ?"
-		args = append(args, tmdbID)
-	}
+	filter := WatchedFilter{UserID: userID, MediaType: mediaType, TmdbID: tmdbID}
 
 	if seasonRaw != "" {
 		seasonNumber, parseErr := strconv.ParseInt(seasonRaw, 10, 64)
 		if parseErr != nil || seasonNumber < 0 {
 			writeError(w, http.StatusBadRequest, "invalid seasonNumber")
-			return
+			return WatchedFilter{}, false
 		}
-		query += " AND season_number = ?"
-		args = append(args, seasonNumber)
+		filter.SeasonNumber = &seasonNumber
 	}
 	if episodeRaw != "" {
 		episodeNumber, parseErr := strconv.ParseInt(episodeRaw, 10, 64)
 		if parseErr != nil || episodeNumber < 0 {
 			writeError(w, http.StatusBadRequest, "invalid episodeNumber")
-			return
+			return WatchedFilter{}, false
 		}
-		query += " AND episode_number = ?"
-		args = append(args, episodeNumber)
+		filter.EpisodeNumber = &episodeNumber
 	}
 
-	query += " ORDER BY watched_at DESC"
-
-	rows, err := a.db.Query(query, args...)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to list watched items")
-		return
+	if fromRaw := strings.TrimSpace(r.URL.Query().Get("from")); fromRaw != "" {
+		if filter.From, err = parseWatchedDate(fromRaw, false); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid from")
+			return WatchedFilter{}, false
+		}
 	}
-	defer rows.Close()
-
-	out := make([]WatchedItem, 0)
-	for rows.Next() {
-		var item WatchedItem
-		if scanErr := rows.Scan(
-			&item.UserID,
-			&item.MediaType,
-			&item.TmdbID,
-			&item.SeasonNumber,
-			&item.EpisodeNumber,
-			&item.WatchedAt,
-		); scanErr != nil {
-			writeError(w, http.StatusInternalServerError, "failed reading watched items")
-			return
+	if toRaw := strings.TrimSpace(r.URL.Query().Get("to")); toRaw != "" {
+		if filter.To, err = parseWatchedDate(toRaw, true); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid to")
+			return WatchedFilter{}, false
 		}
-		out = append(out, item)
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.From.After(filter.To) {
+		writeError(w, http.StatusBadRequest, "from must not be after to")
+		return WatchedFilter{}, false
 	}
 
-	writeJSON(w, http.StatusOK, out)
+	return filter, true
 }
 
-func envOrDefault(key string, fallback string) string {
-	value := strings.TrimSpace(os.Getenv(key))
-	if value == "" {
-		return fallback
+// parseWatchedDate accepts RFC 3339 or a bare date, which covers the whole
+// day: its start for from and its last second for to.
+func parseWatchedDate(raw string, endOfDay bool) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, raw); err == nil {
+		return parsed.UTC(), nil
 	}
+	day, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		return day.Add(24*time.Hour - time.Second), nil
+	}
+	return day, nil
+}
 
-	return value
+// watchedCursor is the position after the last item of a page. Clients get it
+// base64-encoded in X-Next-Cursor and should treat it as opaque.
+type watchedCursor struct {
+	WatchedAt     time.Time `json:"w"`
+	MediaType     string    `json:"m"`
+	TmdbID        int64     `json:"t"`
+	SeasonNumber  int64     `json:"s"`
+	EpisodeNumber int64     `json:"e"`
 }
 
-func (s *Store) handleListSeries(w http.ResponseWriter, r *http.Request) {
-	status := strings.TrimSpace(r.URL.Query().Get("status"))
-	q := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+func encodeWatchedCursor(rec WatchedRecord) string {
+	raw, _ := json.Marshal(watchedCursor{
+		WatchedAt:     rec.WatchedAt.UTC(),
+		MediaType:     rec.MediaType,
+		TmdbID:        rec.TmdbID,
+		SeasonNumber:  rec.SeasonNumber,
+		EpisodeNumber: rec.EpisodeNumber,
+	})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeWatchedCursor(raw string) (*WatchedRecord, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var cursor watchedCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.WatchedAt.IsZero() || cursor.MediaType == "" {
+		return nil, errors.New("incomplete cursor")
+	}
+	return &WatchedRecord{
+		WatchedAt:     cursor.WatchedAt,
+		MediaType:     cursor.MediaType,
+		TmdbID:        cursor.TmdbID,
+		SeasonNumber:  cursor.SeasonNumber,
+		EpisodeNumber: cursor.EpisodeNumber,
+	}, nil
+}
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// handleListWatched returns one page of items; when more remain, the
+// X-Next-Cursor header holds the cursor for the next one.
+func (a *App) handleListWatched(w http.ResponseWriter, r *http.Request) {
+	filter, ok := watchedFilterFromQuery(w, r)
+	if !ok {
+		return
+	}
 
-	out := make([]Series, 0, len(s.items))
-	for _, item := range s.items {
-		if status != "" && item.Status != status {
-			continue
+	limit := defaultWatchedPageSize
+	if limitRaw := strings.TrimSpace(r.URL.Query().Get("limit")); limitRaw != "" {
+		parsed, err := strconv.Atoi(limitRaw)
+		if err != nil || parsed <= 0 || parsed > maxWatchedPageSize {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxWatchedPageSize))
+			return
 		}
-
-		if q != "" {
-			if !strings.Contains(strings.ToLower(item.Title), q) && !strings.Contains(strings.ToLower(item.Overview), q) {
-				continue
-			}
+		limit = parsed
+	}
+	if cursorRaw := strings.TrimSpace(r.URL.Query().Get("cursor")); cursorRaw != "" {
+		after, err := decodeWatchedCursor(cursorRaw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
 		}
+		filter.After = after
+	}
+	// One extra row tells whether another page exists.
+	filter.Limit = limit + 1
 
-		out = append(out, item)
+	records, err := a.repos.Watched.List(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list watched items")
+		return
+	}
+	if len(records) > limit {
+		records = records[:limit]
+		w.Header().Set("X-Next-Cursor", encodeWatchedCursor(records[limit-1]))
+	}
+
+	out := make([]WatchedItem, 0, len(records))
+	for _, rec := range records {
+		out = append(out, WatchedItem{
+			UserID:        rec.UserID,
+			MediaType:     rec.MediaType,
+			TmdbID:        rec.TmdbID,
+			SeasonNumber:  rec.SeasonNumber,
+			EpisodeNumber: rec.EpisodeNumber,
+			WatchedAt:     rec.WatchedAt.UTC().Format(time.RFC3339),
+			PlayCount:     rec.PlayCount,
+		})
 	}
 
 	writeJSON(w, http.StatusOK, out)
 }
 
-func (s *Store) handleCreateSeries(w http.ResponseWriter, r *http.Request) {
-	var in CreateSeriesInput
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid json body")
+// handleListWatchEvents returns every single viewing, so clients can show a
+// rewatch history and pick one to remove.
+func (a *App) handleListWatchEvents(w http.ResponseWriter, r *http.Request) {
+	filter, ok := watchedFilterFromQuery(w, r)
+	if !ok {
 		return
 	}
 
-	in.Title = strings.TrimSpace(in.Title)
-	in.Status = strings.TrimSpace(in.Status)
-
-	if in.Title == "" {
-		writeError(w, http.StatusBadRequest, "title is required")
+	events, err := a.repos.Watched.Events(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list watch events")
 		return
 	}
 
-	if in.Status == "" {
-		in.Status = "planned"
+	out := make([]WatchEventItem, 0, len(events))
+	for _, ev := range events {
+		out = append(out, WatchEventItem{
+			ID:            ev.ID,
+			MediaType:     ev.MediaType,
+			TmdbID:        ev.TmdbID,
+			SeasonNumber:  ev.SeasonNumber,
+			EpisodeNumber: ev.EpisodeNumber,
+			WatchedAt:     ev.WatchedAt.UTC().Format(time.RFC3339),
+		})
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	item := Series{
-		ID:       s.nextID,
-		Title:    in.Title,
-		Overview: in.Overview,
-		Poster:   in.Poster,
-		Seasons:  in.Seasons,
-		Status:   in.Status,
-		Rating:   in.Rating,
-	}
-
-	s.nextID++
-	s.items = append(s.items, item)
-
-	writeJSON(w, http.StatusCreated, item)
+	writeJSON(w, http.StatusOK, out)
 }
 
-func (s *Store) handlePatchSeries(w http.ResponseWriter, r *http.Request) {
-	id, err := parseIDFromPath(r.URL.Path)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+func (a *App) handleDeleteWatchEvent(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.ParseInt(strings.TrimSpace(r.PathValue("id")), 10, 64)
+	if err != nil || eventID <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid event id")
 		return
 	}
 
-	var in UpdateSeriesInput
-	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid json body")
+	ev, err := a.repos.Watched.DeleteEvent(authUserID(r), eventID)
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "watch event not found")
 		return
 	}
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	idx := -1
-	for i := range s.items {
-		if s.items[i].ID == id {
-			idx = i
-			break
-		}
-	}
-
-	if idx == -1 {
-		writeError(w, http.StatusNotFound, "series not found")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete watch event")
 		return
 	}
+	a.inferTitleStatus(ev.UserID, ev.MediaType, ev.TmdbID, false)
 
-	if in.Overview != nil {
-		s.items[idx].Overview = *in.Overview
-	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	if in.Poster != nil {
-		s.items[idx].Poster = *in.Poster
+func envOrDefault(key string, fallback string) string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
 	}
 
-	if in.Seasons != nil {
-		s.items[idx].Seasons = *in.Seasons
-	}
+	return value
+}
 
-	if in.Status != nil {
-		s.items[idx].Status = strings.TrimSpace(*in.Status)
+func envIntOrDefault(key string, fallback int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
 	}
 
-	if in.Rating != nil {
-		s.items[idx].Rating = *in.Rating
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("invalid %s %q, using %d", key, value, fallback)
+		return fallback
 	}
 
-	writeJSON(w, http.StatusOK, s.items[idx])
+	return parsed
 }
 
-func (s *Store) handleDeleteSeries(w http.ResponseWriter, r *http.Request) {
-	id, err := parseIDFromPath(r.URL.Path)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
-		return
-	}
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	idx := -1
-	for i := range s.items {
-		if s.items[i].ID == id {
-			idx = i
-			break
-		}
+func envBoolOrDefault(key string, fallback bool) bool {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
 	}
 
-	if idx == -1 {
-		writeError(w, http.StatusNotFound, "series not found")
-		return
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("invalid %s %q, using %t", key, value, fallback)
+		return fallback
 	}
 
-	s.items = append(s.items[:idx], s.items[idx+1:]...)
-	w.WriteHeader(http.StatusNoContent)
+	return parsed
 }
 
-func parseIDFromPath(path string) (int, error) {
-	trimmed := strings.Trim(path, "/")
-	parts := strings.Split(trimmed, "/")
-	if len(parts) != 3 || parts[0] != "api" || parts[1] != "series" {
-		return 0, fmt.Errorf("invalid path")
+func envDurationOrDefault(key string, fallback time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
 	}
 
-	id, err := strconv.Atoi(parts[2])
-	if err != nil {
-		return 0, fmt.Errorf("invalid series id")
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("invalid %s %q, using %s", key, value, fallback)
+		return fallback
 	}
 
-	return id, nil
+	return parsed
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -915,8 +1182,9 @@ func writeError(w http.ResponseWriter, status int, msg string) {
 func cors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Next-Cursor")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureMailer hands every message to the test instead of delivering it.
+type captureMailer struct {
+	sent chan MailMessage
+}
+
+func (m *captureMailer) Send(ctx context.Context, msg MailMessage) error {
+	m.sent <- msg
+	return nil
+}
+
+// next waits for the message sendMailAsync delivers in the background.
+func (m *captureMailer) next(t *testing.T) MailMessage {
+	t.Helper()
+	select {
+	case msg := <-m.sent:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no mail sent")
+		return MailMessage{}
+	}
+}
+
+func (m *captureMailer) none(t *testing.T) {
+	t.Helper()
+	select {
+	case msg := <-m.sent:
+		t.Fatalf("unexpected mail to %s: %q", msg.To, msg.Subject)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// newTestApp wires an App to a fresh SQLite database.
+func newTestApp(t *testing.T) (*App, *captureMailer) {
+	t.Helper()
+	dsn, err := sqliteDSN(filepath.Join(t.TempDir(), "tracksm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+
+	repos := newSQLiteRepositories(db)
+	mailer := &captureMailer{sent: make(chan MailMessage, 16)}
+	app := &App{
+		store:            NewStore(db, repos.Watched),
+		db:               sqliteDB{db},
+		repos:            repos,
+		sessions:         &SessionSigner{key: []byte("test-secret"), ttl: time.Hour},
+		mailer:           mailer,
+		appURL:           "http://app.test",
+		apiURL:           "http://api.test",
+		unverifiedPolicy: unverifiedAllow,
+		bcryptCost:       4,
+		limits:           NewAuthRateLimits(NewMemoryAttemptStore(time.Hour)),
+		oidc:             map[string]*OIDCProvider{},
+		ratingScale:      ratingScaleStars,
+	}
+	return app, mailer
+}
+
+func createPasswordUser(t *testing.T, a *App, email string, password string) User {
+	t.Helper()
+	hash, err := a.hashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Name: "Test", Email: email, Username: strings.Split(email, "@")[0], PasswordHash: hash, EmailVerified: true}
+	if err := a.repos.Users.Create(&user); err != nil {
+		t.Fatalf("create %s: %v", email, err)
+	}
+	return user
+}
+
+// loginSession opens a session for userID and returns its bearer token.
+func loginSession(t *testing.T, a *App, userID int64) string {
+	t.Helper()
+	token, _, err := a.createSession(httptest.NewRequest(http.MethodPost, "/api/auth/login", nil), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func jsonRequest(t *testing.T, method string, target string, token string, body any) *http.Request {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, target, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func serve(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, out any) {
+	t.Helper()
+	if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+// The frontend calls the API cross-origin, so every method a route uses has
+// to pass the preflight.
+func TestCORSPreflightAllowsRouteMethods(t *testing.T) {
+	handler := cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("preflight reached the handler: %s %s", r.Method, r.URL.Path)
+	}))
+
+	for _, tc := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPut, "/api/user/titles/tv/1396"},
+		{http.MethodPut, "/api/user/ratings"},
+		{http.MethodPut, "/api/user/lists/1/order"},
+	} {
+		req := httptest.NewRequest(http.MethodOptions, tc.path, nil)
+		req.Header.Set("Origin", "http://localhost:3000")
+		req.Header.Set("Access-Control-Request-Method", tc.method)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("%s %s: preflight status %d", tc.method, tc.path, rec.Code)
+		}
+		allowed := strings.Split(rec.Header().Get("Access-Control-Allow-Methods"), ", ")
+		if !slices.Contains(allowed, tc.method) {
+			t.Fatalf("%s %s: Access-Control-Allow-Methods is %q", tc.method, tc.path, rec.Header().Get("Access-Control-Allow-Methods"))
+		}
+	}
+}
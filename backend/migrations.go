@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// migration is one step of the schema history. Versions are contiguous and
+// applied in order; a released migration must never be edited or reordered,
+// only followed by a new one.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// migrations 1-12 replay the DDL that used to run on every start. They are
+// written to be no-ops on databases created before schema_migrations existed.
+var migrations = []migration{
+	{1, "create users table", ensureUsersTable},
+	{2, "add users profile columns", ensureUsersProfileColumns},
+	{3, "add users email_verified_at", ensureUsersEmailVerifiedColumn},
+	{4, "create watched_items table", ensureWatchedTable},
+	{5, "create sessions table", ensureSessionsTable},
+	{6, "add sessions device columns", ensureSessionsDeviceColumns},
+	{7, "create password_reset_tokens table", ensurePasswordResetTable},
+	{8, "create email_verification_tokens table", ensureEmailVerificationTable},
+	{9, "create two-factor tables", ensureTwoFactorTables},
+	{10, "create oidc tables", ensureOIDCTables},
+	{11, "create personal_access_tokens table", ensureAccessTokensTable},
+	{12, "add users delete_after", ensureUsersDeletionColumn},
+	{13, "create series table", ensureSeriesTable},
+	{14, "add series owner and tmdb id", ensureSeriesOwnerColumns},
+	{15, "add series title_key", ensureSeriesTitleKey},
+	{16, "add watched_items foreign key and indexes", ensureWatchedForeignKey},
+	{17, "replace watched_items with watch_events", ensureWatchEvents},
+	{18, "create title status tables", ensureTitleStatusTables},
+	{19, "create ratings table", ensureRatingsTable},
+	{20, "create user lists tables", ensureUserListsTables},
+	{21, "settle ownerless series", ensureSeriesOwnersSettled},
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt string
+}
+
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func ensureSchemaMigrationsTable(db *sql.DB) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+    `
+
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed creating schema_migrations table: %w", err)
+	}
+
+	return nil
+}
+
+// appliedMigrations returns the applied_at of every recorded version. It
+// only reads, so that migrate status works on a read-only connection; a
+// database without schema_migrations has no recorded versions.
+func appliedMigrations(db *sql.DB) (map[int]string, error) {
+	applied := make(map[int]string)
+	var tables int
+	if err := db.QueryRow(
+		"SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'",
+	).Scan(&tables); err != nil {
+		return nil, fmt.Errorf("failed reading schema_migrations: %w", err)
+	}
+	if tables == 0 {
+		return applied, nil
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed reading schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt string
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed reading schema_migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func schemaVersion(applied map[int]string) int {
+	version := 0
+	for v := range applied {
+		version = max(version, v)
+	}
+	return version
+}
+
+// migrateDatabase applies every pending migration, each in its own
+// transaction. It refuses to touch a database written by a newer binary.
+func migrateDatabase(db *sql.DB) error {
+	if err := ensureSchemaMigrationsTable(db); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	if current, latest := schemaVersion(applied), latestSchemaVersion(); current > latest {
+		return fmt.Errorf("database schema version %d is newer than this binary supports (%d)", current, latest)
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return err
+		}
+		log.Printf("applied migration %d: %s", m.version, m.name)
+	}
+
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("migration %d: %w", m.version, err)
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		m.version,
+		m.name,
+		time.Now().UTC().Format(dbTimeLayout),
+	); err != nil {
+		return fmt.Errorf("migration %d: failed recording version: %w", m.version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migration %d: %w", m.version, err)
+	}
+	return nil
+}
+
+// migrationStatus lists known migrations plus any unknown versions recorded
+// by a newer binary. AppliedAt is empty for pending migrations.
+func migrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		out = append(out, MigrationStatus{Version: m.version, Name: m.name, AppliedAt: applied[m.version]})
+		delete(applied, m.version)
+	}
+	for version, appliedAt := range applied {
+		out = append(out, MigrationStatus{Version: version, Name: "(unknown)", AppliedAt: appliedAt})
+	}
+	return out, nil
+}
+
+func printMigrationStatus(w io.Writer, db *sql.DB) error {
+	statuses, err := migrationStatus(db)
+	if err != nil {
+		return err
+	}
+
+	current := 0
+	pending := 0
+	for _, s := range statuses {
+		if s.AppliedAt == "" {
+			pending++
+			fmt.Fprintf(w, "%4d  pending               %s\n", s.Version, s.Name)
+			continue
+		}
+		current = max(current, s.Version)
+		fmt.Fprintf(w, "%4d  %-20s  %s\n", s.Version, s.AppliedAt, s.Name)
+	}
+	fmt.Fprintf(w, "\nschema version %d, binary version %d, %d pending\n", current, latestSchemaVersion(), pending)
+	if current > latestSchemaVersion() {
+		fmt.Fprintln(w, "database is newer than this binary")
+	}
+	return nil
+}
+
+func columnExists(tx *sql.Tx, tableName string, columnName string) (bool, error) {
+	var count int
+	err := tx.QueryRow(
+		"SELECT COUNT(1) FROM pragma_table_info(?) WHERE name = ?",
+		tableName,
+		columnName,
+	).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed inspecting %s columns: %w", tableName, err)
+	}
+	return count > 0, nil
+}
+
+// addColumnIfMissing keeps column migrations idempotent for databases that
+// already received the column from the pre-migration startup code.
+func addColumnIfMissing(tx *sql.Tx, tableName string, columnName string, columnDef string) error {
+	exists, err := columnExists(tx, tableName, columnName)
+	if err != nil || exists {
+		return err
+	}
+
+	query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", tableName, columnName, columnDef)
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed adding %s.%s column: %w", tableName, columnName, err)
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrationStatusDoesNotWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracksm.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	ro, err := openReadOnlyDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	var out bytes.Buffer
+	if err := printMigrationStatus(&out, ro); err != nil {
+		t.Fatalf("status on a read-only connection: %v", err)
+	}
+	if !strings.Contains(out.String(), "schema version 0") {
+		t.Fatalf("status output:\n%s", out.String())
+	}
+
+	var tables int
+	if err := ro.QueryRow("SELECT COUNT(1) FROM sqlite_master WHERE name = 'schema_migrations'").Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Fatal("migrate status created schema_migrations")
+	}
+
+	if _, err := openReadOnlyDatabase(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Fatal("opening a missing database read-only should fail instead of creating it")
+	}
+}
+
+// TestWatchedMigrations seeds a database at schema version 15, as left by
+// releases that still stored one watched_items row per item, and applies
+// migrations 16 and 17 to it.
+func TestWatchedMigrations(t *testing.T) {
+	db := openEmptyDatabase(t)
+	if err := ensureSchemaMigrationsTable(db); err != nil {
+		t.Fatal(err)
+	}
+	apply := func(version int) {
+		t.Helper()
+		if err := applyMigration(db, migrations[version-1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for version := 1; version <= 15; version++ {
+		apply(version)
+	}
+
+	if _, err := db.Exec(`
+    INSERT INTO users (id, name, email, password_hash) VALUES (1, 'Ana', 'ana@example.com', 'x'), (2, 'Bia', 'bia@example.com', 'x');
+    INSERT INTO watched_items (id, user_id, media_type, tmdb_id, season_number, episode_number, watched_at) VALUES
+        (10, 1, 'movie', 603, 0, 0, '2025-01-02 20:00:00'),
+        (11, 1, 'tv', 1396, 1, 1, '2025-01-03 21:00:00'),
+        (12, 2, 'movie', 603, 0, 0, '2025-01-04 22:00:00'),
+        (13, 3, 'movie', 604, 0, 0, '2025-01-05 23:00:00');
+    `); err != nil {
+		t.Fatal(err)
+	}
+	count := func(query string, args ...any) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	index := func(name string) bool {
+		return count("SELECT COUNT(1) FROM sqlite_master WHERE type = 'index' AND name = ?", name) == 1
+	}
+
+	apply(16)
+	if n := count("SELECT COUNT(1) FROM watched_items"); n != 3 {
+		t.Fatalf("after 16: %d watched_items rows, want 3 without the orphan", n)
+	}
+	if n := count("SELECT COUNT(1) FROM pragma_foreign_key_list('watched_items') WHERE \"table\" = 'users' AND on_delete = 'CASCADE'"); n != 1 {
+		t.Fatal("after 16: watched_items has no cascading foreign key to users")
+	}
+	if !index("idx_watched_user_media_watched_at") {
+		t.Fatal("after 16: history index missing")
+	}
+
+	apply(17)
+	if n := count("SELECT COUNT(1) FROM sqlite_master WHERE name = 'watched_items'"); n != 0 {
+		t.Fatal("after 17: watched_items still exists")
+	}
+	for _, name := range []string{"idx_watch_events_item", "idx_watch_events_user_media_watched_at"} {
+		if !index(name) {
+			t.Fatalf("after 17: %s missing", name)
+		}
+	}
+	rows, err := db.Query("SELECT id, user_id, media_type, tmdb_id, season_number, episode_number, watched_at FROM watch_events ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for rows.Next() {
+		var (
+			id, userID, tmdbID, season, episode int64
+			mediaType                           string
+			watchedAt                           time.Time
+		)
+		if err := rows.Scan(&id, &userID, &mediaType, &tmdbID, &season, &episode, &watchedAt); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%d %d %s/%d/%d/%d %s", id, userID, mediaType, tmdbID, season, episode, watchedAt.UTC().Format(time.DateTime)))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"10 1 movie/603/0/0 2025-01-02 20:00:00",
+		"11 1 tv/1396/1/1 2025-01-03 21:00:00",
+		"12 2 movie/603/0/0 2025-01-04 22:00:00",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("after 17: watch_events\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, err := db.Exec("DELETE FROM users WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := count("SELECT COUNT(1) FROM watch_events WHERE user_id = 1"); n != 0 {
+		t.Fatalf("deleting a user left %d watch_events", n)
+	}
+
+	// A rewatch now adds an event instead of overwriting the first one.
+	if _, err := db.Exec("INSERT INTO watch_events (user_id, media_type, tmdb_id, watched_at) VALUES (2, 'movie', 603, '2025-02-01 20:00:00')"); err != nil {
+		t.Fatal(err)
+	}
+	if n := count("SELECT COUNT(1) FROM watch_events WHERE user_id = 2 AND tmdb_id = 603"); n != 2 {
+		t.Fatalf("rewatch: %d events, want 2", n)
+	}
+
+	if err := migrateDatabase(db); err != nil {
+		t.Fatalf("later migrations on the upgraded database: %v", err)
+	}
+}
+
+// openEmptyDatabase opens a new database file with the connection pragmas
+// the server uses, and no schema.
+func openEmptyDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn, err := sqliteDSN(filepath.Join(t.TempDir(), "tracksm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrationsAreContiguous(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Fatalf("migration %q has version %d at position %d", m.name, m.version, i+1)
+		}
+		if m.name == "" || m.up == nil {
+			t.Fatalf("migration %d has no name or no up function", m.version)
+		}
+	}
+}
+
+func TestMigrateDatabase(t *testing.T) {
+	db := openEmptyDatabase(t)
+	if err := migrateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) || schemaVersion(applied) != latestSchemaVersion() {
+		t.Fatalf("after migrating: %d applied, version %d", len(applied), schemaVersion(applied))
+	}
+
+	// A second run finds nothing to do and leaves the recorded times alone.
+	if err := migrateDatabase(db); err != nil {
+		t.Fatalf("migrating twice: %v", err)
+	}
+	again, err := appliedMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(applied, again) {
+		t.Fatalf("second run changed schema_migrations: %v, then %v", applied, again)
+	}
+
+	var out bytes.Buffer
+	if err := printMigrationStatus(&out, db); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("schema version %d, binary version %d, 0 pending", latestSchemaVersion(), latestSchemaVersion()); !strings.Contains(out.String(), want) {
+		t.Fatalf("status output:\n%s", out.String())
+	}
+}
+
+func TestMigrateDatabaseRefusesNewerSchema(t *testing.T) {
+	db := openEmptyDatabase(t)
+	if err := migrateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	newer := latestSchemaVersion() + 1
+	if _, err := db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, 'from the future')", newer); err != nil {
+		t.Fatal(err)
+	}
+
+	err := migrateDatabase(db)
+	if err == nil || !strings.Contains(err.Error(), "newer than this binary") {
+		t.Fatalf("got %v, want a refusal", err)
+	}
+	statuses, err := migrationStatus(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := statuses[len(statuses)-1]; last.Version != newer || last.Name != "(unknown)" || last.AppliedAt == "" {
+		t.Fatalf("status of the unknown version: %+v", last)
+	}
+}
+
+func TestApplyMigrationRollsBack(t *testing.T) {
+	db := openEmptyDatabase(t)
+	if err := ensureSchemaMigrationsTable(db); err != nil {
+		t.Fatal(err)
+	}
+	broken := migration{1, "half done", func(tx *sql.Tx) error {
+		if _, err := tx.Exec("CREATE TABLE half_done (id INTEGER PRIMARY KEY)"); err != nil {
+			return err
+		}
+		return errors.New("second statement failed")
+	}}
+	if err := applyMigration(db, broken); err == nil {
+		t.Fatal("applying a failing migration succeeded")
+	}
+
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(1) FROM sqlite_master WHERE name = 'half_done'").Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 || len(applied) != 0 {
+		t.Fatalf("failed migration left %d table(s) and versions %v", tables, applied)
+	}
+}
+
+// TestMigrateLegacyDatabase starts from the schema the startup DDL created
+// before schema_migrations existed, with the profile columns already added.
+func TestMigrateLegacyDatabase(t *testing.T) {
+	db := openEmptyDatabase(t)
+	if _, err := db.Exec(`
+    CREATE TABLE users (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL,
+        email TEXT NOT NULL UNIQUE,
+        password_hash TEXT NOT NULL,
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        username TEXT,
+        photo_url TEXT NOT NULL DEFAULT ''
+    );
+    CREATE TABLE watched_items (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        media_type TEXT NOT NULL,
+        tmdb_id INTEGER NOT NULL,
+        season_number INTEGER NOT NULL DEFAULT 0,
+        episode_number INTEGER NOT NULL DEFAULT 0,
+        watched_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE(user_id, media_type, tmdb_id, season_number, episode_number)
+    );
+    INSERT INTO users (id, name, email, password_hash, username) VALUES (1, 'Ana', 'ana@example.com', 'x', 'ana');
+    INSERT INTO watched_items (user_id, media_type, tmdb_id) VALUES (1, 'movie', 603);
+    `); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateDatabase(db); err != nil {
+		t.Fatalf("migrating a legacy database: %v", err)
+	}
+	var username string
+	var events int
+	if err := db.QueryRow("SELECT username, (SELECT COUNT(1) FROM watch_events WHERE user_id = users.id) FROM users WHERE id = 1").Scan(&username, &events); err != nil {
+		t.Fatal(err)
+	}
+	if username != "ana" || events != 1 {
+		t.Fatalf("legacy data after migrating: username %q, %d event(s)", username, events)
+	}
+}
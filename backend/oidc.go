@@ -0,0 +1,649 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+// OIDCProviderConfig describes one identity provider. Providers are plain
+// data, loaded from OIDC_PROVIDERS_FILE or OIDC_PROVIDERS, so a local mock IdP
+// can be plugged in the same way as a real one. Endpoints left empty are read
+// from the issuer's discovery document.
+type OIDCProviderConfig struct {
+	ID                    string   `json:"id"`
+	Name                  string   `json:"name"`
+	Issuer                string   `json:"issuer"`
+	ClientID              string   `json:"clientId"`
+	ClientSecret          string   `json:"clientSecret"`
+	RedirectURL           string   `json:"redirectUrl"`
+	Scopes                []string `json:"scopes"`
+	AuthorizationEndpoint string   `json:"authorizationEndpoint"`
+	TokenEndpoint         string   `json:"tokenEndpoint"`
+	JWKSURI               string   `json:"jwksUri"`
+}
+
+type OIDCProvider struct {
+	config OIDCProviderConfig
+	client *http.Client
+
+	mu         sync.Mutex
+	discovered bool
+	keys       map[string]crypto.PublicKey
+}
+
+type OIDCProviderInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type OIDCCallbackInput struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+type oidcClaims struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      json.RawMessage `json:"aud"`
+	ExpiresAt     int64           `json:"exp"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified json.RawMessage `json:"email_verified"`
+	Name          string          `json:"name"`
+}
+
+func loadOIDCProviders() (map[string]*OIDCProvider, error) {
+	raw := []byte(os.Getenv("OIDC_PROVIDERS"))
+	if path := envOrDefault("OIDC_PROVIDERS_FILE", ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading OIDC_PROVIDERS_FILE: %w", err)
+		}
+		raw = data
+	}
+
+	providers := make(map[string]*OIDCProvider)
+	if len(strings.TrimSpace(string(raw))) == 0 {
+		return providers, nil
+	}
+
+	var configs []OIDCProviderConfig
+	if err := json.Unmarshal(raw, &configs); err != nil {
+		return nil, fmt.Errorf("failed parsing OIDC providers: %w", err)
+	}
+
+	for _, config := range configs {
+		config.ID = strings.ToLower(strings.TrimSpace(config.ID))
+		config.Issuer = strings.TrimRight(strings.TrimSpace(config.Issuer), "/")
+		if config.ID == "" || config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+			return nil, fmt.Errorf("OIDC provider %q needs id, issuer, clientId and redirectUrl", config.ID)
+		}
+		if _, exists := providers[config.ID]; exists {
+			return nil, fmt.Errorf("duplicate OIDC provider %q", config.ID)
+		}
+		if config.Name == "" {
+			config.Name = config.ID
+		}
+		if len(config.Scopes) == 0 {
+			config.Scopes = []string{"openid", "email", "profile"}
+		}
+		providers[config.ID] = &OIDCProvider{
+			config: config,
+			client: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	return providers, nil
+}
+
+func ensureOIDCTables(tx *sql.Tx) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS oidc_states (
+        state_hash TEXT PRIMARY KEY,
+        provider TEXT NOT NULL,
+        code_verifier TEXT NOT NULL,
+        nonce TEXT NOT NULL,
+        expires_at DATETIME NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS user_identities (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        provider TEXT NOT NULL,
+        subject TEXT NOT NULL,
+        email TEXT NOT NULL DEFAULT '',
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE(provider, subject)
+    );
+    `
+
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed creating oidc tables: %w", err)
+	}
+
+	return nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, endpoint string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", endpoint, res.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(out)
+}
+
+// discover fills the endpoints missing from the configuration.
+func (p *OIDCProvider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovered {
+		return nil
+	}
+	if p.config.AuthorizationEndpoint != "" && p.config.TokenEndpoint != "" && p.config.JWKSURI != "" {
+		p.discovered = true
+		return nil
+	}
+
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return fmt.Errorf("failed discovering %s: %w", p.config.ID, err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != p.config.Issuer {
+		return fmt.Errorf("discovery issuer mismatch for %s", p.config.ID)
+	}
+
+	if p.config.AuthorizationEndpoint == "" {
+		p.config.AuthorizationEndpoint = doc.AuthorizationEndpoint
+	}
+	if p.config.TokenEndpoint == "" {
+		p.config.TokenEndpoint = doc.TokenEndpoint
+	}
+	if p.config.JWKSURI == "" {
+		p.config.JWKSURI = doc.JWKSURI
+	}
+	p.discovered = true
+	return nil
+}
+
+func (p *OIDCProvider) authorizationURL(state string, nonce string, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", strings.Join(p.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.config.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.config.AuthorizationEndpoint + separator + params.Encode()
+}
+
+func (p *OIDCProvider) exchangeCode(ctx context.Context, code string, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", verifier)
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK || body.IDToken == "" {
+		return "", fmt.Errorf("token endpoint returned %d %s", res.StatusCode, body.Error)
+	}
+	return body.IDToken, nil
+}
+
+func (p *OIDCProvider) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	// Unknown key ids trigger a refetch so provider key rotation just works.
+	var doc struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.config.JWKSURI, &doc); err != nil {
+		return nil, fmt.Errorf("failed fetching jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range doc.Keys {
+		switch jwk.Kty {
+		case "RSA":
+			n, nErr := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, eErr := base64.RawURLEncoding.DecodeString(jwk.E)
+			if nErr != nil || eErr != nil {
+				continue
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			if jwk.Crv != "P-256" {
+				continue
+			}
+			x, xErr := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, yErr := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if xErr != nil || yErr != nil {
+				continue
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// verifyIDToken checks the signature (RS256 or ES256) and the standard claims.
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, idToken string, nonce string, now time.Time) (oidcClaims, error) {
+	var claims oidcClaims
+
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return claims, fmt.Errorf("malformed id token")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, fmt.Errorf("malformed id token header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return claims, fmt.Errorf("malformed id token header")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, fmt.Errorf("malformed id token signature")
+	}
+	key, err := p.publicKey(ctx, header.Kid)
+	if err != nil {
+		return claims, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch header.Alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) != nil {
+			return claims, fmt.Errorf("invalid id token signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return claims, fmt.Errorf("invalid id token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return claims, fmt.Errorf("invalid id token signature")
+		}
+	default:
+		return claims, fmt.Errorf("unsupported id token algorithm %q", header.Alg)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, fmt.Errorf("malformed id token payload")
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, fmt.Errorf("malformed id token payload")
+	}
+
+	if strings.TrimRight(claims.Issuer, "/") != p.config.Issuer {
+		return claims, fmt.Errorf("id token issuer mismatch")
+	}
+	if !audienceContains(claims.Audience, p.config.ClientID) {
+		return claims, fmt.Errorf("id token audience mismatch")
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return claims, fmt.Errorf("id token expired")
+	}
+	if claims.Nonce != nonce {
+		return claims, fmt.Errorf("id token nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return claims, fmt.Errorf("id token without subject")
+	}
+
+	return claims, nil
+}
+
+func audienceContains(raw json.RawMessage, clientID string) bool {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return single == clientID
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err == nil {
+		for _, aud := range many {
+			if aud == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// emailVerified accepts both the boolean and the string form some providers use.
+func (c oidcClaims) emailVerified() bool {
+	var verified bool
+	if err := json.Unmarshal(c.EmailVerified, &verified); err == nil {
+		return verified
+	}
+	var text string
+	if err := json.Unmarshal(c.EmailVerified, &text); err == nil {
+		return strings.EqualFold(text, "true")
+	}
+	return false
+}
+
+func (a *App) oidcProvider(w http.ResponseWriter, r *http.Request) (*OIDCProvider, bool) {
+	provider, ok := a.oidc[strings.ToLower(r.PathValue("provider"))]
+	if !ok {
+		writeError(w, http.StatusNotFound, "provider not found")
+		return nil, false
+	}
+	if err := provider.discover(r.Context()); err != nil {
+		writeError(w, http.StatusBadGateway, "provider unavailable")
+		return nil, false
+	}
+	return provider, true
+}
+
+func (a *App) handleListOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	out := make([]OIDCProviderInfo, 0, len(a.oidc))
+	for _, provider := range a.oidc {
+		out = append(out, OIDCProviderInfo{ID: provider.config.ID, Name: provider.config.Name})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleStartOIDC stores the state, nonce and PKCE verifier and returns the
+// URL the browser should be sent to.
+func (a *App) handleStartOIDC(w http.ResponseWriter, r *http.Request) {
+	provider, ok := a.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	state, stateErr := randomToken(32)
+	nonce, nonceErr := randomToken(32)
+	verifier, verifierErr := randomToken(48)
+	if stateErr != nil || nonceErr != nil || verifierErr != nil {
+		writeError(w, http.StatusInternalServerError, "failed to start login")
+		return
+	}
+
+	now := time.Now().UTC()
+	_, _ = a.db.Exec("DELETE FROM oidc_states WHERE expires_at <= ?", now.Format(dbTimeLayout))
+	if _, err := a.db.Exec(
+		"INSERT INTO oidc_states (state_hash, provider, code_verifier, nonce, expires_at) VALUES (?, ?, ?, ?, ?)",
+		hashToken(state),
+		provider.config.ID,
+		verifier,
+		nonce,
+		now.Add(oidcStateTTL).Format(dbTimeLayout),
+	); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to start login")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"authorizationUrl": provider.authorizationURL(state, nonce, verifier),
+	})
+}
+
+func (a *App) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := a.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	var in OIDCCallbackInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if strings.TrimSpace(in.Code) == "" || strings.TrimSpace(in.State) == "" {
+		writeError(w, http.StatusBadRequest, "code and state are required")
+		return
+	}
+
+	var verifier, nonce string
+	err := a.db.QueryRow(
+		`DELETE FROM oidc_states
+         WHERE state_hash = ? AND provider = ? AND expires_at > ?
+         RETURNING code_verifier, nonce`,
+		hashToken(in.State),
+		provider.config.ID,
+		time.Now().UTC().Format(dbTimeLayout),
+	).Scan(&verifier, &nonce)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusBadRequest, "invalid or expired state")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to complete login")
+		return
+	}
+
+	idToken, err := provider.exchangeCode(r.Context(), in.Code, verifier)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "failed to exchange authorization code")
+		return
+	}
+	claims, err := provider.verifyIDToken(r.Context(), idToken, nonce, time.Now())
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := a.resolveOIDCUser(provider.config.ID, claims)
+	if err != nil {
+		if statusErr, ok := err.(oidcLinkError); ok {
+			writeError(w, statusErr.status, statusErr.message)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to complete login")
+		return
+	}
+
+	if a.unverifiedPolicy == unverifiedBlock {
+		user, err := a.repos.Users.ByID(userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to complete login")
+			return
+		}
+		if !user.EmailVerified {
+			writeError(w, http.StatusForbidden, "email not verified")
+			return
+		}
+	}
+
+	enabled, err := a.twoFactorEnabled(userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to complete login")
+		return
+	}
+	if enabled {
+		challenge, expiresAt := a.sessions.signChallenge(userID, time.Now().UTC())
+		writeJSON(w, http.StatusOK, TwoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+			ExpiresAt:         expiresAt,
+		})
+		return
+	}
+
+	a.writeLoginSuccess(w, r, userID)
+}
+
+type oidcLinkError struct {
+	status  int
+	message string
+}
+
+func (e oidcLinkError) Error() string {
+	return e.message
+}
+
+// resolveOIDCUser maps an identity to a users row: an existing link wins,
+// then an account with the same provider-verified email, otherwise a new
+// account is created.
+func (a *App) resolveOIDCUser(providerID string, claims oidcClaims) (int64, error) {
+	var userID int64
+	err := a.db.QueryRow(
+		"SELECT user_id FROM user_identities WHERE provider = ? AND subject = ? LIMIT 1",
+		providerID,
+		claims.Subject,
+	).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	email, emailErr := normalizeEmail(claims.Email)
+	if emailErr != nil {
+		return 0, oidcLinkError{http.StatusBadRequest, "provider did not return a valid email"}
+	}
+	verified := claims.emailVerified()
+
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	existing, err := a.repos.Users.ByEmail(email)
+	switch {
+	case err == nil:
+		// Linking on an unverified email would let anyone claim the account.
+		if !verified {
+			return 0, oidcLinkError{http.StatusConflict, "email already registered; verify it with the provider to link"}
+		}
+		if _, err := a.repos.Users.MarkEmailVerified(existing.ID, email, time.Now().UTC()); err != nil {
+			return 0, err
+		}
+		userID = existing.ID
+	case errors.Is(err, errNotFound):
+		username, err := generateAvailableUsername(a.repos.Users, usernameBaseFromNameOrEmail(name, email), 0)
+		if err != nil {
+			return 0, err
+		}
+		// An empty hash never matches, so the account has no password until
+		// the user sets one through the reset flow.
+		user := User{
+			Name:          truncate(name, 80),
+			Email:         email,
+			Username:      username,
+			EmailVerified: verified,
+		}
+		if err := a.repos.Users.Create(&user); err != nil {
+			return 0, err
+		}
+		userID = user.ID
+	default:
+		return 0, err
+	}
+
+	if _, err := a.db.Exec(
+		"INSERT INTO user_identities (user_id, provider, subject, email) VALUES (?, ?, ?, ?)",
+		userID,
+		providerID,
+		claims.Subject,
+		email,
+	); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
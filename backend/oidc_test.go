@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	mockClientID    = "tracksm"
+	mockRedirectURL = "http://app.test/login/oidc"
+	mockKeyID       = "test-key"
+)
+
+// mockIdP is a minimal OpenID provider: discovery, JWKS and a token endpoint
+// that checks PKCE before handing out the ID token prepared by authorize.
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    map[string]any
+	key       *rsa.PrivateKey
+}
+
+// mockLogin is what the user's sign-in at the IdP produces. Claims override
+// the defaults; key, when set, signs the token instead of the published key.
+type mockLogin struct {
+	claims map[string]any
+	key    *rsa.PrivateKey
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{t: t, key: key, grants: make(map[string]mockGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+			"kid": mockKeyID,
+			"kty": "RSA",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", idp.handleToken)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	idp.mu.Lock()
+	grant, ok := idp.grants[r.PostForm.Get("code")]
+	delete(idp.grants, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok ||
+		r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("client_id") != mockClientID ||
+		r.PostForm.Get("redirect_uri") != mockRedirectURL ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id_token": idp.sign(grant.key, grant.claims), "token_type": "Bearer"})
+}
+
+func (idp *mockIdP) sign(key *rsa.PrivateKey, claims map[string]any) string {
+	idp.t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": mockKeyID})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// authorize plays the browser leg: it reads the authorization URL the way the
+// IdP would and returns the code it redirects back with.
+func (idp *mockIdP) authorize(authorizationURL string, login mockLogin) (code string, state string) {
+	idp.t.Helper()
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	query := parsed.Query()
+	if parsed.Path != "/authorize" || query.Get("client_id") != mockClientID || query.Get("redirect_uri") != mockRedirectURL ||
+		query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		idp.t.Fatalf("unexpected authorization URL %s", authorizationURL)
+	}
+
+	claims := map[string]any{
+		"iss":            idp.server.URL,
+		"aud":            mockClientID,
+		"sub":            "subject-1",
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          query.Get("nonce"),
+		"email":          "oidc@example.com",
+		"email_verified": true,
+		"name":           "Oidc User",
+	}
+	for name, value := range login.claims {
+		claims[name] = value
+	}
+	key := login.key
+	if key == nil {
+		key = idp.key
+	}
+
+	code, err = randomToken(16)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.grants[code] = mockGrant{challenge: query.Get("code_challenge"), claims: claims, key: key}
+	idp.mu.Unlock()
+	return code, query.Get("state")
+}
+
+func newOIDCTestApp(t *testing.T) (*App, *mockIdP) {
+	t.Helper()
+	a, _ := newTestApp(t)
+	idp := newMockIdP(t)
+	a.oidc["mock"] = &OIDCProvider{
+		config: OIDCProviderConfig{
+			ID:          "mock",
+			Name:        "Mock",
+			Issuer:      idp.server.URL,
+			ClientID:    mockClientID,
+			RedirectURL: mockRedirectURL,
+			Scopes:      []string{"openid", "email", "profile"},
+		},
+		client: idp.server.Client(),
+	}
+	return a, idp
+}
+
+func startOIDC(t *testing.T, a *App) string {
+	t.Helper()
+	req := jsonRequest(t, http.MethodPost, "/api/auth/oidc/mock/start", "", nil)
+	req.SetPathValue("provider", "mock")
+	rec := serve(a.handleStartOIDC, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("start: status %d %s", rec.Code, rec.Body)
+	}
+	var out struct {
+		AuthorizationURL string `json:"authorizationUrl"`
+	}
+	decodeBody(t, rec, &out)
+	return out.AuthorizationURL
+}
+
+func finishOIDC(t *testing.T, a *App, code string, state string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := jsonRequest(t, http.MethodPost, "/api/auth/oidc/mock/callback", "", OIDCCallbackInput{Code: code, State: state})
+	req.SetPathValue("provider", "mock")
+	return serve(a.handleOIDCCallback, req)
+}
+
+func TestOIDCLoginCreatesAccount(t *testing.T) {
+	a, idp := newOIDCTestApp(t)
+
+	code, state := idp.authorize(startOIDC(t, a), mockLogin{})
+	rec := finishOIDC(t, a, code, state)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback: status %d %s", rec.Code, rec.Body)
+	}
+	var login LoginResponse
+	decodeBody(t, rec, &login)
+	if login.Token == "" || login.Email != "oidc@example.com" || !login.EmailVerified {
+		t.Fatalf("callback: got %+v", login)
+	}
+
+	// The state is single use.
+	if rec := finishOIDC(t, a, code, state); rec.Code != http.StatusBadRequest {
+		t.Fatalf("reused state: status %d %s", rec.Code, rec.Body)
+	}
+
+	// Signing in again with the same subject reaches the same account, even
+	// when the provider now reports another email.
+	code, state = idp.authorize(startOIDC(t, a), mockLogin{claims: map[string]any{"email": "renamed@example.com"}})
+	rec = finishOIDC(t, a, code, state)
+	var again LoginResponse
+	decodeBody(t, rec, &again)
+	if rec.Code != http.StatusOK || again.ID != login.ID {
+		t.Fatalf("second login: status %d %+v, want user %d", rec.Code, again, login.ID)
+	}
+}
+
+func TestOIDCLoginLinksVerifiedEmail(t *testing.T) {
+	a, idp := newOIDCTestApp(t)
+	existing := createPasswordUser(t, a, "oidc@example.com", "password123")
+
+	code, state := idp.authorize(startOIDC(t, a), mockLogin{})
+	rec := finishOIDC(t, a, code, state)
+	var login LoginResponse
+	decodeBody(t, rec, &login)
+	if rec.Code != http.StatusOK || login.ID != existing.ID {
+		t.Fatalf("callback: status %d %+v, want user %d", rec.Code, login, existing.ID)
+	}
+}
+
+// An email the provider has not verified must not take over an account.
+func TestOIDCLoginRefusesUnverifiedEmailOfExistingAccount(t *testing.T) {
+	a, idp := newOIDCTestApp(t)
+	createPasswordUser(t, a, "oidc@example.com", "password123")
+
+	code, state := idp.authorize(startOIDC(t, a), mockLogin{claims: map[string]any{"email_verified": false}})
+	if rec := finishOIDC(t, a, code, state); rec.Code != http.StatusConflict {
+		t.Fatalf("callback: status %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCLoginRejectsBadResponses(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		login  mockLogin
+		tamper func(code string, state string) (string, string)
+		status int
+	}{
+		{name: "state mismatch", tamper: func(code, state string) (string, string) { return code, state + "x" }, status: http.StatusBadRequest},
+		{name: "unknown code", tamper: func(code, state string) (string, string) { return code + "x", state }, status: http.StatusUnauthorized},
+		{name: "nonce mismatch", login: mockLogin{claims: map[string]any{"nonce": "other-nonce"}}, status: http.StatusUnauthorized},
+		{name: "wrong audience", login: mockLogin{claims: map[string]any{"aud": "someone-else"}}, status: http.StatusUnauthorized},
+		{name: "audience list without us", login: mockLogin{claims: map[string]any{"aud": []string{"a", "b"}}}, status: http.StatusUnauthorized},
+		{name: "wrong issuer", login: mockLogin{claims: map[string]any{"iss": "https://evil.example.com"}}, status: http.StatusUnauthorized},
+		{name: "expired token", login: mockLogin{claims: map[string]any{"exp": time.Now().Add(-time.Minute).Unix()}}, status: http.StatusUnauthorized},
+		{name: "bad signature", login: mockLogin{key: otherKey}, status: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, idp := newOIDCTestApp(t)
+
+			code, state := idp.authorize(startOIDC(t, a), tc.login)
+			if tc.tamper != nil {
+				code, state = tc.tamper(code, state)
+			}
+			if rec := finishOIDC(t, a, code, state); rec.Code != tc.status {
+				t.Fatalf("callback: status %d %s, want %d", rec.Code, rec.Body, tc.status)
+			}
+			if _, err := a.repos.Users.ByEmail("oidc@example.com"); err == nil {
+				t.Fatal("a rejected login created an account")
+			}
+		})
+	}
+}
+
+// The verifier sent to the token endpoint must be the one whose challenge
+// went out in the authorization URL.
+func TestOIDCLoginPKCEVerifier(t *testing.T) {
+	a, idp := newOIDCTestApp(t)
+
+	first := startOIDC(t, a)
+	second := startOIDC(t, a)
+	// Answer the second login's state with a code bound to the first
+	// login's challenge, as an attacker injecting a stolen code would.
+	code, _ := idp.authorize(first, mockLogin{})
+	_, state := idp.authorize(second, mockLogin{})
+	if rec := finishOIDC(t, a, code, state); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("mismatched verifier: status %d %s", rec.Code, rec.Body)
+	}
+
+	code, state = idp.authorize(first, mockLogin{})
+	if rec := finishOIDC(t, a, code, state); rec.Code != http.StatusOK {
+		t.Fatalf("matching verifier: status %d %s", rec.Code, rec.Body)
+	}
+}
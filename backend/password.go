@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// bcryptCostFromEnv reads BCRYPT_COST. Raising it upgrades existing hashes the
+// next time each user logs in.
+func bcryptCostFromEnv() int {
+	raw := envOrDefault("BCRYPT_COST", "")
+	if raw == "" {
+		return bcrypt.DefaultCost
+	}
+
+	cost, err := strconv.Atoi(raw)
+	if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		log.Printf("invalid BCRYPT_COST %q, using %d", raw, bcrypt.DefaultCost)
+		return bcrypt.DefaultCost
+	}
+	return cost
+}
+
+func (a *App) hashPassword(password string) (string, error) {
+	hashBytes, err := bcrypt.GenerateFromPassword([]byte(password), a.bcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashBytes), nil
+}
+
+// upgradePasswordHash re-hashes a password that was just verified when its
+// stored hash uses a lower cost than configured. Failures are only logged,
+// the login itself already succeeded.
+func (a *App) upgradePasswordHash(userID int64, passwordHash string, password string) {
+	cost, err := bcrypt.Cost([]byte(passwordHash))
+	if err != nil || cost >= a.bcryptCost {
+		return
+	}
+
+	upgraded, err := a.hashPassword(password)
+	if err != nil {
+		log.Printf("failed upgrading password hash for user %d: %v", userID, err)
+		return
+	}
+
+	// Matching on the old hash avoids clobbering a concurrent password change.
+	if _, err := a.repos.Users.ReplacePasswordHash(userID, passwordHash, upgraded); err != nil {
+		log.Printf("failed upgrading password hash for user %d: %v", userID, err)
+	}
+}
+
+// retryAfterError is returned by checkPassword while the user is locked out.
+type retryAfterError struct {
+	wait time.Duration
+}
+
+func (e retryAfterError) Error() string {
+	return fmt.Sprintf("too many attempts, retry in %s", e.wait)
+}
+
+// retryAfter reports whether err is a lockout and how long it lasts.
+func retryAfter(err error) (time.Duration, bool) {
+	var limited retryAfterError
+	if errors.As(err, &limited) {
+		return limited.wait, true
+	}
+	return 0, false
+}
+
+// checkPassword verifies the caller's password before a sensitive account
+// action. Wrong guesses count against the passwordCheck limiter, so a stolen
+// session cannot brute-force the password through any of them; once it locks
+// the error is an retryAfterError.
+func (a *App) checkPassword(userID int64, password string) (bool, error) {
+	now := time.Now()
+	limiterKey := strconv.FormatInt(userID, 10)
+	if wait := a.limits.passwordCheck.Retry(limiterKey, now); wait > 0 {
+		return false, retryAfterError{wait: wait}
+	}
+
+	user, err := a.repos.Users.ByID(userID)
+	if err != nil {
+		return false, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		a.limits.passwordCheck.Record(limiterKey, now)
+		return false, nil
+	}
+	a.limits.passwordCheck.Reset(limiterKey)
+	return true, nil
+}
+
+func (a *App) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	var in ChangePasswordInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	if in.CurrentPassword == "" {
+		writeError(w, http.StatusBadRequest, "currentPassword is required")
+		return
+	}
+	if err := validatePassword(in.NewPassword); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	auth := authFromRequest(r)
+
+	ok, err := a.checkPassword(auth.UserID, in.CurrentPassword)
+	if wait, limited := retryAfter(err); limited {
+		writeTooManyRequests(w, wait)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load user profile")
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	newHash, err := a.hashPassword(in.NewPassword)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to process password")
+		return
+	}
+
+	if err := a.repos.Users.SetPasswordHash(auth.UserID, newHash); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to change password")
+		return
+	}
+	revoked, err := a.repos.Sessions.DeleteByUser(auth.UserID, auth.SessionID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to change password")
+		return
+	}
+	// Tokens handed to scripts were created under the old password too.
+	result, err := a.db.Exec("DELETE FROM personal_access_tokens WHERE user_id = ?", auth.UserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to change password")
+		return
+	}
+	revokedTokens, _ := result.RowsAffected()
+
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "revokedSessions": revoked, "revokedTokens": revokedTokens})
+}
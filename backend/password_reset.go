@@ -0,0 +1,200 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type ForgotPasswordInput struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordInput struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func ensurePasswordResetTable(tx *sql.Tx) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS password_reset_tokens (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        token_hash TEXT NOT NULL UNIQUE,
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        expires_at DATETIME NOT NULL,
+        used_at DATETIME
+    );
+    `
+
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed creating password_reset_tokens table: %w", err)
+	}
+
+	return nil
+}
+
+// handleForgotPassword always answers 202 so the endpoint cannot be used to
+// discover which emails are registered.
+func (a *App) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var in ForgotPasswordInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	in.Email = strings.ToLower(strings.TrimSpace(in.Email))
+	if in.Email == "" {
+		writeError(w, http.StatusBadRequest, "email is required")
+		return
+	}
+
+	if !a.allowMail(w, r, in.Email) {
+		return
+	}
+
+	accepted := map[string]string{"status": "accepted"}
+
+	user, err := a.repos.Users.ByEmail(in.Email)
+	if errors.Is(err, errNotFound) {
+		writeJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to start password reset")
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to start password reset")
+		return
+	}
+
+	now := time.Now().UTC()
+	ttl := envDurationOrDefault("RESET_TOKEN_TTL", time.Hour)
+
+	// Only the most recent link stays valid.
+	if _, err := a.db.Exec(
+		"DELETE FROM password_reset_tokens WHERE user_id = ? AND used_at IS NULL",
+		user.ID,
+	); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to start password reset")
+		return
+	}
+	if _, err := a.db.Exec(
+		"INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES (?, ?, ?)",
+		user.ID,
+		hashToken(token),
+		now.Add(ttl).Format(dbTimeLayout),
+	); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to start password reset")
+		return
+	}
+
+	link := fmt.Sprintf("%s/redefinir-senha?token=%s", a.appURL, url.QueryEscape(token))
+	a.sendMailAsync(MailMessage{
+		To:      in.Email,
+		Subject: "Redefinicao de senha - TrackSM",
+		Body: fmt.Sprintf(
+			"Ola, %s!\n\nRecebemos um pedido para redefinir a sua senha. Use o link abaixo em ate %s:\n\n%s\n\nSe voce nao fez este pedido, ignore este email.\n",
+			user.Name,
+			ttl,
+			link,
+		),
+	})
+
+	writeJSON(w, http.StatusAccepted, accepted)
+}
+
+func (a *App) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var in ResetPasswordInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	in.Token = strings.TrimSpace(in.Token)
+	if in.Token == "" {
+		writeError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+	if err := validatePassword(in.Password); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	passwordHash, err := a.hashPassword(in.Password)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to process password")
+		return
+	}
+
+	now := time.Now().UTC().Format(dbTimeLayout)
+
+	var (
+		tokenID int64
+		userID  int64
+	)
+	err = a.db.inTx(func(tx *sql.Tx) error {
+		if err := tx.QueryRow(
+			`SELECT id, user_id FROM password_reset_tokens
+             WHERE token_hash = ? AND used_at IS NULL AND expires_at > ? LIMIT 1`,
+			hashToken(in.Token),
+			now,
+		).Scan(&tokenID, &userID); err != nil {
+			return err
+		}
+
+		// The used_at guard makes the token single-use even under concurrent requests.
+		claimed, err := rowsAffected(tx.Exec(
+			"UPDATE password_reset_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL",
+			now,
+			tokenID,
+		))
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return sql.ErrNoRows
+		}
+
+		_, err = tx.Exec("DELETE FROM personal_access_tokens WHERE user_id = ?", userID)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusBadRequest, "invalid or expired token")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to reset password")
+		return
+	}
+
+	// Users and sessions may live in another database, so the token is claimed
+	// first and handed back if the password could not be written.
+	if err := a.repos.Users.SetPasswordHash(userID, passwordHash); err != nil {
+		a.releaseResetToken(tokenID)
+		writeError(w, http.StatusInternalServerError, "failed to reset password")
+		return
+	}
+	// Whoever triggered the reset may be locking out an attacker.
+	if _, err := a.repos.Sessions.DeleteByUser(userID, 0); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to reset password")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (a *App) releaseResetToken(tokenID int64) {
+	if _, err := a.db.Exec("UPDATE password_reset_tokens SET used_at = NULL WHERE id = ?", tokenID); err != nil {
+		log.Printf("failed releasing password reset token %d: %v", tokenID, err)
+	}
+}
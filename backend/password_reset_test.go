@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// resetTokenFromMail pulls the token out of the link in a reset email.
+func resetTokenFromMail(t *testing.T, msg MailMessage) string {
+	t.Helper()
+	for _, field := range strings.Fields(msg.Body) {
+		if !strings.Contains(field, "/redefinir-senha?") {
+			continue
+		}
+		link, err := url.Parse(field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return link.Query().Get("token")
+	}
+	t.Fatalf("no reset link in %q", msg.Body)
+	return ""
+}
+
+func TestPasswordResetLinkWorksOnce(t *testing.T) {
+	a, mailer := newTestApp(t)
+	user := createPasswordUser(t, a, "reset@example.com", "old-password")
+	session := loginSession(t, a, user.ID)
+
+	rec := serve(a.handleForgotPassword, jsonRequest(t, http.MethodPost, "/api/auth/password/forgot", "", ForgotPasswordInput{Email: " Reset@Example.com "}))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("forgot: status %d %s", rec.Code, rec.Body)
+	}
+	msg := mailer.next(t)
+	if msg.To != "reset@example.com" {
+		t.Fatalf("mail sent to %q", msg.To)
+	}
+	token := resetTokenFromMail(t, msg)
+
+	reset := ResetPasswordInput{Token: token, Password: "new-password"}
+	rec = serve(a.handleResetPassword, jsonRequest(t, http.MethodPost, "/api/auth/password/reset", "", reset))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reset: status %d %s", rec.Code, rec.Body)
+	}
+	updated, err := a.repos.Users.ByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(updated.PasswordHash), []byte("new-password")) != nil {
+		t.Fatal("password was not changed")
+	}
+	if rec := serve(a.requireAuth(scopeSessionOnly, a.handleListSessions), jsonRequest(t, http.MethodGet, "/api/auth/sessions", session, nil)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("session survived the reset: status %d", rec.Code)
+	}
+
+	reset.Password = "third-password"
+	rec = serve(a.handleResetPassword, jsonRequest(t, http.MethodPost, "/api/auth/password/reset", "", reset))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("second reset: status %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestPasswordResetOnlyLatestLinkIsValid(t *testing.T) {
+	a, mailer := newTestApp(t)
+	createPasswordUser(t, a, "reset@example.com", "old-password")
+
+	var tokens []string
+	for range 2 {
+		serve(a.handleForgotPassword, jsonRequest(t, http.MethodPost, "/api/auth/password/forgot", "", ForgotPasswordInput{Email: "reset@example.com"}))
+		tokens = append(tokens, resetTokenFromMail(t, mailer.next(t)))
+	}
+
+	rec := serve(a.handleResetPassword, jsonRequest(t, http.MethodPost, "/api/auth/password/reset", "", ResetPasswordInput{Token: tokens[0], Password: "new-password"}))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("superseded link: status %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(a.handleResetPassword, jsonRequest(t, http.MethodPost, "/api/auth/password/reset", "", ResetPasswordInput{Token: tokens[1], Password: "new-password"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("latest link: status %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestPasswordResetExpiredLink(t *testing.T) {
+	a, mailer := newTestApp(t)
+	createPasswordUser(t, a, "reset@example.com", "old-password")
+
+	serve(a.handleForgotPassword, jsonRequest(t, http.MethodPost, "/api/auth/password/forgot", "", ForgotPasswordInput{Email: "reset@example.com"}))
+	token := resetTokenFromMail(t, mailer.next(t))
+	if _, err := a.db.Exec("UPDATE password_reset_tokens SET expires_at = '2000-01-01 00:00:00'"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(a.handleResetPassword, jsonRequest(t, http.MethodPost, "/api/auth/password/reset", "", ResetPasswordInput{Token: token, Password: "new-password"}))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expired link: status %d %s", rec.Code, rec.Body)
+	}
+}
+
+// Unknown emails get the same answer as registered ones and no mail.
+func TestPasswordResetUnknownEmail(t *testing.T) {
+	a, mailer := newTestApp(t)
+	createPasswordUser(t, a, "reset@example.com", "old-password")
+
+	known := serve(a.handleForgotPassword, jsonRequest(t, http.MethodPost, "/api/auth/password/forgot", "", ForgotPasswordInput{Email: "reset@example.com"}))
+	mailer.next(t)
+	unknown := serve(a.handleForgotPassword, jsonRequest(t, http.MethodPost, "/api/auth/password/forgot", "", ForgotPasswordInput{Email: "nobody@example.com"}))
+
+	if unknown.Code != known.Code || unknown.Body.String() != known.Body.String() {
+		t.Fatalf("unknown email answered %d %q, known %d %q", unknown.Code, unknown.Body, known.Code, known.Body)
+	}
+	mailer.none(t)
+}
+
+// Reset and verification mail share per-address and per-IP limits, counted
+// whether or not the address is registered.
+func TestPasswordResetMailIsRateLimited(t *testing.T) {
+	a, mailer := newTestApp(t)
+	createPasswordUser(t, a, "reset@example.com", "old-password")
+	send := func(handler http.HandlerFunc, target string, body any, ip string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := jsonRequest(t, http.MethodPost, target, "", body)
+		req.RemoteAddr = ip + ":4321"
+		return serve(handler, req)
+	}
+
+	for i := 0; i < a.limits.mailEmail.limit; i++ {
+		rec := send(a.handleForgotPassword, "/api/auth/password/forgot", ForgotPasswordInput{Email: "reset@example.com"}, fmt.Sprintf("198.51.100.%d", i))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("forgot %d: status %d %s", i, rec.Code, rec.Body)
+		}
+		mailer.next(t)
+	}
+	rec := send(a.handleResendVerification, "/api/auth/email/resend", ResendVerificationInput{Email: "reset@example.com"}, "203.0.113.1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("same address after the limit: status %d %s", rec.Code, rec.Body)
+	}
+	mailer.none(t)
+
+	for i := 0; i < a.limits.mailIP.limit; i++ {
+		rec := send(a.handleForgotPassword, "/api/auth/password/forgot", ForgotPasswordInput{Email: fmt.Sprintf("nobody%d@example.com", i)}, "203.0.113.2")
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("unknown address %d: status %d %s", i, rec.Code, rec.Body)
+		}
+	}
+	rec = send(a.handleResendVerification, "/api/auth/email/resend", ResendVerificationInput{Email: "other@example.com"}, "203.0.113.2")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("same IP after the limit: status %d %s", rec.Code, rec.Body)
+	}
+}
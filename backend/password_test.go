@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestChangePasswordRevokesSessionsAndTokens(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "change@example.com", "old-password")
+	current := loginSession(t, a, user.ID)
+	other := loginSession(t, a, user.ID)
+
+	rec := serve(a.requireAuth(scopeSessionOnly, a.handleCreateAccessToken), jsonRequest(t, http.MethodPost, "/api/auth/tokens", current, CreateAccessTokenInput{Name: "script", Scopes: []string{scopeWatchedRead}}))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create token: status %d %s", rec.Code, rec.Body)
+	}
+	var pat CreateAccessTokenResponse
+	decodeBody(t, rec, &pat)
+
+	rec = serve(a.requireAuth(scopeSessionOnly, a.handleChangePassword), jsonRequest(t, http.MethodPost, "/api/auth/password", current, ChangePasswordInput{CurrentPassword: "old-password", NewPassword: "new-password"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("change: status %d %s", rec.Code, rec.Body)
+	}
+	var out struct {
+		RevokedSessions int `json:"revokedSessions"`
+		RevokedTokens   int `json:"revokedTokens"`
+	}
+	decodeBody(t, rec, &out)
+	if out.RevokedSessions != 1 || out.RevokedTokens != 1 {
+		t.Fatalf("change: got %+v", out)
+	}
+
+	listWatched := a.requireAuth(scopeWatchedRead, a.handleListWatched)
+	for name, token := range map[string]string{"other session": other, "access token": pat.Token} {
+		if rec := serve(listWatched, jsonRequest(t, http.MethodGet, "/api/user/watched?mediaType=all", token, nil)); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%s still works: status %d", name, rec.Code)
+		}
+	}
+	if rec := serve(listWatched, jsonRequest(t, http.MethodGet, "/api/user/watched?mediaType=all", current, nil)); rec.Code != http.StatusOK {
+		t.Fatalf("current session: status %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestChangePasswordRateLimitsWrongPasswords(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "change@example.com", "old-password")
+	session := loginSession(t, a, user.ID)
+	change := a.requireAuth(scopeSessionOnly, a.handleChangePassword)
+
+	for i := 0; i < a.limits.passwordCheck.limit; i++ {
+		rec := serve(change, jsonRequest(t, http.MethodPost, "/api/auth/password", session, ChangePasswordInput{CurrentPassword: "guess", NewPassword: "new-password"}))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: status %d %s", i, rec.Code, rec.Body)
+		}
+	}
+
+	rec := serve(change, jsonRequest(t, http.MethodPost, "/api/auth/password", session, ChangePasswordInput{CurrentPassword: "old-password", NewPassword: "new-password"}))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("after lockout: status %d %s", rec.Code, rec.Body)
+	}
+}
+
+// Every action that asks for the current password shares one limiter, so
+// guesses cannot be spread across endpoints.
+func TestPasswordChecksShareRateLimit(t *testing.T) {
+	type endpoint struct {
+		name    string
+		handler func(*App) http.HandlerFunc
+		method  string
+		target  string
+		body    func(password string) any
+	}
+	endpoints := []endpoint{
+		{"change password", func(a *App) http.HandlerFunc { return a.handleChangePassword }, http.MethodPost, "/api/auth/password", func(p string) any {
+			return ChangePasswordInput{CurrentPassword: p, NewPassword: "new-password"}
+		}},
+		{"change email", func(a *App) http.HandlerFunc { return a.handleChangeEmail }, http.MethodPost, "/api/auth/email/change", func(p string) any {
+			return ChangeEmailInput{Email: "new@example.com", Password: p}
+		}},
+		{"disable 2fa", func(a *App) http.HandlerFunc { return a.handleTwoFactorDisable }, http.MethodPost, "/api/auth/profile/2fa/disable", func(p string) any {
+			return TwoFactorPasswordInput{Password: p}
+		}},
+		{"recovery codes", func(a *App) http.HandlerFunc { return a.handleRegenerateRecoveryCodes }, http.MethodPost, "/api/auth/profile/2fa/recovery-codes", func(p string) any {
+			return TwoFactorPasswordInput{Password: p}
+		}},
+		{"delete account", func(a *App) http.HandlerFunc { return a.handleDeleteAccount }, http.MethodDelete, "/api/auth/account", func(p string) any {
+			return DeleteAccountInput{Password: p}
+		}},
+	}
+
+	for _, guessed := range endpoints {
+		t.Run(guessed.name, func(t *testing.T) {
+			a, _ := newTestApp(t)
+			user := createPasswordUser(t, a, "guess@example.com", "old-password")
+			session := loginSession(t, a, user.ID)
+			call := func(e endpoint, password string) int {
+				t.Helper()
+				return serve(a.requireAuth(scopeSessionOnly, e.handler(a)), jsonRequest(t, e.method, e.target, session, e.body(password))).Code
+			}
+
+			for i := 0; i < a.limits.passwordCheck.limit; i++ {
+				if code := call(guessed, "guess"); code != http.StatusUnauthorized {
+					t.Fatalf("guess %d: status %d", i, code)
+				}
+			}
+			for _, e := range endpoints {
+				if code := call(e, "old-password"); code != http.StatusTooManyRequests {
+					t.Fatalf("%s after lockout: status %d", e.name, code)
+				}
+			}
+		})
+	}
+}
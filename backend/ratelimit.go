@@ -0,0 +1,242 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memoryStoreSweepInterval is how often MemoryAttemptStore drops expired
+// records, so keys that are probed once do not pile up.
+const memoryStoreSweepInterval = time.Minute
+
+// AttemptRecord is the per-key state kept by an AttemptStore.
+type AttemptRecord struct {
+	Attempts    []time.Time
+	Lockouts    int
+	LockedUntil time.Time
+	LastSeen    time.Time
+	// ExpiresAt lets the store drop the record once its attempts have left
+	// the window. Zero keeps it until it has been idle for the store's ttl,
+	// which is how lockout history survives between attempts.
+	ExpiresAt time.Time
+}
+
+// AttemptStore holds attempt counters. Update must apply fn atomically for the
+// key, which is what lets a shared backend replace the in-process one. Get
+// must not create a record for an unknown key.
+type AttemptStore interface {
+	Get(key string) (AttemptRecord, bool)
+	Update(key string, fn func(rec *AttemptRecord)) AttemptRecord
+	Delete(key string)
+}
+
+type MemoryAttemptStore struct {
+	mu        sync.Mutex
+	records   map[string]*AttemptRecord
+	ttl       time.Duration
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryAttemptStore keeps records until they expire or have been idle
+// for ttl.
+func NewMemoryAttemptStore(ttl time.Duration) *MemoryAttemptStore {
+	return &MemoryAttemptStore{records: make(map[string]*AttemptRecord), ttl: ttl, now: time.Now}
+}
+
+func (s *MemoryAttemptStore) expired(rec *AttemptRecord, now time.Time) bool {
+	if now.Before(rec.LockedUntil) {
+		return false
+	}
+	if !rec.ExpiresAt.IsZero() {
+		return now.After(rec.ExpiresAt)
+	}
+	return now.Sub(rec.LastSeen) > s.ttl
+}
+
+// sweep must be called with s.mu held.
+func (s *MemoryAttemptStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memoryStoreSweepInterval {
+		return
+	}
+	for k, rec := range s.records {
+		if s.expired(rec, now) {
+			delete(s.records, k)
+		}
+	}
+	s.lastSweep = now
+}
+
+func (s *MemoryAttemptStore) Get(key string) (AttemptRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	rec, ok := s.records[key]
+	if !ok || s.expired(rec, now) {
+		return AttemptRecord{}, false
+	}
+	out := *rec
+	out.Attempts = append([]time.Time(nil), rec.Attempts...)
+	return out, true
+}
+
+func (s *MemoryAttemptStore) Update(key string, fn func(rec *AttemptRecord)) AttemptRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	rec, ok := s.records[key]
+	if !ok || s.expired(rec, now) {
+		rec = &AttemptRecord{}
+		s.records[key] = rec
+	}
+	fn(rec)
+	rec.LastSeen = now
+
+	out := *rec
+	out.Attempts = append([]time.Time(nil), rec.Attempts...)
+	return out
+}
+
+func (s *MemoryAttemptStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+}
+
+// RateLimiter allows limit attempts per key within a sliding window. Going over
+// locks the key for baseLockout, doubling on every consecutive lockout up to
+// maxLockout.
+type RateLimiter struct {
+	store       AttemptStore
+	prefix      string
+	limit       int
+	window      time.Duration
+	baseLockout time.Duration
+	maxLockout  time.Duration
+}
+
+func (l *RateLimiter) key(key string) string {
+	return l.prefix + ":" + key
+}
+
+// Retry returns how long key has to wait before its next attempt.
+func (l *RateLimiter) Retry(key string, now time.Time) time.Duration {
+	rec, ok := l.store.Get(l.key(key))
+	if ok && now.Before(rec.LockedUntil) {
+		return rec.LockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// Record counts an attempt and returns the lockout it triggered, if any.
+func (l *RateLimiter) Record(key string, now time.Time) time.Duration {
+	rec := l.store.Update(l.key(key), func(rec *AttemptRecord) {
+		cutoff := now.Add(-l.window)
+		kept := rec.Attempts[:0]
+		for _, at := range rec.Attempts {
+			if at.After(cutoff) {
+				kept = append(kept, at)
+			}
+		}
+		rec.Attempts = append(kept, now)
+
+		if len(rec.Attempts) < l.limit {
+			if rec.Lockouts == 0 {
+				rec.ExpiresAt = now.Add(l.window)
+			}
+			return
+		}
+
+		lockout := l.baseLockout
+		for i := 0; i < rec.Lockouts && lockout < l.maxLockout; i++ {
+			lockout *= 2
+		}
+		lockout = min(lockout, l.maxLockout)
+		rec.Lockouts++
+		rec.LockedUntil = now.Add(lockout)
+		rec.Attempts = rec.Attempts[:0]
+		rec.ExpiresAt = time.Time{}
+	})
+
+	if now.Before(rec.LockedUntil) {
+		return rec.LockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// Reset forgets the attempts and lockout history for key.
+func (l *RateLimiter) Reset(key string) {
+	l.store.Delete(l.key(key))
+}
+
+type AuthRateLimits struct {
+	loginEmail    *RateLimiter
+	loginIP       *RateLimiter
+	registerEmail *RateLimiter
+	registerIP    *RateLimiter
+	twoFactor     *RateLimiter
+	passwordCheck *RateLimiter
+	mailEmail     *RateLimiter
+	mailIP        *RateLimiter
+}
+
+func NewAuthRateLimits(store AttemptStore) *AuthRateLimits {
+	window := envDurationOrDefault("RATE_LIMIT_WINDOW", 15*time.Minute)
+	baseLockout := envDurationOrDefault("RATE_LIMIT_LOCKOUT", time.Minute)
+	maxLockout := envDurationOrDefault("RATE_LIMIT_MAX_LOCKOUT", 24*time.Hour)
+
+	limiter := func(prefix string, limitKey string, fallback int) *RateLimiter {
+		return &RateLimiter{
+			store:       store,
+			prefix:      prefix,
+			limit:       envIntOrDefault(limitKey, fallback),
+			window:      window,
+			baseLockout: baseLockout,
+			maxLockout:  maxLockout,
+		}
+	}
+
+	return &AuthRateLimits{
+		loginEmail:    limiter("login:email", "RATE_LIMIT_LOGIN_EMAIL", 5),
+		loginIP:       limiter("login:ip", "RATE_LIMIT_LOGIN_IP", 20),
+		registerEmail: limiter("register:email", "RATE_LIMIT_REGISTER_EMAIL", 3),
+		registerIP:    limiter("register:ip", "RATE_LIMIT_REGISTER_IP", 10),
+		twoFactor:     limiter("2fa:user", "RATE_LIMIT_TWO_FACTOR", 5),
+		passwordCheck: limiter("password:user", "RATE_LIMIT_PASSWORD_CHECK", 5),
+		mailEmail:     limiter("mail:email", "RATE_LIMIT_MAIL_EMAIL", 3),
+		mailIP:        limiter("mail:ip", "RATE_LIMIT_MAIL_IP", 10),
+	}
+}
+
+func writeTooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, http.StatusTooManyRequests, "too many attempts, try again later")
+}
+
+// allowMail counts a request that sends mail to email from an anonymous
+// caller. Every request counts, whether or not the address is registered, so
+// the limit neither floods an inbox nor tells accounts apart.
+func (a *App) allowMail(w http.ResponseWriter, r *http.Request, email string) bool {
+	now := time.Now()
+	ip := a.clientIP(r)
+	if wait := max(a.limits.mailEmail.Retry(email, now), a.limits.mailIP.Retry(ip, now)); wait > 0 {
+		writeTooManyRequests(w, wait)
+		return false
+	}
+	a.limits.mailEmail.Record(email, now)
+	a.limits.mailIP.Record(ip, now)
+	return true
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testLimiter returns a limiter over a store that shares the test's clock.
+func testLimiter(clock *time.Time) (*RateLimiter, *MemoryAttemptStore) {
+	store := NewMemoryAttemptStore(48 * time.Hour)
+	store.now = func() time.Time { return *clock }
+	return &RateLimiter{
+		store:       store,
+		prefix:      "test",
+		limit:       3,
+		window:      10 * time.Minute,
+		baseLockout: time.Minute,
+		maxLockout:  5 * time.Minute,
+	}, store
+}
+
+func TestRateLimiterLockouts(t *testing.T) {
+	type step struct {
+		after time.Duration // since the previous step
+		op    string        // "record", "retry" or "reset"
+		want  time.Duration
+	}
+	for _, tc := range []struct {
+		name  string
+		steps []step
+	}{
+		{"under the limit", []step{
+			{0, "record", 0},
+			{time.Second, "record", 0},
+			{time.Second, "retry", 0},
+		}},
+		{"lockout at the limit", []step{
+			{0, "record", 0},
+			{time.Second, "record", 0},
+			{time.Second, "record", time.Minute},
+			{time.Second, "retry", 59 * time.Second},
+			{59 * time.Second, "retry", 0},
+		}},
+		{"attempts leave the window", []step{
+			{0, "record", 0},
+			{time.Second, "record", 0},
+			{10 * time.Minute, "record", 0},
+			{time.Second, "record", 0},
+			{time.Second, "record", time.Minute},
+		}},
+		{"consecutive lockouts double up to the max", []step{
+			{0, "record", 0}, {0, "record", 0}, {0, "record", time.Minute},
+			{time.Minute, "record", 0}, {0, "record", 0}, {0, "record", 2 * time.Minute},
+			{2 * time.Minute, "record", 0}, {0, "record", 0}, {0, "record", 4 * time.Minute},
+			{4 * time.Minute, "record", 0}, {0, "record", 0}, {0, "record", 5 * time.Minute},
+			{5 * time.Minute, "record", 0}, {0, "record", 0}, {0, "record", 5 * time.Minute},
+		}},
+		{"success resets the backoff", []step{
+			{0, "record", 0}, {0, "record", 0}, {0, "record", time.Minute},
+			{time.Minute, "record", 0}, {0, "record", 0}, {0, "record", 2 * time.Minute},
+			{2 * time.Minute, "reset", 0},
+			{0, "retry", 0},
+			{0, "record", 0}, {0, "record", 0}, {0, "record", time.Minute},
+		}},
+		{"reset lifts an active lockout", []step{
+			{0, "record", 0}, {0, "record", 0}, {0, "record", time.Minute},
+			{time.Second, "reset", 0},
+			{0, "retry", 0},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+			limiter, _ := testLimiter(&clock)
+			for i, s := range tc.steps {
+				clock = clock.Add(s.after)
+				var got time.Duration
+				switch s.op {
+				case "record":
+					got = limiter.Record("key", clock)
+				case "retry":
+					got = limiter.Retry("key", clock)
+				case "reset":
+					limiter.Reset("key")
+				}
+				if got != s.want {
+					t.Fatalf("step %d (%s): got %v, want %v", i, s.op, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterRetryDoesNotStoreUnknownKeys(t *testing.T) {
+	clock := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter, store := testLimiter(&clock)
+
+	for _, key := range []string{"a@example.com", "b@example.com", "10.0.0.1"} {
+		if wait := limiter.Retry(key, clock); wait != 0 {
+			t.Fatalf("Retry(%s) = %v", key, wait)
+		}
+	}
+	if len(store.records) != 0 {
+		t.Fatalf("Retry stored %d records", len(store.records))
+	}
+}
+
+func TestMemoryAttemptStorePrunesExpiredRecords(t *testing.T) {
+	clock := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter, store := testLimiter(&clock)
+
+	limiter.Record("probe", clock)
+	for range limiter.limit {
+		limiter.Record("locked", clock)
+	}
+
+	// Once the window has passed, attempts alone are no reason to keep a key.
+	clock = clock.Add(limiter.window + memoryStoreSweepInterval)
+	limiter.Retry("other", clock)
+	if _, ok := store.records["test:probe"]; ok {
+		t.Fatal("expired attempts were kept")
+	}
+	if _, ok := store.records["test:locked"]; !ok {
+		t.Fatal("lockout history was dropped before the ttl")
+	}
+
+	clock = clock.Add(store.ttl)
+	limiter.Retry("other", clock)
+	if len(store.records) != 0 {
+		t.Fatalf("%d records left after the ttl", len(store.records))
+	}
+}
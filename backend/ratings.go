@@ -0,0 +1,371 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Rating scales. Scores are stored from 1 to 10 either way, so a half-star
+// rating is the score divided by two and the scale can change at any time.
+const (
+	ratingScaleStars = 5
+	ratingScaleTen   = 10
+)
+
+const maxReviewLength = 10000
+
+type RatingInput struct {
+	UserID        int64   `json:"userId"`
+	MediaType     string  `json:"mediaType"`
+	TmdbID        int64   `json:"tmdbId"`
+	SeasonNumber  int64   `json:"seasonNumber"`
+	EpisodeNumber int64   `json:"episodeNumber"`
+	Rating        float64 `json:"rating"`
+	// Scale is 5 (half stars) or 10; zero means the server default.
+	Scale   int    `json:"scale"`
+	Review  string `json:"review"`
+	Spoiler bool   `json:"spoiler"`
+}
+
+type RatingItem struct {
+	UserID        int64   `json:"userId"`
+	MediaType     string  `json:"mediaType"`
+	TmdbID        int64   `json:"tmdbId"`
+	SeasonNumber  int64   `json:"seasonNumber"`
+	EpisodeNumber int64   `json:"episodeNumber"`
+	Rating        float64 `json:"rating"`
+	Scale         int     `json:"scale"`
+	Review        string  `json:"review"`
+	Spoiler       bool    `json:"spoiler"`
+	CreatedAt     string  `json:"createdAt"`
+	UpdatedAt     string  `json:"updatedAt"`
+}
+
+type RatingSummaryItem struct {
+	MediaType     string  `json:"mediaType"`
+	TmdbID        int64   `json:"tmdbId"`
+	SeasonNumber  int64   `json:"seasonNumber"`
+	EpisodeNumber int64   `json:"episodeNumber"`
+	Average       float64 `json:"average"`
+	Count         int     `json:"count"`
+	Scale         int     `json:"scale"`
+}
+
+func ensureRatingsTable(tx *sql.Tx) error {
+	query := `
+    CREATE TABLE ratings (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        media_type TEXT NOT NULL,
+        tmdb_id INTEGER NOT NULL,
+        season_number INTEGER NOT NULL DEFAULT 0,
+        episode_number INTEGER NOT NULL DEFAULT 0,
+        score INTEGER NOT NULL CHECK (score BETWEEN 1 AND 10),
+        review TEXT NOT NULL DEFAULT '',
+        spoiler INTEGER NOT NULL DEFAULT 0,
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE(user_id, media_type, tmdb_id, season_number, episode_number)
+    );
+    CREATE INDEX idx_ratings_item ON ratings(media_type, tmdb_id, season_number, episode_number);
+    CREATE INDEX idx_ratings_user_updated_at ON ratings(user_id, updated_at DESC);
+    `
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed creating ratings table: %w", err)
+	}
+	return nil
+}
+
+func ratingScaleFromEnv() int {
+	raw := envOrDefault("RATING_SCALE", strconv.Itoa(ratingScaleStars))
+	scale, err := strconv.Atoi(raw)
+	if err != nil || (scale != ratingScaleStars && scale != ratingScaleTen) {
+		log.Printf("invalid RATING_SCALE %q, using %d", raw, ratingScaleStars)
+		return ratingScaleStars
+	}
+	return scale
+}
+
+// resolveRatingScale picks the scale a request asked for, or the server default.
+func (a *App) resolveRatingScale(requested int) (int, error) {
+	switch requested {
+	case 0:
+		return a.ratingScale, nil
+	case ratingScaleStars, ratingScaleTen:
+		return requested, nil
+	default:
+		return 0, fmt.Errorf("scale must be 5 or 10")
+	}
+}
+
+func scoreFromRating(rating float64, scale int) (int, error) {
+	if scale == ratingScaleStars {
+		doubled := rating * 2
+		if doubled != math.Trunc(doubled) || doubled < 1 || doubled > 10 {
+			return 0, fmt.Errorf("rating must be between 0.5 and 5 in steps of 0.5")
+		}
+		return int(doubled), nil
+	}
+	if rating != math.Trunc(rating) || rating < 1 || rating > 10 {
+		return 0, fmt.Errorf("rating must be a whole number between 1 and 10")
+	}
+	return int(rating), nil
+}
+
+// ratingFromScore converts a score, or an average of scores, to scale.
+func ratingFromScore(score float64, scale int) float64 {
+	if scale == ratingScaleStars {
+		score /= 2
+	}
+	return math.Round(score*100) / 100
+}
+
+func ratingItem(rating Rating, scale int) RatingItem {
+	return RatingItem{
+		UserID:        rating.UserID,
+		MediaType:     rating.MediaType,
+		TmdbID:        rating.TmdbID,
+		SeasonNumber:  rating.SeasonNumber,
+		EpisodeNumber: rating.EpisodeNumber,
+		Rating:        ratingFromScore(float64(rating.Score), scale),
+		Scale:         scale,
+		Review:        rating.Review,
+		Spoiler:       rating.Spoiler,
+		CreatedAt:     rating.CreatedAt.UTC().Format(time.RFC3339),
+		UpdatedAt:     rating.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// ratingKey validates the item fields of in the way watched items are
+// validated, so ratings exist at the same granularity.
+func ratingKey(in RatingInput) (Rating, error) {
+	item := WatchedInput{
+		UserID:        in.UserID,
+		MediaType:     in.MediaType,
+		TmdbID:        in.TmdbID,
+		SeasonNumber:  in.SeasonNumber,
+		EpisodeNumber: in.EpisodeNumber,
+	}
+	if err := normalizeWatchedInput(&item); err != nil {
+		return Rating{}, err
+	}
+	return Rating{
+		UserID:        item.UserID,
+		MediaType:     item.MediaType,
+		TmdbID:        item.TmdbID,
+		SeasonNumber:  item.SeasonNumber,
+		EpisodeNumber: item.EpisodeNumber,
+	}, nil
+}
+
+// ratingFilterFromQuery reads the optional mediaType, tmdbId, seasonNumber
+// and episodeNumber query parameters, plus scale.
+func (a *App) ratingFilterFromQuery(w http.ResponseWriter, r *http.Request) (RatingFilter, int, bool) {
+	query := r.URL.Query()
+	var filter RatingFilter
+
+	switch mediaType := strings.ToLower(strings.TrimSpace(query.Get("mediaType"))); mediaType {
+	case "", "all":
+	case "movie", "tv":
+		filter.MediaType = mediaType
+	default:
+		writeError(w, http.StatusBadRequest, "mediaType must be movie, tv or all")
+		return RatingFilter{}, 0, false
+	}
+
+	if raw := strings.TrimSpace(query.Get("tmdbId")); raw != "" {
+		tmdbID, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || tmdbID <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid tmdbId")
+			return RatingFilter{}, 0, false
+		}
+		filter.TmdbID = tmdbID
+	}
+	if raw := strings.TrimSpace(query.Get("seasonNumber")); raw != "" {
+		seasonNumber, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || seasonNumber < 0 {
+			writeError(w, http.StatusBadRequest, "invalid seasonNumber")
+			return RatingFilter{}, 0, false
+		}
+		filter.SeasonNumber = &seasonNumber
+	}
+	if raw := strings.TrimSpace(query.Get("episodeNumber")); raw != "" {
+		episodeNumber, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || episodeNumber < 0 {
+			writeError(w, http.StatusBadRequest, "invalid episodeNumber")
+			return RatingFilter{}, 0, false
+		}
+		filter.EpisodeNumber = &episodeNumber
+	}
+
+	requested := 0
+	if raw := strings.TrimSpace(query.Get("scale")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "scale must be 5 or 10")
+			return RatingFilter{}, 0, false
+		}
+		requested = parsed
+	}
+	scale, err := a.resolveRatingScale(requested)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return RatingFilter{}, 0, false
+	}
+	return filter, scale, true
+}
+
+// handleSetRating creates or replaces the user's rating of an item.
+func (a *App) handleSetRating(w http.ResponseWriter, r *http.Request) {
+	var in RatingInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	userID, err := resolveUserID(r, in.UserID)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	in.UserID = userID
+
+	var errs ValidationErrors
+	rating, err := ratingKey(in)
+	if err != nil {
+		errs.add("item", codeInvalidValue, err.Error())
+	}
+	scale, err := a.resolveRatingScale(in.Scale)
+	if err != nil {
+		errs.add("scale", codeInvalidValue, err.Error())
+	} else if rating.Score, err = scoreFromRating(in.Rating, scale); err != nil {
+		errs.add("rating", codeOutOfRange, err.Error())
+	}
+	rating.Review = strings.TrimSpace(in.Review)
+	if utf8.RuneCountInString(rating.Review) > maxReviewLength {
+		errs.add("review", codeTooLong, fmt.Sprintf("review must have at most %d characters", maxReviewLength))
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, http.StatusBadRequest, errs)
+		return
+	}
+	// A spoiler flag without a review would hide nothing.
+	rating.Spoiler = in.Spoiler && rating.Review != ""
+
+	if err := a.repos.Ratings.Set(&rating, time.Now().UTC().Truncate(time.Second)); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to save rating")
+		return
+	}
+	writeJSON(w, http.StatusOK, ratingItem(rating, scale))
+}
+
+func (a *App) handleDeleteRating(w http.ResponseWriter, r *http.Request) {
+	var in RatingInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	userID, err := resolveUserID(r, in.UserID)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	in.UserID = userID
+
+	key, err := ratingKey(in)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	deleted, err := a.repos.Ratings.Delete(key)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete rating")
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, "rating not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListUserRatings returns one user's ratings and reviews. Reviews
+// marked as spoilers are included; clients decide whether to hide them.
+func (a *App) handleListUserRatings(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(strings.TrimSpace(r.PathValue("userId")), 10, 64)
+	if err != nil || userID <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid userId")
+		return
+	}
+	filter, scale, ok := a.ratingFilterFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := a.repos.Users.ByID(userID); errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load user")
+		return
+	}
+
+	filter.UserID = userID
+	ratings, err := a.repos.Ratings.List(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list ratings")
+		return
+	}
+
+	out := make([]RatingItem, 0, len(ratings))
+	for _, rating := range ratings {
+		out = append(out, ratingItem(rating, scale))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleRatingSummaries returns the community average of a movie or show and,
+// for shows, of each rated season and episode. seasonNumber and episodeNumber
+// narrow it to one of them.
+func (a *App) handleRatingSummaries(w http.ResponseWriter, r *http.Request) {
+	mediaType, tmdbID, ok := titleFromPath(w, r)
+	if !ok {
+		return
+	}
+	filter, scale, ok := a.ratingFilterFromQuery(w, r)
+	if !ok {
+		return
+	}
+	filter.MediaType = mediaType
+	filter.TmdbID = tmdbID
+
+	summaries, err := a.repos.Ratings.Summaries(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load ratings")
+		return
+	}
+
+	out := make([]RatingSummaryItem, 0, len(summaries))
+	for _, summary := range summaries {
+		out = append(out, RatingSummaryItem{
+			MediaType:     summary.MediaType,
+			TmdbID:        summary.TmdbID,
+			SeasonNumber:  summary.SeasonNumber,
+			EpisodeNumber: summary.EpisodeNumber,
+			Average:       ratingFromScore(summary.AverageScore, scale),
+			Count:         summary.Count,
+			Scale:         scale,
+		})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
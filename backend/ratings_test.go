@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestRatingScaleRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		stars float64
+		ten   float64
+	}{
+		{0.5, 1},
+		{1, 2},
+		{2.5, 5},
+		{4.5, 9},
+		{5, 10},
+	} {
+		starScore, err := scoreFromRating(tc.stars, ratingScaleStars)
+		if err != nil {
+			t.Fatalf("%v stars: %v", tc.stars, err)
+		}
+		tenScore, err := scoreFromRating(tc.ten, ratingScaleTen)
+		if err != nil {
+			t.Fatalf("%v of 10: %v", tc.ten, err)
+		}
+		if starScore != tenScore {
+			t.Fatalf("%v stars stored as %d, %v of 10 as %d", tc.stars, starScore, tc.ten, tenScore)
+		}
+		if got := ratingFromScore(float64(starScore), ratingScaleTen); got != tc.ten {
+			t.Errorf("%v stars read on 10: got %v, want %v", tc.stars, got, tc.ten)
+		}
+		if got := ratingFromScore(float64(tenScore), ratingScaleStars); got != tc.stars {
+			t.Errorf("%v of 10 read on 5: got %v, want %v", tc.ten, got, tc.stars)
+		}
+	}
+
+	// Every stored score, odd ones included, survives a trip through either scale.
+	for score := 1; score <= 10; score++ {
+		for _, scale := range []int{ratingScaleStars, ratingScaleTen} {
+			got, err := scoreFromRating(ratingFromScore(float64(score), scale), scale)
+			if err != nil || got != score {
+				t.Errorf("score %d on %d: got %d, %v", score, scale, got, err)
+			}
+		}
+	}
+}
+
+func TestScoreFromRatingRejects(t *testing.T) {
+	for _, tc := range []struct {
+		rating float64
+		scale  int
+	}{
+		{0, ratingScaleStars},
+		{0.25, ratingScaleStars},
+		{5.5, ratingScaleStars},
+		{-0.5, ratingScaleStars},
+		{4.75, ratingScaleStars},
+		{0, ratingScaleTen},
+		{0.5, ratingScaleTen},
+		{9.5, ratingScaleTen},
+		{11, ratingScaleTen},
+	} {
+		if score, err := scoreFromRating(tc.rating, tc.scale); err == nil {
+			t.Errorf("%v on %d: got score %d, want an error", tc.rating, tc.scale, score)
+		}
+	}
+}
+
+func TestRatingsAcrossScales(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "ratings@example.com", "password123")
+	session := loginSession(t, a, user.ID)
+	set := a.requireAuth(scopeRatingsWrite, a.handleSetRating)
+	list := a.requireAuth(scopeRatingsRead, a.handleListUserRatings)
+	read := func(scale int) float64 {
+		t.Helper()
+		req := jsonRequest(t, http.MethodGet, fmt.Sprintf("/api/users/%d/ratings?scale=%d", user.ID, scale), session, nil)
+		req.SetPathValue("userId", fmt.Sprint(user.ID))
+		rec := serve(list, req)
+		var out []RatingItem
+		decodeBody(t, rec, &out)
+		if rec.Code != http.StatusOK || len(out) != 1 || out[0].Scale != scale {
+			t.Fatalf("list on %d: status %d, %+v", scale, rec.Code, out)
+		}
+		return out[0].Rating
+	}
+
+	for _, tc := range []struct {
+		rating float64
+		scale  int
+		stars  float64
+		ten    float64
+	}{
+		{0.5, ratingScaleStars, 0.5, 1},
+		{5, ratingScaleStars, 5, 10},
+		{1, ratingScaleTen, 0.5, 1},
+		{10, ratingScaleTen, 5, 10},
+		// The server default is half stars.
+		{3.5, 0, 3.5, 7},
+	} {
+		in := RatingInput{MediaType: "movie", TmdbID: 603, Rating: tc.rating, Scale: tc.scale}
+		rec := serve(set, jsonRequest(t, http.MethodPut, "/api/user/ratings", session, in))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("rate %v on %d: status %d %s", tc.rating, tc.scale, rec.Code, rec.Body)
+		}
+		if stars, ten := read(ratingScaleStars), read(ratingScaleTen); stars != tc.stars || ten != tc.ten {
+			t.Fatalf("rated %v on %d: read %v stars and %v of 10, want %v and %v", tc.rating, tc.scale, stars, ten, tc.stars, tc.ten)
+		}
+	}
+
+	for _, in := range []RatingInput{
+		{MediaType: "movie", TmdbID: 603, Rating: 5.5, Scale: ratingScaleStars},
+		{MediaType: "movie", TmdbID: 603, Rating: 0.5, Scale: ratingScaleTen},
+		{MediaType: "movie", TmdbID: 603, Rating: 7, Scale: 7},
+	} {
+		if rec := serve(set, jsonRequest(t, http.MethodPut, "/api/user/ratings", session, in)); rec.Code != http.StatusBadRequest {
+			t.Fatalf("rate %v on %d: status %d", in.Rating, in.Scale, rec.Code)
+		}
+	}
+	if got := read(ratingScaleTen); got != 7 {
+		t.Fatalf("rejected ratings changed the stored one: got %v of 10", got)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	errNotFound   = errors.New("record not found")
+	errEmailTaken = errors.New("email already registered")
+	// errListItemExists is returned when a title is added to a list twice.
+	errListItemExists = errors.New("item already in list")
+	// errListOrderMismatch is returned by Reorder when the ids given are not
+	// exactly the items of the list.
+	errListOrderMismatch = errors.New("order must list every item of the list once")
+	// errDeletionDue is returned by CancelDeletion once the grace period of
+	// the account has ended; the purger may already be removing its data.
+	errDeletionDue = errors.New("account deletion already due")
+)
+
+type User struct {
+	ID            int64
+	Name          string
+	Email         string
+	Username      string
+	PhotoURL      string
+	PasswordHash  string
+	EmailVerified bool
+	CreatedAt     time.Time
+}
+
+type Session struct {
+	ID         int64
+	UserID     int64
+	TokenHash  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	UserAgent  string
+	IP         string
+}
+
+// WatchEvent is a single viewing of a movie, show, season or episode;
+// WatchEventItem is its JSON form.
+type WatchEvent struct {
+	ID            int64
+	UserID        int64
+	MediaType     string
+	TmdbID        int64
+	SeasonNumber  int64
+	EpisodeNumber int64
+	WatchedAt     time.Time
+}
+
+// WatchedRecord sums up a user's viewings of one item: WatchedAt is the most
+// recent one and PlayCount how many there are. WatchedItem is its JSON form.
+type WatchedRecord struct {
+	UserID        int64
+	MediaType     string
+	TmdbID        int64
+	SeasonNumber  int64
+	EpisodeNumber int64
+	WatchedAt     time.Time
+	PlayCount     int
+}
+
+// WatchedChange is one item of a batch. Unmark removes every viewing of the
+// item; otherwise a viewing at WatchedAt is recorded, always with Rewatch and
+// only if the item has none yet without it.
+type WatchedChange struct {
+	WatchEvent
+	Unmark  bool
+	Rewatch bool
+}
+
+// Outcomes reported in WatchedChangeResult.Status.
+const (
+	watchCreated    = "created"
+	watchRewatched  = "rewatched"
+	watchUnchanged  = "unchanged"
+	watchDeleted    = "deleted"
+	watchNotWatched = "not_watched"
+)
+
+type WatchedChangeResult struct {
+	Status string
+	// EventID is the viewing recorded by the change, if any.
+	EventID   int64
+	PlayCount int
+}
+
+// WatchedFilter selects a user's viewings. An empty MediaType, zero TmdbID,
+// nil season/episode numbers and zero From/To match any value. From and To
+// bound watched_at, both inclusive.
+type WatchedFilter struct {
+	UserID        int64
+	MediaType     string
+	TmdbID        int64
+	SeasonNumber  *int64
+	EpisodeNumber *int64
+	From          time.Time
+	To            time.Time
+	// After and Limit page through List: only records ordered after After
+	// are returned, at most Limit of them (0 means all).
+	After *WatchedRecord
+	Limit int
+}
+
+// TitleStatus is where a user stands with a movie or show; TitleStatusItem is
+// its JSON form. Inferred is set when the status was derived from viewings
+// rather than chosen by the user.
+type TitleStatus struct {
+	UserID          int64
+	MediaType       string
+	TmdbID          int64
+	Status          string
+	Inferred        bool
+	CreatedAt       time.Time
+	StatusChangedAt time.Time
+}
+
+// TitleStatusChange is one entry of a title's status history. FromStatus is
+// empty for the first status.
+type TitleStatusChange struct {
+	FromStatus string
+	ToStatus   string
+	Inferred   bool
+	ChangedAt  time.Time
+}
+
+// TitleStatusFilter selects a user's titles; empty fields match any value.
+type TitleStatusFilter struct {
+	UserID    int64
+	MediaType string
+	Status    string
+}
+
+// Rating is a user's score for a movie, show, season or episode, keyed like
+// WatchedRecord. Score runs from 1 to 10 whatever scale the client uses.
+type Rating struct {
+	UserID        int64
+	MediaType     string
+	TmdbID        int64
+	SeasonNumber  int64
+	EpisodeNumber int64
+	Score         int
+	Review        string
+	Spoiler       bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// RatingFilter selects ratings; zero and nil fields match any value, so a
+// filter without UserID covers every user.
+type RatingFilter struct {
+	UserID        int64
+	MediaType     string
+	TmdbID        int64
+	SeasonNumber  *int64
+	EpisodeNumber *int64
+}
+
+// RatingSummary aggregates every user's rating of one item.
+type RatingSummary struct {
+	MediaType     string
+	TmdbID        int64
+	SeasonNumber  int64
+	EpisodeNumber int64
+	Count         int
+	AverageScore  float64
+}
+
+// UserList is a named, ordered list of titles owned by a user. ShareToken is
+// the secret that opens an unlisted list; ItemCount is filled in on reads.
+type UserList struct {
+	ID          int64
+	UserID      int64
+	Name        string
+	Description string
+	Visibility  string
+	ShareToken  string
+	ItemCount   int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// UserListItem is one title of a list. Positions start at 0 and have no gaps.
+type UserListItem struct {
+	ID        int64
+	ListID    int64
+	MediaType string
+	TmdbID    int64
+	Position  int
+	Note      string
+	AddedAt   time.Time
+}
+
+// UserRepository stores accounts. Lookups return errNotFound when no row
+// matches and Create returns errEmailTaken for a duplicate email.
+type UserRepository interface {
+	Create(u *User) error
+	ByID(id int64) (User, error)
+	ByEmail(email string) (User, error)
+	UsernameTaken(username string, excludeUserID int64) (bool, error)
+	UpdateProfile(id int64, name string, username string, photoURL string) (bool, error)
+	SetUsername(id int64, username string) error
+	SetPhotoURL(id int64, photoURL string) error
+	SetPasswordHash(id int64, hash string) error
+	// ReplacePasswordHash only writes if the stored hash is still oldHash.
+	ReplacePasswordHash(id int64, oldHash string, newHash string) (bool, error)
+	// SetEmail replaces the address and marks it verified at verifiedAt.
+	SetEmail(id int64, email string, verifiedAt time.Time) error
+	// MarkEmailVerified keeps an earlier verification time if there is one.
+	MarkEmailVerified(id int64, email string, at time.Time) (bool, error)
+	ScheduleDeletion(id int64, at time.Time) error
+	// CancelDeletion only cancels a deletion still in its grace period at now
+	// and returns errDeletionDue for one that has already ended.
+	CancelDeletion(id int64, now time.Time) (bool, error)
+	DueForDeletion(now time.Time) ([]int64, error)
+	Delete(id int64) error
+}
+
+type SessionRepository interface {
+	// Create stores s, sets s.ID and prunes the user's expired sessions.
+	Create(s *Session) error
+	ByTokenHash(tokenHash string, now time.Time) (Session, error)
+	ByID(id int64) (Session, error)
+	// Touch updates last_seen_at and ip unless they were written after since.
+	Touch(id int64, now time.Time, ip string, since time.Time) error
+	ListByUser(userID int64, now time.Time) ([]Session, error)
+	Delete(userID int64, id int64) (bool, error)
+	// DeleteByUser removes every session of userID except exceptID.
+	DeleteByUser(userID int64, exceptID int64) (int64, error)
+}
+
+type WatchedRepository interface {
+	// AddEvent records one more viewing and sets ev.ID.
+	AddEvent(ev *WatchEvent) error
+	// SetLatest moves the item's most recent viewing to ev.WatchedAt, or
+	// records a first one, and sets ev.ID.
+	SetLatest(ev *WatchEvent) error
+	// Delete removes every viewing of rec's item.
+	Delete(rec WatchedRecord) (bool, error)
+	// DeleteEvent removes one viewing and returns it, or errNotFound.
+	DeleteEvent(userID int64, id int64) (WatchEvent, error)
+	// ApplyChanges runs every change in one transaction and returns a result
+	// per change, in order. On error nothing is applied.
+	ApplyChanges(changes []WatchedChange) ([]WatchedChangeResult, error)
+	// List returns one record per watched item, most recently watched first
+	// and then by media type, tmdb id, season and episode.
+	List(filter WatchedFilter) ([]WatchedRecord, error)
+	// Events returns single viewings, most recent first.
+	Events(filter WatchedFilter) ([]WatchEvent, error)
+	// EpisodeCounts maps each of tmdbIDs the user watched episodes of to the
+	// watched episode count.
+	EpisodeCounts(userID int64, tmdbIDs []int64) (map[int64]int, error)
+	DeleteByUser(userID int64) error
+}
+
+// TitleStatusRepository stores title statuses and their history. Get returns
+// errNotFound for a title without a status.
+type TitleStatusRepository interface {
+	Get(userID int64, mediaType string, tmdbID int64) (TitleStatus, error)
+	// List returns matching titles, most recently changed first.
+	List(filter TitleStatusFilter) ([]TitleStatus, error)
+	// Set stores ts.Status at at, logging a history entry when the status
+	// changes, and fills in the stored timestamps.
+	Set(ts *TitleStatus, at time.Time) error
+	// Delete removes the status and its history.
+	Delete(userID int64, mediaType string, tmdbID int64) (bool, error)
+	// History returns a title's changes, oldest first.
+	History(userID int64, mediaType string, tmdbID int64) ([]TitleStatusChange, error)
+	DeleteByUser(userID int64) error
+}
+
+// RatingRepository stores ratings and reviews, one per user and item.
+type RatingRepository interface {
+	// Set creates or replaces the rating at at and fills in the stored
+	// timestamps; CreatedAt keeps the time of the first rating.
+	Set(rating *Rating, at time.Time) error
+	// Delete removes the rating of the item named by key.
+	Delete(key Rating) (bool, error)
+	// List returns matching ratings, most recently updated first.
+	List(filter RatingFilter) ([]Rating, error)
+	// Summaries aggregates matching ratings per item, ordered by season and
+	// episode.
+	Summaries(filter RatingFilter) ([]RatingSummary, error)
+	DeleteByUser(userID int64) error
+}
+
+// ListRepository stores user lists and their items. Item methods do not
+// check ownership; callers load the list first. Every item change also
+// bumps the list's UpdatedAt to at.
+type ListRepository interface {
+	Create(list *UserList, at time.Time) error
+	ByID(id int64) (UserList, error)
+	ByShareToken(token string) (UserList, error)
+	// ByUser returns a user's lists, most recently updated first. An empty
+	// visibility matches any.
+	ByUser(userID int64, visibility string) ([]UserList, error)
+	// Update saves the name, description, visibility and share token.
+	Update(list *UserList, at time.Time) (bool, error)
+	Delete(userID int64, id int64) (bool, error)
+	Items(listID int64) ([]UserListItem, error)
+	// AddItem inserts item at position, or at the end when position is
+	// negative or past it, and returns errListItemExists for a duplicate.
+	AddItem(item *UserListItem, position int, at time.Time) error
+	SetItemNote(listID int64, itemID int64, note string, at time.Time) (bool, error)
+	// MoveItem moves an item to position, shifting the items in between.
+	MoveItem(listID int64, itemID int64, position int, at time.Time) (bool, error)
+	RemoveItem(listID int64, itemID int64, at time.Time) (bool, error)
+	// Reorder sets the positions to the order of itemIDs.
+	Reorder(listID int64, itemIDs []int64, at time.Time) error
+	DeleteByUser(userID int64) error
+}
+
+type Repositories struct {
+	Users    UserRepository
+	Sessions SessionRepository
+	Watched  WatchedRepository
+	Titles   TitleStatusRepository
+	Ratings  RatingRepository
+	Lists    ListRepository
+	close    func() error
+}
+
+func (r *Repositories) Close() error {
+	if r.close == nil {
+		return nil
+	}
+	return r.close()
+}
+
+// sameListItems reports whether ids names every item in current exactly once.
+func sameListItems(current map[int64]bool, ids []int64) bool {
+	if len(ids) != len(current) {
+		return false
+	}
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if !current[id] || seen[id] {
+			return false
+		}
+		seen[id] = true
+	}
+	return true
+}
+
+// rowsAffected reports whether an Exec changed any row.
+func rowsAffected(result sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// databaseDriver is DB_DRIVER, lower-cased, with SQLite as the default.
+func databaseDriver() string {
+	return strings.ToLower(envOrDefault("DB_DRIVER", "sqlite"))
+}
+
+// openRepositories picks the backend from DB_DRIVER. Only SQLite can run the
+// app for now: the tables in userDataTables are still written through a.db
+// and hold user ids, so keeping users in Postgres would split them from their
+// rows without foreign keys or cascades. The Postgres repositories stay under
+// the conformance suite until those tables move behind repositories too.
+func openRepositories(db *sql.DB) (*Repositories, error) {
+	switch driver := databaseDriver(); driver {
+	case "sqlite":
+		return newSQLiteRepositories(db), nil
+	case "postgres":
+		return nil, fmt.Errorf("DB_DRIVER=postgres is not supported yet: %s still live in SQLite", strings.Join(userDataTables, ", "))
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q", driver)
+	}
+}
@@ -0,0 +1,1384 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type postgresUsers struct{ db *sql.DB }
+type postgresSessions struct{ db *sql.DB }
+type postgresWatched struct{ db *sql.DB }
+type postgresTitles struct{ db *sql.DB }
+type postgresRatings struct{ db *sql.DB }
+type postgresLists struct{ db *sql.DB }
+
+// postgresMigrations holds the schema for the tables served from postgres.
+// Like migrations, entries are append-only; the version is the index + 1.
+var postgresMigrations = []string{
+	`CREATE TABLE users (
+        id BIGSERIAL PRIMARY KEY,
+        name TEXT NOT NULL,
+        email TEXT NOT NULL UNIQUE,
+        password_hash TEXT NOT NULL,
+        username TEXT,
+        photo_url TEXT NOT NULL DEFAULT '',
+        email_verified_at TIMESTAMPTZ,
+        delete_after TIMESTAMPTZ,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+    );
+    CREATE INDEX idx_users_username ON users (lower(username));
+    CREATE TABLE sessions (
+        id BIGSERIAL PRIMARY KEY,
+        user_id BIGINT NOT NULL,
+        token_hash TEXT NOT NULL UNIQUE,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+        last_seen_at TIMESTAMPTZ,
+        expires_at TIMESTAMPTZ NOT NULL,
+        user_agent TEXT NOT NULL DEFAULT '',
+        ip TEXT NOT NULL DEFAULT ''
+    );
+    CREATE INDEX idx_sessions_user ON sessions (user_id);
+    CREATE TABLE watched_items (
+        id BIGSERIAL PRIMARY KEY,
+        user_id BIGINT NOT NULL,
+        media_type TEXT NOT NULL,
+        tmdb_id BIGINT NOT NULL,
+        season_number BIGINT NOT NULL DEFAULT 0,
+        episode_number BIGINT NOT NULL DEFAULT 0,
+        watched_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+        UNIQUE (user_id, media_type, tmdb_id, season_number, episode_number)
+    );`,
+	`DELETE FROM watched_items w WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.id = w.user_id);
+    ALTER TABLE watched_items ADD CONSTRAINT watched_items_user_id_fkey
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+    CREATE INDEX idx_watched_user_media_watched_at
+        ON watched_items (user_id, media_type, watched_at DESC)
+        INCLUDE (tmdb_id, season_number, episode_number);`,
+	`CREATE TABLE watch_events (
+        id BIGSERIAL PRIMARY KEY,
+        user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+        media_type TEXT NOT NULL,
+        tmdb_id BIGINT NOT NULL,
+        season_number BIGINT NOT NULL DEFAULT 0,
+        episode_number BIGINT NOT NULL DEFAULT 0,
+        watched_at TIMESTAMPTZ NOT NULL DEFAULT now()
+    );
+    INSERT INTO watch_events (user_id, media_type, tmdb_id, season_number, episode_number, watched_at)
+        SELECT user_id, media_type, tmdb_id, season_number, episode_number, watched_at
+        FROM watched_items ORDER BY id;
+    DROP TABLE watched_items;
+    CREATE INDEX idx_watch_events_item
+        ON watch_events (user_id, media_type, tmdb_id, season_number, episode_number, watched_at);
+    CREATE INDEX idx_watch_events_user_media_watched_at
+        ON watch_events (user_id, media_type, watched_at DESC);`,
+	`CREATE TABLE title_statuses (
+        id BIGSERIAL PRIMARY KEY,
+        user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+        media_type TEXT NOT NULL,
+        tmdb_id BIGINT NOT NULL,
+        status TEXT NOT NULL,
+        inferred BOOLEAN NOT NULL DEFAULT false,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+        status_changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+        UNIQUE (user_id, media_type, tmdb_id)
+    );
+    CREATE INDEX idx_title_statuses_user_status
+        ON title_statuses (user_id, status, status_changed_at DESC);
+    CREATE TABLE title_status_changes (
+        id BIGSERIAL PRIMARY KEY,
+        user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+        media_type TEXT NOT NULL,
+        tmdb_id BIGINT NOT NULL,
+        from_status TEXT NOT NULL DEFAULT '',
+        to_status TEXT NOT NULL,
+        inferred BOOLEAN NOT NULL DEFAULT false,
+        changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+    );
+    CREATE INDEX idx_title_status_changes_title
+        ON title_status_changes (user_id, media_type, tmdb_id, changed_at);
+    INSERT INTO title_statuses (user_id, media_type, tmdb_id, status, inferred, created_at, status_changed_at)
+        SELECT user_id, media_type, tmdb_id,
+            CASE WHEN media_type = 'movie' THEN 'completed' ELSE 'watching' END,
+            true, min(watched_at), max(watched_at)
+        FROM watch_events
+        GROUP BY user_id, media_type, tmdb_id;
+    INSERT INTO title_status_changes (user_id, media_type, tmdb_id, from_status, to_status, inferred, changed_at)
+        SELECT user_id, media_type, tmdb_id, '', status, true, status_changed_at
+        FROM title_statuses ORDER BY id;`,
+	`CREATE TABLE ratings (
+        id BIGSERIAL PRIMARY KEY,
+        user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+        media_type TEXT NOT NULL,
+        tmdb_id BIGINT NOT NULL,
+        season_number BIGINT NOT NULL DEFAULT 0,
+        episode_number BIGINT NOT NULL DEFAULT 0,
+        score SMALLINT NOT NULL CHECK (score BETWEEN 1 AND 10),
+        review TEXT NOT NULL DEFAULT '',
+        spoiler BOOLEAN NOT NULL DEFAULT false,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+        updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+        UNIQUE (user_id, media_type, tmdb_id, season_number, episode_number)
+    );
+    CREATE INDEX idx_ratings_item ON ratings (media_type, tmdb_id, season_number, episode_number);
+    CREATE INDEX idx_ratings_user_updated_at ON ratings (user_id, updated_at DESC);`,
+	`CREATE TABLE user_lists (
+        id BIGSERIAL PRIMARY KEY,
+        user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+        name TEXT NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        visibility TEXT NOT NULL DEFAULT 'private',
+        share_token TEXT UNIQUE,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+        updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+    );
+    CREATE INDEX idx_user_lists_user ON user_lists (user_id, updated_at DESC);
+    CREATE TABLE user_list_items (
+        id BIGSERIAL PRIMARY KEY,
+        list_id BIGINT NOT NULL REFERENCES user_lists (id) ON DELETE CASCADE,
+        media_type TEXT NOT NULL,
+        tmdb_id BIGINT NOT NULL,
+        position INTEGER NOT NULL,
+        note TEXT NOT NULL DEFAULT '',
+        added_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+        UNIQUE (list_id, media_type, tmdb_id)
+    );
+    CREATE INDEX idx_user_list_items_position ON user_list_items (list_id, position);`,
+}
+
+func openPostgresRepositories(dsn string) (*Repositories, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening postgres: %w", err)
+	}
+	configurePool(db)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed pinging postgres: %w", err)
+	}
+	if err := migratePostgres(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Repositories{
+		Users:    &postgresUsers{db: db},
+		Sessions: &postgresSessions{db: db},
+		Watched:  &postgresWatched{db: db},
+		Titles:   &postgresTitles{db: db},
+		Ratings:  &postgresRatings{db: db},
+		Lists:    &postgresLists{db: db},
+		close:    db.Close,
+	}, nil
+}
+
+func migratePostgres(db *sql.DB) error {
+	if _, err := db.Exec(`
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INTEGER PRIMARY KEY,
+        applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+    )`); err != nil {
+		return fmt.Errorf("failed creating postgres schema_migrations table: %w", err)
+	}
+
+	var current int
+	if err := db.QueryRow("SELECT coalesce(max(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("failed reading postgres schema version: %w", err)
+	}
+	if current > len(postgresMigrations) {
+		return fmt.Errorf("postgres schema version %d is newer than this binary supports (%d)", current, len(postgresMigrations))
+	}
+
+	for i := current; i < len(postgresMigrations); i++ {
+		version := i + 1
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(postgresMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("postgres migration %d: %w", version, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("postgres migration %d: failed recording version: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("postgres migration %d: %w", version, err)
+		}
+		log.Printf("applied postgres migration %d", version)
+	}
+	return nil
+}
+
+func isPostgresUniqueEmail(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "users_email_key"
+}
+
+const postgresUserColumns = `id, name, email, coalesce(username, ''), photo_url, password_hash,
+    email_verified_at IS NOT NULL, created_at`
+
+func (s *postgresUsers) Create(u *User) error {
+	var verifiedAt any
+	if u.EmailVerified {
+		verifiedAt = time.Now().UTC()
+	}
+
+	err := s.db.QueryRow(
+		`INSERT INTO users (name, email, password_hash, username, photo_url, email_verified_at)
+         VALUES ($1, $2, $3, $4, $5, $6)
+         RETURNING id`,
+		u.Name,
+		u.Email,
+		u.PasswordHash,
+		u.Username,
+		u.PhotoURL,
+		verifiedAt,
+	).Scan(&u.ID)
+	if err != nil && isPostgresUniqueEmail(err) {
+		return errEmailTaken
+	}
+	return err
+}
+
+func (s *postgresUsers) ByID(id int64) (User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+postgresUserColumns+" FROM users WHERE id = $1", id))
+}
+
+func (s *postgresUsers) ByEmail(email string) (User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+postgresUserColumns+" FROM users WHERE email = $1", email))
+}
+
+func (s *postgresUsers) UsernameTaken(username string, excludeUserID int64) (bool, error) {
+	var taken bool
+	err := s.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM users WHERE lower(username) = lower($1) AND id <> $2)",
+		username,
+		excludeUserID,
+	).Scan(&taken)
+	return taken, err
+}
+
+func (s *postgresUsers) UpdateProfile(id int64, name string, username string, photoURL string) (bool, error) {
+	return rowsAffected(s.db.Exec(
+		"UPDATE users SET name = $1, username = $2, photo_url = $3 WHERE id = $4",
+		name,
+		username,
+		photoURL,
+		id,
+	))
+}
+
+func (s *postgresUsers) SetUsername(id int64, username string) error {
+	_, err := s.db.Exec("UPDATE users SET username = $1 WHERE id = $2", username, id)
+	return err
+}
+
+func (s *postgresUsers) SetPhotoURL(id int64, photoURL string) error {
+	_, err := s.db.Exec("UPDATE users SET photo_url = $1 WHERE id = $2", photoURL, id)
+	return err
+}
+
+func (s *postgresUsers) SetPasswordHash(id int64, hash string) error {
+	_, err := s.db.Exec("UPDATE users SET password_hash = $1 WHERE id = $2", hash, id)
+	return err
+}
+
+func (s *postgresUsers) ReplacePasswordHash(id int64, oldHash string, newHash string) (bool, error) {
+	return rowsAffected(s.db.Exec(
+		"UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3",
+		newHash,
+		id,
+		oldHash,
+	))
+}
+
+func (s *postgresUsers) SetEmail(id int64, email string, verifiedAt time.Time) error {
+	_, err := s.db.Exec(
+		"UPDATE users SET email = $1, email_verified_at = $2 WHERE id = $3",
+		email,
+		verifiedAt.UTC(),
+		id,
+	)
+	if err != nil && isPostgresUniqueEmail(err) {
+		return errEmailTaken
+	}
+	return err
+}
+
+func (s *postgresUsers) MarkEmailVerified(id int64, email string, at time.Time) (bool, error) {
+	return rowsAffected(s.db.Exec(
+		"UPDATE users SET email_verified_at = coalesce(email_verified_at, $1) WHERE id = $2 AND email = $3",
+		at.UTC(),
+		id,
+		email,
+	))
+}
+
+func (s *postgresUsers) ScheduleDeletion(id int64, at time.Time) error {
+	_, err := s.db.Exec("UPDATE users SET delete_after = $1 WHERE id = $2", at.UTC(), id)
+	return err
+}
+
+func (s *postgresUsers) CancelDeletion(id int64, now time.Time) (bool, error) {
+	cancelled, err := rowsAffected(s.db.Exec(
+		"UPDATE users SET delete_after = NULL WHERE id = $1 AND delete_after > $2",
+		id,
+		now.UTC(),
+	))
+	if err != nil || cancelled {
+		return cancelled, err
+	}
+
+	var due bool
+	if err := s.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND delete_after <= $2)",
+		id,
+		now.UTC(),
+	).Scan(&due); err != nil {
+		return false, err
+	}
+	if due {
+		return false, errDeletionDue
+	}
+	return false, nil
+}
+
+func (s *postgresUsers) DueForDeletion(now time.Time) ([]int64, error) {
+	rows, err := s.db.Query("SELECT id FROM users WHERE delete_after <= $1", now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *postgresUsers) Delete(id int64) error {
+	_, err := s.db.Exec("DELETE FROM users WHERE id = $1", id)
+	return err
+}
+
+const postgresSessionColumns = "id, user_id, token_hash, created_at, last_seen_at, expires_at, user_agent, ip"
+
+func (r *postgresSessions) Create(s *Session) error {
+	_, _ = r.db.Exec("DELETE FROM sessions WHERE user_id = $1 AND expires_at <= now()", s.UserID)
+
+	return r.db.QueryRow(
+		`INSERT INTO sessions (user_id, token_hash, created_at, expires_at, last_seen_at, user_agent, ip)
+         VALUES ($1, $2, $3, $4, $3, $5, $6)
+         RETURNING id`,
+		s.UserID,
+		s.TokenHash,
+		s.CreatedAt.UTC(),
+		s.ExpiresAt.UTC(),
+		s.UserAgent,
+		s.IP,
+	).Scan(&s.ID)
+}
+
+func (r *postgresSessions) ByTokenHash(tokenHash string, now time.Time) (Session, error) {
+	return scanSession(r.db.QueryRow(
+		"SELECT "+postgresSessionColumns+" FROM sessions WHERE token_hash = $1 AND expires_at > $2",
+		tokenHash,
+		now.UTC(),
+	))
+}
+
+func (r *postgresSessions) ByID(id int64) (Session, error) {
+	return scanSession(r.db.QueryRow("SELECT "+postgresSessionColumns+" FROM sessions WHERE id = $1", id))
+}
+
+func (r *postgresSessions) Touch(id int64, now time.Time, ip string, since time.Time) error {
+	_, err := r.db.Exec(
+		`UPDATE sessions SET last_seen_at = $1, ip = $2
+         WHERE id = $3 AND (last_seen_at IS NULL OR last_seen_at < $4)`,
+		now.UTC(),
+		ip,
+		id,
+		since.UTC(),
+	)
+	return err
+}
+
+func (r *postgresSessions) ListByUser(userID int64, now time.Time) ([]Session, error) {
+	rows, err := r.db.Query(
+		`SELECT `+postgresSessionColumns+`
+         FROM sessions
+         WHERE user_id = $1 AND expires_at > $2
+         ORDER BY coalesce(last_seen_at, created_at) DESC, id DESC`,
+		userID,
+		now.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Session, 0)
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+func (r *postgresSessions) Delete(userID int64, id int64) (bool, error) {
+	return rowsAffected(r.db.Exec("DELETE FROM sessions WHERE id = $1 AND user_id = $2", id, userID))
+}
+
+func (r *postgresSessions) DeleteByUser(userID int64, exceptID int64) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM sessions WHERE user_id = $1 AND id <> $2", userID, exceptID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *postgresWatched) AddEvent(ev *WatchEvent) error {
+	return r.db.QueryRow(
+		`INSERT INTO watch_events (user_id, media_type, tmdb_id, season_number, episode_number, watched_at)
+         VALUES ($1, $2, $3, $4, $5, $6)
+         RETURNING id`,
+		ev.UserID,
+		ev.MediaType,
+		ev.TmdbID,
+		ev.SeasonNumber,
+		ev.EpisodeNumber,
+		ev.WatchedAt.UTC(),
+	).Scan(&ev.ID)
+}
+
+func (r *postgresWatched) SetLatest(ev *WatchEvent) error {
+	err := r.db.QueryRow(
+		`UPDATE watch_events SET watched_at = $1
+         WHERE id = (
+             SELECT id FROM watch_events
+             WHERE user_id = $2 AND media_type = $3 AND tmdb_id = $4 AND season_number = $5 AND episode_number = $6
+             ORDER BY watched_at DESC, id DESC
+             LIMIT 1
+         )
+         RETURNING id`,
+		ev.WatchedAt.UTC(),
+		ev.UserID,
+		ev.MediaType,
+		ev.TmdbID,
+		ev.SeasonNumber,
+		ev.EpisodeNumber,
+	).Scan(&ev.ID)
+	if err == sql.ErrNoRows {
+		return r.AddEvent(ev)
+	}
+	return err
+}
+
+func (r *postgresWatched) Delete(rec WatchedRecord) (bool, error) {
+	return rowsAffected(r.db.Exec(
+		`DELETE FROM watch_events
+         WHERE user_id = $1 AND media_type = $2 AND tmdb_id = $3 AND season_number = $4 AND episode_number = $5`,
+		rec.UserID,
+		rec.MediaType,
+		rec.TmdbID,
+		rec.SeasonNumber,
+		rec.EpisodeNumber,
+	))
+}
+
+func (r *postgresWatched) DeleteEvent(userID int64, id int64) (WatchEvent, error) {
+	var ev WatchEvent
+	err := r.db.QueryRow(
+		`DELETE FROM watch_events WHERE id = $1 AND user_id = $2
+         RETURNING id, user_id, media_type, tmdb_id, season_number, episode_number, watched_at`,
+		id,
+		userID,
+	).Scan(&ev.ID, &ev.UserID, &ev.MediaType, &ev.TmdbID, &ev.SeasonNumber, &ev.EpisodeNumber, &ev.WatchedAt)
+	if err == sql.ErrNoRows {
+		return ev, errNotFound
+	}
+	return ev, err
+}
+
+func (r *postgresWatched) ApplyChanges(changes []WatchedChange) ([]WatchedChangeResult, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]WatchedChangeResult, 0, len(changes))
+	for _, change := range changes {
+		result, err := applyPostgresWatchedChange(tx, change)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func applyPostgresWatchedChange(tx *sql.Tx, change WatchedChange) (WatchedChangeResult, error) {
+	ev := change.WatchEvent
+	item := []any{ev.UserID, ev.MediaType, ev.TmdbID, ev.SeasonNumber, ev.EpisodeNumber}
+	const match = "user_id = $1 AND media_type = $2 AND tmdb_id = $3 AND season_number = $4 AND episode_number = $5"
+
+	var result WatchedChangeResult
+	if change.Unmark {
+		deleted, err := rowsAffected(tx.Exec("DELETE FROM watch_events WHERE "+match, item...))
+		if err != nil {
+			return result, err
+		}
+		result.Status = watchNotWatched
+		if deleted {
+			result.Status = watchDeleted
+		}
+		return result, nil
+	}
+
+	insert := `INSERT INTO watch_events (user_id, media_type, tmdb_id, season_number, episode_number, watched_at)
+               SELECT $1::bigint, $2::text, $3::bigint, $4::bigint, $5::bigint, $6::timestamptz`
+	if !change.Rewatch {
+		insert += " WHERE NOT EXISTS (SELECT 1 FROM watch_events WHERE " + match + ")"
+	}
+	err := tx.QueryRow(insert+" RETURNING id", append(item, ev.WatchedAt.UTC())...).Scan(&result.EventID)
+	if err != nil && err != sql.ErrNoRows {
+		return result, err
+	}
+
+	if err := tx.QueryRow("SELECT COUNT(1) FROM watch_events WHERE "+match, item...).Scan(&result.PlayCount); err != nil {
+		return result, err
+	}
+	switch {
+	case result.EventID == 0:
+		result.Status = watchUnchanged
+	case result.PlayCount == 1:
+		result.Status = watchCreated
+	default:
+		result.Status = watchRewatched
+	}
+	return result, nil
+}
+
+func postgresWatchedWhere(filter WatchedFilter) (string, []any) {
+	where := "user_id = $1"
+	args := []any{filter.UserID}
+
+	if filter.MediaType != "" {
+		args = append(args, filter.MediaType)
+		where += fmt.Sprintf(" AND media_type = $%d", len(args))
+	}
+	if filter.TmdbID > 0 {
+		args = append(args, filter.TmdbID)
+		where += fmt.Sprintf(" AND tmdb_id = $%d", len(args))
+	}
+	if filter.SeasonNumber != nil {
+		args = append(args, *filter.SeasonNumber)
+		where += fmt.Sprintf(" AND season_number = $%d", len(args))
+	}
+	if filter.EpisodeNumber != nil {
+		args = append(args, *filter.EpisodeNumber)
+		where += fmt.Sprintf(" AND episode_number = $%d", len(args))
+	}
+	if !filter.From.IsZero() {
+		args = append(args, filter.From.UTC())
+		where += fmt.Sprintf(" AND watched_at >= $%d", len(args))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To.UTC())
+		where += fmt.Sprintf(" AND watched_at <= $%d", len(args))
+	}
+	return where, args
+}
+
+func (r *postgresWatched) List(filter WatchedFilter) ([]WatchedRecord, error) {
+	where, args := postgresWatchedWhere(filter)
+	query := `SELECT user_id, media_type, tmdb_id, season_number, episode_number, last_watched_at, play_count
+              FROM (
+                  SELECT user_id, media_type, tmdb_id, season_number, episode_number,
+                         max(watched_at) AS last_watched_at, COUNT(1) AS play_count
+                  FROM watch_events
+                  WHERE ` + where + `
+                  GROUP BY user_id, media_type, tmdb_id, season_number, episode_number
+              ) AS items`
+	if after := filter.After; after != nil {
+		n := len(args)
+		query += fmt.Sprintf(` WHERE last_watched_at < $%d OR (last_watched_at = $%d
+                   AND (media_type, tmdb_id, season_number, episode_number) > ($%d, $%d, $%d, $%d))`,
+			n+1, n+1, n+2, n+3, n+4, n+5)
+		args = append(args, after.WatchedAt.UTC(), after.MediaType, after.TmdbID, after.SeasonNumber, after.EpisodeNumber)
+	}
+	query += " ORDER BY last_watched_at DESC, media_type, tmdb_id, season_number, episode_number"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]WatchedRecord, 0)
+	for rows.Next() {
+		var rec WatchedRecord
+		if err := rows.Scan(
+			&rec.UserID,
+			&rec.MediaType,
+			&rec.TmdbID,
+			&rec.SeasonNumber,
+			&rec.EpisodeNumber,
+			&rec.WatchedAt,
+			&rec.PlayCount,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+func (r *postgresWatched) Events(filter WatchedFilter) ([]WatchEvent, error) {
+	where, args := postgresWatchedWhere(filter)
+	rows, err := r.db.Query(
+		`SELECT id, user_id, media_type, tmdb_id, season_number, episode_number, watched_at
+         FROM watch_events
+         WHERE `+where+`
+         ORDER BY watched_at DESC, id DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]WatchEvent, 0)
+	for rows.Next() {
+		var ev WatchEvent
+		if err := rows.Scan(
+			&ev.ID,
+			&ev.UserID,
+			&ev.MediaType,
+			&ev.TmdbID,
+			&ev.SeasonNumber,
+			&ev.EpisodeNumber,
+			&ev.WatchedAt,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, ev)
+	}
+	return out, rows.Err()
+}
+
+func (r *postgresWatched) EpisodeCounts(userID int64, tmdbIDs []int64) (map[int64]int, error) {
+	rows, err := r.db.Query(
+		`SELECT tmdb_id, COUNT(1) FROM (
+             SELECT DISTINCT tmdb_id, season_number, episode_number FROM watch_events
+             WHERE user_id = $1 AND media_type = 'tv' AND episode_number > 0
+               AND tmdb_id = ANY($2::bigint[])
+         ) AS episodes
+         GROUP BY tmdb_id`,
+		userID,
+		pq.Array(tmdbIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int64]int)
+	for rows.Next() {
+		var (
+			tmdbID int64
+			count  int
+		)
+		if err := rows.Scan(&tmdbID, &count); err != nil {
+			return nil, err
+		}
+		counts[tmdbID] = count
+	}
+	return counts, rows.Err()
+}
+
+func (r *postgresWatched) DeleteByUser(userID int64) error {
+	_, err := r.db.Exec("DELETE FROM watch_events WHERE user_id = $1", userID)
+	return err
+}
+
+func (r *postgresTitles) Get(userID int64, mediaType string, tmdbID int64) (TitleStatus, error) {
+	ts := TitleStatus{UserID: userID, MediaType: mediaType, TmdbID: tmdbID}
+	err := r.db.QueryRow(
+		`SELECT status, inferred, created_at, status_changed_at FROM title_statuses
+         WHERE user_id = $1 AND media_type = $2 AND tmdb_id = $3`,
+		userID,
+		mediaType,
+		tmdbID,
+	).Scan(&ts.Status, &ts.Inferred, &ts.CreatedAt, &ts.StatusChangedAt)
+	if err == sql.ErrNoRows {
+		return ts, errNotFound
+	}
+	return ts, err
+}
+
+func (r *postgresTitles) List(filter TitleStatusFilter) ([]TitleStatus, error) {
+	where := "user_id = $1"
+	args := []any{filter.UserID}
+	if filter.MediaType != "" {
+		args = append(args, filter.MediaType)
+		where += fmt.Sprintf(" AND media_type = $%d", len(args))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		where += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+
+	rows, err := r.db.Query(
+		`SELECT user_id, media_type, tmdb_id, status, inferred, created_at, status_changed_at
+         FROM title_statuses
+         WHERE `+where+`
+         ORDER BY status_changed_at DESC, id DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]TitleStatus, 0)
+	for rows.Next() {
+		var ts TitleStatus
+		if err := rows.Scan(
+			&ts.UserID,
+			&ts.MediaType,
+			&ts.TmdbID,
+			&ts.Status,
+			&ts.Inferred,
+			&ts.CreatedAt,
+			&ts.StatusChangedAt,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, ts)
+	}
+	return out, rows.Err()
+}
+
+func (r *postgresTitles) Set(ts *TitleStatus, at time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// FOR UPDATE keeps two concurrent changes from logging the same transition.
+	var previous string
+	err = tx.QueryRow(
+		`SELECT status FROM title_statuses WHERE user_id = $1 AND media_type = $2 AND tmdb_id = $3
+         FOR UPDATE`,
+		ts.UserID,
+		ts.MediaType,
+		ts.TmdbID,
+	).Scan(&previous)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec(
+			`INSERT INTO title_statuses (user_id, media_type, tmdb_id, status, inferred, created_at, status_changed_at)
+             VALUES ($1, $2, $3, $4, $5, $6, $6)`,
+			ts.UserID,
+			ts.MediaType,
+			ts.TmdbID,
+			ts.Status,
+			ts.Inferred,
+			at.UTC(),
+		)
+	case err != nil:
+		return err
+	case previous == ts.Status:
+		_, err = tx.Exec(
+			"UPDATE title_statuses SET inferred = $1 WHERE user_id = $2 AND media_type = $3 AND tmdb_id = $4",
+			ts.Inferred,
+			ts.UserID,
+			ts.MediaType,
+			ts.TmdbID,
+		)
+	default:
+		_, err = tx.Exec(
+			`UPDATE title_statuses SET status = $1, inferred = $2, status_changed_at = $3
+             WHERE user_id = $4 AND media_type = $5 AND tmdb_id = $6`,
+			ts.Status,
+			ts.Inferred,
+			at.UTC(),
+			ts.UserID,
+			ts.MediaType,
+			ts.TmdbID,
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	if previous != ts.Status {
+		if _, err := tx.Exec(
+			`INSERT INTO title_status_changes (user_id, media_type, tmdb_id, from_status, to_status, inferred, changed_at)
+             VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			ts.UserID,
+			ts.MediaType,
+			ts.TmdbID,
+			previous,
+			ts.Status,
+			ts.Inferred,
+			at.UTC(),
+		); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.QueryRow(
+		"SELECT created_at, status_changed_at FROM title_statuses WHERE user_id = $1 AND media_type = $2 AND tmdb_id = $3",
+		ts.UserID,
+		ts.MediaType,
+		ts.TmdbID,
+	).Scan(&ts.CreatedAt, &ts.StatusChangedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *postgresTitles) Delete(userID int64, mediaType string, tmdbID int64) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"DELETE FROM title_status_changes WHERE user_id = $1 AND media_type = $2 AND tmdb_id = $3",
+		userID,
+		mediaType,
+		tmdbID,
+	); err != nil {
+		return false, err
+	}
+	deleted, err := rowsAffected(tx.Exec(
+		"DELETE FROM title_statuses WHERE user_id = $1 AND media_type = $2 AND tmdb_id = $3",
+		userID,
+		mediaType,
+		tmdbID,
+	))
+	if err != nil {
+		return false, err
+	}
+	return deleted, tx.Commit()
+}
+
+func (r *postgresTitles) History(userID int64, mediaType string, tmdbID int64) ([]TitleStatusChange, error) {
+	rows, err := r.db.Query(
+		`SELECT from_status, to_status, inferred, changed_at FROM title_status_changes
+         WHERE user_id = $1 AND media_type = $2 AND tmdb_id = $3
+         ORDER BY changed_at, id`,
+		userID,
+		mediaType,
+		tmdbID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]TitleStatusChange, 0)
+	for rows.Next() {
+		var change TitleStatusChange
+		if err := rows.Scan(&change.FromStatus, &change.ToStatus, &change.Inferred, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, change)
+	}
+	return out, rows.Err()
+}
+
+func (r *postgresTitles) DeleteByUser(userID int64) error {
+	if _, err := r.db.Exec("DELETE FROM title_status_changes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	_, err := r.db.Exec("DELETE FROM title_statuses WHERE user_id = $1", userID)
+	return err
+}
+
+func (r *postgresRatings) Set(rating *Rating, at time.Time) error {
+	return r.db.QueryRow(
+		`INSERT INTO ratings (user_id, media_type, tmdb_id, season_number, episode_number, score, review, spoiler, created_at, updated_at)
+         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+         ON CONFLICT (user_id, media_type, tmdb_id, season_number, episode_number) DO UPDATE
+         SET score = excluded.score, review = excluded.review, spoiler = excluded.spoiler, updated_at = excluded.updated_at
+         RETURNING created_at, updated_at`,
+		rating.UserID,
+		rating.MediaType,
+		rating.TmdbID,
+		rating.SeasonNumber,
+		rating.EpisodeNumber,
+		rating.Score,
+		rating.Review,
+		rating.Spoiler,
+		at.UTC(),
+	).Scan(&rating.CreatedAt, &rating.UpdatedAt)
+}
+
+func (r *postgresRatings) Delete(key Rating) (bool, error) {
+	return rowsAffected(r.db.Exec(
+		`DELETE FROM ratings
+         WHERE user_id = $1 AND media_type = $2 AND tmdb_id = $3 AND season_number = $4 AND episode_number = $5`,
+		key.UserID,
+		key.MediaType,
+		key.TmdbID,
+		key.SeasonNumber,
+		key.EpisodeNumber,
+	))
+}
+
+func postgresRatingsWhere(filter RatingFilter) (string, []any) {
+	where := "true"
+	var args []any
+
+	if filter.UserID > 0 {
+		args = append(args, filter.UserID)
+		where += fmt.Sprintf(" AND user_id = $%d", len(args))
+	}
+	if filter.MediaType != "" {
+		args = append(args, filter.MediaType)
+		where += fmt.Sprintf(" AND media_type = $%d", len(args))
+	}
+	if filter.TmdbID > 0 {
+		args = append(args, filter.TmdbID)
+		where += fmt.Sprintf(" AND tmdb_id = $%d", len(args))
+	}
+	if filter.SeasonNumber != nil {
+		args = append(args, *filter.SeasonNumber)
+		where += fmt.Sprintf(" AND season_number = $%d", len(args))
+	}
+	if filter.EpisodeNumber != nil {
+		args = append(args, *filter.EpisodeNumber)
+		where += fmt.Sprintf(" AND episode_number = $%d", len(args))
+	}
+	return where, args
+}
+
+func (r *postgresRatings) List(filter RatingFilter) ([]Rating, error) {
+	where, args := postgresRatingsWhere(filter)
+	rows, err := r.db.Query(
+		`SELECT user_id, media_type, tmdb_id, season_number, episode_number, score, review, spoiler, created_at, updated_at
+         FROM ratings
+         WHERE `+where+`
+         ORDER BY updated_at DESC, id DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Rating, 0)
+	for rows.Next() {
+		var rating Rating
+		if err := rows.Scan(
+			&rating.UserID,
+			&rating.MediaType,
+			&rating.TmdbID,
+			&rating.SeasonNumber,
+			&rating.EpisodeNumber,
+			&rating.Score,
+			&rating.Review,
+			&rating.Spoiler,
+			&rating.CreatedAt,
+			&rating.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, rating)
+	}
+	return out, rows.Err()
+}
+
+func (r *postgresRatings) Summaries(filter RatingFilter) ([]RatingSummary, error) {
+	where, args := postgresRatingsWhere(filter)
+	rows, err := r.db.Query(
+		`SELECT media_type, tmdb_id, season_number, episode_number, COUNT(1), AVG(score)::float8
+         FROM ratings
+         WHERE `+where+`
+         GROUP BY media_type, tmdb_id, season_number, episode_number
+         ORDER BY media_type, tmdb_id, season_number, episode_number`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]RatingSummary, 0)
+	for rows.Next() {
+		var summary RatingSummary
+		if err := rows.Scan(
+			&summary.MediaType,
+			&summary.TmdbID,
+			&summary.SeasonNumber,
+			&summary.EpisodeNumber,
+			&summary.Count,
+			&summary.AverageScore,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, summary)
+	}
+	return out, rows.Err()
+}
+
+func (r *postgresRatings) DeleteByUser(userID int64) error {
+	_, err := r.db.Exec("DELETE FROM ratings WHERE user_id = $1", userID)
+	return err
+}
+
+// inTx runs fn in a transaction that holds the lock on list listID, so item
+// positions are never computed from a stale count. listID 0 takes no lock.
+func (r *postgresLists) inTx(listID int64, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if listID != 0 {
+		var id int64
+		err := tx.QueryRow("SELECT id FROM user_lists WHERE id = $1 FOR UPDATE", listID).Scan(&id)
+		if err == sql.ErrNoRows {
+			return errNotFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const postgresListColumns = `id, user_id, name, description, visibility, coalesce(share_token, ''),
+    (SELECT COUNT(1) FROM user_list_items i WHERE i.list_id = user_lists.id), created_at, updated_at`
+
+func (r *postgresLists) Create(list *UserList, at time.Time) error {
+	err := r.db.QueryRow(
+		`INSERT INTO user_lists (user_id, name, description, visibility, share_token, created_at, updated_at)
+         VALUES ($1, $2, $3, $4, $5, $6, $6)
+         RETURNING id`,
+		list.UserID,
+		list.Name,
+		list.Description,
+		list.Visibility,
+		nullableToken(list.ShareToken),
+		at.UTC(),
+	).Scan(&list.ID)
+	list.ItemCount = 0
+	list.CreatedAt = at
+	list.UpdatedAt = at
+	return err
+}
+
+func (r *postgresLists) ByID(id int64) (UserList, error) {
+	return scanUserList(r.db.QueryRow("SELECT "+postgresListColumns+" FROM user_lists WHERE id = $1", id))
+}
+
+func (r *postgresLists) ByShareToken(token string) (UserList, error) {
+	return scanUserList(r.db.QueryRow("SELECT "+postgresListColumns+" FROM user_lists WHERE share_token = $1", token))
+}
+
+func (r *postgresLists) ByUser(userID int64, visibility string) ([]UserList, error) {
+	where := "user_id = $1"
+	args := []any{userID}
+	if visibility != "" {
+		args = append(args, visibility)
+		where += fmt.Sprintf(" AND visibility = $%d", len(args))
+	}
+
+	rows, err := r.db.Query(
+		"SELECT "+postgresListColumns+" FROM user_lists WHERE "+where+" ORDER BY updated_at DESC, id DESC",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]UserList, 0)
+	for rows.Next() {
+		list, err := scanUserList(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, list)
+	}
+	return out, rows.Err()
+}
+
+func (r *postgresLists) Update(list *UserList, at time.Time) (bool, error) {
+	updated, err := rowsAffected(r.db.Exec(
+		`UPDATE user_lists SET name = $1, description = $2, visibility = $3, share_token = $4, updated_at = $5
+         WHERE id = $6 AND user_id = $7`,
+		list.Name,
+		list.Description,
+		list.Visibility,
+		nullableToken(list.ShareToken),
+		at.UTC(),
+		list.ID,
+		list.UserID,
+	))
+	if updated {
+		list.UpdatedAt = at
+	}
+	return updated, err
+}
+
+func (r *postgresLists) Delete(userID int64, id int64) (bool, error) {
+	// user_list_items follows through its foreign key.
+	return rowsAffected(r.db.Exec("DELETE FROM user_lists WHERE id = $1 AND user_id = $2", id, userID))
+}
+
+func (r *postgresLists) Items(listID int64) ([]UserListItem, error) {
+	rows, err := r.db.Query(
+		`SELECT id, list_id, media_type, tmdb_id, position, note, added_at
+         FROM user_list_items
+         WHERE list_id = $1
+         ORDER BY position, id`,
+		listID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]UserListItem, 0)
+	for rows.Next() {
+		var item UserListItem
+		if err := rows.Scan(
+			&item.ID,
+			&item.ListID,
+			&item.MediaType,
+			&item.TmdbID,
+			&item.Position,
+			&item.Note,
+			&item.AddedAt,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+func touchPostgresList(tx *sql.Tx, listID int64, at time.Time) error {
+	_, err := tx.Exec("UPDATE user_lists SET updated_at = $1 WHERE id = $2", at.UTC(), listID)
+	return err
+}
+
+func postgresListItemPosition(tx *sql.Tx, listID int64, itemID int64) (int, error) {
+	var position int
+	err := tx.QueryRow(
+		"SELECT position FROM user_list_items WHERE id = $1 AND list_id = $2",
+		itemID,
+		listID,
+	).Scan(&position)
+	if err == sql.ErrNoRows {
+		return 0, errNotFound
+	}
+	return position, err
+}
+
+func (r *postgresLists) AddItem(item *UserListItem, position int, at time.Time) error {
+	return r.inTx(item.ListID, func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM user_list_items WHERE list_id = $1 AND media_type = $2 AND tmdb_id = $3)",
+			item.ListID,
+			item.MediaType,
+			item.TmdbID,
+		).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return errListItemExists
+		}
+
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(1) FROM user_list_items WHERE list_id = $1", item.ListID).Scan(&count); err != nil {
+			return err
+		}
+		if position < 0 || position > count {
+			position = count
+		}
+		if _, err := tx.Exec(
+			"UPDATE user_list_items SET position = position + 1 WHERE list_id = $1 AND position >= $2",
+			item.ListID,
+			position,
+		); err != nil {
+			return err
+		}
+
+		if err := tx.QueryRow(
+			`INSERT INTO user_list_items (list_id, media_type, tmdb_id, position, note, added_at)
+             VALUES ($1, $2, $3, $4, $5, $6)
+             RETURNING id`,
+			item.ListID,
+			item.MediaType,
+			item.TmdbID,
+			position,
+			item.Note,
+			at.UTC(),
+		).Scan(&item.ID); err != nil {
+			return err
+		}
+		item.Position = position
+		item.AddedAt = at
+		return touchPostgresList(tx, item.ListID, at)
+	})
+}
+
+func (r *postgresLists) SetItemNote(listID int64, itemID int64, note string, at time.Time) (bool, error) {
+	var updated bool
+	err := r.inTx(0, func(tx *sql.Tx) error {
+		var err error
+		updated, err = rowsAffected(tx.Exec(
+			"UPDATE user_list_items SET note = $1 WHERE id = $2 AND list_id = $3",
+			note,
+			itemID,
+			listID,
+		))
+		if err != nil || !updated {
+			return err
+		}
+		return touchPostgresList(tx, listID, at)
+	})
+	return updated, err
+}
+
+func (r *postgresLists) MoveItem(listID int64, itemID int64, position int, at time.Time) (bool, error) {
+	err := r.inTx(listID, func(tx *sql.Tx) error {
+		current, err := postgresListItemPosition(tx, listID, itemID)
+		if err != nil {
+			return err
+		}
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(1) FROM user_list_items WHERE list_id = $1", listID).Scan(&count); err != nil {
+			return err
+		}
+		position = min(max(position, 0), count-1)
+
+		switch {
+		case position < current:
+			_, err = tx.Exec(
+				"UPDATE user_list_items SET position = position + 1 WHERE list_id = $1 AND position >= $2 AND position < $3",
+				listID,
+				position,
+				current,
+			)
+		case position > current:
+			_, err = tx.Exec(
+				"UPDATE user_list_items SET position = position - 1 WHERE list_id = $1 AND position > $2 AND position <= $3",
+				listID,
+				current,
+				position,
+			)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE user_list_items SET position = $1 WHERE id = $2", position, itemID); err != nil {
+			return err
+		}
+		return touchPostgresList(tx, listID, at)
+	})
+	if err == errNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *postgresLists) RemoveItem(listID int64, itemID int64, at time.Time) (bool, error) {
+	err := r.inTx(listID, func(tx *sql.Tx) error {
+		position, err := postgresListItemPosition(tx, listID, itemID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM user_list_items WHERE id = $1", itemID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			"UPDATE user_list_items SET position = position - 1 WHERE list_id = $1 AND position > $2",
+			listID,
+			position,
+		); err != nil {
+			return err
+		}
+		return touchPostgresList(tx, listID, at)
+	})
+	if err == errNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *postgresLists) Reorder(listID int64, itemIDs []int64, at time.Time) error {
+	return r.inTx(listID, func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id FROM user_list_items WHERE list_id = $1", listID)
+		if err != nil {
+			return err
+		}
+		current := make(map[int64]bool)
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			current[id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if !sameListItems(current, itemIDs) {
+			return errListOrderMismatch
+		}
+
+		if _, err := tx.Exec(
+			`UPDATE user_list_items SET position = ordered.position - 1
+             FROM unnest($1::bigint[]) WITH ORDINALITY AS ordered (id, position)
+             WHERE user_list_items.id = ordered.id`,
+			pq.Array(itemIDs),
+		); err != nil {
+			return err
+		}
+		return touchPostgresList(tx, listID, at)
+	})
+}
+
+func (r *postgresLists) DeleteByUser(userID int64) error {
+	_, err := r.db.Exec("DELETE FROM user_lists WHERE user_id = $1", userID)
+	return err
+}
@@ -0,0 +1,1270 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+type sqliteUsers struct{ db sqliteDB }
+type sqliteSessions struct{ db sqliteDB }
+type sqliteWatched struct{ db sqliteDB }
+type sqliteTitles struct{ db sqliteDB }
+type sqliteRatings struct{ db sqliteDB }
+type sqliteLists struct{ db sqliteDB }
+
+func newSQLiteRepositories(db *sql.DB) *Repositories {
+	conn := sqliteDB{db}
+	return &Repositories{
+		Users:    &sqliteUsers{db: conn},
+		Sessions: &sqliteSessions{db: conn},
+		Watched:  &sqliteWatched{db: conn},
+		Titles:   &sqliteTitles{db: conn},
+		Ratings:  &sqliteRatings{db: conn},
+		Lists:    &sqliteLists{db: conn},
+	}
+}
+
+// sqliteTime formats t the way DATETIME columns are stored, so that string
+// comparisons in SQL order correctly.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(dbTimeLayout)
+}
+
+func isSQLiteUniqueEmail(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "unique constraint failed: users.email")
+}
+
+const sqliteUserColumns = `id, name, email, coalesce(username, ''), photo_url, password_hash,
+    email_verified_at IS NOT NULL, created_at`
+
+func scanUser(row rowScanner) (User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Username, &u.PhotoURL, &u.PasswordHash, &u.EmailVerified, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return u, errNotFound
+	}
+	return u, err
+}
+
+func (s *sqliteUsers) Create(u *User) error {
+	var verifiedAt any
+	if u.EmailVerified {
+		verifiedAt = sqliteTime(time.Now())
+	}
+
+	result, err := s.db.Exec(
+		`INSERT INTO users (name, email, password_hash, username, photo_url, email_verified_at)
+         VALUES (?, ?, ?, ?, ?, ?)`,
+		u.Name,
+		u.Email,
+		u.PasswordHash,
+		u.Username,
+		u.PhotoURL,
+		verifiedAt,
+	)
+	if err != nil {
+		if isSQLiteUniqueEmail(err) {
+			return errEmailTaken
+		}
+		return err
+	}
+
+	u.ID, err = result.LastInsertId()
+	return err
+}
+
+func (s *sqliteUsers) ByID(id int64) (User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+sqliteUserColumns+" FROM users WHERE id = ? LIMIT 1", id))
+}
+
+func (s *sqliteUsers) ByEmail(email string) (User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+sqliteUserColumns+" FROM users WHERE email = ? LIMIT 1", email))
+}
+
+func (s *sqliteUsers) UsernameTaken(username string, excludeUserID int64) (bool, error) {
+	var count int
+	err := s.db.QueryRow(
+		"SELECT COUNT(1) FROM users WHERE lower(coalesce(username, '')) = lower(?) AND id <> ?",
+		username,
+		excludeUserID,
+	).Scan(&count)
+	return count > 0, err
+}
+
+func (s *sqliteUsers) UpdateProfile(id int64, name string, username string, photoURL string) (bool, error) {
+	return rowsAffected(s.db.Exec(
+		"UPDATE users SET name = ?, username = ?, photo_url = ? WHERE id = ?",
+		name,
+		username,
+		photoURL,
+		id,
+	))
+}
+
+func (s *sqliteUsers) SetUsername(id int64, username string) error {
+	_, err := s.db.Exec("UPDATE users SET username = ? WHERE id = ?", username, id)
+	return err
+}
+
+func (s *sqliteUsers) SetPhotoURL(id int64, photoURL string) error {
+	_, err := s.db.Exec("UPDATE users SET photo_url = ? WHERE id = ?", photoURL, id)
+	return err
+}
+
+func (s *sqliteUsers) SetPasswordHash(id int64, hash string) error {
+	_, err := s.db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", hash, id)
+	return err
+}
+
+func (s *sqliteUsers) ReplacePasswordHash(id int64, oldHash string, newHash string) (bool, error) {
+	return rowsAffected(s.db.Exec(
+		"UPDATE users SET password_hash = ? WHERE id = ? AND password_hash = ?",
+		newHash,
+		id,
+		oldHash,
+	))
+}
+
+func (s *sqliteUsers) SetEmail(id int64, email string, verifiedAt time.Time) error {
+	_, err := s.db.Exec(
+		"UPDATE users SET email = ?, email_verified_at = ? WHERE id = ?",
+		email,
+		sqliteTime(verifiedAt),
+		id,
+	)
+	if err != nil && isSQLiteUniqueEmail(err) {
+		return errEmailTaken
+	}
+	return err
+}
+
+func (s *sqliteUsers) MarkEmailVerified(id int64, email string, at time.Time) (bool, error) {
+	return rowsAffected(s.db.Exec(
+		"UPDATE users SET email_verified_at = coalesce(email_verified_at, ?) WHERE id = ? AND email = ?",
+		sqliteTime(at),
+		id,
+		email,
+	))
+}
+
+func (s *sqliteUsers) ScheduleDeletion(id int64, at time.Time) error {
+	_, err := s.db.Exec("UPDATE users SET delete_after = ? WHERE id = ?", sqliteTime(at), id)
+	return err
+}
+
+func (s *sqliteUsers) CancelDeletion(id int64, now time.Time) (bool, error) {
+	cancelled, err := rowsAffected(s.db.Exec(
+		"UPDATE users SET delete_after = NULL WHERE id = ? AND delete_after > ?",
+		id,
+		sqliteTime(now),
+	))
+	if err != nil || cancelled {
+		return cancelled, err
+	}
+
+	var due bool
+	if err := s.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND delete_after IS NOT NULL AND delete_after <= ?)",
+		id,
+		sqliteTime(now),
+	).Scan(&due); err != nil {
+		return false, err
+	}
+	if due {
+		return false, errDeletionDue
+	}
+	return false, nil
+}
+
+func (s *sqliteUsers) DueForDeletion(now time.Time) ([]int64, error) {
+	rows, err := s.db.Query(
+		"SELECT id FROM users WHERE delete_after IS NOT NULL AND delete_after <= ?",
+		sqliteTime(now),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *sqliteUsers) Delete(id int64) error {
+	_, err := s.db.Exec("DELETE FROM users WHERE id = ?", id)
+	return err
+}
+
+const sqliteSessionColumns = "id, user_id, token_hash, created_at, last_seen_at, expires_at, user_agent, ip"
+
+// scanSession reads sqliteSessionColumns or their postgres equivalent.
+func scanSession(row rowScanner) (Session, error) {
+	var (
+		s          Session
+		lastSeenAt sql.NullTime
+	)
+	err := row.Scan(&s.ID, &s.UserID, &s.TokenHash, &s.CreatedAt, &lastSeenAt, &s.ExpiresAt, &s.UserAgent, &s.IP)
+	if err == sql.ErrNoRows {
+		return s, errNotFound
+	}
+	s.LastSeenAt = s.CreatedAt
+	if lastSeenAt.Valid {
+		s.LastSeenAt = lastSeenAt.Time
+	}
+	return s, err
+}
+
+func (r *sqliteSessions) Create(s *Session) error {
+	now := time.Now()
+	_, _ = r.db.Exec(
+		"DELETE FROM sessions WHERE user_id = ? AND expires_at <= ?",
+		s.UserID,
+		sqliteTime(now),
+	)
+
+	result, err := r.db.Exec(
+		`INSERT INTO sessions (user_id, token_hash, created_at, expires_at, last_seen_at, user_agent, ip)
+         VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		s.UserID,
+		s.TokenHash,
+		sqliteTime(s.CreatedAt),
+		sqliteTime(s.ExpiresAt),
+		sqliteTime(s.CreatedAt),
+		s.UserAgent,
+		s.IP,
+	)
+	if err != nil {
+		return err
+	}
+
+	s.ID, err = result.LastInsertId()
+	return err
+}
+
+func (r *sqliteSessions) ByTokenHash(tokenHash string, now time.Time) (Session, error) {
+	return scanSession(r.db.QueryRow(
+		"SELECT "+sqliteSessionColumns+" FROM sessions WHERE token_hash = ? AND expires_at > ? LIMIT 1",
+		tokenHash,
+		sqliteTime(now),
+	))
+}
+
+func (r *sqliteSessions) ByID(id int64) (Session, error) {
+	return scanSession(r.db.QueryRow("SELECT "+sqliteSessionColumns+" FROM sessions WHERE id = ? LIMIT 1", id))
+}
+
+func (r *sqliteSessions) Touch(id int64, now time.Time, ip string, since time.Time) error {
+	_, err := r.db.Exec(
+		`UPDATE sessions SET last_seen_at = ?, ip = ?
+         WHERE id = ? AND (last_seen_at IS NULL OR last_seen_at < ?)`,
+		sqliteTime(now),
+		ip,
+		id,
+		sqliteTime(since),
+	)
+	return err
+}
+
+func (r *sqliteSessions) ListByUser(userID int64, now time.Time) ([]Session, error) {
+	rows, err := r.db.Query(
+		`SELECT `+sqliteSessionColumns+`
+         FROM sessions
+         WHERE user_id = ? AND expires_at > ?
+         ORDER BY coalesce(last_seen_at, created_at) DESC, id DESC`,
+		userID,
+		sqliteTime(now),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Session, 0)
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqliteSessions) Delete(userID int64, id int64) (bool, error) {
+	return rowsAffected(r.db.Exec("DELETE FROM sessions WHERE id = ? AND user_id = ?", id, userID))
+}
+
+func (r *sqliteSessions) DeleteByUser(userID int64, exceptID int64) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM sessions WHERE user_id = ? AND id <> ?", userID, exceptID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *sqliteWatched) AddEvent(ev *WatchEvent) error {
+	result, err := r.db.Exec(
+		`INSERT INTO watch_events (user_id, media_type, tmdb_id, season_number, episode_number, watched_at)
+         VALUES (?, ?, ?, ?, ?, ?)`,
+		ev.UserID,
+		ev.MediaType,
+		ev.TmdbID,
+		ev.SeasonNumber,
+		ev.EpisodeNumber,
+		sqliteTime(ev.WatchedAt),
+	)
+	if err != nil {
+		return err
+	}
+	ev.ID, err = result.LastInsertId()
+	return err
+}
+
+func (r *sqliteWatched) SetLatest(ev *WatchEvent) error {
+	err := retryBusy(func() error {
+		return r.db.QueryRow(
+			`UPDATE watch_events SET watched_at = ?
+             WHERE id = (
+                 SELECT id FROM watch_events
+                 WHERE user_id = ? AND media_type = ? AND tmdb_id = ? AND season_number = ? AND episode_number = ?
+                 ORDER BY watched_at DESC, id DESC
+                 LIMIT 1
+             )
+             RETURNING id`,
+			sqliteTime(ev.WatchedAt),
+			ev.UserID,
+			ev.MediaType,
+			ev.TmdbID,
+			ev.SeasonNumber,
+			ev.EpisodeNumber,
+		).Scan(&ev.ID)
+	})
+	if err == sql.ErrNoRows {
+		return r.AddEvent(ev)
+	}
+	return err
+}
+
+func (r *sqliteWatched) Delete(rec WatchedRecord) (bool, error) {
+	return rowsAffected(r.db.Exec(
+		`DELETE FROM watch_events
+         WHERE user_id = ? AND media_type = ? AND tmdb_id = ? AND season_number = ? AND episode_number = ?`,
+		rec.UserID,
+		rec.MediaType,
+		rec.TmdbID,
+		rec.SeasonNumber,
+		rec.EpisodeNumber,
+	))
+}
+
+func (r *sqliteWatched) DeleteEvent(userID int64, id int64) (WatchEvent, error) {
+	var ev WatchEvent
+	err := retryBusy(func() error {
+		return r.db.QueryRow(
+			`DELETE FROM watch_events WHERE id = ? AND user_id = ?
+             RETURNING id, user_id, media_type, tmdb_id, season_number, episode_number, watched_at`,
+			id,
+			userID,
+		).Scan(&ev.ID, &ev.UserID, &ev.MediaType, &ev.TmdbID, &ev.SeasonNumber, &ev.EpisodeNumber, &ev.WatchedAt)
+	})
+	if err == sql.ErrNoRows {
+		return ev, errNotFound
+	}
+	return ev, err
+}
+
+func (r *sqliteWatched) ApplyChanges(changes []WatchedChange) ([]WatchedChangeResult, error) {
+	var results []WatchedChangeResult
+	err := r.db.inTx(func(tx *sql.Tx) error {
+		results = make([]WatchedChangeResult, 0, len(changes))
+		for _, change := range changes {
+			result, err := applySQLiteWatchedChange(tx, change)
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func applySQLiteWatchedChange(tx *sql.Tx, change WatchedChange) (WatchedChangeResult, error) {
+	ev := change.WatchEvent
+	item := []any{ev.UserID, ev.MediaType, ev.TmdbID, ev.SeasonNumber, ev.EpisodeNumber}
+	const match = "user_id = ? AND media_type = ? AND tmdb_id = ? AND season_number = ? AND episode_number = ?"
+
+	var result WatchedChangeResult
+	if change.Unmark {
+		deleted, err := rowsAffected(tx.Exec("DELETE FROM watch_events WHERE "+match, item...))
+		if err != nil {
+			return result, err
+		}
+		result.Status = watchNotWatched
+		if deleted {
+			result.Status = watchDeleted
+		}
+		return result, nil
+	}
+
+	insert := `INSERT INTO watch_events (user_id, media_type, tmdb_id, season_number, episode_number, watched_at)
+               SELECT ?, ?, ?, ?, ?, ?`
+	args := append(item, sqliteTime(ev.WatchedAt))
+	if !change.Rewatch {
+		insert += " WHERE NOT EXISTS (SELECT 1 FROM watch_events WHERE " + match + ")"
+		args = append(args, item...)
+	}
+	inserted, err := tx.Exec(insert, args...)
+	if err != nil {
+		return result, err
+	}
+	if n, err := inserted.RowsAffected(); err != nil {
+		return result, err
+	} else if n > 0 {
+		if result.EventID, err = inserted.LastInsertId(); err != nil {
+			return result, err
+		}
+	}
+
+	if err := tx.QueryRow("SELECT COUNT(1) FROM watch_events WHERE "+match, item...).Scan(&result.PlayCount); err != nil {
+		return result, err
+	}
+	switch {
+	case result.EventID == 0:
+		result.Status = watchUnchanged
+	case result.PlayCount == 1:
+		result.Status = watchCreated
+	default:
+		result.Status = watchRewatched
+	}
+	return result, nil
+}
+
+func sqliteWatchedWhere(filter WatchedFilter) (string, []any) {
+	where := "user_id = ?"
+	args := []any{filter.UserID}
+
+	if filter.MediaType != "" {
+		where += " AND media_type = ?"
+		args = append(args, filter.MediaType)
+	}
+	if filter.TmdbID > 0 {
+		where += " AND tmdb_id = ?"
+		args = append(args, filter.TmdbID)
+	}
+	if filter.SeasonNumber != nil {
+		where += " AND season_number = ?"
+		args = append(args, *filter.SeasonNumber)
+	}
+	if filter.EpisodeNumber != nil {
+		where += " AND episode_number = ?"
+		args = append(args, *filter.EpisodeNumber)
+	}
+	if !filter.From.IsZero() {
+		where += " AND watched_at >= ?"
+		args = append(args, sqliteTime(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where += " AND watched_at <= ?"
+		args = append(args, sqliteTime(filter.To))
+	}
+	return where, args
+}
+
+func (r *sqliteWatched) List(filter WatchedFilter) ([]WatchedRecord, error) {
+	where, args := sqliteWatchedWhere(filter)
+	query := `SELECT user_id, media_type, tmdb_id, season_number, episode_number, last_watched_at, play_count
+              FROM (
+                  SELECT user_id, media_type, tmdb_id, season_number, episode_number,
+                         max(watched_at) AS last_watched_at, COUNT(1) AS play_count
+                  FROM watch_events
+                  WHERE ` + where + `
+                  GROUP BY user_id, media_type, tmdb_id, season_number, episode_number
+              ) AS items`
+	if after := filter.After; after != nil {
+		query += ` WHERE last_watched_at < ? OR (last_watched_at = ?
+                   AND (media_type, tmdb_id, season_number, episode_number) > (?, ?, ?, ?))`
+		lastWatchedAt := sqliteTime(after.WatchedAt)
+		args = append(args, lastWatchedAt, lastWatchedAt, after.MediaType, after.TmdbID, after.SeasonNumber, after.EpisodeNumber)
+	}
+	query += " ORDER BY last_watched_at DESC, media_type, tmdb_id, season_number, episode_number"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]WatchedRecord, 0)
+	for rows.Next() {
+		var (
+			rec       WatchedRecord
+			watchedAt string
+		)
+		if err := rows.Scan(
+			&rec.UserID,
+			&rec.MediaType,
+			&rec.TmdbID,
+			&rec.SeasonNumber,
+			&rec.EpisodeNumber,
+			&watchedAt,
+			&rec.PlayCount,
+		); err != nil {
+			return nil, err
+		}
+		// max() drops the column type, so the driver hands back the stored text.
+		if rec.WatchedAt, err = time.Parse(dbTimeLayout, watchedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqliteWatched) Events(filter WatchedFilter) ([]WatchEvent, error) {
+	where, args := sqliteWatchedWhere(filter)
+	rows, err := r.db.Query(
+		`SELECT id, user_id, media_type, tmdb_id, season_number, episode_number, watched_at
+         FROM watch_events
+         WHERE `+where+`
+         ORDER BY watched_at DESC, id DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]WatchEvent, 0)
+	for rows.Next() {
+		var ev WatchEvent
+		if err := rows.Scan(
+			&ev.ID,
+			&ev.UserID,
+			&ev.MediaType,
+			&ev.TmdbID,
+			&ev.SeasonNumber,
+			&ev.EpisodeNumber,
+			&ev.WatchedAt,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, ev)
+	}
+	return out, rows.Err()
+}
+
+// EpisodeCounts counts distinct episodes, so rewatches do not inflate progress.
+func (r *sqliteWatched) EpisodeCounts(userID int64, tmdbIDs []int64) (map[int64]int, error) {
+	counts := make(map[int64]int)
+	if len(tmdbIDs) == 0 {
+		return counts, nil
+	}
+	args := []any{userID}
+	for _, id := range tmdbIDs {
+		args = append(args, id)
+	}
+	rows, err := r.db.Query(
+		`SELECT tmdb_id, COUNT(1) FROM (
+             SELECT DISTINCT tmdb_id, season_number, episode_number FROM watch_events
+             WHERE user_id = ? AND media_type = 'tv' AND episode_number > 0
+               AND tmdb_id IN (`+strings.Repeat("?, ", len(tmdbIDs)-1)+`?)
+         ) AS episodes
+         GROUP BY tmdb_id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			tmdbID int64
+			count  int
+		)
+		if err := rows.Scan(&tmdbID, &count); err != nil {
+			return nil, err
+		}
+		counts[tmdbID] = count
+	}
+	return counts, rows.Err()
+}
+
+func (r *sqliteWatched) DeleteByUser(userID int64) error {
+	_, err := r.db.Exec("DELETE FROM watch_events WHERE user_id = ?", userID)
+	return err
+}
+
+func (r *sqliteTitles) Get(userID int64, mediaType string, tmdbID int64) (TitleStatus, error) {
+	ts := TitleStatus{UserID: userID, MediaType: mediaType, TmdbID: tmdbID}
+	err := r.db.QueryRow(
+		`SELECT status, inferred, created_at, status_changed_at FROM title_statuses
+         WHERE user_id = ? AND media_type = ? AND tmdb_id = ?`,
+		userID,
+		mediaType,
+		tmdbID,
+	).Scan(&ts.Status, &ts.Inferred, &ts.CreatedAt, &ts.StatusChangedAt)
+	if err == sql.ErrNoRows {
+		return ts, errNotFound
+	}
+	return ts, err
+}
+
+func (r *sqliteTitles) List(filter TitleStatusFilter) ([]TitleStatus, error) {
+	where := "user_id = ?"
+	args := []any{filter.UserID}
+	if filter.MediaType != "" {
+		where += " AND media_type = ?"
+		args = append(args, filter.MediaType)
+	}
+	if filter.Status != "" {
+		where += " AND status = ?"
+		args = append(args, filter.Status)
+	}
+
+	rows, err := r.db.Query(
+		`SELECT user_id, media_type, tmdb_id, status, inferred, created_at, status_changed_at
+         FROM title_statuses
+         WHERE `+where+`
+         ORDER BY status_changed_at DESC, id DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]TitleStatus, 0)
+	for rows.Next() {
+		var ts TitleStatus
+		if err := rows.Scan(
+			&ts.UserID,
+			&ts.MediaType,
+			&ts.TmdbID,
+			&ts.Status,
+			&ts.Inferred,
+			&ts.CreatedAt,
+			&ts.StatusChangedAt,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, ts)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqliteTitles) Set(ts *TitleStatus, at time.Time) error {
+	return r.db.inTx(func(tx *sql.Tx) error {
+		var previous string
+		err := tx.QueryRow(
+			"SELECT status FROM title_statuses WHERE user_id = ? AND media_type = ? AND tmdb_id = ?",
+			ts.UserID,
+			ts.MediaType,
+			ts.TmdbID,
+		).Scan(&previous)
+		switch {
+		case err == sql.ErrNoRows:
+			_, err = tx.Exec(
+				`INSERT INTO title_statuses (user_id, media_type, tmdb_id, status, inferred, created_at, status_changed_at)
+                 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				ts.UserID,
+				ts.MediaType,
+				ts.TmdbID,
+				ts.Status,
+				ts.Inferred,
+				sqliteTime(at),
+				sqliteTime(at),
+			)
+		case err != nil:
+			return err
+		case previous == ts.Status:
+			_, err = tx.Exec(
+				"UPDATE title_statuses SET inferred = ? WHERE user_id = ? AND media_type = ? AND tmdb_id = ?",
+				ts.Inferred,
+				ts.UserID,
+				ts.MediaType,
+				ts.TmdbID,
+			)
+		default:
+			_, err = tx.Exec(
+				`UPDATE title_statuses SET status = ?, inferred = ?, status_changed_at = ?
+                 WHERE user_id = ? AND media_type = ? AND tmdb_id = ?`,
+				ts.Status,
+				ts.Inferred,
+				sqliteTime(at),
+				ts.UserID,
+				ts.MediaType,
+				ts.TmdbID,
+			)
+		}
+		if err != nil {
+			return err
+		}
+
+		if previous != ts.Status {
+			if _, err := tx.Exec(
+				`INSERT INTO title_status_changes (user_id, media_type, tmdb_id, from_status, to_status, inferred, changed_at)
+                 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				ts.UserID,
+				ts.MediaType,
+				ts.TmdbID,
+				previous,
+				ts.Status,
+				ts.Inferred,
+				sqliteTime(at),
+			); err != nil {
+				return err
+			}
+		}
+
+		return tx.QueryRow(
+			"SELECT created_at, status_changed_at FROM title_statuses WHERE user_id = ? AND media_type = ? AND tmdb_id = ?",
+			ts.UserID,
+			ts.MediaType,
+			ts.TmdbID,
+		).Scan(&ts.CreatedAt, &ts.StatusChangedAt)
+	})
+}
+
+func (r *sqliteTitles) Delete(userID int64, mediaType string, tmdbID int64) (bool, error) {
+	var deleted bool
+	err := r.db.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(
+			"DELETE FROM title_status_changes WHERE user_id = ? AND media_type = ? AND tmdb_id = ?",
+			userID,
+			mediaType,
+			tmdbID,
+		); err != nil {
+			return err
+		}
+		var err error
+		deleted, err = rowsAffected(tx.Exec(
+			"DELETE FROM title_statuses WHERE user_id = ? AND media_type = ? AND tmdb_id = ?",
+			userID,
+			mediaType,
+			tmdbID,
+		))
+		return err
+	})
+	return deleted, err
+}
+
+func (r *sqliteTitles) History(userID int64, mediaType string, tmdbID int64) ([]TitleStatusChange, error) {
+	rows, err := r.db.Query(
+		`SELECT from_status, to_status, inferred, changed_at FROM title_status_changes
+         WHERE user_id = ? AND media_type = ? AND tmdb_id = ?
+         ORDER BY changed_at, id`,
+		userID,
+		mediaType,
+		tmdbID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]TitleStatusChange, 0)
+	for rows.Next() {
+		var change TitleStatusChange
+		if err := rows.Scan(&change.FromStatus, &change.ToStatus, &change.Inferred, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, change)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqliteTitles) DeleteByUser(userID int64) error {
+	if _, err := r.db.Exec("DELETE FROM title_status_changes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	_, err := r.db.Exec("DELETE FROM title_statuses WHERE user_id = ?", userID)
+	return err
+}
+
+func (r *sqliteRatings) Set(rating *Rating, at time.Time) error {
+	return retryBusy(func() error {
+		return r.db.QueryRow(
+			`INSERT INTO ratings (user_id, media_type, tmdb_id, season_number, episode_number, score, review, spoiler, created_at, updated_at)
+             VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+             ON CONFLICT (user_id, media_type, tmdb_id, season_number, episode_number) DO UPDATE
+             SET score = excluded.score, review = excluded.review, spoiler = excluded.spoiler, updated_at = excluded.updated_at
+             RETURNING created_at, updated_at`,
+			rating.UserID,
+			rating.MediaType,
+			rating.TmdbID,
+			rating.SeasonNumber,
+			rating.EpisodeNumber,
+			rating.Score,
+			rating.Review,
+			rating.Spoiler,
+			sqliteTime(at),
+			sqliteTime(at),
+		).Scan(&rating.CreatedAt, &rating.UpdatedAt)
+	})
+}
+
+func (r *sqliteRatings) Delete(key Rating) (bool, error) {
+	return rowsAffected(r.db.Exec(
+		`DELETE FROM ratings
+         WHERE user_id = ? AND media_type = ? AND tmdb_id = ? AND season_number = ? AND episode_number = ?`,
+		key.UserID,
+		key.MediaType,
+		key.TmdbID,
+		key.SeasonNumber,
+		key.EpisodeNumber,
+	))
+}
+
+func sqliteRatingsWhere(filter RatingFilter) (string, []any) {
+	where := "1 = 1"
+	var args []any
+
+	if filter.UserID > 0 {
+		where += " AND user_id = ?"
+		args = append(args, filter.UserID)
+	}
+	if filter.MediaType != "" {
+		where += " AND media_type = ?"
+		args = append(args, filter.MediaType)
+	}
+	if filter.TmdbID > 0 {
+		where += " AND tmdb_id = ?"
+		args = append(args, filter.TmdbID)
+	}
+	if filter.SeasonNumber != nil {
+		where += " AND season_number = ?"
+		args = append(args, *filter.SeasonNumber)
+	}
+	if filter.EpisodeNumber != nil {
+		where += " AND episode_number = ?"
+		args = append(args, *filter.EpisodeNumber)
+	}
+	return where, args
+}
+
+func (r *sqliteRatings) List(filter RatingFilter) ([]Rating, error) {
+	where, args := sqliteRatingsWhere(filter)
+	rows, err := r.db.Query(
+		`SELECT user_id, media_type, tmdb_id, season_number, episode_number, score, review, spoiler, created_at, updated_at
+         FROM ratings
+         WHERE `+where+`
+         ORDER BY updated_at DESC, id DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Rating, 0)
+	for rows.Next() {
+		var rating Rating
+		if err := rows.Scan(
+			&rating.UserID,
+			&rating.MediaType,
+			&rating.TmdbID,
+			&rating.SeasonNumber,
+			&rating.EpisodeNumber,
+			&rating.Score,
+			&rating.Review,
+			&rating.Spoiler,
+			&rating.CreatedAt,
+			&rating.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, rating)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqliteRatings) Summaries(filter RatingFilter) ([]RatingSummary, error) {
+	where, args := sqliteRatingsWhere(filter)
+	rows, err := r.db.Query(
+		`SELECT media_type, tmdb_id, season_number, episode_number, COUNT(1), AVG(score)
+         FROM ratings
+         WHERE `+where+`
+         GROUP BY media_type, tmdb_id, season_number, episode_number
+         ORDER BY media_type, tmdb_id, season_number, episode_number`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]RatingSummary, 0)
+	for rows.Next() {
+		var summary RatingSummary
+		if err := rows.Scan(
+			&summary.MediaType,
+			&summary.TmdbID,
+			&summary.SeasonNumber,
+			&summary.EpisodeNumber,
+			&summary.Count,
+			&summary.AverageScore,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, summary)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqliteRatings) DeleteByUser(userID int64) error {
+	_, err := r.db.Exec("DELETE FROM ratings WHERE user_id = ?", userID)
+	return err
+}
+
+const sqliteListColumns = `id, user_id, name, description, visibility, coalesce(share_token, ''),
+    (SELECT COUNT(1) FROM user_list_items i WHERE i.list_id = user_lists.id), created_at, updated_at`
+
+func scanUserList(row rowScanner) (UserList, error) {
+	var list UserList
+	err := row.Scan(
+		&list.ID,
+		&list.UserID,
+		&list.Name,
+		&list.Description,
+		&list.Visibility,
+		&list.ShareToken,
+		&list.ItemCount,
+		&list.CreatedAt,
+		&list.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return list, errNotFound
+	}
+	return list, err
+}
+
+// nullableToken stores an empty share token as NULL, which the unique index
+// on share_token ignores.
+func nullableToken(token string) any {
+	if token == "" {
+		return nil
+	}
+	return token
+}
+
+func (r *sqliteLists) Create(list *UserList, at time.Time) error {
+	result, err := r.db.Exec(
+		`INSERT INTO user_lists (user_id, name, description, visibility, share_token, created_at, updated_at)
+         VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		list.UserID,
+		list.Name,
+		list.Description,
+		list.Visibility,
+		nullableToken(list.ShareToken),
+		sqliteTime(at),
+		sqliteTime(at),
+	)
+	if err != nil {
+		return err
+	}
+	list.ID, err = result.LastInsertId()
+	list.ItemCount = 0
+	list.CreatedAt = at
+	list.UpdatedAt = at
+	return err
+}
+
+func (r *sqliteLists) ByID(id int64) (UserList, error) {
+	return scanUserList(r.db.QueryRow("SELECT "+sqliteListColumns+" FROM user_lists WHERE id = ?", id))
+}
+
+func (r *sqliteLists) ByShareToken(token string) (UserList, error) {
+	return scanUserList(r.db.QueryRow("SELECT "+sqliteListColumns+" FROM user_lists WHERE share_token = ?", token))
+}
+
+func (r *sqliteLists) ByUser(userID int64, visibility string) ([]UserList, error) {
+	where := "user_id = ?"
+	args := []any{userID}
+	if visibility != "" {
+		where += " AND visibility = ?"
+		args = append(args, visibility)
+	}
+
+	rows, err := r.db.Query(
+		"SELECT "+sqliteListColumns+" FROM user_lists WHERE "+where+" ORDER BY updated_at DESC, id DESC",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]UserList, 0)
+	for rows.Next() {
+		list, err := scanUserList(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, list)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqliteLists) Update(list *UserList, at time.Time) (bool, error) {
+	updated, err := rowsAffected(r.db.Exec(
+		`UPDATE user_lists SET name = ?, description = ?, visibility = ?, share_token = ?, updated_at = ?
+         WHERE id = ? AND user_id = ?`,
+		list.Name,
+		list.Description,
+		list.Visibility,
+		nullableToken(list.ShareToken),
+		sqliteTime(at),
+		list.ID,
+		list.UserID,
+	))
+	if updated {
+		list.UpdatedAt = at
+	}
+	return updated, err
+}
+
+func (r *sqliteLists) Delete(userID int64, id int64) (bool, error) {
+	// user_list_items follows through its foreign key.
+	return rowsAffected(r.db.Exec("DELETE FROM user_lists WHERE id = ? AND user_id = ?", id, userID))
+}
+
+func (r *sqliteLists) Items(listID int64) ([]UserListItem, error) {
+	rows, err := r.db.Query(
+		`SELECT id, list_id, media_type, tmdb_id, position, note, added_at
+         FROM user_list_items
+         WHERE list_id = ?
+         ORDER BY position, id`,
+		listID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]UserListItem, 0)
+	for rows.Next() {
+		var item UserListItem
+		if err := rows.Scan(
+			&item.ID,
+			&item.ListID,
+			&item.MediaType,
+			&item.TmdbID,
+			&item.Position,
+			&item.Note,
+			&item.AddedAt,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+func touchSQLiteList(tx *sql.Tx, listID int64, at time.Time) error {
+	_, err := tx.Exec("UPDATE user_lists SET updated_at = ? WHERE id = ?", sqliteTime(at), listID)
+	return err
+}
+
+// sqliteListItemPosition returns the position of an item, or errNotFound.
+func sqliteListItemPosition(tx *sql.Tx, listID int64, itemID int64) (int, error) {
+	var position int
+	err := tx.QueryRow(
+		"SELECT position FROM user_list_items WHERE id = ? AND list_id = ?",
+		itemID,
+		listID,
+	).Scan(&position)
+	if err == sql.ErrNoRows {
+		return 0, errNotFound
+	}
+	return position, err
+}
+
+func (r *sqliteLists) AddItem(item *UserListItem, position int, at time.Time) error {
+	return r.db.inTx(func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM user_list_items WHERE list_id = ? AND media_type = ? AND tmdb_id = ?)",
+			item.ListID,
+			item.MediaType,
+			item.TmdbID,
+		).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return errListItemExists
+		}
+
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(1) FROM user_list_items WHERE list_id = ?", item.ListID).Scan(&count); err != nil {
+			return err
+		}
+		if position < 0 || position > count {
+			position = count
+		}
+		if _, err := tx.Exec(
+			"UPDATE user_list_items SET position = position + 1 WHERE list_id = ? AND position >= ?",
+			item.ListID,
+			position,
+		); err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(
+			`INSERT INTO user_list_items (list_id, media_type, tmdb_id, position, note, added_at)
+             VALUES (?, ?, ?, ?, ?, ?)`,
+			item.ListID,
+			item.MediaType,
+			item.TmdbID,
+			position,
+			item.Note,
+			sqliteTime(at),
+		)
+		if err != nil {
+			return err
+		}
+		if item.ID, err = result.LastInsertId(); err != nil {
+			return err
+		}
+		item.Position = position
+		item.AddedAt = at
+		return touchSQLiteList(tx, item.ListID, at)
+	})
+}
+
+func (r *sqliteLists) SetItemNote(listID int64, itemID int64, note string, at time.Time) (bool, error) {
+	var updated bool
+	err := r.db.inTx(func(tx *sql.Tx) error {
+		var err error
+		updated, err = rowsAffected(tx.Exec(
+			"UPDATE user_list_items SET note = ? WHERE id = ? AND list_id = ?",
+			note,
+			itemID,
+			listID,
+		))
+		if err != nil || !updated {
+			return err
+		}
+		return touchSQLiteList(tx, listID, at)
+	})
+	return updated, err
+}
+
+func (r *sqliteLists) MoveItem(listID int64, itemID int64, position int, at time.Time) (bool, error) {
+	err := r.db.inTx(func(tx *sql.Tx) error {
+		current, err := sqliteListItemPosition(tx, listID, itemID)
+		if err != nil {
+			return err
+		}
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(1) FROM user_list_items WHERE list_id = ?", listID).Scan(&count); err != nil {
+			return err
+		}
+		position = min(max(position, 0), count-1)
+
+		switch {
+		case position < current:
+			_, err = tx.Exec(
+				"UPDATE user_list_items SET position = position + 1 WHERE list_id = ? AND position >= ? AND position < ?",
+				listID,
+				position,
+				current,
+			)
+		case position > current:
+			_, err = tx.Exec(
+				"UPDATE user_list_items SET position = position - 1 WHERE list_id = ? AND position > ? AND position <= ?",
+				listID,
+				current,
+				position,
+			)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE user_list_items SET position = ? WHERE id = ?", position, itemID); err != nil {
+			return err
+		}
+		return touchSQLiteList(tx, listID, at)
+	})
+	if err == errNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *sqliteLists) RemoveItem(listID int64, itemID int64, at time.Time) (bool, error) {
+	err := r.db.inTx(func(tx *sql.Tx) error {
+		position, err := sqliteListItemPosition(tx, listID, itemID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM user_list_items WHERE id = ?", itemID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			"UPDATE user_list_items SET position = position - 1 WHERE list_id = ? AND position > ?",
+			listID,
+			position,
+		); err != nil {
+			return err
+		}
+		return touchSQLiteList(tx, listID, at)
+	})
+	if err == errNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *sqliteLists) Reorder(listID int64, itemIDs []int64, at time.Time) error {
+	return r.db.inTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id FROM user_list_items WHERE list_id = ?", listID)
+		if err != nil {
+			return err
+		}
+		current := make(map[int64]bool)
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			current[id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if !sameListItems(current, itemIDs) {
+			return errListOrderMismatch
+		}
+
+		for position, id := range itemIDs {
+			if _, err := tx.Exec("UPDATE user_list_items SET position = ? WHERE id = ?", position, id); err != nil {
+				return err
+			}
+		}
+		return touchSQLiteList(tx, listID, at)
+	})
+}
+
+func (r *sqliteLists) DeleteByUser(userID int64) error {
+	_, err := r.db.Exec("DELETE FROM user_lists WHERE user_id = ?", userID)
+	return err
+}
@@ -0,0 +1,747 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Every backend runs the same suite so that they stay interchangeable.
+func TestSQLiteRepositories(t *testing.T) {
+	dsn, err := sqliteDSN(filepath.Join(t.TempDir(), "tracksm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+
+	runRepositoryConformance(t, newSQLiteRepositories(db))
+}
+
+// The app refuses Postgres until every table with user ids can follow users
+// there.
+func TestOpenRepositoriesRefusesPostgres(t *testing.T) {
+	t.Setenv("DB_DRIVER", "postgres")
+	t.Setenv("DATABASE_URL", "postgres://localhost/tracksm")
+	if repos, err := openRepositories(nil); err == nil || !strings.Contains(err.Error(), "personal_access_tokens") {
+		t.Fatalf("openRepositories: got %v, %v", repos, err)
+	}
+}
+
+// TestPostgresRepositories needs TEST_DATABASE_URL and works in a throwaway
+// schema, so it can point at a shared database.
+func TestPostgresRepositories(t *testing.T) {
+	dsn := strings.TrimSpace(os.Getenv("TEST_DATABASE_URL"))
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	if err := admin.Ping(); err != nil {
+		t.Skipf("postgres not reachable: %v", err)
+	}
+
+	schema := fmt.Sprintf("tracksm_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	if strings.Contains(dsn, "://") {
+		if strings.Contains(dsn, "?") {
+			dsn += "&search_path=" + schema
+		} else {
+			dsn += "?search_path=" + schema
+		}
+	} else {
+		dsn += " search_path=" + schema
+	}
+
+	repos, err := openPostgresRepositories(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repos.Close() })
+
+	runRepositoryConformance(t, repos)
+}
+
+func runRepositoryConformance(t *testing.T, repos *Repositories) {
+	t.Run("users", func(t *testing.T) { testUserRepository(t, repos.Users) })
+	t.Run("sessions", func(t *testing.T) { testSessionRepository(t, repos.Users, repos.Sessions) })
+	t.Run("watched", func(t *testing.T) { testWatchedRepository(t, repos.Users, repos.Watched) })
+	t.Run("titles", func(t *testing.T) { testTitleStatusRepository(t, repos.Users, repos.Titles) })
+	t.Run("ratings", func(t *testing.T) { testRatingRepository(t, repos.Users, repos.Ratings) })
+	t.Run("lists", func(t *testing.T) { testListRepository(t, repos.Users, repos.Lists) })
+}
+
+func createTestUser(t *testing.T, users UserRepository, email string) User {
+	t.Helper()
+	u := User{Name: "Test", Email: email, Username: strings.Split(email, "@")[0], PasswordHash: "hash"}
+	if err := users.Create(&u); err != nil {
+		t.Fatalf("create %s: %v", email, err)
+	}
+	if u.ID == 0 {
+		t.Fatalf("create %s: id not set", email)
+	}
+	return u
+}
+
+func testUserRepository(t *testing.T, users UserRepository) {
+	u := createTestUser(t, users, "ana@example.com")
+
+	if err := users.Create(&User{Name: "Other", Email: "ana@example.com", PasswordHash: "x"}); !errors.Is(err, errEmailTaken) {
+		t.Fatalf("duplicate email: got %v, want errEmailTaken", err)
+	}
+
+	got, err := users.ByEmail("ana@example.com")
+	if err != nil || got.ID != u.ID || got.Name != "Test" || got.EmailVerified || got.CreatedAt.IsZero() {
+		t.Fatalf("ByEmail: got %+v, %v", got, err)
+	}
+	if _, err := users.ByID(u.ID + 1000); !errors.Is(err, errNotFound) {
+		t.Fatalf("ByID missing: got %v, want errNotFound", err)
+	}
+
+	if taken, err := users.UsernameTaken("ANA", 0); err != nil || !taken {
+		t.Fatalf("UsernameTaken: got %v, %v", taken, err)
+	}
+	if taken, err := users.UsernameTaken("ana", u.ID); err != nil || taken {
+		t.Fatalf("UsernameTaken excluding owner: got %v, %v", taken, err)
+	}
+
+	if ok, err := users.UpdateProfile(u.ID, "Ana", "ana2", "https://example.com/a.png"); err != nil || !ok {
+		t.Fatalf("UpdateProfile: got %v, %v", ok, err)
+	}
+	if err := users.SetUsername(u.ID, "ana3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetPhotoURL(u.ID, "https://example.com/b.png"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = users.ByID(u.ID)
+	if got.Name != "Ana" || got.Username != "ana3" || got.PhotoURL != "https://example.com/b.png" {
+		t.Fatalf("profile not stored: %+v", got)
+	}
+
+	if ok, err := users.ReplacePasswordHash(u.ID, "stale", "new"); err != nil || ok {
+		t.Fatalf("ReplacePasswordHash with stale hash: got %v, %v", ok, err)
+	}
+	if ok, err := users.ReplacePasswordHash(u.ID, "hash", "new"); err != nil || !ok {
+		t.Fatalf("ReplacePasswordHash: got %v, %v", ok, err)
+	}
+	if err := users.SetPasswordHash(u.ID, "newer"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = users.ByID(u.ID); got.PasswordHash != "newer" {
+		t.Fatalf("password hash: got %q", got.PasswordHash)
+	}
+
+	now := time.Now()
+	if ok, err := users.MarkEmailVerified(u.ID, "other@example.com", now); err != nil || ok {
+		t.Fatalf("MarkEmailVerified for another address: got %v, %v", ok, err)
+	}
+	if ok, err := users.MarkEmailVerified(u.ID, "ana@example.com", now); err != nil || !ok {
+		t.Fatalf("MarkEmailVerified: got %v, %v", ok, err)
+	}
+	if got, _ = users.ByID(u.ID); !got.EmailVerified {
+		t.Fatal("email not marked verified")
+	}
+
+	other := createTestUser(t, users, "bia@example.com")
+	if err := users.SetEmail(other.ID, "ana@example.com", now); !errors.Is(err, errEmailTaken) {
+		t.Fatalf("SetEmail to a taken address: got %v, want errEmailTaken", err)
+	}
+	if err := users.SetEmail(other.ID, "bia2@example.com", now); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = users.ByEmail("bia2@example.com"); got.ID != other.ID || !got.EmailVerified {
+		t.Fatalf("SetEmail: got %+v", got)
+	}
+
+	if err := users.ScheduleDeletion(u.ID, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := users.DueForDeletion(now); err != nil || len(ids) != 0 {
+		t.Fatalf("DueForDeletion before the deadline: got %v, %v", ids, err)
+	}
+	if ids, err := users.DueForDeletion(now.Add(2 * time.Hour)); err != nil || len(ids) != 1 || ids[0] != u.ID {
+		t.Fatalf("DueForDeletion after the deadline: got %v, %v", ids, err)
+	}
+	if ok, err := users.CancelDeletion(u.ID, now.Add(2*time.Hour)); !errors.Is(err, errDeletionDue) || ok {
+		t.Fatalf("CancelDeletion after the deadline: got %v, %v, want errDeletionDue", ok, err)
+	}
+	if ok, err := users.CancelDeletion(u.ID, now); err != nil || !ok {
+		t.Fatalf("CancelDeletion: got %v, %v", ok, err)
+	}
+	if ok, err := users.CancelDeletion(u.ID, now); err != nil || ok {
+		t.Fatalf("CancelDeletion twice: got %v, %v", ok, err)
+	}
+
+	if err := users.Delete(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.ByID(other.ID); !errors.Is(err, errNotFound) {
+		t.Fatalf("deleted user: got %v, want errNotFound", err)
+	}
+}
+
+func testSessionRepository(t *testing.T, users UserRepository, sessions SessionRepository) {
+	u := createTestUser(t, users, "sessions@example.com")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	create := func(hash string, expiresAt time.Time) Session {
+		t.Helper()
+		s := Session{UserID: u.ID, TokenHash: hash, CreatedAt: now, ExpiresAt: expiresAt, UserAgent: "test", IP: "127.0.0.1"}
+		if err := sessions.Create(&s); err != nil {
+			t.Fatalf("create session: %v", err)
+		}
+		return s
+	}
+
+	first := create("hash-1", now.Add(time.Hour))
+	second := create("hash-2", now.Add(time.Hour))
+	create("hash-expired", now.Add(-time.Minute))
+
+	got, err := sessions.ByTokenHash("hash-1", now)
+	if err != nil || got.ID != first.ID || got.UserID != u.ID || !got.ExpiresAt.Equal(first.ExpiresAt) {
+		t.Fatalf("ByTokenHash: got %+v, %v", got, err)
+	}
+	if !got.LastSeenAt.Equal(now) {
+		t.Fatalf("LastSeenAt defaults to CreatedAt: got %v, want %v", got.LastSeenAt, now)
+	}
+	if _, err := sessions.ByTokenHash("hash-expired", now); !errors.Is(err, errNotFound) {
+		t.Fatalf("expired session: got %v, want errNotFound", err)
+	}
+
+	later := now.Add(10 * time.Minute)
+	if err := sessions.Touch(first.ID, later, "10.0.0.1", later.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.Touch(first.ID, later.Add(time.Second), "10.0.0.2", later.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = sessions.ByID(first.ID)
+	if !got.LastSeenAt.Equal(later) || got.IP != "10.0.0.1" {
+		t.Fatalf("Touch: got %+v", got)
+	}
+
+	list, err := sessions.ListByUser(u.ID, now)
+	if err != nil || len(list) != 2 || list[0].ID != first.ID || list[1].ID != second.ID {
+		t.Fatalf("ListByUser: got %+v, %v", list, err)
+	}
+
+	if ok, err := sessions.Delete(u.ID+1000, second.ID); err != nil || ok {
+		t.Fatalf("Delete with another user id: got %v, %v", ok, err)
+	}
+	third := create("hash-3", now.Add(time.Hour))
+	if revoked, err := sessions.DeleteByUser(u.ID, third.ID); err != nil || revoked != 2 {
+		t.Fatalf("DeleteByUser: got %d, %v", revoked, err)
+	}
+	if ok, err := sessions.Delete(u.ID, third.ID); err != nil || !ok {
+		t.Fatalf("Delete: got %v, %v", ok, err)
+	}
+	if list, _ = sessions.ListByUser(u.ID, now); len(list) != 0 {
+		t.Fatalf("sessions left: %+v", list)
+	}
+}
+
+func testWatchedRepository(t *testing.T, users UserRepository, watched WatchedRepository) {
+	u := createTestUser(t, users, "watched@example.com")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	episode := func(tmdbID, season, number int64, at time.Time) WatchEvent {
+		return WatchEvent{UserID: u.ID, MediaType: "tv", TmdbID: tmdbID, SeasonNumber: season, EpisodeNumber: number, WatchedAt: at}
+	}
+	events := []WatchEvent{
+		episode(1396, 1, 1, now.Add(-3*time.Hour)),
+		episode(1396, 1, 2, now.Add(-2*time.Hour)),
+		episode(1396, 2, 1, now.Add(-time.Hour)),
+		episode(70523, 1, 1, now),
+		{UserID: u.ID, MediaType: "movie", TmdbID: 603, WatchedAt: now},
+	}
+	for i := range events {
+		if err := watched.SetLatest(&events[i]); err != nil {
+			t.Fatal(err)
+		}
+		if events[i].ID == 0 {
+			t.Fatalf("SetLatest did not set an id for %+v", events[i])
+		}
+	}
+
+	// SetLatest redates the latest viewing; AddEvent keeps the earlier one.
+	first := episode(1396, 1, 1, now.Add(-30*time.Minute))
+	if err := watched.SetLatest(&first); err != nil || first.ID != events[0].ID {
+		t.Fatalf("SetLatest: got id %d, %v; want %d", first.ID, err, events[0].ID)
+	}
+	rewatch := episode(1396, 1, 1, now.Add(time.Hour))
+	if err := watched.AddEvent(&rewatch); err != nil || rewatch.ID == first.ID {
+		t.Fatalf("AddEvent: got id %d, %v", rewatch.ID, err)
+	}
+
+	all, err := watched.List(WatchedFilter{UserID: u.ID, MediaType: "tv"})
+	if err != nil || len(all) != 4 {
+		t.Fatalf("List tv: got %d records, %v", len(all), err)
+	}
+	if all[0].TmdbID != 1396 || all[0].EpisodeNumber != 1 || !all[0].WatchedAt.Equal(now.Add(time.Hour)) || all[0].PlayCount != 2 {
+		t.Fatalf("List order: first record %+v", all[0])
+	}
+	if all[1].PlayCount != 1 {
+		t.Fatalf("List play count: second record %+v", all[1])
+	}
+
+	season, number := int64(1), int64(1)
+	history, err := watched.Events(WatchedFilter{UserID: u.ID, MediaType: "tv", TmdbID: 1396, SeasonNumber: &season, EpisodeNumber: &number})
+	if err != nil || len(history) != 2 || history[0].ID != rewatch.ID || !history[1].WatchedAt.Equal(first.WatchedAt) {
+		t.Fatalf("Events: got %+v, %v", history, err)
+	}
+
+	filtered, err := watched.List(WatchedFilter{UserID: u.ID, MediaType: "tv", TmdbID: 1396, SeasonNumber: &season})
+	if err != nil || len(filtered) != 2 {
+		t.Fatalf("List season: got %+v, %v", filtered, err)
+	}
+	movies, err := watched.List(WatchedFilter{UserID: u.ID, MediaType: "movie"})
+	if err != nil || len(movies) != 1 || movies[0].TmdbID != 603 {
+		t.Fatalf("List movie: got %+v, %v", movies, err)
+	}
+
+	// Pages follow the full order; the movie and 70523 tie on watched_at and
+	// are ordered by media type.
+	everything, err := watched.List(WatchedFilter{UserID: u.ID})
+	if err != nil || len(everything) != 5 || everything[1].MediaType != "movie" || everything[2].TmdbID != 70523 {
+		t.Fatalf("List all: got %+v, %v", everything, err)
+	}
+	var paged []WatchedRecord
+	page := WatchedFilter{UserID: u.ID, Limit: 2}
+	for {
+		records, err := watched.List(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paged = append(paged, records...)
+		if len(records) < page.Limit {
+			break
+		}
+		page.After = &records[len(records)-1]
+	}
+	if len(paged) != len(everything) {
+		t.Fatalf("paged List: got %d records, want %d", len(paged), len(everything))
+	}
+	for i := range paged {
+		if paged[i] != everything[i] {
+			t.Fatalf("paged List record %d: got %+v, want %+v", i, paged[i], everything[i])
+		}
+	}
+
+	ranged, err := watched.List(WatchedFilter{UserID: u.ID, From: now.Add(-150 * time.Minute), To: now.Add(-time.Hour)})
+	if err != nil || len(ranged) != 2 || ranged[0].SeasonNumber != 2 || ranged[1].EpisodeNumber != 2 {
+		t.Fatalf("List from/to: got %+v, %v", ranged, err)
+	}
+
+	// A rewatched episode still counts once.
+	counts, err := watched.EpisodeCounts(u.ID, []int64{1396, 70523, 603})
+	if err != nil || counts[1396] != 3 || counts[70523] != 1 || counts[603] != 0 {
+		t.Fatalf("EpisodeCounts: got %v, %v", counts, err)
+	}
+	if counts, err := watched.EpisodeCounts(u.ID, []int64{70523}); err != nil || len(counts) != 1 || counts[70523] != 1 {
+		t.Fatalf("EpisodeCounts for one series: got %v, %v", counts, err)
+	}
+
+	if _, err := watched.DeleteEvent(u.ID+1000, rewatch.ID); !errors.Is(err, errNotFound) {
+		t.Fatalf("DeleteEvent for another user: got %v", err)
+	}
+	if ev, err := watched.DeleteEvent(u.ID, rewatch.ID); err != nil || ev.TmdbID != 1396 || ev.SeasonNumber != 1 || !ev.WatchedAt.Equal(rewatch.WatchedAt) {
+		t.Fatalf("DeleteEvent: got %+v, %v", ev, err)
+	}
+	if all, _ = watched.List(WatchedFilter{UserID: u.ID, MediaType: "tv", TmdbID: 1396, SeasonNumber: &season, EpisodeNumber: &number}); len(all) != 1 || all[0].PlayCount != 1 || !all[0].WatchedAt.Equal(first.WatchedAt) {
+		t.Fatalf("List after DeleteEvent: got %+v", all)
+	}
+
+	// A batch reports each item and marks already watched items only on rewatch.
+	batch := []WatchedChange{
+		{WatchEvent: episode(1396, 1, 1, now)},
+		{WatchEvent: episode(1396, 3, 1, now)},
+		{WatchEvent: episode(1396, 3, 1, now), Rewatch: true},
+		{WatchEvent: episode(1396, 1, 2, time.Time{}), Unmark: true},
+		{WatchEvent: episode(1396, 1, 2, time.Time{}), Unmark: true},
+	}
+	results, err := watched.ApplyChanges(batch)
+	if err != nil || len(results) != len(batch) {
+		t.Fatalf("ApplyChanges: got %+v, %v", results, err)
+	}
+	wantStatus := []string{watchUnchanged, watchCreated, watchRewatched, watchDeleted, watchNotWatched}
+	wantPlays := []int{1, 1, 2, 0, 0}
+	for i, result := range results {
+		if result.Status != wantStatus[i] || result.PlayCount != wantPlays[i] || (result.EventID != 0) != (i == 1 || i == 2) {
+			t.Fatalf("ApplyChanges result %d: got %+v", i, result)
+		}
+	}
+
+	// A failing change rolls the whole batch back.
+	failing := []WatchedChange{
+		{WatchEvent: episode(1396, 4, 1, now)},
+		{WatchEvent: WatchEvent{UserID: u.ID + 1000, MediaType: "movie", TmdbID: 603, WatchedAt: now}},
+	}
+	if _, err := watched.ApplyChanges(failing); err == nil {
+		t.Fatal("ApplyChanges with an unknown user succeeded")
+	}
+	season4 := int64(4)
+	if all, _ = watched.List(WatchedFilter{UserID: u.ID, MediaType: "tv", TmdbID: 1396, SeasonNumber: &season4}); len(all) != 0 {
+		t.Fatalf("failed batch left %+v", all)
+	}
+
+	if ok, err := watched.Delete(WatchedRecord{UserID: u.ID, MediaType: "tv", TmdbID: 1396, SeasonNumber: 2, EpisodeNumber: 1}); err != nil || !ok {
+		t.Fatalf("Delete: got %v, %v", ok, err)
+	}
+	if ok, err := watched.Delete(WatchedRecord{UserID: u.ID, MediaType: "tv", TmdbID: 1396, SeasonNumber: 2, EpisodeNumber: 1}); err != nil || ok {
+		t.Fatalf("Delete twice: got %v, %v", ok, err)
+	}
+
+	if err := watched.DeleteByUser(u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ = watched.List(WatchedFilter{UserID: u.ID, MediaType: "tv"}); len(all) != 0 {
+		t.Fatalf("records left: %+v", all)
+	}
+
+	// The foreign key rejects unknown users and follows account deletion.
+	stray := WatchEvent{UserID: u.ID + 1000, MediaType: "movie", TmdbID: 603, WatchedAt: now}
+	if err := watched.AddEvent(&stray); err == nil {
+		t.Fatal("AddEvent for an unknown user succeeded")
+	}
+	again := episode(1396, 1, 1, now)
+	if err := watched.AddEvent(&again); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Delete(u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ = watched.List(WatchedFilter{UserID: u.ID, MediaType: "tv"}); len(all) != 0 {
+		t.Fatalf("records left after deleting the user: %+v", all)
+	}
+}
+
+func testTitleStatusRepository(t *testing.T, users UserRepository, titles TitleStatusRepository) {
+	u := createTestUser(t, users, "titles@example.com")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	if _, err := titles.Get(u.ID, "tv", 1396); !errors.Is(err, errNotFound) {
+		t.Fatalf("Get before Set: got %v", err)
+	}
+
+	show := TitleStatus{UserID: u.ID, MediaType: "tv", TmdbID: 1396, Status: titleWatchlist}
+	if err := titles.Set(&show, now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if !show.CreatedAt.Equal(now.Add(-2*time.Hour)) || !show.StatusChangedAt.Equal(show.CreatedAt) {
+		t.Fatalf("Set filled in %+v", show)
+	}
+
+	// Only a different status is a change; setting the same one again just
+	// updates the inferred flag.
+	show.Status = titleWatching
+	show.Inferred = true
+	if err := titles.Set(&show, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	show.Inferred = false
+	if err := titles.Set(&show, now); err != nil {
+		t.Fatal(err)
+	}
+	got, err := titles.Get(u.ID, "tv", 1396)
+	if err != nil || got.Status != titleWatching || got.Inferred || !got.StatusChangedAt.Equal(now.Add(-time.Hour)) || !got.CreatedAt.Equal(now.Add(-2*time.Hour)) {
+		t.Fatalf("Get: got %+v, %v", got, err)
+	}
+
+	history, err := titles.History(u.ID, "tv", 1396)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("History: got %+v, %v", history, err)
+	}
+	if history[0].FromStatus != "" || history[0].ToStatus != titleWatchlist || history[1].FromStatus != titleWatchlist || history[1].ToStatus != titleWatching || !history[1].Inferred {
+		t.Fatalf("History: got %+v", history)
+	}
+
+	movie := TitleStatus{UserID: u.ID, MediaType: "movie", TmdbID: 603, Status: titleCompleted, Inferred: true}
+	if err := titles.Set(&movie, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	all, err := titles.List(TitleStatusFilter{UserID: u.ID})
+	if err != nil || len(all) != 2 || all[0].TmdbID != 603 || all[1].TmdbID != 1396 {
+		t.Fatalf("List: got %+v, %v", all, err)
+	}
+	if shows, _ := titles.List(TitleStatusFilter{UserID: u.ID, MediaType: "tv"}); len(shows) != 1 || shows[0].TmdbID != 1396 {
+		t.Fatalf("List tv: got %+v", shows)
+	}
+	if done, _ := titles.List(TitleStatusFilter{UserID: u.ID, Status: titleCompleted}); len(done) != 1 || done[0].TmdbID != 603 {
+		t.Fatalf("List completed: got %+v", done)
+	}
+
+	if ok, err := titles.Delete(u.ID, "tv", 1396); err != nil || !ok {
+		t.Fatalf("Delete: got %v, %v", ok, err)
+	}
+	if ok, err := titles.Delete(u.ID, "tv", 1396); err != nil || ok {
+		t.Fatalf("Delete twice: got %v, %v", ok, err)
+	}
+	if history, _ = titles.History(u.ID, "tv", 1396); len(history) != 0 {
+		t.Fatalf("history left after Delete: %+v", history)
+	}
+
+	if err := titles.DeleteByUser(u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ = titles.List(TitleStatusFilter{UserID: u.ID}); len(all) != 0 {
+		t.Fatalf("statuses left: %+v", all)
+	}
+}
+
+func testRatingRepository(t *testing.T, users UserRepository, ratings RatingRepository) {
+	alice := createTestUser(t, users, "ratings-alice@example.com")
+	bob := createTestUser(t, users, "ratings-bob@example.com")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	show := Rating{UserID: alice.ID, MediaType: "tv", TmdbID: 1396, Score: 9}
+	if err := ratings.Set(&show, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if !show.CreatedAt.Equal(now.Add(-time.Hour)) || !show.UpdatedAt.Equal(show.CreatedAt) {
+		t.Fatalf("Set filled in %+v", show)
+	}
+
+	// Rating the same item again replaces it and keeps CreatedAt.
+	show.Score = 10
+	show.Review = "Best ending"
+	show.Spoiler = true
+	if err := ratings.Set(&show, now); err != nil {
+		t.Fatal(err)
+	}
+	if !show.CreatedAt.Equal(now.Add(-time.Hour)) || !show.UpdatedAt.Equal(now) {
+		t.Fatalf("Set again filled in %+v", show)
+	}
+
+	season := int64(1)
+	episode := int64(2)
+	for _, rating := range []Rating{
+		{UserID: alice.ID, MediaType: "tv", TmdbID: 1396, SeasonNumber: 1, EpisodeNumber: 2, Score: 7},
+		{UserID: bob.ID, MediaType: "tv", TmdbID: 1396, Score: 5},
+		{UserID: bob.ID, MediaType: "tv", TmdbID: 1396, SeasonNumber: 1, EpisodeNumber: 2, Score: 4},
+		{UserID: bob.ID, MediaType: "movie", TmdbID: 603, Score: 8},
+	} {
+		if err := ratings.Set(&rating, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	own, err := ratings.List(RatingFilter{UserID: alice.ID})
+	if err != nil || len(own) != 2 {
+		t.Fatalf("List: got %+v, %v", own, err)
+	}
+	if own[0].EpisodeNumber != 2 || own[1].Score != 10 || own[1].Review != "Best ending" || !own[1].Spoiler {
+		t.Fatalf("List: got %+v", own)
+	}
+	if episodes, _ := ratings.List(RatingFilter{UserID: alice.ID, MediaType: "tv", TmdbID: 1396, SeasonNumber: &season, EpisodeNumber: &episode}); len(episodes) != 1 || episodes[0].Score != 7 {
+		t.Fatalf("List episode: got %+v", episodes)
+	}
+
+	summaries, err := ratings.Summaries(RatingFilter{MediaType: "tv", TmdbID: 1396})
+	if err != nil || len(summaries) != 2 {
+		t.Fatalf("Summaries: got %+v, %v", summaries, err)
+	}
+	if summaries[0].EpisodeNumber != 0 || summaries[0].Count != 2 || summaries[0].AverageScore != 7.5 {
+		t.Fatalf("Summaries show: got %+v", summaries[0])
+	}
+	if summaries[1].EpisodeNumber != 2 || summaries[1].Count != 2 || summaries[1].AverageScore != 5.5 {
+		t.Fatalf("Summaries episode: got %+v", summaries[1])
+	}
+
+	if err := ratings.Set(&Rating{UserID: alice.ID, MediaType: "movie", TmdbID: 603, Score: 11}, now); err == nil {
+		t.Fatal("Set with a score above 10 succeeded")
+	}
+
+	key := Rating{UserID: alice.ID, MediaType: "tv", TmdbID: 1396, SeasonNumber: 1, EpisodeNumber: 2}
+	if ok, err := ratings.Delete(key); err != nil || !ok {
+		t.Fatalf("Delete: got %v, %v", ok, err)
+	}
+	if ok, err := ratings.Delete(key); err != nil || ok {
+		t.Fatalf("Delete twice: got %v, %v", ok, err)
+	}
+
+	if err := ratings.DeleteByUser(bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := ratings.List(RatingFilter{UserID: bob.ID}); len(left) != 0 {
+		t.Fatalf("ratings left: %+v", left)
+	}
+	if err := users.Delete(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := ratings.Summaries(RatingFilter{}); len(left) != 0 {
+		t.Fatalf("ratings left after deleting the user: %+v", left)
+	}
+}
+
+func listItemOrder(t *testing.T, lists ListRepository, listID int64) []int64 {
+	t.Helper()
+	items, err := lists.Items(listID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int64, 0, len(items))
+	for i, item := range items {
+		if item.Position != i {
+			t.Fatalf("item %d at position %d, want %d", item.ID, item.Position, i)
+		}
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func testListRepository(t *testing.T, users UserRepository, lists ListRepository) {
+	alice := createTestUser(t, users, "lists-alice@example.com")
+	bob := createTestUser(t, users, "lists-bob@example.com")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	favorites := UserList{UserID: alice.ID, Name: "Favorites", Visibility: "private"}
+	if err := lists.Create(&favorites, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if favorites.ID == 0 || !favorites.CreatedAt.Equal(now.Add(-time.Hour)) {
+		t.Fatalf("Create filled in %+v", favorites)
+	}
+	shared := UserList{UserID: alice.ID, Name: "Shared", Visibility: "unlisted", ShareToken: "list-token"}
+	if err := lists.Create(&shared, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := lists.Create(&UserList{UserID: bob.ID, Name: "Bob", Visibility: "public"}, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := lists.Create(&UserList{UserID: bob.ID, Name: "Clash", Visibility: "unlisted", ShareToken: "list-token"}, now); err == nil {
+		t.Fatal("Create with a duplicate share token succeeded")
+	}
+
+	var ids []int64
+	for _, item := range []UserListItem{
+		{MediaType: "movie", TmdbID: 603},
+		{MediaType: "tv", TmdbID: 1396, Note: "Rewatch"},
+		{MediaType: "movie", TmdbID: 155},
+	} {
+		item.ListID = favorites.ID
+		if err := lists.AddItem(&item, -1, now); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, item.ID)
+	}
+	first := UserListItem{ListID: favorites.ID, MediaType: "movie", TmdbID: 27205}
+	if err := lists.AddItem(&first, 0, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := lists.AddItem(&UserListItem{ListID: favorites.ID, MediaType: "movie", TmdbID: 603}, -1, now); !errors.Is(err, errListItemExists) {
+		t.Fatalf("AddItem duplicate: got %v", err)
+	}
+	if got := listItemOrder(t, lists, favorites.ID); !slices.Equal(got, []int64{first.ID, ids[0], ids[1], ids[2]}) {
+		t.Fatalf("order after AddItem: %v", got)
+	}
+
+	if ok, err := lists.MoveItem(favorites.ID, first.ID, 2, now); err != nil || !ok {
+		t.Fatalf("MoveItem: got %v, %v", ok, err)
+	}
+	if got := listItemOrder(t, lists, favorites.ID); !slices.Equal(got, []int64{ids[0], ids[1], first.ID, ids[2]}) {
+		t.Fatalf("order after MoveItem: %v", got)
+	}
+	if ok, err := lists.MoveItem(shared.ID, first.ID, 0, now); err != nil || ok {
+		t.Fatalf("MoveItem on another list: got %v, %v", ok, err)
+	}
+
+	if ok, err := lists.SetItemNote(favorites.ID, ids[0], "Classic", now); err != nil || !ok {
+		t.Fatalf("SetItemNote: got %v, %v", ok, err)
+	}
+	if ok, err := lists.RemoveItem(favorites.ID, ids[1], now); err != nil || !ok {
+		t.Fatalf("RemoveItem: got %v, %v", ok, err)
+	}
+	if ok, err := lists.RemoveItem(favorites.ID, ids[1], now); err != nil || ok {
+		t.Fatalf("RemoveItem twice: got %v, %v", ok, err)
+	}
+	if got := listItemOrder(t, lists, favorites.ID); !slices.Equal(got, []int64{ids[0], first.ID, ids[2]}) {
+		t.Fatalf("order after RemoveItem: %v", got)
+	}
+
+	if err := lists.Reorder(favorites.ID, []int64{ids[2], ids[0]}, now); !errors.Is(err, errListOrderMismatch) {
+		t.Fatalf("Reorder with a missing item: got %v", err)
+	}
+	if err := lists.Reorder(favorites.ID, []int64{ids[2], ids[0], ids[0]}, now); !errors.Is(err, errListOrderMismatch) {
+		t.Fatalf("Reorder with a repeated item: got %v", err)
+	}
+	if err := lists.Reorder(favorites.ID, []int64{ids[2], first.ID, ids[0]}, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got := listItemOrder(t, lists, favorites.ID); !slices.Equal(got, []int64{ids[2], first.ID, ids[0]}) {
+		t.Fatalf("order after Reorder: %v", got)
+	}
+
+	got, err := lists.ByID(favorites.ID)
+	if err != nil || got.ItemCount != 3 || !got.UpdatedAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("ByID: got %+v, %v", got, err)
+	}
+	items, _ := lists.Items(favorites.ID)
+	if items[2].Note != "Classic" {
+		t.Fatalf("Items: got %+v", items)
+	}
+
+	owned, err := lists.ByUser(alice.ID, "")
+	if err != nil || len(owned) != 2 || owned[0].ID != favorites.ID {
+		t.Fatalf("ByUser: got %+v, %v", owned, err)
+	}
+	if public, _ := lists.ByUser(alice.ID, "public"); len(public) != 0 {
+		t.Fatalf("ByUser public: got %+v", public)
+	}
+
+	if byToken, err := lists.ByShareToken("list-token"); err != nil || byToken.ID != shared.ID {
+		t.Fatalf("ByShareToken: got %+v, %v", byToken, err)
+	}
+	shared.Name = "Renamed"
+	shared.Visibility = "private"
+	shared.ShareToken = ""
+	if ok, err := lists.Update(&shared, now); err != nil || !ok {
+		t.Fatalf("Update: got %v, %v", ok, err)
+	}
+	if _, err := lists.ByShareToken("list-token"); !errors.Is(err, errNotFound) {
+		t.Fatalf("ByShareToken after clearing it: got %v", err)
+	}
+
+	if ok, err := lists.Delete(bob.ID, favorites.ID); err != nil || ok {
+		t.Fatalf("Delete by another user: got %v, %v", ok, err)
+	}
+	if ok, err := lists.Delete(alice.ID, favorites.ID); err != nil || !ok {
+		t.Fatalf("Delete: got %v, %v", ok, err)
+	}
+	if items, _ := lists.Items(favorites.ID); len(items) != 0 {
+		t.Fatalf("items left after Delete: %+v", items)
+	}
+
+	if err := lists.DeleteByUser(bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := lists.ByUser(bob.ID, ""); len(left) != 0 {
+		t.Fatalf("lists left: %+v", left)
+	}
+	if err := users.Delete(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lists.ByID(shared.ID); !errors.Is(err, errNotFound) {
+		t.Fatalf("ByID after deleting the user: got %v", err)
+	}
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type Series struct {
+	ID       int     `json:"id"`
+	TmdbID   int64   `json:"tmdbId"`
+	Title    string  `json:"title"`
+	Overview string  `json:"overview"`
+	Poster   string  `json:"poster"`
+	Seasons  int     `json:"seasons"`
+	Status   string  `json:"status"`
+	Rating   float64 `json:"rating"`
+	// WatchedEpisodes counts the user's watched episodes of this show.
+	WatchedEpisodes int `json:"watchedEpisodes"`
+}
+
+type CreateSeriesInput struct {
+	TmdbID   int64   `json:"tmdbId"`
+	Title    string  `json:"title"`
+	Overview string  `json:"overview"`
+	Poster   string  `json:"poster"`
+	Seasons  int     `json:"seasons"`
+	Status   string  `json:"status"`
+	Rating   float64 `json:"rating"`
+}
+
+type UpdateSeriesInput struct {
+	Overview *string  `json:"overview"`
+	Poster   *string  `json:"poster"`
+	Seasons  *int     `json:"seasons"`
+	Status   *string  `json:"status"`
+	Rating   *float64 `json:"rating"`
+}
+
+// Series statuses, in the order a show usually moves through them.
+var seriesStatuses = []string{"planned", "watching", "paused", "dropped", "completed"}
+
+const (
+	maxSeriesTitle   = 200
+	maxSeriesRating  = 10
+	maxSeriesSeasons = 1000
+)
+
+// Store serves each user's series list from the series table.
+type Store struct {
+	db      sqliteDB
+	watched WatchedRepository
+}
+
+const seriesColumns = "id, coalesce(tmdb_id, 0), title, overview, poster, seasons, status, rating"
+
+// demoSeries is inserted by the seed command into a user's empty list.
+var demoSeries = []Series{
+	{
+		TmdbID:   1396,
+		Title:    "Breaking Bad",
+		Overview: "Professor de química vira produtor de metanfetamina.",
+		Poster:   "https://image.tmdb.org/t/p/w500/ztkUQFLlC19CCMYHW9o1zWhJRNq.jpg",
+		Seasons:  5,
+		Status:   "completed",
+		Rating:   9.5,
+	},
+	{
+		TmdbID:   95396,
+		Title:    "Severance",
+		Overview: "Funcionários separam memórias pessoais e de trabalho.",
+		Poster:   "https://image.tmdb.org/t/p/w500/lF4M1taK9Q4S3mM7Qv7v6V5T4Qf.jpg",
+		Seasons:  2,
+		Status:   "watching",
+		Rating:   9.0,
+	},
+	{
+		TmdbID:   70523,
+		Title:    "Dark",
+		Overview: "Mistérios temporais em uma cidade alemã.",
+		Poster:   "https://image.tmdb.org/t/p/w500/5Lo5fY2R8xk3Q4zNwJ2Y8Q6kU2q.jpg",
+		Seasons:  3,
+		Status:   "planned",
+		Rating:   8.8,
+	},
+}
+
+func NewStore(db *sql.DB, watched WatchedRepository) *Store {
+	return &Store{db: sqliteDB{db}, watched: watched}
+}
+
+func ensureSeriesTable(tx *sql.Tx) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS series (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        title TEXT NOT NULL,
+        overview TEXT NOT NULL DEFAULT '',
+        poster TEXT NOT NULL DEFAULT '',
+        seasons INTEGER NOT NULL DEFAULT 0,
+        status TEXT NOT NULL,
+        rating REAL NOT NULL DEFAULT 0,
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS idx_series_status ON series(status);
+    `
+
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed creating series table: %w", err)
+	}
+
+	return nil
+}
+
+// ensureSeriesOwnerColumns scopes series to a user and links them to TMDB.
+// Rows created while the catalog was global have no owner and are no longer
+// served.
+func ensureSeriesOwnerColumns(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "series", "user_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(tx, "series", "tmdb_id", "INTEGER"); err != nil {
+		return err
+	}
+
+	query := `
+    DROP INDEX IF EXISTS idx_series_status;
+    CREATE INDEX IF NOT EXISTS idx_series_user_status ON series(user_id, status);
+    CREATE UNIQUE INDEX IF NOT EXISTS idx_series_user_tmdb ON series(user_id, tmdb_id);
+    `
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed indexing series owners: %w", err)
+	}
+	return nil
+}
+
+// ensureSeriesOwnersSettled deals with the rows migration 14 left without an
+// owner, which no route can reach. With a single account they can only be
+// its; otherwise there is no telling whose they were, so they are dropped.
+func ensureSeriesOwnersSettled(tx *sql.Tx) error {
+	var orphans int
+	if err := tx.QueryRow("SELECT COUNT(1) FROM series WHERE user_id IS NULL").Scan(&orphans); err != nil {
+		return fmt.Errorf("failed counting ownerless series: %w", err)
+	}
+	if orphans == 0 {
+		return nil
+	}
+	// Only the SQLite users table can tell whether there is a single account.
+	if driver := databaseDriver(); driver != "sqlite" {
+		return fmt.Errorf("cannot settle %d ownerless series with DB_DRIVER=%s: users are not in SQLite", orphans, driver)
+	}
+
+	var users int
+	if err := tx.QueryRow("SELECT COUNT(1) FROM users").Scan(&users); err != nil {
+		return fmt.Errorf("failed counting users: %w", err)
+	}
+
+	if users == 1 {
+		result, err := tx.Exec("UPDATE series SET user_id = (SELECT id FROM users) WHERE user_id IS NULL")
+		if err != nil {
+			return fmt.Errorf("failed assigning ownerless series: %w", err)
+		}
+		if assigned, _ := result.RowsAffected(); assigned > 0 {
+			log.Printf("assigned %d ownerless series to the only user", assigned)
+		}
+		return nil
+	}
+
+	result, err := tx.Exec("DELETE FROM series WHERE user_id IS NULL")
+	if err != nil {
+		return fmt.Errorf("failed deleting ownerless series: %w", err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted > 0 {
+		log.Printf("deleted %d ownerless series", deleted)
+	}
+	return nil
+}
+
+// ensureSeriesTitleKey adds the normalized title used for deduplication and
+// backfills it for existing rows.
+func ensureSeriesTitleKey(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "series", "title_key", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT id, title FROM series WHERE title_key = ''")
+	if err != nil {
+		return fmt.Errorf("failed reading series titles: %w", err)
+	}
+	keys := make(map[int]string)
+	for rows.Next() {
+		var (
+			id    int
+			title string
+		)
+		if err := rows.Scan(&id, &title); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed reading series titles: %w", err)
+		}
+		keys[id] = seriesTitleKey(title)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed reading series titles: %w", err)
+	}
+
+	for id, key := range keys {
+		if _, err := tx.Exec("UPDATE series SET title_key = ? WHERE id = ?", key, id); err != nil {
+			return fmt.Errorf("failed backfilling series.title_key: %w", err)
+		}
+	}
+
+	// Not unique: lists may already hold duplicates from before validation.
+	if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_series_user_title ON series(user_id, title_key)"); err != nil {
+		return fmt.Errorf("failed indexing series titles: %w", err)
+	}
+	return nil
+}
+
+// seriesTitleKey folds case and whitespace so "Dark" and " dark " collide.
+func seriesTitleKey(title string) string {
+	return strings.ToLower(strings.Join(strings.Fields(title), " "))
+}
+
+func validSeriesStatus(status string) bool {
+	return slices.Contains(seriesStatuses, status)
+}
+
+func validateSeriesStatus(errs *ValidationErrors, status string) {
+	if !validSeriesStatus(status) {
+		errs.add("status", codeInvalidValue, "status must be one of "+strings.Join(seriesStatuses, ", "))
+	}
+}
+
+func validateSeriesRating(errs *ValidationErrors, rating float64) {
+	if rating < 0 || rating > maxSeriesRating {
+		errs.add("rating", codeOutOfRange, fmt.Sprintf("rating must be between 0 and %d", maxSeriesRating))
+	}
+}
+
+func validateSeriesSeasons(errs *ValidationErrors, seasons int) {
+	if seasons < 0 || seasons > maxSeriesSeasons {
+		errs.add("seasons", codeOutOfRange, fmt.Sprintf("seasons must be between 0 and %d", maxSeriesSeasons))
+	}
+}
+
+// seedSeries inserts demoSeries when the user's list is empty and reports how
+// many rows were added.
+func seedSeries(db sqliteDB, userID int64) (int, error) {
+	var added int
+	err := db.inTx(func(tx *sql.Tx) error {
+		added = 0
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(1) FROM series WHERE user_id = ?", userID).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+
+		for _, item := range demoSeries {
+			if _, err := tx.Exec(
+				`INSERT INTO series (user_id, tmdb_id, title, title_key, overview, poster, seasons, status, rating)
+                 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				userID,
+				item.TmdbID,
+				item.Title,
+				seriesTitleKey(item.Title),
+				item.Overview,
+				item.Poster,
+				item.Seasons,
+				item.Status,
+				item.Rating,
+			); err != nil {
+				return err
+			}
+		}
+		added = len(demoSeries)
+		return nil
+	})
+	return added, err
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSeries(row rowScanner) (Series, error) {
+	var item Series
+	err := row.Scan(
+		&item.ID,
+		&item.TmdbID,
+		&item.Title,
+		&item.Overview,
+		&item.Poster,
+		&item.Seasons,
+		&item.Status,
+		&item.Rating,
+	)
+	return item, err
+}
+
+func (s *Store) getSeries(userID int64, id int) (Series, error) {
+	item, err := scanSeries(s.db.QueryRow(
+		"SELECT "+seriesColumns+" FROM series WHERE id = ? AND user_id = ?",
+		id,
+		userID,
+	))
+	if err != nil {
+		return item, err
+	}
+
+	if item.TmdbID > 0 {
+		// watch_events may live in another database, so it is counted separately.
+		counts, err := s.watched.EpisodeCounts(userID, []int64{item.TmdbID})
+		if err != nil {
+			return item, err
+		}
+		item.WatchedEpisodes = counts[item.TmdbID]
+	}
+	return item, nil
+}
+
+func (s *Store) handleListSeries(w http.ResponseWriter, r *http.Request) {
+	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	q := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+
+	userID := authUserID(r)
+	query := "SELECT " + seriesColumns + " FROM series WHERE user_id = ?"
+	args := []any{userID}
+	if status != "" {
+		if !validSeriesStatus(status) {
+			var errs ValidationErrors
+			validateSeriesStatus(&errs, status)
+			writeValidationErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list series")
+		return
+	}
+	defer rows.Close()
+
+	out := make([]Series, 0)
+	var tmdbIDs []int64
+	for rows.Next() {
+		item, scanErr := scanSeries(rows)
+		if scanErr != nil {
+			writeError(w, http.StatusInternalServerError, "failed reading series")
+			return
+		}
+
+		// Matched in Go rather than with LIKE, which only folds ASCII case.
+		if q != "" {
+			if !strings.Contains(strings.ToLower(item.Title), q) && !strings.Contains(strings.ToLower(item.Overview), q) {
+				continue
+			}
+		}
+
+		out = append(out, item)
+		if item.TmdbID > 0 {
+			tmdbIDs = append(tmdbIDs, item.TmdbID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed reading series")
+		return
+	}
+
+	// Counted only for the series returned, since watch_events may hold
+	// thousands of other titles.
+	counts, err := s.watched.EpisodeCounts(userID, tmdbIDs)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list series")
+		return
+	}
+	for i := range out {
+		out[i].WatchedEpisodes = counts[out[i].TmdbID]
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Store) handleCreateSeries(w http.ResponseWriter, r *http.Request) {
+	var in CreateSeriesInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	in.Title = strings.TrimSpace(in.Title)
+	in.Status = strings.TrimSpace(in.Status)
+
+	if in.Status == "" {
+		in.Status = "planned"
+	}
+
+	var errs ValidationErrors
+	// tmdbId stays optional so that clients written before it existed keep
+	// working; series without one just have no watch progress.
+	if in.TmdbID < 0 {
+		errs.add("tmdbId", codeOutOfRange, "tmdbId must be positive")
+	}
+	switch {
+	case in.Title == "":
+		errs.add("title", codeRequired, "title is required")
+	case len([]rune(in.Title)) > maxSeriesTitle:
+		errs.add("title", codeTooLong, fmt.Sprintf("title must have at most %d characters", maxSeriesTitle))
+	}
+	validateSeriesStatus(&errs, in.Status)
+	validateSeriesRating(&errs, in.Rating)
+	validateSeriesSeasons(&errs, in.Seasons)
+	if len(errs) > 0 {
+		writeValidationErrors(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	userID := authUserID(r)
+	titleKey := seriesTitleKey(in.Title)
+	// NULL never matches the uniqueness check or index, so any number of
+	// series can go without a tmdbId.
+	tmdbID := sql.NullInt64{Int64: in.TmdbID, Valid: in.TmdbID > 0}
+
+	var (
+		id        int
+		conflicts ValidationErrors
+	)
+	err := s.db.inTx(func(tx *sql.Tx) error {
+		var tmdbTaken, titleTaken bool
+		if err := tx.QueryRow(
+			`SELECT
+                 EXISTS(SELECT 1 FROM series WHERE user_id = ? AND tmdb_id = ?),
+                 EXISTS(SELECT 1 FROM series WHERE user_id = ? AND title_key = ?)`,
+			userID,
+			tmdbID,
+			userID,
+			titleKey,
+		).Scan(&tmdbTaken, &titleTaken); err != nil {
+			return err
+		}
+		conflicts = nil
+		if tmdbTaken {
+			conflicts.add("tmdbId", codeDuplicate, "series already in list")
+		}
+		if titleTaken {
+			conflicts.add("title", codeDuplicate, "a series with this title is already in the list")
+		}
+		if len(conflicts) > 0 {
+			return nil
+		}
+
+		return tx.QueryRow(
+			`INSERT INTO series (user_id, tmdb_id, title, title_key, overview, poster, seasons, status, rating)
+             VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+             RETURNING id`,
+			userID,
+			tmdbID,
+			in.Title,
+			titleKey,
+			in.Overview,
+			in.Poster,
+			in.Seasons,
+			in.Status,
+			in.Rating,
+		).Scan(&id)
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create series")
+		return
+	}
+	if len(conflicts) > 0 {
+		writeValidationErrors(w, http.StatusConflict, conflicts)
+		return
+	}
+
+	item, err := s.getSeries(userID, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create series")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, item)
+}
+
+func (s *Store) handlePatchSeries(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDFromPath(r.URL.Path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var in UpdateSeriesInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	// Absent fields keep their stored value; present ones must be valid.
+	var (
+		errs   ValidationErrors
+		status *string
+	)
+	if in.Status != nil {
+		trimmed := strings.TrimSpace(*in.Status)
+		status = &trimmed
+		validateSeriesStatus(&errs, trimmed)
+	}
+	if in.Rating != nil {
+		validateSeriesRating(&errs, *in.Rating)
+	}
+	if in.Seasons != nil {
+		validateSeriesSeasons(&errs, *in.Seasons)
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	userID := authUserID(r)
+
+	result, err := s.db.Exec(
+		`UPDATE series SET
+             overview = coalesce(?, overview),
+             poster = coalesce(?, poster),
+             seasons = coalesce(?, seasons),
+             status = coalesce(?, status),
+             rating = coalesce(?, rating)
+         WHERE id = ? AND user_id = ?`,
+		in.Overview,
+		in.Poster,
+		in.Seasons,
+		status,
+		in.Rating,
+		id,
+		userID,
+	)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update series")
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, http.StatusNotFound, "series not found")
+		return
+	}
+
+	item, err := s.getSeries(userID, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update series")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, item)
+}
+
+func (s *Store) handleDeleteSeries(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDFromPath(r.URL.Path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := s.db.Exec("DELETE FROM series WHERE id = ? AND user_id = ?", id, authUserID(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete series")
+		return
+	}
+
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		writeError(w, http.StatusNotFound, "series not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseIDFromPath(path string) (int, error) {
+	trimmed := strings.Trim(path, "/")
+	parts := strings.Split(trimmed, "/")
+	if len(parts) != 3 || parts[0] != "api" || parts[1] != "series" {
+		return 0, fmt.Errorf("invalid path")
+	}
+
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return 0, fmt.Errorf("invalid series id")
+	}
+
+	return id, nil
+}
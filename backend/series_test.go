@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeriesOwnersSettled(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		driver  string
+		users   []string
+		want    int
+		orphans int
+	}{
+		{"sole user keeps them", "sqlite", []string{"only@example.com"}, 2, 0},
+		{"several users drop them", "sqlite", []string{"a@example.com", "b@example.com"}, 0, 0},
+		{"no users drop them", "sqlite", nil, 0, 0},
+		{"users elsewhere leave them", "postgres", nil, 0, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, _ := newTestApp(t)
+			t.Setenv("DB_DRIVER", tc.driver)
+			var owner int64
+			for _, email := range tc.users {
+				owner = createPasswordUser(t, a, email, "password123").ID
+			}
+			for _, title := range []string{"Dark", "Lost"} {
+				if _, err := a.db.Exec("INSERT INTO series (title, status) VALUES (?, 'planned')", title); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := a.db.inTx(ensureSeriesOwnersSettled); (err != nil) != (tc.orphans > 0) {
+				t.Fatalf("ensureSeriesOwnersSettled: %v", err)
+			}
+
+			var orphans, owned int
+			if err := a.db.QueryRow("SELECT COUNT(1) FROM series WHERE user_id IS NULL").Scan(&orphans); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.db.QueryRow("SELECT COUNT(1) FROM series WHERE user_id = ?", owner).Scan(&owned); err != nil {
+				t.Fatal(err)
+			}
+			if orphans != tc.orphans || owned != tc.want {
+				t.Fatalf("got %d ownerless and %d owned series, want %d and %d", orphans, owned, tc.orphans, tc.want)
+			}
+		})
+	}
+}
+
+func TestCreateSeriesWithoutTmdbID(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "series@example.com", "password123")
+	session := loginSession(t, a, user.ID)
+	create := a.requireAuth(scopeSeriesWrite, a.store.handleCreateSeries)
+
+	for _, tc := range []struct {
+		name   string
+		in     CreateSeriesInput
+		status int
+	}{
+		{"without tmdbId", CreateSeriesInput{Title: "Dark"}, http.StatusCreated},
+		{"another without tmdbId", CreateSeriesInput{Title: "Lost"}, http.StatusCreated},
+		{"same title", CreateSeriesInput{Title: " dark "}, http.StatusConflict},
+		{"with tmdbId", CreateSeriesInput{Title: "Breaking Bad", TmdbID: 1396}, http.StatusCreated},
+		{"same tmdbId", CreateSeriesInput{Title: "Breaking Bad (2008)", TmdbID: 1396}, http.StatusConflict},
+		{"negative tmdbId", CreateSeriesInput{Title: "Ozark", TmdbID: -1}, http.StatusBadRequest},
+	} {
+		rec := serve(create, jsonRequest(t, http.MethodPost, "/api/series", session, tc.in))
+		if rec.Code != tc.status {
+			t.Fatalf("%s: status %d %s", tc.name, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestListSeriesCountsWatchedEpisodes(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "series@example.com", "password123")
+	session := loginSession(t, a, user.ID)
+	create := a.requireAuth(scopeSeriesWrite, a.store.handleCreateSeries)
+	for _, in := range []CreateSeriesInput{
+		{Title: "Breaking Bad", TmdbID: 1396, Status: "watching"},
+		{Title: "Dark", TmdbID: 70523, Status: "planned"},
+		{Title: "Lost", Status: "dropped"},
+	} {
+		if rec := serve(create, jsonRequest(t, http.MethodPost, "/api/series", session, in)); rec.Code != http.StatusCreated {
+			t.Fatalf("create %s: status %d %s", in.Title, rec.Code, rec.Body)
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, ev := range []WatchEvent{
+		{TmdbID: 1396, SeasonNumber: 1, EpisodeNumber: 1},
+		{TmdbID: 1396, SeasonNumber: 1, EpisodeNumber: 1},
+		{TmdbID: 1396, SeasonNumber: 1, EpisodeNumber: 2},
+		{TmdbID: 70523, SeasonNumber: 1, EpisodeNumber: 1},
+		{TmdbID: 1399, SeasonNumber: 1, EpisodeNumber: 1},
+	} {
+		ev.UserID = user.ID
+		ev.MediaType = "tv"
+		ev.WatchedAt = now
+		if err := a.repos.Watched.AddEvent(&ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := a.requireAuth(scopeSeriesRead, a.store.handleListSeries)
+	for _, tc := range []struct {
+		target string
+		want   map[string]int
+	}{
+		{"/api/series", map[string]int{"Breaking Bad": 2, "Dark": 1, "Lost": 0}},
+		{"/api/series?status=planned", map[string]int{"Dark": 1}},
+		{"/api/series?q=lost", map[string]int{"Lost": 0}},
+	} {
+		rec := serve(list, jsonRequest(t, http.MethodGet, tc.target, session, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d %s", tc.target, rec.Code, rec.Body)
+		}
+		var out []Series
+		decodeBody(t, rec, &out)
+		got := make(map[string]int)
+		for _, item := range out {
+			got[item.Title] = item.WatchedEpisodes
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: got %v, want %v", tc.target, got, tc.want)
+		}
+		for title, n := range tc.want {
+			if got[title] != n {
+				t.Fatalf("%s: got %v, want %v", tc.target, got, tc.want)
+			}
+		}
+	}
+}
+
+// seriesTitles lists the user's series through the handler, in id order.
+func seriesTitles(t *testing.T, store *Store, a *App, session string) []string {
+	t.Helper()
+	rec := serve(a.requireAuth(scopeSeriesRead, store.handleListSeries), jsonRequest(t, http.MethodGet, "/api/series", session, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: status %d %s", rec.Code, rec.Body)
+	}
+	var out []Series
+	decodeBody(t, rec, &out)
+	titles := make([]string, 0, len(out))
+	for _, item := range out {
+		titles = append(titles, item.Title)
+	}
+	return titles
+}
+
+func TestSeriesPersist(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "catalog@example.com", "password123")
+	other := createPasswordUser(t, a, "other@example.com", "password123")
+	session := loginSession(t, a, user.ID)
+	otherSession := loginSession(t, a, other.ID)
+
+	if got := seriesTitles(t, a.store, a, session); len(got) != 0 {
+		t.Fatalf("a new database starts with series: %v", got)
+	}
+
+	create := a.requireAuth(scopeSeriesWrite, a.store.handleCreateSeries)
+	var ids []int
+	for _, title := range []string{"Dark", "Lost", "Ozark"} {
+		rec := serve(create, jsonRequest(t, http.MethodPost, "/api/series", session, CreateSeriesInput{Title: title}))
+		var item Series
+		decodeBody(t, rec, &item)
+		if rec.Code != http.StatusCreated || item.Title != title || item.Status != "planned" {
+			t.Fatalf("create %s: status %d, %+v", title, rec.Code, item)
+		}
+		ids = append(ids, item.ID)
+	}
+	patch := func(session string, id int, in UpdateSeriesInput) int {
+		t.Helper()
+		return serve(a.requireAuth(scopeSeriesWrite, a.store.handlePatchSeries), jsonRequest(t, http.MethodPatch, fmt.Sprintf("/api/series/%d", id), session, in)).Code
+	}
+	remove := func(session string, target string) int {
+		t.Helper()
+		return serve(a.requireAuth(scopeSeriesWrite, a.store.handleDeleteSeries), jsonRequest(t, http.MethodDelete, target, session, nil)).Code
+	}
+
+	status, seasons := "watching", 2
+	if code := patch(session, ids[0], UpdateSeriesInput{Status: &status, Seasons: &seasons}); code != http.StatusOK {
+		t.Fatalf("patch: status %d", code)
+	}
+	if code := remove(session, fmt.Sprintf("/api/series/%d", ids[1])); code != http.StatusNoContent {
+		t.Fatalf("delete: status %d", code)
+	}
+
+	// Other users see neither the series nor a difference from a missing id.
+	if got := seriesTitles(t, a.store, a, otherSession); len(got) != 0 {
+		t.Fatalf("another user's list: %v", got)
+	}
+	if code := patch(otherSession, ids[0], UpdateSeriesInput{Status: &status}); code != http.StatusNotFound {
+		t.Fatalf("patch another user's series: status %d", code)
+	}
+	if code := remove(otherSession, fmt.Sprintf("/api/series/%d", ids[2])); code != http.StatusNotFound {
+		t.Fatalf("delete another user's series: status %d", code)
+	}
+	if code := patch(session, ids[1], UpdateSeriesInput{Status: &status}); code != http.StatusNotFound {
+		t.Fatalf("patch a deleted series: status %d", code)
+	}
+	if code := remove(session, fmt.Sprintf("/api/series/%d", ids[1])); code != http.StatusNotFound {
+		t.Fatalf("delete twice: status %d", code)
+	}
+	for _, target := range []string{"/api/series/dark", "/api/series/1/2", "/api/other/1"} {
+		if code := remove(session, target); code != http.StatusBadRequest {
+			t.Fatalf("delete %s: status %d", target, code)
+		}
+	}
+
+	// A new Store on the same database, as after a restart, sees every change.
+	restarted := NewStore(a.db.pool, a.repos.Watched)
+	if got := seriesTitles(t, restarted, a, session); !slices.Equal(got, []string{"Dark", "Ozark"}) {
+		t.Fatalf("after a restart: %v", got)
+	}
+	item, err := restarted.getSeries(user.ID, ids[0])
+	if err != nil || item.Status != "watching" || item.Seasons != 2 {
+		t.Fatalf("patched series after a restart: %+v, %v", item, err)
+	}
+}
+
+func TestSeedSeries(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "seed@example.com", "password123")
+	filled := createPasswordUser(t, a, "filled@example.com", "password123")
+	session := loginSession(t, a, user.ID)
+	filledSession := loginSession(t, a, filled.ID)
+
+	want := make([]string, 0, len(demoSeries))
+	for _, item := range demoSeries {
+		want = append(want, item.Title)
+	}
+	if added, err := seedSeries(a.db, user.ID); err != nil || added != len(demoSeries) {
+		t.Fatalf("seed an empty list: got %d, %v", added, err)
+	}
+	if got := seriesTitles(t, a.store, a, session); !slices.Equal(got, want) {
+		t.Fatalf("seeded list: got %v, want %v", got, want)
+	}
+	if added, err := seedSeries(a.db, user.ID); err != nil || added != 0 {
+		t.Fatalf("seed twice: got %d, %v", added, err)
+	}
+	if got := seriesTitles(t, a.store, a, session); len(got) != len(demoSeries) {
+		t.Fatalf("list after seeding twice: %v", got)
+	}
+
+	// A list with anything in it is left alone.
+	create := a.requireAuth(scopeSeriesWrite, a.store.handleCreateSeries)
+	if rec := serve(create, jsonRequest(t, http.MethodPost, "/api/series", filledSession, CreateSeriesInput{Title: "Dark"})); rec.Code != http.StatusCreated {
+		t.Fatalf("create: status %d %s", rec.Code, rec.Body)
+	}
+	if added, err := seedSeries(a.db, filled.ID); err != nil || added != 0 {
+		t.Fatalf("seed a non-empty list: got %d, %v", added, err)
+	}
+	if got := seriesTitles(t, a.store, a, filledSession); !slices.Equal(got, []string{"Dark"}) {
+		t.Fatalf("non-empty list after seeding: %v", got)
+	}
+}
+
+func TestSeriesValidation(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "valid@example.com", "password123")
+	session := loginSession(t, a, user.ID)
+	create := a.requireAuth(scopeSeriesWrite, a.store.handleCreateSeries)
+	patch := a.requireAuth(scopeSeriesWrite, a.store.handlePatchSeries)
+
+	for _, tc := range []struct {
+		name   string
+		in     CreateSeriesInput
+		status int
+		want   []string
+	}{
+		{"paused", CreateSeriesInput{Title: "Paused", Status: "paused"}, http.StatusCreated, nil},
+		{"dropped", CreateSeriesInput{Title: "Dropped", Status: " dropped "}, http.StatusCreated, nil},
+		{"rating bounds", CreateSeriesInput{Title: "Bounds", Rating: 10, Seasons: maxSeriesSeasons}, http.StatusCreated, nil},
+		{"unknown status", CreateSeriesInput{Title: "A", Status: "binged"}, http.StatusBadRequest, []string{"status:" + codeInvalidValue}},
+		{"status case", CreateSeriesInput{Title: "A", Status: "Watching"}, http.StatusBadRequest, []string{"status:" + codeInvalidValue}},
+		{"no title", CreateSeriesInput{Title: "  "}, http.StatusBadRequest, []string{"title:" + codeRequired}},
+		{"long title", CreateSeriesInput{Title: strings.Repeat("é", maxSeriesTitle+1)}, http.StatusBadRequest, []string{"title:" + codeTooLong}},
+		{"negative rating", CreateSeriesInput{Title: "A", Rating: -0.1}, http.StatusBadRequest, []string{"rating:" + codeOutOfRange}},
+		{"rating above 10", CreateSeriesInput{Title: "A", Rating: 10.5}, http.StatusBadRequest, []string{"rating:" + codeOutOfRange}},
+		{"negative seasons", CreateSeriesInput{Title: "A", Seasons: -1}, http.StatusBadRequest, []string{"seasons:" + codeOutOfRange}},
+		{"too many seasons", CreateSeriesInput{Title: "A", Seasons: maxSeriesSeasons + 1}, http.StatusBadRequest, []string{"seasons:" + codeOutOfRange}},
+		{"every field at once", CreateSeriesInput{Status: "x", Rating: 11, Seasons: -1}, http.StatusBadRequest, []string{"title:" + codeRequired, "status:" + codeInvalidValue, "rating:" + codeOutOfRange, "seasons:" + codeOutOfRange}},
+		{"duplicate title", CreateSeriesInput{Title: "PAUSED"}, http.StatusConflict, []string{"title:" + codeDuplicate}},
+	} {
+		rec := serve(create, jsonRequest(t, http.MethodPost, "/api/series", session, tc.in))
+		if rec.Code != tc.status {
+			t.Fatalf("%s: status %d %s", tc.name, rec.Code, rec.Body)
+		}
+		if tc.want == nil {
+			continue
+		}
+		var body validationErrorResponse
+		decodeBody(t, rec, &body)
+		got := make([]string, 0, len(body.Fields))
+		for _, f := range body.Fields {
+			got = append(got, f.Field+":"+f.Code)
+		}
+		slices.Sort(got)
+		slices.Sort(tc.want)
+		if !slices.Equal(got, tc.want) {
+			t.Fatalf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	rec := serve(create, jsonRequest(t, http.MethodPost, "/api/series", session, CreateSeriesInput{Title: "Patched", Status: "watching", Rating: 8, Seasons: 3}))
+	var item Series
+	decodeBody(t, rec, &item)
+	target := fmt.Sprintf("/api/series/%d", item.ID)
+	str := func(s string) *string { return &s }
+	num := func(n float64) *float64 { return &n }
+	count := func(n int) *int { return &n }
+	for _, tc := range []struct {
+		name   string
+		in     UpdateSeriesInput
+		status int
+		want   Series
+	}{
+		{"empty status", UpdateSeriesInput{Status: str("")}, http.StatusBadRequest, item},
+		{"unknown status", UpdateSeriesInput{Status: str("binged")}, http.StatusBadRequest, item},
+		{"rating out of range", UpdateSeriesInput{Rating: num(-1)}, http.StatusBadRequest, item},
+		{"negative seasons", UpdateSeriesInput{Seasons: count(-2)}, http.StatusBadRequest, item},
+		{"one bad field rejects all", UpdateSeriesInput{Status: str("paused"), Rating: num(11)}, http.StatusBadRequest, item},
+		{"absent fields are kept", UpdateSeriesInput{Overview: str("Nova sinopse")}, http.StatusOK, Series{Status: "watching", Rating: 8, Seasons: 3}},
+		{"zero values are set", UpdateSeriesInput{Status: str(" paused "), Rating: num(0), Seasons: count(0)}, http.StatusOK, Series{Status: "paused"}},
+	} {
+		rec := serve(patch, jsonRequest(t, http.MethodPatch, target, session, tc.in))
+		if rec.Code != tc.status {
+			t.Fatalf("%s: status %d %s", tc.name, rec.Code, rec.Body)
+		}
+		got, err := a.store.getSeries(user.ID, item.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != tc.want.Status || got.Rating != tc.want.Rating || got.Seasons != tc.want.Seasons {
+			t.Fatalf("%s: got %+v, want status %s, rating %v, seasons %d", tc.name, got, tc.want.Status, tc.want.Rating, tc.want.Seasons)
+		}
+	}
+
+	list := a.requireAuth(scopeSeriesRead, a.store.handleListSeries)
+	if rec := serve(list, jsonRequest(t, http.MethodGet, "/api/series?status=binged", session, nil)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("list with an unknown status: status %d", rec.Code)
+	}
+}
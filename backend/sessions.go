@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SessionInfo struct {
+	ID         int64  `json:"id"`
+	CreatedAt  string `json:"createdAt"`
+	LastSeenAt string `json:"lastSeenAt"`
+	ExpiresAt  string `json:"expiresAt"`
+	UserAgent  string `json:"userAgent"`
+	IP         string `json:"ip"`
+	Current    bool   `json:"current"`
+}
+
+func (a *App) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	auth := authFromRequest(r)
+
+	sessions, err := a.repos.Sessions.ListByUser(auth.UserID, time.Now())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list sessions")
+		return
+	}
+
+	out := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		out = append(out, SessionInfo{
+			ID:         session.ID,
+			CreatedAt:  session.CreatedAt.UTC().Format(time.RFC3339),
+			LastSeenAt: session.LastSeenAt.UTC().Format(time.RFC3339),
+			ExpiresAt:  session.ExpiresAt.UTC().Format(time.RFC3339),
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			Current:    session.ID == auth.SessionID,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (a *App) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := strconv.ParseInt(strings.TrimSpace(r.PathValue("id")), 10, 64)
+	if err != nil || sessionID <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid session id")
+		return
+	}
+
+	deleted, err := a.repos.Sessions.Delete(authUserID(r), sessionID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to revoke session")
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
+	auth := authFromRequest(r)
+	if _, err := a.repos.Sessions.Delete(auth.UserID, auth.SessionID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to log out")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	revoked, err := a.repos.Sessions.DeleteByUser(authUserID(r), 0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to log out sessions")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int64{"revoked": revoked})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestSessions(t *testing.T) {
+	a, _ := newTestApp(t)
+	a.trustProxy = true
+	user := createPasswordUser(t, a, "devices@example.com", "password123")
+	other := createPasswordUser(t, a, "other@example.com", "password123")
+	list := a.requireAuth(scopeSessionOnly, a.handleListSessions)
+
+	login := func(email string, userAgent string, ip string) string {
+		t.Helper()
+		req := jsonRequest(t, http.MethodPost, "/api/auth/login", "", LoginInput{Email: email, Password: "password123"})
+		req.Header.Set("User-Agent", userAgent)
+		req.Header.Set("X-Forwarded-For", ip+", 10.0.0.1")
+		rec := serve(a.handleLogin, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login: status %d %s", rec.Code, rec.Body)
+		}
+		var out LoginResponse
+		decodeBody(t, rec, &out)
+		return out.Token
+	}
+	sessions := func(token string) ([]SessionInfo, int) {
+		t.Helper()
+		rec := serve(list, jsonRequest(t, http.MethodGet, "/api/auth/sessions", token, nil))
+		var out []SessionInfo
+		if rec.Code == http.StatusOK {
+			decodeBody(t, rec, &out)
+		}
+		return out, rec.Code
+	}
+	revoke := func(token string, id string) int {
+		t.Helper()
+		req := jsonRequest(t, http.MethodDelete, "/api/auth/sessions/"+id, token, nil)
+		req.SetPathValue("id", id)
+		return serve(a.requireAuth(scopeSessionOnly, a.handleDeleteSession), req).Code
+	}
+
+	phone := login(user.Email, "Phone/1.0", "203.0.113.7")
+	laptop := login(user.Email, "Laptop/2.0", "198.51.100.4")
+	tablet := login(user.Email, "Tablet/3.0", "192.0.2.9")
+	otherSession := login(other.Email, "Other/1.0", "192.0.2.10")
+
+	got, status := sessions(laptop)
+	if status != http.StatusOK || len(got) != 3 {
+		t.Fatalf("list: status %d, %+v", status, got)
+	}
+	devices := make(map[string]SessionInfo)
+	for _, s := range got {
+		devices[s.UserAgent] = s
+		if s.CreatedAt == "" || s.LastSeenAt == "" || s.ExpiresAt == "" {
+			t.Fatalf("session without times: %+v", s)
+		}
+	}
+	if s := devices["Laptop/2.0"]; !s.Current || s.IP != "198.51.100.4" {
+		t.Fatalf("laptop session: %+v", s)
+	}
+	if s := devices["Phone/1.0"]; s.Current || s.IP != "203.0.113.7" {
+		t.Fatalf("phone session: %+v", s)
+	}
+	phoneID := fmt.Sprint(devices["Phone/1.0"].ID)
+
+	if status := revoke(laptop, "abc"); status != http.StatusBadRequest {
+		t.Fatalf("revoke with a bad id: status %d", status)
+	}
+	// Another user's session looks the same as one that does not exist.
+	if status := revoke(otherSession, phoneID); status != http.StatusNotFound {
+		t.Fatalf("revoke another user's session: status %d", status)
+	}
+	if _, status := sessions(phone); status != http.StatusOK {
+		t.Fatalf("phone after a foreign revoke: status %d", status)
+	}
+
+	if status := revoke(laptop, phoneID); status != http.StatusNoContent {
+		t.Fatalf("revoke the phone: status %d", status)
+	}
+	if _, status := sessions(phone); status != http.StatusUnauthorized {
+		t.Fatalf("revoked phone session: status %d", status)
+	}
+	if status := revoke(laptop, phoneID); status != http.StatusNotFound {
+		t.Fatalf("revoke twice: status %d", status)
+	}
+
+	if rec := serve(a.requireAuth(scopeSessionOnly, a.handleLogout), jsonRequest(t, http.MethodPost, "/api/auth/logout", tablet, nil)); rec.Code != http.StatusNoContent {
+		t.Fatalf("logout: status %d", rec.Code)
+	}
+	if _, status := sessions(tablet); status != http.StatusUnauthorized {
+		t.Fatalf("logged out tablet: status %d", status)
+	}
+	if got, _ := sessions(laptop); len(got) != 1 || !got[0].Current {
+		t.Fatalf("sessions after logout: %+v", got)
+	}
+
+	desktop := login(user.Email, "Desktop/1.0", "192.0.2.11")
+	rec := serve(a.requireAuth(scopeSessionOnly, a.handleLogoutAll), jsonRequest(t, http.MethodPost, "/api/auth/logout-all", laptop, nil))
+	var out map[string]int64
+	decodeBody(t, rec, &out)
+	if rec.Code != http.StatusOK || out["revoked"] != 2 {
+		t.Fatalf("logout-all: status %d, %v", rec.Code, out)
+	}
+	for name, token := range map[string]string{"laptop": laptop, "desktop": desktop} {
+		if _, status := sessions(token); status != http.StatusUnauthorized {
+			t.Fatalf("%s after logout-all: status %d", name, status)
+		}
+	}
+	if _, status := sessions(otherSession); status != http.StatusOK {
+		t.Fatalf("another user's session after logout-all: status %d", status)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	busyRetryAttempts  = 5
+	busyRetryBaseDelay = 25 * time.Millisecond
+)
+
+var (
+	sqliteJournalModes = []string{"WAL", "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "OFF"}
+	sqliteSyncLevels   = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+)
+
+// sqliteDSN adds the connection pragmas to a database path. They go in the
+// DSN because PRAGMA statements only affect the connection that runs them.
+func sqliteDSN(path string) (string, error) {
+	journalMode := strings.ToUpper(envOrDefault("SQLITE_JOURNAL_MODE", "WAL"))
+	if !slices.Contains(sqliteJournalModes, journalMode) {
+		return "", fmt.Errorf("invalid SQLITE_JOURNAL_MODE %q", journalMode)
+	}
+	synchronous := strings.ToUpper(envOrDefault("SQLITE_SYNCHRONOUS", "NORMAL"))
+	if !slices.Contains(sqliteSyncLevels, synchronous) {
+		return "", fmt.Errorf("invalid SQLITE_SYNCHRONOUS %q", synchronous)
+	}
+	busyTimeout := envDurationOrDefault("SQLITE_BUSY_TIMEOUT", 5*time.Second)
+
+	pragmas := url.Values{}
+	pragmas.Add("_pragma", "foreign_keys(1)")
+	pragmas.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", busyTimeout.Milliseconds()))
+	pragmas.Add("_pragma", fmt.Sprintf("journal_mode(%s)", journalMode))
+	pragmas.Add("_pragma", fmt.Sprintf("synchronous(%s)", synchronous))
+	// Write transactions take the lock when they begin, where busy_timeout
+	// waits for it. A deferred one that reads first fails at once with
+	// SQLITE_BUSY when another writer commits before it writes. The driver
+	// leaves transactions begun with ReadOnly deferred, which is what
+	// sqliteDB.readTx uses.
+	pragmas.Set("_txlock", "immediate")
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + pragmas.Encode(), nil
+}
+
+func openDatabase() (*sql.DB, error) {
+	dbPath := envOrDefault("DB_PATH", "tracksm.db")
+	dsn, err := sqliteDSN(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening sqlite: %w", err)
+	}
+	configurePool(db)
+
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed pinging sqlite: %w", err)
+	}
+
+	return db, nil
+}
+
+// openReadOnlyDatabase opens path with mode=ro, for commands that only
+// inspect a database and must neither create nor change it. The path goes
+// in a file: URI, escaped so that ? and # stay part of the name.
+func openReadOnlyDatabase(path string) (*sql.DB, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	dsn := url.URL{Scheme: "file", Path: filepath.ToSlash(abs), RawQuery: "mode=ro"}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed opening sqlite: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed opening %s read-only: %w", path, err)
+	}
+	return db, nil
+}
+
+// configurePool applies DB_MAX_OPEN_CONNS and DB_MAX_IDLE_CONNS to either
+// backend. With WAL, SQLite readers no longer wait for the single writer, so
+// more than one connection pays off there too.
+func configurePool(db *sql.DB) {
+	db.SetMaxOpenConns(envIntOrDefault("DB_MAX_OPEN_CONNS", 8))
+	db.SetMaxIdleConns(envIntOrDefault("DB_MAX_IDLE_CONNS", 4))
+}
+
+func isSQLiteBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	// Extended codes such as SQLITE_BUSY_SNAPSHOT keep the primary code in
+	// the low byte.
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// retryBusy runs fn again with jittered exponential backoff while it fails
+// with SQLITE_BUSY, which busy_timeout alone does not rule out, e.g. when the
+// timeout runs out under a long write.
+func retryBusy(fn func() error) error {
+	delay := busyRetryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == busyRetryAttempts || !isSQLiteBusy(err) {
+			return err
+		}
+		time.Sleep(delay + rand.N(delay))
+		delay *= 2
+	}
+}
+
+// sqliteDB is how the app reaches SQLite. Statements and whole transactions
+// are retried while the database reports busy; the handle itself stays
+// unexported so nothing begins a transaction around the retries.
+type sqliteDB struct {
+	pool *sql.DB
+}
+
+func (c sqliteDB) Exec(query string, args ...any) (sql.Result, error) {
+	var result sql.Result
+	err := retryBusy(func() error {
+		var err error
+		result, err = c.pool.Exec(query, args...)
+		return err
+	})
+	return result, err
+}
+
+func (c sqliteDB) Query(query string, args ...any) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := retryBusy(func() error {
+		var err error
+		rows, err = c.pool.Query(query, args...)
+		return err
+	})
+	return rows, err
+}
+
+// QueryRow retries while running the query fails with busy. sql.Row holds
+// that error back until Scan, so it is read through Err.
+func (c sqliteDB) QueryRow(query string, args ...any) *sql.Row {
+	var row *sql.Row
+	_ = retryBusy(func() error {
+		row = c.pool.QueryRow(query, args...)
+		return row.Err()
+	})
+	return row
+}
+
+// inTx runs fn in a write transaction, which begins immediate, and commits
+// it. The whole transaction runs again while the database is busy, so fn may
+// run more than once and must only keep what its last run produced.
+func (c sqliteDB) inTx(fn func(tx *sql.Tx) error) error {
+	return retryBusy(func() error {
+		tx, err := c.pool.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// readTx runs fn in a read-only transaction. It begins deferred and never
+// takes the write lock, so readers do not queue behind writers.
+func (c sqliteDB) readTx(fn func(tx *sql.Tx) error) error {
+	return retryBusy(func() error {
+		tx, err := c.pool.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		return fn(tx)
+	})
+}
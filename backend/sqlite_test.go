@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestSQLite(t *testing.T) sqliteDB {
+	t.Helper()
+	dsn, err := sqliteDSN(filepath.Join(t.TempDir(), "tracksm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	configurePool(db)
+	if err := migrateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+	return sqliteDB{db}
+}
+
+// Handlers and repositories both write through sqliteDB, whose transactions
+// and statements must keep concurrent writers from seeing SQLITE_BUSY.
+func TestSQLiteConcurrentWritersDoNotFailBusy(t *testing.T) {
+	db := openTestSQLite(t)
+
+	const writers = 8
+	const rounds = 25
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*rounds*2)
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range rounds {
+				// Read, then write in the same transaction, like the token
+				// and 2FA handlers do.
+				if err := db.inTx(func(tx *sql.Tx) error {
+					var count int
+					if err := tx.QueryRow("SELECT COUNT(1) FROM password_reset_tokens").Scan(&count); err != nil {
+						return err
+					}
+					// Give the other writers time to commit in between.
+					time.Sleep(time.Millisecond)
+					_, err := tx.Exec(
+						"INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES (?, ?, '2100-01-01 00:00:00')",
+						w,
+						fmt.Sprintf("tx-%d-%d", w, i),
+					)
+					return err
+				}); err != nil {
+					errs <- err
+				}
+
+				if _, err := db.Exec(
+					"INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES (?, ?, '2100-01-01 00:00:00')",
+					w,
+					fmt.Sprintf("exec-%d-%d", w, i),
+				); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent write failed (busy=%v): %v", isSQLiteBusy(err), err)
+	}
+	var total int
+	if err := db.QueryRow("SELECT COUNT(1) FROM password_reset_tokens").Scan(&total); err != nil {
+		t.Fatal(err)
+	}
+	if total != writers*rounds*2 {
+		t.Fatalf("got %d rows, want %d", total, writers*rounds*2)
+	}
+}
+
+// Read-only transactions begin deferred, so they do not wait for the write
+// lock that an open write transaction holds.
+func TestSQLiteReadTxDoesNotWaitForWriters(t *testing.T) {
+	db := openTestSQLite(t)
+
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	writerDone := make(chan error, 1)
+	go func() {
+		writerDone <- db.inTx(func(tx *sql.Tx) error {
+			close(locked)
+			<-release
+			_, err := tx.Exec("INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES (1, 'held', '2100-01-01 00:00:00')")
+			return err
+		})
+	}()
+	<-locked
+
+	readDone := make(chan error, 1)
+	go func() {
+		readDone <- db.readTx(func(tx *sql.Tx) error {
+			var count int
+			return tx.QueryRow("SELECT COUNT(1) FROM password_reset_tokens").Scan(&count)
+		})
+	}()
+	select {
+	case err := <-readDone:
+		if err != nil {
+			t.Fatalf("readTx: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("readTx waited for the write lock")
+	}
+
+	close(release)
+	if err := <-writerDone; err != nil {
+		t.Fatalf("inTx: %v", err)
+	}
+}
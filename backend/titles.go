@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Title statuses. watchlist, paused and dropped are only ever set by the
+// user; watching and completed are also inferred from viewings.
+const (
+	titleWatchlist = "watchlist"
+	titleWatching  = "watching"
+	titlePaused    = "paused"
+	titleDropped   = "dropped"
+	titleCompleted = "completed"
+)
+
+var titleStatuses = []string{titleWatchlist, titleWatching, titlePaused, titleDropped, titleCompleted}
+
+type TitleStatusInput struct {
+	Status string `json:"status"`
+}
+
+type TitleStatusChangeItem struct {
+	FromStatus string `json:"fromStatus"`
+	ToStatus   string `json:"toStatus"`
+	Inferred   bool   `json:"inferred"`
+	ChangedAt  string `json:"changedAt"`
+}
+
+type TitleStatusItem struct {
+	MediaType       string                  `json:"mediaType"`
+	TmdbID          int64                   `json:"tmdbId"`
+	Status          string                  `json:"status"`
+	Inferred        bool                    `json:"inferred"`
+	CreatedAt       string                  `json:"createdAt"`
+	StatusChangedAt string                  `json:"statusChangedAt"`
+	History         []TitleStatusChangeItem `json:"history,omitempty"`
+}
+
+// ensureTitleStatusTables creates the status tables and infers a status for
+// every title already watched: movies are completed, shows are in progress.
+func ensureTitleStatusTables(tx *sql.Tx) error {
+	query := `
+    CREATE TABLE title_statuses (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        media_type TEXT NOT NULL,
+        tmdb_id INTEGER NOT NULL,
+        status TEXT NOT NULL,
+        inferred INTEGER NOT NULL DEFAULT 0,
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        status_changed_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE(user_id, media_type, tmdb_id)
+    );
+    CREATE INDEX idx_title_statuses_user_status
+        ON title_statuses(user_id, status, status_changed_at DESC);
+    CREATE TABLE title_status_changes (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        media_type TEXT NOT NULL,
+        tmdb_id INTEGER NOT NULL,
+        from_status TEXT NOT NULL DEFAULT '',
+        to_status TEXT NOT NULL,
+        inferred INTEGER NOT NULL DEFAULT 0,
+        changed_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX idx_title_status_changes_title
+        ON title_status_changes(user_id, media_type, tmdb_id, changed_at);
+    `
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed creating title status tables: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+    INSERT INTO title_statuses (user_id, media_type, tmdb_id, status, inferred, created_at, status_changed_at)
+        SELECT user_id, media_type, tmdb_id,
+            CASE WHEN media_type = 'movie' THEN 'completed' ELSE 'watching' END,
+            1, min(watched_at), max(watched_at)
+        FROM watch_events
+        GROUP BY user_id, media_type, tmdb_id;
+    INSERT INTO title_status_changes (user_id, media_type, tmdb_id, from_status, to_status, inferred, changed_at)
+        SELECT user_id, media_type, tmdb_id, '', status, 1, status_changed_at
+        FROM title_statuses ORDER BY id;
+    `); err != nil {
+		return fmt.Errorf("failed inferring title statuses: %w", err)
+	}
+	return nil
+}
+
+func titleStatusItem(ts TitleStatus) TitleStatusItem {
+	return TitleStatusItem{
+		MediaType:       ts.MediaType,
+		TmdbID:          ts.TmdbID,
+		Status:          ts.Status,
+		Inferred:        ts.Inferred,
+		CreatedAt:       ts.CreatedAt.UTC().Format(time.RFC3339),
+		StatusChangedAt: ts.StatusChangedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// titleFromPath reads the {mediaType}/{tmdbId} path values.
+func titleFromPath(w http.ResponseWriter, r *http.Request) (string, int64, bool) {
+	mediaType := strings.ToLower(strings.TrimSpace(r.PathValue("mediaType")))
+	if mediaType != "movie" && mediaType != "tv" {
+		writeError(w, http.StatusBadRequest, "mediaType must be movie or tv")
+		return "", 0, false
+	}
+	tmdbID, err := strconv.ParseInt(strings.TrimSpace(r.PathValue("tmdbId")), 10, 64)
+	if err != nil || tmdbID <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid tmdbId")
+		return "", 0, false
+	}
+	return mediaType, tmdbID, true
+}
+
+func (a *App) handleListTitleStatuses(w http.ResponseWriter, r *http.Request) {
+	filter := TitleStatusFilter{UserID: authUserID(r)}
+
+	switch mediaType := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mediaType"))); mediaType {
+	case "", "all":
+	case "movie", "tv":
+		filter.MediaType = mediaType
+	default:
+		writeError(w, http.StatusBadRequest, "mediaType must be movie, tv or all")
+		return
+	}
+	if status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status"))); status != "" {
+		if !slices.Contains(titleStatuses, status) {
+			writeError(w, http.StatusBadRequest, "status must be one of "+strings.Join(titleStatuses, ", "))
+			return
+		}
+		filter.Status = status
+	}
+
+	statuses, err := a.repos.Titles.List(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list title statuses")
+		return
+	}
+
+	out := make([]TitleStatusItem, 0, len(statuses))
+	for _, ts := range statuses {
+		out = append(out, titleStatusItem(ts))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleGetTitleStatus returns the status together with its history.
+func (a *App) handleGetTitleStatus(w http.ResponseWriter, r *http.Request) {
+	mediaType, tmdbID, ok := titleFromPath(w, r)
+	if !ok {
+		return
+	}
+	a.writeTitleStatus(w, authUserID(r), mediaType, tmdbID)
+}
+
+func (a *App) writeTitleStatus(w http.ResponseWriter, userID int64, mediaType string, tmdbID int64) {
+	ts, err := a.repos.Titles.Get(userID, mediaType, tmdbID)
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "title status not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load title status")
+		return
+	}
+	history, err := a.repos.Titles.History(userID, mediaType, tmdbID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load title status")
+		return
+	}
+
+	item := titleStatusItem(ts)
+	item.History = make([]TitleStatusChangeItem, 0, len(history))
+	for _, change := range history {
+		item.History = append(item.History, TitleStatusChangeItem{
+			FromStatus: change.FromStatus,
+			ToStatus:   change.ToStatus,
+			Inferred:   change.Inferred,
+			ChangedAt:  change.ChangedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+// handleSetTitleStatus stores a status chosen by the user. It is never
+// overridden by inference except when new viewings contradict it.
+func (a *App) handleSetTitleStatus(w http.ResponseWriter, r *http.Request) {
+	mediaType, tmdbID, ok := titleFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var in TitleStatusInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	in.Status = strings.ToLower(strings.TrimSpace(in.Status))
+	var errs ValidationErrors
+	switch {
+	case in.Status == "":
+		errs.add("status", codeRequired, "status is required")
+	case !slices.Contains(titleStatuses, in.Status):
+		errs.add("status", codeInvalidValue, "status must be one of "+strings.Join(titleStatuses, ", "))
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	userID := authUserID(r)
+	ts := TitleStatus{UserID: userID, MediaType: mediaType, TmdbID: tmdbID, Status: in.Status}
+	if err := a.repos.Titles.Set(&ts, time.Now().UTC().Truncate(time.Second)); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to save title status")
+		return
+	}
+	a.writeTitleStatus(w, userID, mediaType, tmdbID)
+}
+
+func (a *App) handleDeleteTitleStatus(w http.ResponseWriter, r *http.Request) {
+	mediaType, tmdbID, ok := titleFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := a.repos.Titles.Delete(authUserID(r), mediaType, tmdbID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete title status")
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, "title status not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// inferTitleStatus updates a title's status after its viewings changed.
+// marked tells whether something was watched rather than removed. Failures
+// are only logged: the viewings themselves are already saved.
+//
+// A movie with a viewing is completed. A show is completed once it is marked
+// as a whole or every regular episode TMDB lists is watched, and watching
+// otherwise. Removals only touch inferred statuses, so a status the user
+// picked survives unmarking an episode; new viewings move any status on.
+func (a *App) inferTitleStatus(userID int64, mediaType string, tmdbID int64, marked bool) {
+	a.inferStatus(userID, mediaType, tmdbID, marked, true)
+}
+
+// inferStatus does the work of inferTitleStatus. A show whose episode list
+// is not cached is settled in the background once TMDB answers, so that
+// watched writes never wait on it; fetch is unset for that second pass.
+func (a *App) inferStatus(userID int64, mediaType string, tmdbID int64, marked bool, fetch bool) {
+	current, err := a.repos.Titles.Get(userID, mediaType, tmdbID)
+	exists := err == nil
+	if err != nil && !errors.Is(err, errNotFound) {
+		log.Printf("failed loading status of %s %d for user %d: %v", mediaType, tmdbID, userID, err)
+		return
+	}
+	if !marked && exists && !current.Inferred {
+		return
+	}
+
+	records, err := a.repos.Watched.List(WatchedFilter{UserID: userID, MediaType: mediaType, TmdbID: tmdbID})
+	if err != nil {
+		log.Printf("failed loading viewings of %s %d for user %d: %v", mediaType, tmdbID, userID, err)
+		return
+	}
+	if len(records) == 0 {
+		if exists {
+			a.revertTitleStatus(userID, mediaType, tmdbID)
+		}
+		return
+	}
+
+	status := titleCompleted
+	if mediaType == "tv" {
+		var seasons []tmdbSeason
+		cached := true
+		if a.tmdb != nil {
+			seasons, cached = a.tmdb.CachedSeasons(tmdbID)
+		}
+		complete, known := showComplete(seasons, records)
+		switch {
+		case complete:
+		case !cached && fetch:
+			a.inferShowStatusLater(userID, tmdbID, marked)
+			return
+		case !known && exists && current.Status == titleCompleted:
+			// Without an episode list a completed show stays completed.
+			return
+		default:
+			status = titleWatching
+		}
+	}
+	if exists && current.Status == status {
+		return
+	}
+
+	ts := TitleStatus{UserID: userID, MediaType: mediaType, TmdbID: tmdbID, Status: status, Inferred: true}
+	if err := a.repos.Titles.Set(&ts, time.Now().UTC().Truncate(time.Second)); err != nil {
+		log.Printf("failed saving status of %s %d for user %d: %v", mediaType, tmdbID, userID, err)
+	}
+}
+
+// inferShowStatusLater fetches a show's episode list off the request path and
+// then infers its status, with or without the list.
+func (a *App) inferShowStatusLater(userID int64, tmdbID int64, marked bool) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if _, err := a.tmdb.Seasons(ctx, tmdbID); err != nil {
+			log.Printf("failed loading seasons of show %d: %v", tmdbID, err)
+		}
+		a.inferStatus(userID, "tv", tmdbID, marked, false)
+	}()
+}
+
+// revertTitleStatus handles a title whose last viewing was removed. A title
+// the user had put on the watchlist goes back there; any other inferred
+// status is dropped.
+func (a *App) revertTitleStatus(userID int64, mediaType string, tmdbID int64) {
+	history, err := a.repos.Titles.History(userID, mediaType, tmdbID)
+	if err != nil {
+		log.Printf("failed loading status history of %s %d for user %d: %v", mediaType, tmdbID, userID, err)
+		return
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Inferred {
+			continue
+		}
+		if history[i].ToStatus == titleWatchlist {
+			ts := TitleStatus{UserID: userID, MediaType: mediaType, TmdbID: tmdbID, Status: titleWatchlist}
+			if err := a.repos.Titles.Set(&ts, time.Now().UTC().Truncate(time.Second)); err != nil {
+				log.Printf("failed saving status of %s %d for user %d: %v", mediaType, tmdbID, userID, err)
+			}
+			return
+		}
+		break
+	}
+	if _, err := a.repos.Titles.Delete(userID, mediaType, tmdbID); err != nil {
+		log.Printf("failed clearing status of %s %d for user %d: %v", mediaType, tmdbID, userID, err)
+	}
+}
+
+// showComplete reports whether records cover the whole show listed in
+// seasons, and whether that could be told at all.
+func showComplete(seasons []tmdbSeason, records []WatchedRecord) (complete bool, known bool) {
+	for _, rec := range records {
+		if rec.SeasonNumber == 0 && rec.EpisodeNumber == 0 {
+			return true, true
+		}
+	}
+
+	episodes := make(map[int64]int64)
+	var total int64
+	for _, season := range seasons {
+		if season.SeasonNumber > 0 {
+			episodes[season.SeasonNumber] = season.EpisodeCount
+			total += season.EpisodeCount
+		}
+	}
+	if total == 0 {
+		return false, false
+	}
+
+	// A season marked as a whole counts all of its episodes.
+	seen := make(map[int64]map[int64]bool)
+	for _, rec := range records {
+		count, ok := episodes[rec.SeasonNumber]
+		if !ok || rec.EpisodeNumber > count {
+			continue
+		}
+		if seen[rec.SeasonNumber] == nil {
+			seen[rec.SeasonNumber] = make(map[int64]bool)
+		}
+		if rec.EpisodeNumber == 0 {
+			for number := int64(1); number <= count; number++ {
+				seen[rec.SeasonNumber][number] = true
+			}
+			continue
+		}
+		seen[rec.SeasonNumber][rec.EpisodeNumber] = true
+	}
+
+	var watched int64
+	for _, numbers := range seen {
+		watched += int64(len(numbers))
+	}
+	return watched >= total, true
+}
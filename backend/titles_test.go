@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShowComplete(t *testing.T) {
+	seasons := []tmdbSeason{{SeasonNumber: 0, EpisodeCount: 3}, {SeasonNumber: 1, EpisodeCount: 2}, {SeasonNumber: 2, EpisodeCount: 3}}
+	ep := func(season, number int64) WatchedRecord {
+		return WatchedRecord{MediaType: "tv", SeasonNumber: season, EpisodeNumber: number}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		seasons  []tmdbSeason
+		records  []WatchedRecord
+		complete bool
+		known    bool
+	}{
+		{"whole show marked", nil, []WatchedRecord{ep(1, 1), ep(0, 0)}, true, true},
+		{"no episode list", nil, []WatchedRecord{ep(1, 1)}, false, false},
+		{"only specials listed", seasons[:1], []WatchedRecord{ep(0, 1)}, false, false},
+		{"every episode", seasons, []WatchedRecord{ep(1, 1), ep(1, 2), ep(2, 1), ep(2, 2), ep(2, 3)}, true, true},
+		{"seasons marked whole", seasons, []WatchedRecord{ep(1, 0), ep(2, 0)}, true, true},
+		{"season and episodes", seasons, []WatchedRecord{ep(1, 0), ep(1, 1), ep(2, 1), ep(2, 2), ep(2, 3)}, true, true},
+		{"one episode missing", seasons, []WatchedRecord{ep(1, 0), ep(2, 1), ep(2, 2)}, false, true},
+		{"specials do not count", seasons, []WatchedRecord{ep(0, 1), ep(0, 2), ep(0, 3), ep(1, 0), ep(2, 1), ep(2, 2)}, false, true},
+		{"unlisted episodes do not count", seasons, []WatchedRecord{ep(1, 0), ep(2, 1), ep(2, 2), ep(2, 4), ep(3, 1)}, false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			complete, known := showComplete(tc.seasons, tc.records)
+			if complete != tc.complete || known != tc.known {
+				t.Fatalf("got complete %v, known %v, want %v, %v", complete, known, tc.complete, tc.known)
+			}
+		})
+	}
+}
+
+// TestInferTitleStatus drives inferTitleStatus through viewings and user
+// choices without TMDB, checking the stored status after each step.
+func TestInferTitleStatus(t *testing.T) {
+	type step struct {
+		watch  *WatchEvent // recorded, then inferred as marked
+		remove *WatchEvent // deleted, then inferred as unmarked
+		set    string      // picked by the user
+		want   string      // "" when the title has no status
+	}
+	movie := &WatchEvent{MediaType: "movie", TmdbID: 603}
+	episode := &WatchEvent{MediaType: "tv", TmdbID: 1396, SeasonNumber: 1, EpisodeNumber: 1}
+	wholeShow := &WatchEvent{MediaType: "tv", TmdbID: 1396}
+
+	for _, tc := range []struct {
+		name  string
+		title *WatchEvent
+		steps []step
+	}{
+		{"watched movie", movie, []step{{watch: movie, want: titleCompleted}, {remove: movie, want: ""}}},
+		{"viewing moves a picked status on", movie, []step{{set: titleDropped, want: titleDropped}, {watch: movie, want: titleCompleted}}},
+		{"removal keeps a picked status", movie, []step{{watch: movie, want: titleCompleted}, {set: titlePaused, want: titlePaused}, {remove: movie, want: titlePaused}}},
+		{"watchlist comes back", movie, []step{{set: titleWatchlist, want: titleWatchlist}, {watch: movie, want: titleCompleted}, {remove: movie, want: titleWatchlist}}},
+		{"show in progress", wholeShow, []step{{watch: episode, want: titleWatching}, {remove: episode, want: ""}}},
+		{"show marked whole", wholeShow, []step{{watch: episode, want: titleWatching}, {watch: wholeShow, want: titleCompleted}, {remove: wholeShow, want: titleCompleted}}},
+		{"paused show resumed", wholeShow, []step{{set: titlePaused, want: titlePaused}, {watch: episode, want: titleWatching}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, _ := newTestApp(t)
+			user := createPasswordUser(t, a, "status@example.com", "password123")
+			for i, s := range tc.steps {
+				switch {
+				case s.watch != nil:
+					ev := *s.watch
+					ev.UserID = user.ID
+					ev.WatchedAt = time.Now().UTC().Truncate(time.Second)
+					if err := a.repos.Watched.SetLatest(&ev); err != nil {
+						t.Fatal(err)
+					}
+					a.inferTitleStatus(user.ID, ev.MediaType, ev.TmdbID, true)
+				case s.remove != nil:
+					if _, err := a.repos.Watched.Delete(WatchedRecord{
+						UserID:        user.ID,
+						MediaType:     s.remove.MediaType,
+						TmdbID:        s.remove.TmdbID,
+						SeasonNumber:  s.remove.SeasonNumber,
+						EpisodeNumber: s.remove.EpisodeNumber,
+					}); err != nil {
+						t.Fatal(err)
+					}
+					a.inferTitleStatus(user.ID, s.remove.MediaType, s.remove.TmdbID, false)
+				default:
+					ts := TitleStatus{UserID: user.ID, MediaType: tc.title.MediaType, TmdbID: tc.title.TmdbID, Status: s.set}
+					if err := a.repos.Titles.Set(&ts, time.Now()); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				got := ""
+				ts, err := a.repos.Titles.Get(user.ID, tc.title.MediaType, tc.title.TmdbID)
+				switch {
+				case err == nil:
+					got = ts.Status
+				case !errors.Is(err, errNotFound):
+					t.Fatal(err)
+				}
+				if got != s.want {
+					t.Fatalf("step %d: got %q, want %q", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRevertTitleStatus(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "revert@example.com", "password123")
+	set := func(tmdbID int64, status string, inferred bool) {
+		t.Helper()
+		ts := TitleStatus{UserID: user.ID, MediaType: "movie", TmdbID: tmdbID, Status: status, Inferred: inferred}
+		if err := a.repos.Titles.Set(&ts, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The latest status the user picked decides, not an older watchlist.
+	set(1, titleWatchlist, false)
+	set(1, titleCompleted, true)
+	set(2, titleWatchlist, false)
+	set(2, titleDropped, false)
+	set(2, titleCompleted, true)
+	set(3, titleCompleted, true)
+
+	for tmdbID, want := range map[int64]string{1: titleWatchlist, 2: "", 3: ""} {
+		a.revertTitleStatus(user.ID, "movie", tmdbID)
+		ts, err := a.repos.Titles.Get(user.ID, "movie", tmdbID)
+		switch {
+		case want == "" && !errors.Is(err, errNotFound):
+			t.Fatalf("movie %d: got %+v, %v, want no status", tmdbID, ts, err)
+		case want != "" && (err != nil || ts.Status != want || ts.Inferred):
+			t.Fatalf("movie %d: got %+v, %v, want %s", tmdbID, ts, err, want)
+		}
+	}
+}
+
+func TestInferShowStatusDoesNotWaitForTMDB(t *testing.T) {
+	release := make(chan struct{})
+	tmdb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, `{"seasons": [{"season_number": 1, "episode_count": 2}]}`)
+	}))
+	defer tmdb.Close()
+	defer func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	}()
+
+	a, _ := newTestApp(t)
+	a.tmdb = &TMDBClient{apiKey: "key", baseURL: tmdb.URL, client: tmdb.Client(), seasons: make(map[int64]tmdbSeasonsEntry)}
+	user := createPasswordUser(t, a, "tmdb@example.com", "password123")
+	session := loginSession(t, a, user.ID)
+	watch := a.requireAuth(scopeWatchedWrite, a.handleUpsertWatched)
+	status := func() string {
+		ts, err := a.repos.Titles.Get(user.ID, "tv", 1396)
+		if errors.Is(err, errNotFound) {
+			return ""
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts.Status
+	}
+
+	done := make(chan int, 1)
+	go func() {
+		rec := serve(watch, jsonRequest(t, http.MethodPost, "/api/user/watched", session, WatchedInput{MediaType: "tv", TmdbID: 1396, SeasonNumber: 1, EpisodeNumber: 1}))
+		done <- rec.Code
+	}()
+	select {
+	case code := <-done:
+		if code != http.StatusOK {
+			t.Fatalf("first episode: status %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("marking an episode waited for TMDB")
+	}
+	if got := status(); got != "" {
+		t.Fatalf("status before TMDB answered: got %q", got)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for status() != titleWatching {
+		if time.Now().After(deadline) {
+			t.Fatalf("status after TMDB answered: got %q, want %q", status(), titleWatching)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The episode list is cached now, so the last episode settles at once.
+	rec := serve(watch, jsonRequest(t, http.MethodPost, "/api/user/watched", session, WatchedInput{MediaType: "tv", TmdbID: 1396, SeasonNumber: 1, EpisodeNumber: 2}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("last episode: status %d %s", rec.Code, rec.Body)
+	}
+	if got := status(); got != titleCompleted {
+		t.Fatalf("status with a cached episode list: got %q, want %q", got, titleCompleted)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tmdbSeasonsTTL bounds how long a show's season list is reused. New episodes
+// are announced days ahead, so an hour of staleness is harmless.
+const tmdbSeasonsTTL = time.Hour
+
+var errTMDBNotFound = errors.New("show not found on tmdb")
+
+type tmdbSeason struct {
+	SeasonNumber int64 `json:"season_number"`
+	EpisodeCount int64 `json:"episode_count"`
+}
+
+type tmdbSeasonsEntry struct {
+	seasons   []tmdbSeason
+	fetchedAt time.Time
+}
+
+// TMDBClient reads show structure from The Movie Database. The backend only
+// needs it to expand batch requests that name a season or a whole show.
+type TMDBClient struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+
+	mu      sync.Mutex
+	seasons map[int64]tmdbSeasonsEntry
+}
+
+// newTMDBClient returns nil when TMDB_API_KEY is not set.
+func newTMDBClient() *TMDBClient {
+	apiKey := envOrDefault("TMDB_API_KEY", "")
+	if apiKey == "" {
+		return nil
+	}
+	return &TMDBClient{
+		apiKey:  apiKey,
+		baseURL: strings.TrimRight(envOrDefault("TMDB_API_URL", "https://api.themoviedb.org/3"), "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		seasons: make(map[int64]tmdbSeasonsEntry),
+	}
+}
+
+// CachedSeasons returns the seasons Seasons fetched less than
+// tmdbSeasonsTTL ago, without calling TMDB.
+func (c *TMDBClient) CachedSeasons(tmdbID int64) ([]tmdbSeason, bool) {
+	c.mu.Lock()
+	entry, ok := c.seasons[tmdbID]
+	c.mu.Unlock()
+	if !ok || time.Since(entry.fetchedAt) >= tmdbSeasonsTTL {
+		return nil, false
+	}
+	return entry.seasons, true
+}
+
+// Seasons lists a show's seasons, including season 0 for specials.
+func (c *TMDBClient) Seasons(ctx context.Context, tmdbID int64) ([]tmdbSeason, error) {
+	if seasons, ok := c.CachedSeasons(tmdbID); ok {
+		return seasons, nil
+	}
+
+	endpoint := fmt.Sprintf("%s/tv/%d?%s", c.baseURL, tmdbID, url.Values{"api_key": {c.apiKey}}.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		// The *url.Error would print the URL, api_key included.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("failed fetching tmdb show %d: %w", tmdbID, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, errTMDBNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tmdb show %d returned %d", tmdbID, res.StatusCode)
+	}
+
+	var show struct {
+		Seasons []tmdbSeason `json:"seasons"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&show); err != nil {
+		return nil, fmt.Errorf("failed reading tmdb show %d: %w", tmdbID, err)
+	}
+
+	c.mu.Lock()
+	c.seasons[tmdbID] = tmdbSeasonsEntry{seasons: show.Seasons, fetchedAt: time.Now()}
+	c.mu.Unlock()
+	return show.Seasons, nil
+}
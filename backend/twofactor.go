@@ -0,0 +1,517 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RFC 6238 parameters, matching what authenticator apps assume by default.
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	totpSkew   = 1
+
+	challengeTTL      = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	ChallengeToken    string `json:"challengeToken"`
+	ExpiresAt         string `json:"expiresAt"`
+}
+
+type TwoFactorLoginInput struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+}
+
+type TwoFactorSetupResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURL string `json:"otpauthUrl"`
+}
+
+type TwoFactorCodeInput struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorPasswordInput struct {
+	Password string `json:"password"`
+}
+
+type TwoFactorStatus struct {
+	Enabled                bool   `json:"enabled"`
+	EnabledAt              string `json:"enabledAt,omitempty"`
+	RecoveryCodesRemaining int    `json:"recoveryCodesRemaining"`
+}
+
+func ensureTwoFactorTables(tx *sql.Tx) error {
+	query := `
+    CREATE TABLE IF NOT EXISTS totp_credentials (
+        user_id INTEGER PRIMARY KEY,
+        secret TEXT NOT NULL,
+        enabled_at DATETIME,
+        last_step INTEGER NOT NULL DEFAULT 0,
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        code_hash TEXT NOT NULL,
+        used_at DATETIME
+    );
+    `
+
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed creating two-factor tables: %w", err)
+	}
+
+	return nil
+}
+
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// matchTOTP returns the time step the code belongs to, accepting one step of
+// clock drift either way.
+func matchTOTP(encodedSecret string, code string, now time.Time) (int64, bool) {
+	secret, err := totpEncoding.DecodeString(encodedSecret)
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	for len(codes) < recoveryCodeCount {
+		raw, err := randomBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(raw))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+	return codes, nil
+}
+
+// signChallenge issues the short-lived token that links the password step of
+// a login to the second factor.
+func (s *SessionSigner) signChallenge(userID int64, now time.Time) (string, string) {
+	expiresAt := now.Add(challengeTTL)
+	payload := fmt.Sprintf("%d.%d", userID, expiresAt.Unix())
+	return payload + "." + s.sign("2fa:"+payload), expiresAt.UTC().Format(time.RFC3339)
+}
+
+func (s *SessionSigner) verifyChallenge(token string, now time.Time) (int64, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(s.sign("2fa:"+payload))) {
+		return 0, false
+	}
+
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || userID <= 0 {
+		return 0, false
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() >= expiresAt {
+		return 0, false
+	}
+	return userID, true
+}
+
+func (a *App) twoFactorEnabled(userID int64) (bool, error) {
+	var count int
+	err := a.db.QueryRow(
+		"SELECT COUNT(1) FROM totp_credentials WHERE user_id = ? AND enabled_at IS NOT NULL",
+		userID,
+	).Scan(&count)
+	return count > 0, err
+}
+
+// consumeTOTP accepts a code for an enabled credential. Each time step can be
+// used only once, so an observed code cannot be replayed.
+func (a *App) consumeTOTP(userID int64, code string, requireEnabled bool) (bool, error) {
+	query := "SELECT secret, last_step FROM totp_credentials WHERE user_id = ?"
+	if requireEnabled {
+		query += " AND enabled_at IS NOT NULL"
+	}
+
+	var (
+		secret   string
+		lastStep int64
+	)
+	err := a.db.QueryRow(query+" LIMIT 1", userID).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := matchTOTP(secret, code, time.Now())
+	if !ok || step <= lastStep {
+		return false, nil
+	}
+
+	result, err := a.db.Exec(
+		"UPDATE totp_credentials SET last_step = ? WHERE user_id = ? AND last_step < ?",
+		step,
+		userID,
+		step,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+func (a *App) consumeRecoveryCode(userID int64, code string) (bool, error) {
+	result, err := a.db.Exec(
+		`UPDATE totp_recovery_codes SET used_at = ?
+         WHERE id = (
+             SELECT id FROM totp_recovery_codes
+             WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+             LIMIT 1
+         )`,
+		time.Now().UTC().Format(dbTimeLayout),
+		userID,
+		hashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+func (a *App) replaceRecoveryCodes(tx *sql.Tx, userID int64) ([]string, error) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec(
+			"INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES (?, ?)",
+			userID,
+			hashToken(normalizeRecoveryCode(code)),
+		); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func (a *App) handleTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var in TwoFactorLoginInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	now := time.Now()
+	userID, ok := a.sessions.verifyChallenge(strings.TrimSpace(in.ChallengeToken), now.UTC())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid or expired challenge")
+		return
+	}
+
+	limiterKey := strconv.FormatInt(userID, 10)
+	if wait := a.limits.twoFactor.Retry(limiterKey, now); wait > 0 {
+		writeTooManyRequests(w, wait)
+		return
+	}
+
+	var (
+		valid bool
+		err   error
+	)
+	switch {
+	case strings.TrimSpace(in.Code) != "":
+		valid, err = a.consumeTOTP(userID, in.Code, true)
+	case strings.TrimSpace(in.RecoveryCode) != "":
+		valid, err = a.consumeRecoveryCode(userID, in.RecoveryCode)
+	default:
+		writeError(w, http.StatusBadRequest, "code or recoveryCode is required")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to verify code")
+		return
+	}
+	if !valid {
+		a.limits.twoFactor.Record(limiterKey, now)
+		writeError(w, http.StatusUnauthorized, "invalid code")
+		return
+	}
+	a.limits.twoFactor.Reset(limiterKey)
+
+	a.writeLoginSuccess(w, r, userID)
+}
+
+func (a *App) handleTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	userID := authUserID(r)
+
+	var (
+		status    TwoFactorStatus
+		enabledAt sql.NullTime
+	)
+	err := a.db.QueryRow(
+		"SELECT enabled_at FROM totp_credentials WHERE user_id = ? LIMIT 1",
+		userID,
+	).Scan(&enabledAt)
+	if err != nil && err != sql.ErrNoRows {
+		writeError(w, http.StatusInternalServerError, "failed to load two-factor status")
+		return
+	}
+	status.Enabled = enabledAt.Valid
+	if status.Enabled {
+		status.EnabledAt = enabledAt.Time.UTC().Format(time.RFC3339)
+	}
+
+	if status.Enabled {
+		if err := a.db.QueryRow(
+			"SELECT COUNT(1) FROM totp_recovery_codes WHERE user_id = ? AND used_at IS NULL",
+			userID,
+		).Scan(&status.RecoveryCodesRemaining); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load two-factor status")
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleTwoFactorSetup creates a pending secret. It only takes effect once
+// confirmed through handleTwoFactorEnable.
+func (a *App) handleTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	userID := authUserID(r)
+
+	enabled, err := a.twoFactorEnabled(userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to set up two-factor")
+		return
+	}
+	if enabled {
+		writeError(w, http.StatusConflict, "two-factor already enabled")
+		return
+	}
+
+	raw, err := randomBytes(20)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to set up two-factor")
+		return
+	}
+	secret := totpEncoding.EncodeToString(raw)
+
+	if _, err := a.db.Exec(
+		`INSERT INTO totp_credentials (user_id, secret) VALUES (?, ?)
+         ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, last_step = 0`,
+		userID,
+		secret,
+	); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to set up two-factor")
+		return
+	}
+
+	user, err := a.repos.Users.ByID(userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load user profile")
+		return
+	}
+	email := user.Email
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", "TrackSM")
+	params.Set("digits", strconv.Itoa(totpDigits))
+	params.Set("period", strconv.Itoa(totpPeriod))
+	label := url.PathEscape("TrackSM:" + email)
+
+	writeJSON(w, http.StatusOK, TwoFactorSetupResponse{
+		Secret:     secret,
+		OtpauthURL: "otpauth://totp/" + label + "?" + params.Encode(),
+	})
+}
+
+func (a *App) handleTwoFactorEnable(w http.ResponseWriter, r *http.Request) {
+	var in TwoFactorCodeInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	userID := authUserID(r)
+
+	enabled, err := a.twoFactorEnabled(userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to enable two-factor")
+		return
+	}
+	if enabled {
+		writeError(w, http.StatusConflict, "two-factor already enabled")
+		return
+	}
+
+	valid, err := a.consumeTOTP(userID, in.Code, false)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to enable two-factor")
+		return
+	}
+	if !valid {
+		writeError(w, http.StatusBadRequest, "invalid code")
+		return
+	}
+
+	var codes []string
+	if err := a.db.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(
+			"UPDATE totp_credentials SET enabled_at = ? WHERE user_id = ?",
+			time.Now().UTC().Format(dbTimeLayout),
+			userID,
+		); err != nil {
+			return err
+		}
+		var err error
+		codes, err = a.replaceRecoveryCodes(tx, userID)
+		return err
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to enable two-factor")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"enabled": true, "recoveryCodes": codes})
+}
+
+func (a *App) handleTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	var in TwoFactorPasswordInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	userID := authUserID(r)
+
+	ok, err := a.confirmIdentity(r, in.Password)
+	if wait, limited := retryAfter(err); limited {
+		writeTooManyRequests(w, wait)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to disable two-factor")
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	if err := a.db.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM totp_credentials WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = ?", userID)
+		return err
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to disable two-factor")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"enabled": false})
+}
+
+func (a *App) handleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var in TwoFactorPasswordInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	userID := authUserID(r)
+
+	ok, err := a.confirmIdentity(r, in.Password)
+	if wait, limited := retryAfter(err); limited {
+		writeTooManyRequests(w, wait)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to regenerate recovery codes")
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	enabled, err := a.twoFactorEnabled(userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to regenerate recovery codes")
+		return
+	}
+	if !enabled {
+		writeError(w, http.StatusConflict, "two-factor is not enabled")
+		return
+	}
+
+	var codes []string
+	if err := a.db.inTx(func(tx *sql.Tx) error {
+		var err error
+		codes, err = a.replaceRecoveryCodes(tx, userID)
+		return err
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to regenerate recovery codes")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"recoveryCodes": codes})
+}
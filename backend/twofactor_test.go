@@ -0,0 +1,218 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B, SHA-1. The reference values have 8 digits; TOTP
+// truncation keeps the last totpDigits of them.
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	for _, tc := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	} {
+		want := tc.want[len(tc.want)-totpDigits:]
+		if got := totpCode(secret, tc.unix/totpPeriod); got != want {
+			t.Errorf("T=%d: got %s, want %s", tc.unix, got, want)
+		}
+	}
+}
+
+func TestMatchTOTPSkew(t *testing.T) {
+	raw := []byte("12345678901234567890")
+	secret := totpEncoding.EncodeToString(raw)
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+
+	for offset := int64(-3); offset <= 3; offset++ {
+		code := totpCode(raw, current+offset)
+		step, ok := matchTOTP(secret, code, now)
+		inWindow := offset >= -totpSkew && offset <= totpSkew
+		if ok != inWindow {
+			t.Errorf("offset %d: accepted=%v", offset, ok)
+		}
+		if ok && step != current+offset {
+			t.Errorf("offset %d: matched step %d", offset, step)
+		}
+	}
+
+	if _, ok := matchTOTP(secret, "12345", now); ok {
+		t.Error("accepted a short code")
+	}
+	spaced := totpCode(raw, current)
+	if _, ok := matchTOTP(secret, spaced[:3]+" "+spaced[3:], now); !ok {
+		t.Error("rejected a code typed with a space")
+	}
+}
+
+// enableTestTOTP stores an enabled credential and returns its raw secret.
+func enableTestTOTP(t *testing.T, a *App, userID int64) []byte {
+	t.Helper()
+	raw := []byte("12345678901234567890")
+	if _, err := a.db.Exec(
+		"INSERT INTO totp_credentials (user_id, secret, enabled_at) VALUES (?, ?, ?)",
+		userID,
+		totpEncoding.EncodeToString(raw),
+		time.Now().UTC().Format(dbTimeLayout),
+	); err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestConsumeTOTPRejectsReuse(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "totp@example.com", "password123")
+	raw := enableTestTOTP(t, a, user.ID)
+	current := time.Now().Unix() / totpPeriod
+
+	previous := totpCode(raw, current-1)
+	if ok, err := a.consumeTOTP(user.ID, previous, true); err != nil || !ok {
+		t.Fatalf("first use: got %v, %v", ok, err)
+	}
+	if ok, err := a.consumeTOTP(user.ID, previous, true); err != nil || ok {
+		t.Fatalf("same code again: got %v, %v", ok, err)
+	}
+
+	if ok, err := a.consumeTOTP(user.ID, totpCode(raw, current), true); err != nil || !ok {
+		t.Fatalf("next step: got %v, %v", ok, err)
+	}
+	// A step older than the last accepted one is as good as used.
+	if ok, err := a.consumeTOTP(user.ID, totpCode(raw, current-1), true); err != nil || ok {
+		t.Fatalf("earlier step: got %v, %v", ok, err)
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "totp@example.com", "password123")
+	enableTestTOTP(t, a, user.ID)
+
+	var codes []string
+	if err := a.db.inTx(func(tx *sql.Tx) error {
+		var err error
+		codes, err = a.replaceRecoveryCodes(tx, user.ID)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes", len(codes))
+	}
+
+	// Codes are accepted in any case and without the dash.
+	if ok, err := a.consumeRecoveryCode(user.ID, " "+normalizeRecoveryCode(codes[0])+" "); err != nil || !ok {
+		t.Fatalf("first use: got %v, %v", ok, err)
+	}
+	if ok, err := a.consumeRecoveryCode(user.ID, codes[0]); err != nil || ok {
+		t.Fatalf("second use: got %v, %v", ok, err)
+	}
+	if ok, err := a.consumeRecoveryCode(user.ID, codes[1]); err != nil || !ok {
+		t.Fatalf("another code: got %v, %v", ok, err)
+	}
+
+	other := createPasswordUser(t, a, "other@example.com", "password123")
+	if ok, err := a.consumeRecoveryCode(other.ID, codes[2]); err != nil || ok {
+		t.Fatalf("code of another user: got %v, %v", ok, err)
+	}
+}
+
+func TestTwoFactorChallengeExpires(t *testing.T) {
+	signer := &SessionSigner{key: []byte("test-secret"), ttl: time.Hour}
+	issued := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	token, expiresAt := signer.signChallenge(42, issued)
+	if want := issued.Add(challengeTTL).Format(time.RFC3339); expiresAt != want {
+		t.Fatalf("expiresAt = %q, want %q", expiresAt, want)
+	}
+	if userID, ok := signer.verifyChallenge(token, issued.Add(challengeTTL-time.Second)); !ok || userID != 42 {
+		t.Fatalf("before expiry: got %d, %v", userID, ok)
+	}
+	if _, ok := signer.verifyChallenge(token, issued.Add(challengeTTL)); ok {
+		t.Fatal("accepted an expired challenge")
+	}
+	forged, _ := signer.signChallenge(43, issued)
+	if _, ok := signer.verifyChallenge("42"+forged[2:], issued); ok {
+		t.Fatal("accepted a tampered challenge")
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "totp@example.com", "password123")
+	raw := enableTestTOTP(t, a, user.ID)
+
+	rec := serve(a.handleLogin, jsonRequest(t, http.MethodPost, "/api/auth/login", "", LoginInput{Email: user.Email, Password: "password123"}))
+	var challenge TwoFactorChallengeResponse
+	decodeBody(t, rec, &challenge)
+	if rec.Code != http.StatusOK || !challenge.TwoFactorRequired {
+		t.Fatalf("login: status %d %+v", rec.Code, challenge)
+	}
+	if _, err := time.Parse(time.RFC3339, challenge.ExpiresAt); err != nil {
+		t.Fatalf("expiresAt %q: %v", challenge.ExpiresAt, err)
+	}
+
+	code := totpCode(raw, time.Now().Unix()/totpPeriod)
+	rec = serve(a.handleTwoFactorLogin, jsonRequest(t, http.MethodPost, "/api/auth/login/2fa", "", TwoFactorLoginInput{ChallengeToken: challenge.ChallengeToken, Code: code}))
+	var login LoginResponse
+	decodeBody(t, rec, &login)
+	if rec.Code != http.StatusOK || login.Token == "" {
+		t.Fatalf("2fa: status %d %+v", rec.Code, login)
+	}
+
+	rec = serve(a.handleTwoFactorLogin, jsonRequest(t, http.MethodPost, "/api/auth/login/2fa", "", TwoFactorLoginInput{ChallengeToken: challenge.ChallengeToken, Code: code}))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed code: status %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTwoFactorActionsWithoutPasswordNeedRecentLogin(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		loggedIn time.Duration
+		status   int
+	}{
+		{"recent login", time.Minute, http.StatusOK},
+		{"old login", 2 * recentLoginWindow, http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, _ := newTestApp(t)
+			user := User{Name: "Social", Email: "social@example.com", Username: "social", EmailVerified: true}
+			if err := a.repos.Users.Create(&user); err != nil {
+				t.Fatal(err)
+			}
+			enableTestTOTP(t, a, user.ID)
+			session := loginSession(t, a, user.ID)
+			if _, err := a.db.Exec(
+				"UPDATE sessions SET created_at = ? WHERE user_id = ?",
+				sqliteTime(time.Now().Add(-tc.loggedIn)),
+				user.ID,
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			rec := serve(a.requireAuth(scopeSessionOnly, a.handleRegenerateRecoveryCodes), jsonRequest(t, http.MethodPost, "/api/auth/profile/2fa/recovery-codes", session, TwoFactorPasswordInput{}))
+			if rec.Code != tc.status {
+				t.Fatalf("regenerate recovery codes: status %d %s", rec.Code, rec.Body)
+			}
+			rec = serve(a.requireAuth(scopeSessionOnly, a.handleTwoFactorDisable), jsonRequest(t, http.MethodPost, "/api/auth/profile/2fa/disable", session, TwoFactorPasswordInput{}))
+			if rec.Code != tc.status {
+				t.Fatalf("disable: status %d %s", rec.Code, rec.Body)
+			}
+			if enabled, err := a.twoFactorEnabled(user.ID); err != nil || enabled != (tc.status != http.StatusOK) {
+				t.Fatalf("enabled after disable: got %v, %v", enabled, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Validation error codes returned in FieldError.Code. Clients should branch
+// on these rather than on Message.
+const (
+	codeRequired     = "required"
+	codeInvalidValue = "invalid_value"
+	codeOutOfRange   = "out_of_range"
+	codeTooLong      = "too_long"
+	codeDuplicate    = "duplicate"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ValidationErrors []FieldError
+
+func (v *ValidationErrors) add(field string, code string, message string) {
+	*v = append(*v, FieldError{Field: field, Code: code, Message: message})
+}
+
+type validationErrorResponse struct {
+	Error  string           `json:"error"`
+	Fields ValidationErrors `json:"fields"`
+}
+
+// writeValidationErrors keeps the usual "error" key so callers that only read
+// it keep working, and lists every failing field under "fields".
+func writeValidationErrors(w http.ResponseWriter, status int, errs ValidationErrors) {
+	writeJSON(w, status, validationErrorResponse{Error: "validation failed", Fields: errs})
+}
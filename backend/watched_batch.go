@@ -0,0 +1,309 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxWatchedBatch caps the items of one batch after a season or show has been
+// expanded into episodes. Long-running shows stay well below it.
+const maxWatchedBatch = 2000
+
+type WatchedBatchInput struct {
+	// Action is "mark" (the default) or "unmark".
+	Action string `json:"action"`
+	UserID int64  `json:"userId"`
+	// Items lists arbitrary movies and episodes. Without it the batch covers
+	// the episodes of show TmdbID: one season, or every regular season when
+	// SeasonNumber is omitted, optionally limited to an episode range.
+	Items        []WatchedInput `json:"items"`
+	TmdbID       int64          `json:"tmdbId"`
+	SeasonNumber *int64         `json:"seasonNumber"`
+	FromEpisode  int64          `json:"fromEpisode"`
+	ToEpisode    int64          `json:"toEpisode"`
+	// WatchedAt and Rewatch apply to every item that does not set its own.
+	WatchedAt string `json:"watchedAt"`
+	Rewatch   bool   `json:"rewatch"`
+}
+
+type WatchedBatchResult struct {
+	MediaType     string `json:"mediaType"`
+	TmdbID        int64  `json:"tmdbId"`
+	SeasonNumber  int64  `json:"seasonNumber"`
+	EpisodeNumber int64  `json:"episodeNumber"`
+	Status        string `json:"status"`
+	EventID       int64  `json:"eventId,omitempty"`
+	PlayCount     int    `json:"playCount"`
+}
+
+type WatchedBatchResponse struct {
+	Action  string               `json:"action"`
+	Changed int                  `json:"changed"`
+	Results []WatchedBatchResult `json:"results"`
+}
+
+// handleBatchWatched marks or unmarks many items in one transaction. Marking
+// leaves items that were already watched alone unless rewatch is set, so a
+// whole season can be marked without redating episodes seen earlier.
+func (a *App) handleBatchWatched(w http.ResponseWriter, r *http.Request) {
+	var in WatchedBatchInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+
+	userID, err := resolveUserID(r, in.UserID)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	in.Action = strings.ToLower(strings.TrimSpace(in.Action))
+	if in.Action == "" {
+		in.Action = "mark"
+	}
+	unmark := in.Action == "unmark"
+
+	var errs ValidationErrors
+	if in.Action != "mark" && !unmark {
+		errs.add("action", codeInvalidValue, "action must be mark or unmark")
+	}
+	watchedAt, err := normalizeWatchedAt(in.WatchedAt)
+	if err != nil {
+		errs.add("watchedAt", codeInvalidValue, err.Error())
+	}
+
+	switch {
+	case len(in.Items) > 0 && in.TmdbID != 0:
+		errs.add("items", codeInvalidValue, "send either items or tmdbId, not both")
+	case len(in.Items) > maxWatchedBatch:
+		errs.add("items", codeOutOfRange, fmt.Sprintf("items must have at most %d entries", maxWatchedBatch))
+	case len(in.Items) == 0 && in.TmdbID <= 0:
+		errs.add("tmdbId", codeRequired, "tmdbId or items is required")
+	case len(in.Items) == 0:
+		validateWatchedScope(&errs, in)
+	}
+
+	changes := make([]WatchedChange, 0, len(in.Items))
+	for i, item := range in.Items {
+		field := fmt.Sprintf("items[%d]", i)
+		if item.UserID != 0 && item.UserID != userID {
+			writeError(w, http.StatusForbidden, "userId does not match session")
+			return
+		}
+		item.UserID = userID
+		if err := normalizeWatchedInput(&item); err != nil {
+			errs.add(field, codeInvalidValue, err.Error())
+			continue
+		}
+		at := watchedAt
+		if strings.TrimSpace(item.WatchedAt) != "" {
+			if at, err = normalizeWatchedAt(item.WatchedAt); err != nil {
+				errs.add(field+".watchedAt", codeInvalidValue, err.Error())
+				continue
+			}
+		}
+		changes = append(changes, WatchedChange{
+			WatchEvent: WatchEvent{
+				UserID:        item.UserID,
+				MediaType:     item.MediaType,
+				TmdbID:        item.TmdbID,
+				SeasonNumber:  item.SeasonNumber,
+				EpisodeNumber: item.EpisodeNumber,
+				WatchedAt:     at,
+			},
+			Unmark:  unmark,
+			Rewatch: in.Rewatch || item.Rewatch,
+		})
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	if len(in.Items) == 0 {
+		var ok bool
+		if unmark {
+			changes, ok = a.watchedScopeUnmarks(w, in, userID)
+		} else {
+			changes, ok = a.watchedScopeMarks(w, r, in, userID, watchedAt)
+		}
+		if !ok {
+			return
+		}
+		if len(changes) > maxWatchedBatch {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("batch covers more than %d episodes", maxWatchedBatch))
+			return
+		}
+	}
+
+	results, err := a.repos.Watched.ApplyChanges(changes)
+	if err != nil {
+		log.Printf("watched batch for user %d failed: %v", userID, err)
+		writeError(w, http.StatusInternalServerError, "failed to save watched status")
+		return
+	}
+
+	type title struct {
+		mediaType string
+		tmdbID    int64
+	}
+	var touched []title
+	out := WatchedBatchResponse{Action: in.Action, Results: make([]WatchedBatchResult, 0, len(results))}
+	for i, result := range results {
+		ev := changes[i].WatchEvent
+		if result.Status == watchCreated || result.Status == watchRewatched || result.Status == watchDeleted {
+			out.Changed++
+			if t := (title{ev.MediaType, ev.TmdbID}); !slices.Contains(touched, t) {
+				touched = append(touched, t)
+			}
+		}
+		out.Results = append(out.Results, WatchedBatchResult{
+			MediaType:     ev.MediaType,
+			TmdbID:        ev.TmdbID,
+			SeasonNumber:  ev.SeasonNumber,
+			EpisodeNumber: ev.EpisodeNumber,
+			Status:        result.Status,
+			EventID:       result.EventID,
+			PlayCount:     result.PlayCount,
+		})
+	}
+	for _, t := range touched {
+		a.inferTitleStatus(userID, t.mediaType, t.tmdbID, !unmark)
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func validateWatchedScope(errs *ValidationErrors, in WatchedBatchInput) {
+	if in.SeasonNumber != nil && *in.SeasonNumber <= 0 {
+		errs.add("seasonNumber", codeOutOfRange, "seasonNumber must be positive")
+	}
+	if in.FromEpisode < 0 {
+		errs.add("fromEpisode", codeOutOfRange, "fromEpisode must be positive")
+	}
+	if in.ToEpisode < 0 {
+		errs.add("toEpisode", codeOutOfRange, "toEpisode must be positive")
+	}
+	if (in.FromEpisode > 0 || in.ToEpisode > 0) && in.SeasonNumber == nil {
+		errs.add("seasonNumber", codeRequired, "seasonNumber is required with an episode range")
+	}
+	switch {
+	case in.ToEpisode > 0 && in.FromEpisode > in.ToEpisode:
+		errs.add("toEpisode", codeOutOfRange, "toEpisode must not be before fromEpisode")
+	case in.ToEpisode-max(in.FromEpisode, 1)+1 > maxWatchedBatch:
+		// Checked before expanding the range, which would otherwise allocate
+		// one change per episode asked for.
+		errs.add("toEpisode", codeOutOfRange, fmt.Sprintf("episode range must cover at most %d episodes", maxWatchedBatch))
+	}
+}
+
+func inEpisodeRange(in WatchedBatchInput, episode int64) bool {
+	return episode >= in.FromEpisode && (in.ToEpisode == 0 || episode <= in.ToEpisode)
+}
+
+// watchedScopeUnmarks covers what the user has watched in the scope, so it
+// needs no episode list. Without a range, season and show marks go too.
+func (a *App) watchedScopeUnmarks(w http.ResponseWriter, in WatchedBatchInput, userID int64) ([]WatchedChange, bool) {
+	records, err := a.repos.Watched.List(WatchedFilter{
+		UserID:       userID,
+		MediaType:    "tv",
+		TmdbID:       in.TmdbID,
+		SeasonNumber: in.SeasonNumber,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list watched items")
+		return nil, false
+	}
+
+	ranged := in.FromEpisode > 0 || in.ToEpisode > 0
+	changes := make([]WatchedChange, 0, len(records))
+	for _, rec := range records {
+		if ranged && (rec.EpisodeNumber == 0 || !inEpisodeRange(in, rec.EpisodeNumber)) {
+			continue
+		}
+		changes = append(changes, WatchedChange{
+			WatchEvent: WatchEvent{
+				UserID:        userID,
+				MediaType:     rec.MediaType,
+				TmdbID:        rec.TmdbID,
+				SeasonNumber:  rec.SeasonNumber,
+				EpisodeNumber: rec.EpisodeNumber,
+			},
+			Unmark: true,
+		})
+	}
+	slices.SortFunc(changes, compareWatchedChanges)
+	return changes, true
+}
+
+// watchedScopeMarks lists the episodes to mark. A season with an explicit
+// toEpisode needs nothing else; otherwise episode counts come from TMDB.
+func (a *App) watchedScopeMarks(w http.ResponseWriter, r *http.Request, in WatchedBatchInput, userID int64, watchedAt time.Time) ([]WatchedChange, bool) {
+	episode := func(season, number int64) WatchedChange {
+		return WatchedChange{
+			WatchEvent: WatchEvent{
+				UserID:        userID,
+				MediaType:     "tv",
+				TmdbID:        in.TmdbID,
+				SeasonNumber:  season,
+				EpisodeNumber: number,
+				WatchedAt:     watchedAt,
+			},
+			Rewatch: in.Rewatch,
+		}
+	}
+
+	from := max(in.FromEpisode, 1)
+	var changes []WatchedChange
+	if in.SeasonNumber != nil && in.ToEpisode > 0 {
+		for number := from; number <= in.ToEpisode; number++ {
+			changes = append(changes, episode(*in.SeasonNumber, number))
+		}
+		return changes, true
+	}
+
+	if a.tmdb == nil {
+		writeError(w, http.StatusServiceUnavailable, "episode list unavailable; send seasonNumber with fromEpisode and toEpisode")
+		return nil, false
+	}
+	seasons, err := a.tmdb.Seasons(r.Context(), in.TmdbID)
+	if errors.Is(err, errTMDBNotFound) {
+		writeError(w, http.StatusNotFound, "show not found")
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("failed loading seasons of show %d: %v", in.TmdbID, err)
+		writeError(w, http.StatusBadGateway, "failed to load episode list")
+		return nil, false
+	}
+
+	found := false
+	for _, season := range seasons {
+		// Specials (season 0) are only marked one by one.
+		if season.SeasonNumber <= 0 || (in.SeasonNumber != nil && season.SeasonNumber != *in.SeasonNumber) {
+			continue
+		}
+		found = true
+		for number := from; number <= season.EpisodeCount; number++ {
+			changes = append(changes, episode(season.SeasonNumber, number))
+		}
+	}
+	if in.SeasonNumber != nil && !found {
+		writeError(w, http.StatusNotFound, "season not found")
+		return nil, false
+	}
+	slices.SortFunc(changes, compareWatchedChanges)
+	return changes, true
+}
+
+func compareWatchedChanges(a, b WatchedChange) int {
+	return cmp.Or(cmp.Compare(a.SeasonNumber, b.SeasonNumber), cmp.Compare(a.EpisodeNumber, b.EpisodeNumber))
+}
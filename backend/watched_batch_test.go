@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestValidateWatchedScopeEpisodeRange(t *testing.T) {
+	season := int64(1)
+	for _, tc := range []struct {
+		name  string
+		in    WatchedBatchInput
+		field string
+		code  string
+	}{
+		{"whole season", WatchedBatchInput{TmdbID: 1396, SeasonNumber: &season}, "", ""},
+		{"largest range", WatchedBatchInput{TmdbID: 1396, SeasonNumber: &season, ToEpisode: maxWatchedBatch}, "", ""},
+		{"largest range with an offset", WatchedBatchInput{TmdbID: 1396, SeasonNumber: &season, FromEpisode: 11, ToEpisode: maxWatchedBatch + 10}, "", ""},
+		{"range too long", WatchedBatchInput{TmdbID: 1396, SeasonNumber: &season, ToEpisode: maxWatchedBatch + 1}, "toEpisode", codeOutOfRange},
+		{"huge range", WatchedBatchInput{TmdbID: 1396, SeasonNumber: &season, ToEpisode: 2000000000}, "toEpisode", codeOutOfRange},
+		{"reversed range", WatchedBatchInput{TmdbID: 1396, SeasonNumber: &season, FromEpisode: 5, ToEpisode: 2}, "toEpisode", codeOutOfRange},
+		{"range without season", WatchedBatchInput{TmdbID: 1396, ToEpisode: 2}, "seasonNumber", codeRequired},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var errs ValidationErrors
+			validateWatchedScope(&errs, tc.in)
+			if tc.field == "" {
+				if len(errs) > 0 {
+					t.Fatalf("unexpected errors %+v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Field != tc.field || errs[0].Code != tc.code {
+				t.Fatalf("got %+v, want %s on %s", errs, tc.code, tc.field)
+			}
+		})
+	}
+}
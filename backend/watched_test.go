@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// watchedPages walks GET /api/user/watched with limit and returns every item
+// seen and the number of pages it took.
+func watchedPages(t *testing.T, a *App, session string, query string, limit int) ([]WatchedItem, int) {
+	t.Helper()
+	list := a.requireAuth(scopeWatchedRead, a.handleListWatched)
+	var (
+		items  []WatchedItem
+		pages  int
+		cursor string
+	)
+	for {
+		target := fmt.Sprintf("/api/user/watched?%s&limit=%d", query, limit)
+		if cursor != "" {
+			target += "&cursor=" + cursor
+		}
+		rec := serve(list, jsonRequest(t, http.MethodGet, target, session, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d %s", target, rec.Code, rec.Body)
+		}
+		var page []WatchedItem
+		decodeBody(t, rec, &page)
+		items = append(items, page...)
+		pages++
+		cursor = rec.Header().Get("X-Next-Cursor")
+		if cursor == "" {
+			return items, pages
+		}
+		if len(page) != limit {
+			t.Fatalf("%s: got %d items on a page with a next cursor", target, len(page))
+		}
+		if pages > 100 {
+			t.Fatal("pagination does not end")
+		}
+	}
+}
+
+func TestListWatchedPagination(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "pages@example.com", "password123")
+	session := loginSession(t, a, user.ID)
+
+	// Three viewings share each timestamp, so pages often split a tie.
+	base := time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC)
+	events := []WatchEvent{
+		{MediaType: "movie", TmdbID: 603, WatchedAt: base},
+		{MediaType: "tv", TmdbID: 1396, SeasonNumber: 1, EpisodeNumber: 2, WatchedAt: base},
+		{MediaType: "tv", TmdbID: 1396, SeasonNumber: 1, EpisodeNumber: 1, WatchedAt: base},
+		{MediaType: "movie", TmdbID: 604, WatchedAt: base.Add(-time.Hour)},
+		{MediaType: "movie", TmdbID: 605, WatchedAt: base.Add(-time.Hour)},
+		{MediaType: "tv", TmdbID: 70523, SeasonNumber: 2, EpisodeNumber: 1, WatchedAt: base.Add(-time.Hour)},
+		{MediaType: "tv", TmdbID: 1396, SeasonNumber: 2, EpisodeNumber: 1, WatchedAt: base.Add(-2 * time.Hour)},
+	}
+	for _, ev := range events {
+		ev.UserID = user.ID
+		if err := a.repos.Watched.AddEvent(&ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := func(item WatchedItem) string {
+		return fmt.Sprintf("%s/%d/%d/%d", item.MediaType, item.TmdbID, item.SeasonNumber, item.EpisodeNumber)
+	}
+	want := []string{
+		"movie/603/0/0", "tv/1396/1/1", "tv/1396/1/2",
+		"movie/604/0/0", "movie/605/0/0", "tv/70523/2/1",
+		"tv/1396/2/1",
+	}
+
+	for _, tc := range []struct {
+		limit int
+		pages int
+	}{
+		{limit: 1, pages: 7},
+		{limit: 2, pages: 4},
+		{limit: 3, pages: 3},
+		{limit: 6, pages: 2},
+		// A last page that is exactly full has no next cursor.
+		{limit: 7, pages: 1},
+		{limit: 100, pages: 1},
+	} {
+		t.Run(fmt.Sprintf("limit %d", tc.limit), func(t *testing.T) {
+			items, pages := watchedPages(t, a, session, "mediaType=all", tc.limit)
+			got := make([]string, 0, len(items))
+			for _, item := range items {
+				got = append(got, key(item))
+			}
+			if !slices.Equal(got, want) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+			if pages != tc.pages {
+				t.Fatalf("got %d pages, want %d", pages, tc.pages)
+			}
+		})
+	}
+
+	t.Run("filtered", func(t *testing.T) {
+		items, _ := watchedPages(t, a, session, "mediaType=tv&tmdbId=1396", 1)
+		if len(items) != 3 || key(items[0]) != "tv/1396/1/1" || key(items[2]) != "tv/1396/2/1" {
+			t.Fatalf("got %+v", items)
+		}
+	})
+}
+
+func TestListWatchedCursors(t *testing.T) {
+	a, _ := newTestApp(t)
+	user := createPasswordUser(t, a, "cursor@example.com", "password123")
+	session := loginSession(t, a, user.ID)
+	list := a.requireAuth(scopeWatchedRead, a.handleListWatched)
+
+	base := time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC)
+	for i := range 4 {
+		ev := WatchEvent{UserID: user.ID, MediaType: "movie", TmdbID: int64(600 + i), WatchedAt: base.Add(-time.Duration(i) * time.Hour)}
+		if err := a.repos.Watched.AddEvent(&ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page := func(cursor string) ([]WatchedItem, int) {
+		t.Helper()
+		rec := serve(list, jsonRequest(t, http.MethodGet, "/api/user/watched?mediaType=all&limit=2&cursor="+cursor, session, nil))
+		var items []WatchedItem
+		if rec.Code == http.StatusOK {
+			decodeBody(t, rec, &items)
+		}
+		return items, rec.Code
+	}
+	cursorAfter := func(tmdbID int64, at time.Time) string {
+		return encodeWatchedCursor(WatchedRecord{MediaType: "movie", TmdbID: tmdbID, WatchedAt: at})
+	}
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+
+	for _, tc := range []struct {
+		name   string
+		cursor string
+		status int
+		first  int64 // tmdb id of the first item on the page
+	}{
+		{"next page", cursorAfter(601, base.Add(-time.Hour)), http.StatusOK, 602},
+		// The viewing the cursor names was removed or moved since; the page
+		// still starts at its old position.
+		{"stale cursor", cursorAfter(650, base.Add(-90*time.Minute)), http.StatusOK, 602},
+		{"cursor past the end", cursorAfter(603, base.Add(-3*time.Hour)), http.StatusOK, 0},
+		{"not base64", "***", http.StatusBadRequest, 0},
+		{"padded base64", encode(`{"w":"2026-01-01T20:00:00Z","m":"movie"}`) + "==", http.StatusBadRequest, 0},
+		{"not json", encode("cursor"), http.StatusBadRequest, 0},
+		{"wrong types", encode(`{"w":"yesterday","m":"movie"}`), http.StatusBadRequest, 0},
+		{"missing time", encode(`{"m":"movie","t":601}`), http.StatusBadRequest, 0},
+		{"missing media type", encode(`{"w":"2026-01-01T20:00:00Z","t":601}`), http.StatusBadRequest, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			items, status := page(tc.cursor)
+			if status != tc.status {
+				t.Fatalf("status %d, want %d", status, tc.status)
+			}
+			switch {
+			case tc.first == 0 && len(items) != 0:
+				t.Fatalf("got %+v, want no items", items)
+			case tc.first != 0 && (len(items) == 0 || items[0].TmdbID != tc.first):
+				t.Fatalf("got %+v, want a page starting at %d", items, tc.first)
+			}
+		})
+	}
+}
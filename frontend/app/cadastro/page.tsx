@@ -60,6 +60,8 @@ export default function CadastroPage() {
         email?: string;
         username?: string;
         photoUrl?: string;
+        token?: string;
+        expiresAt?: string;
         error?: string;
       };
 
@@ -77,6 +79,8 @@ export default function CadastroPage() {
           email: payload.email || email,
           username: payload.username || "",
           photoUrl: payload.photoUrl || "",
+          token: payload.token || "",
+          expiresAt: payload.expiresAt || "",
           loggedAt: new Date().toISOString(),
         }),
       );
@@ -3,6 +3,8 @@
 import Link from "next/link";
 import { useEffect, useMemo, useState } from "react";
 import { getApiBaseUrl } from "../lib/api-base-url";
+import { authHeaders } from "../lib/auth-headers";
+import { fetchAllWatched } from "../lib/watched";
 
 type CastPerson = {
   id: number;
@@ -164,14 +166,11 @@ export default function DetailMenuSections({ cast, mediaType, tmdbId, seasons }:
     }
 
     if (mediaType !== "tv") return;
+    const currentUserId = userId;
 
     async function loadWatched() {
       try {
-        const response = await fetch(
-          `${API_BASE_URL}/api/user/watched?userId=${userId}&mediaType=${mediaType}&tmdbId=${tmdbId}`,
-        );
-        if (!response.ok) return;
-        const data = (await response.json()) as WatchedItem[];
+        const data = await fetchAllWatched<WatchedItem>({ userId: currentUserId, mediaType, tmdbId });
 
         const keys = new Set<string>(
           data
@@ -245,27 +244,35 @@ export default function DetailMenuSections({ cast, mediaType, tmdbId, seasons }:
     if (!userId || episodeToggleLoadingKey) return;
     setEpisodeToggleLoadingKey(loadingKey);
     try {
-      const successfulKeys: string[] = [];
-      for (const key of keys) {
+      const episodes = keys.map((key) => {
         const [seasonNumberRaw, episodeNumberRaw] = key.split(":");
-        const seasonNumber = Number(seasonNumberRaw);
-        const episodeNumber = Number(episodeNumberRaw);
-        const response = await fetch(`${API_BASE_URL}/api/user/watched`, {
-          method: "POST",
-          headers: { "Content-Type": "application/json" },
-          body: JSON.stringify({
-            userId,
-            mediaType: "tv",
-            tmdbId: Number(tmdbId),
-            seasonNumber,
-            episodeNumber,
-            watchedAt,
-          }),
-        });
-        if (response.ok) {
-          successfulKeys.push(key);
-        }
-      }
+        return { seasonNumber: Number(seasonNumberRaw), episodeNumber: Number(episodeNumberRaw) };
+      });
+      // A single episode goes through POST so that an existing date is moved;
+      // several are marked together in one batch.
+      const response =
+        episodes.length === 1
+          ? await fetch(`${API_BASE_URL}/api/user/watched`, {
+              method: "POST",
+              headers: authHeaders({ "Content-Type": "application/json" }),
+              body: JSON.stringify({
+                userId,
+                mediaType: "tv",
+                tmdbId: Number(tmdbId),
+                ...episodes[0],
+                watchedAt,
+              }),
+            })
+          : await fetch(`${API_BASE_URL}/api/user/watched/batch`, {
+              method: "POST",
+              headers: authHeaders({ "Content-Type": "application/json" }),
+              body: JSON.stringify({
+                userId,
+                watchedAt,
+                items: episodes.map((episode) => ({ mediaType: "tv", tmdbId: Number(tmdbId), ...episode })),
+              }),
+            });
+      const successfulKeys = response.ok ? keys : [];
 
       if (!successfulKeys.length) return;
 
@@ -349,7 +356,7 @@ export default function DetailMenuSections({ cast, mediaType, tmdbId, seasons }:
       setEpisodeToggleLoadingKey(key);
       const response = await fetch(`${API_BASE_URL}/api/user/watched`, {
         method: "DELETE",
-        headers: { "Content-Type": "application/json" },
+        headers: authHeaders({ "Content-Type": "application/json" }),
         body: JSON.stringify({
           userId,
           mediaType: "tv",
@@ -3,6 +3,9 @@ import { notFound } from "next/navigation";
 import DetailScrollLock from "./detail-scroll-lock";
 import DetailMenuSections from "./detail-menu-sections";
 import MovieWatchToggle from "./movie-watch-toggle";
+import TitleListPicker from "./title-list-picker";
+import TitleRating from "./title-rating";
+import TitleStatusSelect from "./title-status-select";
 
 type DetailGenre = {
   id: number;
@@ -361,6 +364,9 @@ export default async function DetailPage({ params }: { params: Promise<{ mediaTy
               <div className="detail-poster detail-poster-empty" />
             )}
             {isMovie ? <MovieWatchToggle tmdbId={id} /> : null}
+            <TitleStatusSelect mediaType={tmdbMediaType} tmdbId={id} />
+            <TitleRating mediaType={tmdbMediaType} tmdbId={id} />
+            <TitleListPicker mediaType={tmdbMediaType} tmdbId={id} />
           </div>
 
           <div className="detail-info">
@@ -2,7 +2,7 @@
 
 import Link from "next/link";
 import { useRouter } from "next/navigation";
-import { FormEvent, useState } from "react";
+import { FormEvent, useEffect, useState } from "react";
 import { getApiBaseUrl } from "../lib/api-base-url";
 
 type StatusState = {
@@ -12,10 +12,92 @@ type StatusState = {
 
 const API_BASE_URL = getApiBaseUrl();
 
+type OidcProvider = {
+  id: string;
+  name: string;
+};
+
+export type LoginPayload = {
+  id?: number;
+  name?: string;
+  email?: string;
+  username?: string;
+  photoUrl?: string;
+  token?: string;
+  expiresAt?: string;
+  twoFactorRequired?: boolean;
+  challengeToken?: string;
+  error?: string;
+};
+
+export function storeLogin(payload: LoginPayload) {
+  localStorage.setItem(
+    "tracksm_auth",
+    JSON.stringify({
+      id: payload.id,
+      name: payload.name,
+      email: payload.email,
+      username: payload.username || "",
+      photoUrl: payload.photoUrl || "",
+      token: payload.token || "",
+      expiresAt: payload.expiresAt || "",
+      loggedAt: new Date().toISOString(),
+    }),
+  );
+  window.dispatchEvent(new Event("tracksm-auth-updated"));
+}
+
 export default function LoginForm() {
   const router = useRouter();
   const [isSubmitting, setIsSubmitting] = useState(false);
   const [status, setStatus] = useState<StatusState>({ type: "idle", message: "" });
+  const [challengeToken, setChallengeToken] = useState<string | null>(null);
+  const [providers, setProviders] = useState<OidcProvider[]>([]);
+
+  useEffect(() => {
+    const pendingChallenge = sessionStorage.getItem("tracksm_2fa_challenge");
+    if (pendingChallenge) {
+      sessionStorage.removeItem("tracksm_2fa_challenge");
+      setChallengeToken(pendingChallenge);
+    }
+
+    async function loadProviders() {
+      try {
+        const response = await fetch(`${API_BASE_URL}/api/auth/oidc/providers`);
+        if (!response.ok) return;
+        setProviders((await response.json()) as OidcProvider[]);
+      } catch {
+        // noop
+      }
+    }
+
+    void loadProviders();
+  }, []);
+
+  async function handleProviderLogin(providerId: string) {
+    setIsSubmitting(true);
+    setStatus({ type: "idle", message: "" });
+
+    try {
+      const response = await fetch(`${API_BASE_URL}/api/auth/oidc/${providerId}/start`, { method: "POST" });
+      const payload = (await response.json().catch(() => ({}))) as { authorizationUrl?: string; error?: string };
+      if (!response.ok || !payload.authorizationUrl) {
+        setStatus({ type: "error", message: payload.error || "Nao foi possivel entrar." });
+        setIsSubmitting(false);
+        return;
+      }
+      window.location.assign(payload.authorizationUrl);
+    } catch {
+      setStatus({ type: "error", message: "Erro de conexao com o servidor." });
+      setIsSubmitting(false);
+    }
+  }
+
+  function completeLogin(payload: LoginPayload) {
+    storeLogin(payload);
+    router.push("/");
+    router.refresh();
+  }
 
   async function handleSubmit(event: FormEvent<HTMLFormElement>) {
     event.preventDefault();
@@ -39,33 +121,17 @@ export default function LoginForm() {
         body: JSON.stringify({ email, password }),
       });
 
-      const payload = (await response.json().catch(() => ({}))) as {
-        id?: number;
-        name?: string;
-        email?: string;
-        username?: string;
-        photoUrl?: string;
-        error?: string;
-      };
+      const payload = (await response.json().catch(() => ({}))) as LoginPayload;
+      if (response.ok && payload.twoFactorRequired && payload.challengeToken) {
+        setChallengeToken(payload.challengeToken);
+        return;
+      }
       if (!response.ok || !payload.name || !payload.email) {
         setStatus({ type: "error", message: payload.error || "Nao foi possivel entrar." });
         return;
       }
 
-      localStorage.setItem(
-        "tracksm_auth",
-        JSON.stringify({
-          id: payload.id,
-          name: payload.name,
-          email: payload.email,
-          username: payload.username || "",
-          photoUrl: payload.photoUrl || "",
-          loggedAt: new Date().toISOString(),
-        }),
-      );
-      window.dispatchEvent(new Event("tracksm-auth-updated"));
-      router.push("/");
-      router.refresh();
+      completeLogin(payload);
     } catch {
       setStatus({ type: "error", message: "Erro de conexao com o servidor." });
     } finally {
@@ -73,6 +139,71 @@ export default function LoginForm() {
     }
   }
 
+  async function handleTwoFactorSubmit(event: FormEvent<HTMLFormElement>) {
+    event.preventDefault();
+    const formData = new FormData(event.currentTarget);
+    const code = String(formData.get("code") || "").trim();
+
+    if (!code) {
+      setStatus({ type: "error", message: "Informe o codigo do autenticador." });
+      return;
+    }
+
+    setIsSubmitting(true);
+    setStatus({ type: "idle", message: "" });
+
+    try {
+      const isRecoveryCode = code.replace(/\s/g, "").length !== 6;
+      const response = await fetch(`${API_BASE_URL}/api/auth/login/2fa`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(isRecoveryCode ? { challengeToken, recoveryCode: code } : { challengeToken, code }),
+      });
+
+      const payload = (await response.json().catch(() => ({}))) as LoginPayload;
+      if (!response.ok || !payload.name || !payload.email) {
+        if (response.status === 401 && payload.error !== "invalid code") {
+          setChallengeToken(null);
+        }
+        setStatus({ type: "error", message: payload.error === "invalid code" ? "Codigo invalido." : payload.error || "Nao foi possivel entrar." });
+        return;
+      }
+
+      completeLogin(payload);
+    } catch {
+      setStatus({ type: "error", message: "Erro de conexao com o servidor." });
+    } finally {
+      setIsSubmitting(false);
+    }
+  }
+
+  if (challengeToken) {
+    return (
+      <>
+        <form className="login-form" onSubmit={handleTwoFactorSubmit}>
+          <label className="login-label" htmlFor="code">
+            Codigo de verificacao
+          </label>
+          <input
+            id="code"
+            name="code"
+            type="text"
+            inputMode="numeric"
+            placeholder="Codigo do autenticador ou de recuperacao"
+            autoComplete="one-time-code"
+            required
+          />
+
+          <button type="submit" className="login-submit" disabled={isSubmitting}>
+            {isSubmitting ? "Verificando..." : "Verificar"}
+          </button>
+        </form>
+
+        {status.type === "error" ? <p className="auth-feedback is-error">{status.message}</p> : null}
+      </>
+    );
+  }
+
   return (
     <>
       <form className="login-form" onSubmit={handleSubmit}>
@@ -91,7 +222,7 @@ export default function LoginForm() {
             <input className="login-check-input" type="checkbox" name="remember" />
             Manter conectado
           </label>
-          <Link href="/" className="login-forgot">
+          <Link href="/redefinir-senha" className="login-forgot">
             Esqueci minha senha
           </Link>
         </div>
@@ -99,6 +230,18 @@ export default function LoginForm() {
         <button type="submit" className="login-submit" disabled={isSubmitting}>
           {isSubmitting ? "Entrando..." : "Entrar"}
         </button>
+
+        {providers.map((provider) => (
+          <button
+            key={provider.id}
+            type="button"
+            className="login-submit"
+            disabled={isSubmitting}
+            onClick={() => void handleProviderLogin(provider.id)}
+          >
+            Entrar com {provider.name}
+          </button>
+        ))}
       </form>
 
       {status.type === "error" ? <p className="auth-feedback is-error">{status.message}</p> : null}
@@ -2,6 +2,7 @@
 
 import { useEffect, useState } from "react";
 import { getApiBaseUrl } from "../lib/api-base-url";
+import { authHeaders } from "../lib/auth-headers";
 
 type StoredAuth = {
   id?: number;
@@ -11,6 +12,11 @@ type WatchedItem = {
   seasonNumber: number;
   episodeNumber: number;
   watchedAt?: string;
+  playCount?: number;
+};
+
+type WatchedSaveResponse = {
+  playCount?: number;
 };
 
 type MovieWatchToggleProps = {
@@ -69,6 +75,7 @@ function watchedAtToDateValue(raw?: string): string | null {
 export default function MovieWatchToggle({ tmdbId }: MovieWatchToggleProps) {
   const [userId, setUserId] = useState<number | null>(null);
   const [movieWatched, setMovieWatched] = useState(false);
+  const [playCount, setPlayCount] = useState(0);
   const [isLoading, setIsLoading] = useState(false);
   const [watchedDate, setWatchedDate] = useState<string>(getTodayValue());
   const [isDatePickerOpen, setIsDatePickerOpen] = useState(false);
@@ -100,11 +107,13 @@ export default function MovieWatchToggle({ tmdbId }: MovieWatchToggleProps) {
       try {
         const response = await fetch(
           `${API_BASE_URL}/api/user/watched?userId=${userId}&mediaType=movie&tmdbId=${tmdbId}`,
+          { headers: authHeaders() },
         );
         if (!response.ok) return;
         const data = (await response.json()) as WatchedItem[];
         const movieEntry = data.find((item) => item.seasonNumber === 0 && item.episodeNumber === 0);
         setMovieWatched(Boolean(movieEntry));
+        setPlayCount(movieEntry?.playCount ?? 0);
         const savedDate = watchedAtToDateValue(movieEntry?.watchedAt);
         if (savedDate) {
           setWatchedDate(savedDate);
@@ -148,7 +157,7 @@ export default function MovieWatchToggle({ tmdbId }: MovieWatchToggleProps) {
       const method = movieWatched ? "DELETE" : "POST";
       const response = await fetch(`${API_BASE_URL}/api/user/watched`, {
         method,
-        headers: { "Content-Type": "application/json" },
+        headers: authHeaders({ "Content-Type": "application/json" }),
         body: JSON.stringify({
           userId,
           mediaType: "movie",
@@ -159,6 +168,12 @@ export default function MovieWatchToggle({ tmdbId }: MovieWatchToggleProps) {
         }),
       });
       if (!response.ok) return;
+      if (method === "POST") {
+        const data = (await response.json()) as WatchedSaveResponse;
+        setPlayCount(data.playCount ?? 1);
+      } else {
+        setPlayCount(0);
+      }
       setMovieWatched((prev) => !prev);
     } finally {
       setIsLoading(false);
@@ -171,7 +186,7 @@ export default function MovieWatchToggle({ tmdbId }: MovieWatchToggleProps) {
     try {
       const response = await fetch(`${API_BASE_URL}/api/user/watched`, {
         method: "POST",
-        headers: { "Content-Type": "application/json" },
+        headers: authHeaders({ "Content-Type": "application/json" }),
         body: JSON.stringify({
           userId,
           mediaType: "movie",
@@ -182,6 +197,36 @@ export default function MovieWatchToggle({ tmdbId }: MovieWatchToggleProps) {
         }),
       });
       if (!response.ok) return;
+      const data = (await response.json()) as WatchedSaveResponse;
+      setPlayCount(data.playCount ?? 1);
+      setMovieWatched(true);
+    } finally {
+      setIsLoading(false);
+    }
+  }
+
+  async function addMovieRewatch() {
+    if (!userId || isLoading) return;
+    setIsLoading(true);
+    try {
+      const today = getTodayValue();
+      const response = await fetch(`${API_BASE_URL}/api/user/watched`, {
+        method: "POST",
+        headers: authHeaders({ "Content-Type": "application/json" }),
+        body: JSON.stringify({
+          userId,
+          mediaType: "movie",
+          tmdbId: Number(tmdbId),
+          seasonNumber: 0,
+          episodeNumber: 0,
+          watchedAt: today,
+          rewatch: true,
+        }),
+      });
+      if (!response.ok) return;
+      const data = (await response.json()) as WatchedSaveResponse;
+      setPlayCount(data.playCount ?? playCount + 1);
+      setWatchedDate(today);
       setMovieWatched(true);
     } finally {
       setIsLoading(false);
@@ -295,8 +340,19 @@ export default function MovieWatchToggle({ tmdbId }: MovieWatchToggleProps) {
         onClick={() => void toggleMovieWatched()}
         disabled={!userId || isLoading}
       >
-        {movieWatched ? "Visto" : "Ver"}
+        {movieWatched ? (playCount > 1 ? `Visto ${playCount}x` : "Visto") : "Ver"}
       </button>
+      {movieWatched ? (
+        <button
+          type="button"
+          className="watched-toggle"
+          onClick={() => void addMovieRewatch()}
+          disabled={!userId || isLoading}
+          title="Registrar que voce viu de novo hoje"
+        >
+          Vi de novo
+        </button>
+      ) : null}
       {!userId ? <span className="watched-help">Faca login para marcar.</span> : null}
     </div>
   );
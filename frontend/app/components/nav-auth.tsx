@@ -3,6 +3,8 @@
 import Link from "next/link";
 import { usePathname, useRouter } from "next/navigation";
 import { useEffect, useMemo, useState } from "react";
+import { getApiBaseUrl } from "../lib/api-base-url";
+import { authHeaders } from "../lib/auth-headers";
 
 type StoredAuth = {
   name?: string;
@@ -11,6 +13,7 @@ type StoredAuth = {
 };
 
 const DEFAULT_AVATAR = "/default-avatar.svg";
+const API_BASE_URL = getApiBaseUrl();
 
 export default function NavAuth() {
   const router = useRouter();
@@ -81,6 +84,7 @@ export default function NavAuth() {
   }
 
   function handleLogout() {
+    void fetch(`${API_BASE_URL}/api/auth/logout`, { method: "POST", headers: authHeaders() }).catch(() => undefined);
     localStorage.removeItem("tracksm_auth");
     window.dispatchEvent(new Event("tracksm-auth-updated"));
     setIsOpen(false);
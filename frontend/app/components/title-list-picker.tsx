@@ -0,0 +1,133 @@
+"use client";
+
+import { useEffect, useState } from "react";
+import { getApiBaseUrl } from "../lib/api-base-url";
+import { authHeaders, getAuthToken } from "../lib/auth-headers";
+
+type TitleListPickerProps = {
+  mediaType: "movie" | "tv";
+  tmdbId: string;
+};
+
+type UserList = {
+  id: number;
+  name: string;
+  itemCount: number;
+};
+
+const API_BASE_URL = getApiBaseUrl();
+
+export default function TitleListPicker({ mediaType, tmdbId }: TitleListPickerProps) {
+  const [loggedIn, setLoggedIn] = useState(false);
+  const [lists, setLists] = useState<UserList[]>([]);
+  const [listId, setListId] = useState("");
+  const [newName, setNewName] = useState("");
+  const [message, setMessage] = useState("");
+  const [isSaving, setIsSaving] = useState(false);
+
+  async function loadLists() {
+    try {
+      const response = await fetch(`${API_BASE_URL}/api/user/lists`, { headers: authHeaders() });
+      if (!response.ok) return;
+      const data = (await response.json()) as UserList[];
+      setLists(data);
+    } catch {
+      // noop
+    }
+  }
+
+  useEffect(() => {
+    const hasToken = Boolean(getAuthToken());
+    setLoggedIn(hasToken);
+    if (hasToken) void loadLists();
+  }, []);
+
+  async function addToList(targetId: number) {
+    const response = await fetch(`${API_BASE_URL}/api/user/lists/${targetId}/items`, {
+      method: "POST",
+      headers: authHeaders({ "Content-Type": "application/json" }),
+      body: JSON.stringify({ mediaType, tmdbId: Number(tmdbId) }),
+    });
+    if (response.status === 409) {
+      setMessage("Ja esta nesta lista.");
+      return;
+    }
+    if (!response.ok) {
+      setMessage("Nao foi possivel adicionar.");
+      return;
+    }
+    setMessage("Adicionado a lista.");
+    await loadLists();
+  }
+
+  async function handleAdd() {
+    if (isSaving) return;
+    setIsSaving(true);
+    setMessage("");
+    try {
+      const name = newName.trim();
+      if (name) {
+        const response = await fetch(`${API_BASE_URL}/api/user/lists`, {
+          method: "POST",
+          headers: authHeaders({ "Content-Type": "application/json" }),
+          body: JSON.stringify({ name }),
+        });
+        if (!response.ok) {
+          setMessage("Nao foi possivel criar a lista.");
+          return;
+        }
+        const created = (await response.json()) as UserList;
+        setNewName("");
+        setListId(String(created.id));
+        await addToList(created.id);
+      } else if (listId) {
+        await addToList(Number(listId));
+      }
+    } catch {
+      setMessage("Nao foi possivel adicionar.");
+    } finally {
+      setIsSaving(false);
+    }
+  }
+
+  if (!loggedIn) return null;
+
+  return (
+    <div className="title-rating">
+      <label className="title-status-field">
+        <span>Adicionar a lista</span>
+        <select
+          className="title-status-select"
+          value={listId}
+          disabled={isSaving}
+          onChange={(event) => setListId(event.target.value)}
+        >
+          <option value="">Escolha uma lista</option>
+          {lists.map((list) => (
+            <option key={list.id} value={list.id}>
+              {list.name} ({list.itemCount})
+            </option>
+          ))}
+        </select>
+      </label>
+      <input
+        className="title-status-select"
+        placeholder="Ou crie uma nova lista"
+        value={newName}
+        maxLength={100}
+        onChange={(event) => setNewName(event.target.value)}
+      />
+      <div className="title-rating-actions">
+        <button
+          type="button"
+          className="watched-toggle"
+          disabled={isSaving || (!listId && !newName.trim())}
+          onClick={() => void handleAdd()}
+        >
+          Adicionar
+        </button>
+      </div>
+      {message ? <span className="watched-help">{message}</span> : null}
+    </div>
+  );
+}
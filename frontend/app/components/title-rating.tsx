@@ -0,0 +1,181 @@
+"use client";
+
+import { useEffect, useState } from "react";
+import { getApiBaseUrl } from "../lib/api-base-url";
+import { authHeaders } from "../lib/auth-headers";
+
+type StoredAuth = {
+  id?: number;
+};
+
+type TitleRatingProps = {
+  mediaType: "movie" | "tv";
+  tmdbId: string;
+};
+
+type RatingItem = {
+  rating: number;
+  review: string;
+  spoiler: boolean;
+};
+
+type RatingSummary = {
+  seasonNumber: number;
+  episodeNumber: number;
+  average: number;
+  count: number;
+};
+
+const API_BASE_URL = getApiBaseUrl();
+const RATING_VALUES = Array.from({ length: 10 }, (_, index) => (index + 1) / 2);
+
+export default function TitleRating({ mediaType, tmdbId }: TitleRatingProps) {
+  const [userId, setUserId] = useState<number | null>(null);
+  const [rating, setRating] = useState(0);
+  const [review, setReview] = useState("");
+  const [spoiler, setSpoiler] = useState(false);
+  const [saved, setSaved] = useState(false);
+  const [summary, setSummary] = useState<RatingSummary | null>(null);
+  const [isSaving, setIsSaving] = useState(false);
+
+  const itemQuery = `mediaType=${mediaType}&tmdbId=${tmdbId}&seasonNumber=0&episodeNumber=0&scale=5`;
+
+  useEffect(() => {
+    try {
+      const raw = localStorage.getItem("tracksm_auth");
+      const parsed = raw ? (JSON.parse(raw) as StoredAuth) : null;
+      setUserId(typeof parsed?.id === "number" ? parsed.id : null);
+    } catch {
+      setUserId(null);
+    }
+  }, []);
+
+  async function loadSummary() {
+    try {
+      const response = await fetch(
+        `${API_BASE_URL}/api/ratings/${mediaType}/${tmdbId}?seasonNumber=0&episodeNumber=0&scale=5`,
+        { headers: authHeaders() },
+      );
+      if (!response.ok) return;
+      const data = (await response.json()) as RatingSummary[];
+      setSummary(data[0] ?? null);
+    } catch {
+      // noop
+    }
+  }
+
+  useEffect(() => {
+    if (!userId) return;
+
+    async function loadRating() {
+      try {
+        const response = await fetch(`${API_BASE_URL}/api/users/${userId}/ratings?${itemQuery}`, {
+          headers: authHeaders(),
+        });
+        if (!response.ok) return;
+        const data = (await response.json()) as RatingItem[];
+        const own = data[0];
+        setSaved(Boolean(own));
+        setRating(own?.rating ?? 0);
+        setReview(own?.review ?? "");
+        setSpoiler(own?.spoiler ?? false);
+      } catch {
+        // noop
+      }
+    }
+
+    void loadRating();
+    void loadSummary();
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [userId, itemQuery]);
+
+  async function saveRating() {
+    if (isSaving || !rating) return;
+    setIsSaving(true);
+    try {
+      const response = await fetch(`${API_BASE_URL}/api/user/ratings`, {
+        method: "PUT",
+        headers: authHeaders({ "Content-Type": "application/json" }),
+        body: JSON.stringify({ mediaType, tmdbId: Number(tmdbId), rating, scale: 5, review, spoiler }),
+      });
+      if (!response.ok) return;
+      setSaved(true);
+      await loadSummary();
+    } catch {
+      // noop
+    } finally {
+      setIsSaving(false);
+    }
+  }
+
+  async function removeRating() {
+    if (isSaving) return;
+    setIsSaving(true);
+    try {
+      const response = await fetch(`${API_BASE_URL}/api/user/ratings`, {
+        method: "DELETE",
+        headers: authHeaders({ "Content-Type": "application/json" }),
+        body: JSON.stringify({ mediaType, tmdbId: Number(tmdbId) }),
+      });
+      if (!response.ok && response.status !== 404) return;
+      setSaved(false);
+      setRating(0);
+      setReview("");
+      setSpoiler(false);
+      await loadSummary();
+    } catch {
+      // noop
+    } finally {
+      setIsSaving(false);
+    }
+  }
+
+  if (!userId) return null;
+
+  return (
+    <div className="title-rating">
+      <label className="title-status-field">
+        <span>Sua nota</span>
+        <select
+          className="title-status-select"
+          value={rating}
+          disabled={isSaving}
+          onChange={(event) => setRating(Number(event.target.value))}
+        >
+          <option value={0}>Sem nota</option>
+          {RATING_VALUES.map((value) => (
+            <option key={value} value={value}>
+              {value.toFixed(1)} / 5
+            </option>
+          ))}
+        </select>
+      </label>
+      <textarea
+        className="title-rating-review"
+        placeholder="Escreva uma resenha (opcional)"
+        value={review}
+        maxLength={10000}
+        onChange={(event) => setReview(event.target.value)}
+      />
+      <label className="title-rating-spoiler">
+        <input type="checkbox" checked={spoiler} onChange={(event) => setSpoiler(event.target.checked)} />
+        Contem spoilers
+      </label>
+      <div className="title-rating-actions">
+        <button type="button" className="watched-toggle" disabled={isSaving || !rating} onClick={() => void saveRating()}>
+          Salvar nota
+        </button>
+        {saved ? (
+          <button type="button" className="watched-toggle" disabled={isSaving} onClick={() => void removeRating()}>
+            Remover
+          </button>
+        ) : null}
+      </div>
+      {summary ? (
+        <span className="watched-help">
+          Media da comunidade: {summary.average.toFixed(1)} / 5 ({summary.count} {summary.count === 1 ? "nota" : "notas"})
+        </span>
+      ) : null}
+    </div>
+  );
+}
@@ -0,0 +1,99 @@
+"use client";
+
+import { useEffect, useState } from "react";
+import { getApiBaseUrl } from "../lib/api-base-url";
+import { authHeaders, getAuthToken } from "../lib/auth-headers";
+
+type TitleStatusSelectProps = {
+  mediaType: "movie" | "tv";
+  tmdbId: string;
+};
+
+type TitleStatusResponse = {
+  status?: string;
+  inferred?: boolean;
+};
+
+const API_BASE_URL = getApiBaseUrl();
+
+const STATUS_LABELS: Record<string, string> = {
+  watchlist: "Quero ver",
+  watching: "Assistindo",
+  paused: "Pausado",
+  dropped: "Abandonado",
+  completed: "Concluido",
+};
+
+export default function TitleStatusSelect({ mediaType, tmdbId }: TitleStatusSelectProps) {
+  const [loggedIn, setLoggedIn] = useState(false);
+  const [status, setStatus] = useState("");
+  const [inferred, setInferred] = useState(false);
+  const [isSaving, setIsSaving] = useState(false);
+
+  const endpoint = `${API_BASE_URL}/api/user/titles/${mediaType}/${tmdbId}`;
+
+  useEffect(() => {
+    const hasToken = Boolean(getAuthToken());
+    setLoggedIn(hasToken);
+    if (!hasToken) return;
+
+    async function loadStatus() {
+      try {
+        const response = await fetch(endpoint, { headers: authHeaders() });
+        if (!response.ok) {
+          setStatus("");
+          return;
+        }
+        const data = (await response.json()) as TitleStatusResponse;
+        setStatus(data.status ?? "");
+        setInferred(Boolean(data.inferred));
+      } catch {
+        // noop
+      }
+    }
+
+    void loadStatus();
+  }, [endpoint]);
+
+  async function changeStatus(next: string) {
+    if (isSaving) return;
+    setIsSaving(true);
+    try {
+      const response = next
+        ? await fetch(endpoint, {
+            method: "PUT",
+            headers: authHeaders({ "Content-Type": "application/json" }),
+            body: JSON.stringify({ status: next }),
+          })
+        : await fetch(endpoint, { method: "DELETE", headers: authHeaders() });
+      if (!response.ok && response.status !== 404) return;
+      setStatus(next);
+      setInferred(false);
+    } catch {
+      // noop
+    } finally {
+      setIsSaving(false);
+    }
+  }
+
+  if (!loggedIn) return null;
+
+  return (
+    <label className="title-status-field">
+      <span>Status{inferred ? " (automatico)" : ""}</span>
+      <select
+        className="title-status-select"
+        value={status}
+        disabled={isSaving}
+        onChange={(event) => void changeStatus(event.target.value)}
+      >
+        <option value="">Sem status</option>
+        {Object.entries(STATUS_LABELS).map(([value, label]) => (
+          <option key={value} value={value}>
+            {label}
+          </option>
+        ))}
+      </select>
+    </label>
+  );
+}
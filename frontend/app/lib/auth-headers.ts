@@ -0,0 +1,18 @@
+"use client";
+
+export function getAuthToken(): string {
+  try {
+    const raw = localStorage.getItem("tracksm_auth");
+    if (!raw) return "";
+    const parsed = JSON.parse(raw) as { token?: string };
+    return typeof parsed.token === "string" ? parsed.token : "";
+  } catch {
+    return "";
+  }
+}
+
+export function authHeaders(headers: Record<string, string> = {}): Record<string, string> {
+  const token = getAuthToken();
+  if (!token) return headers;
+  return { ...headers, Authorization: `Bearer ${token}` };
+}
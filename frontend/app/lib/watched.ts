@@ -0,0 +1,31 @@
+"use client";
+
+import { getApiBaseUrl } from "./api-base-url";
+import { authHeaders } from "./auth-headers";
+
+const PAGE_SIZE = 1000;
+
+// fetchAllWatched follows X-Next-Cursor until GET /api/user/watched has no
+// more pages. query holds the filters, e.g. { mediaType: "all" }.
+export async function fetchAllWatched<T>(query: Record<string, string | number>): Promise<T[]> {
+  const items: T[] = [];
+  let cursor = "";
+  do {
+    const params = new URLSearchParams();
+    for (const [key, value] of Object.entries(query)) {
+      params.set(key, String(value));
+    }
+    params.set("limit", String(PAGE_SIZE));
+    if (cursor) params.set("cursor", cursor);
+
+    const response = await fetch(`${getApiBaseUrl()}/api/user/watched?${params.toString()}`, {
+      headers: authHeaders(),
+    });
+    if (!response.ok) {
+      throw new Error(`watched list returned ${response.status}`);
+    }
+    items.push(...((await response.json()) as T[]));
+    cursor = response.headers.get("X-Next-Cursor") ?? "";
+  } while (cursor);
+  return items;
+}
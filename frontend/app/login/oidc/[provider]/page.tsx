@@ -0,0 +1,73 @@
+"use client";
+
+import Link from "next/link";
+import { useParams, useRouter } from "next/navigation";
+import { useEffect, useState } from "react";
+import { storeLogin, type LoginPayload } from "../../../components/login-form";
+import { getApiBaseUrl } from "../../../lib/api-base-url";
+
+const API_BASE_URL = getApiBaseUrl();
+
+export default function OidcCallbackPage() {
+  const router = useRouter();
+  const params = useParams<{ provider: string }>();
+  const [errorMessage, setErrorMessage] = useState<string | null>(null);
+
+  useEffect(() => {
+    const search = new URLSearchParams(window.location.search);
+    const code = search.get("code") || "";
+    const state = search.get("state") || "";
+    if (!code || !state) {
+      setErrorMessage(search.get("error_description") || "Login cancelado.");
+      return;
+    }
+
+    async function complete() {
+      try {
+        const response = await fetch(`${API_BASE_URL}/api/auth/oidc/${params.provider}/callback`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ code, state }),
+        });
+        const payload = (await response.json().catch(() => ({}))) as LoginPayload;
+
+        if (response.ok && payload.twoFactorRequired && payload.challengeToken) {
+          sessionStorage.setItem("tracksm_2fa_challenge", payload.challengeToken);
+          router.replace("/login");
+          return;
+        }
+        if (!response.ok || !payload.name || !payload.email) {
+          setErrorMessage(payload.error || "Nao foi possivel entrar.");
+          return;
+        }
+
+        storeLogin(payload);
+        router.replace("/");
+        router.refresh();
+      } catch {
+        setErrorMessage("Erro de conexao com o servidor.");
+      }
+    }
+
+    void complete();
+  }, [params.provider, router]);
+
+  return (
+    <main className="login-page">
+      <section className="login-card" aria-label="Acesso a conta">
+        <p className="login-kicker">Acesso</p>
+        <h1 className="login-title">Entrar no TrackSM</h1>
+
+        <p className={`auth-feedback ${errorMessage ? "is-error" : "is-success"}`}>
+          {errorMessage || "Concluindo login..."}
+        </p>
+
+        <p className="login-footer">
+          <Link href="/login" className="login-footer-link">
+            Voltar para o login
+          </Link>
+        </p>
+      </section>
+    </main>
+  );
+}
@@ -3,6 +3,8 @@
 import Link from "next/link";
 import { WheelEvent, useEffect, useMemo, useState } from "react";
 import { getApiBaseUrl } from "../lib/api-base-url";
+import { authHeaders } from "../lib/auth-headers";
+import { fetchAllWatched } from "../lib/watched";
 
 type StoredAuth = {
   id?: number;
@@ -11,6 +13,7 @@ type StoredAuth = {
 };
 
 type WatchedItem = {
+  mediaType: "movie" | "tv";
   tmdbId: number;
   seasonNumber: number;
   episodeNumber: number;
@@ -270,20 +273,11 @@ export default function MinhasSeriesPage() {
       setIsLoading(true);
       setErrorMessage(null);
       try {
-        const [watchedTvResponse, watchedMovieResponse] = await Promise.all([
-          fetch(`${API_BASE_URL}/api/user/watched?userId=${userId}&mediaType=tv`),
-          fetch(`${API_BASE_URL}/api/user/watched?userId=${userId}&mediaType=movie`),
-        ]);
-
-        if (!watchedTvResponse.ok) {
-          throw new Error("Nao foi possivel carregar episodios assistidos.");
-        }
-        if (!watchedMovieResponse.ok) {
-          throw new Error("Nao foi possivel carregar filmes vistos.");
-        }
-
-        const watchedTvItems = (await watchedTvResponse.json()) as WatchedItem[];
-        const watchedMovieItems = (await watchedMovieResponse.json()) as WatchedItem[];
+        const watchedItems = await fetchAllWatched<WatchedItem>({ userId, mediaType: "all" }).catch(() => {
+          throw new Error("Nao foi possivel carregar os itens assistidos.");
+        });
+        const watchedTvItems = watchedItems.filter((item) => item.mediaType === "tv");
+        const watchedMovieItems = watchedItems.filter((item) => item.mediaType === "movie");
 
         const tvWatchedEpisodes = watchedTvItems.filter((item) => item.seasonNumber > 0 && item.episodeNumber > 0);
         setWatchedTvItems(tvWatchedEpisodes);
@@ -417,7 +411,7 @@ export default function MinhasSeriesPage() {
     try {
       const response = await fetch(`${API_BASE_URL}/api/user/watched`, {
         method: "POST",
-        headers: { "Content-Type": "application/json" },
+        headers: authHeaders({ "Content-Type": "application/json" }),
         body: JSON.stringify({
           userId: auth.id,
           mediaType: "tv",
@@ -3,6 +3,7 @@
 import Link from "next/link";
 import { ChangeEvent, FormEvent, useEffect, useMemo, useState } from "react";
 import { getApiBaseUrl } from "../lib/api-base-url";
+import { authHeaders } from "../lib/auth-headers";
 
 type StoredAuth = {
   id?: number;
@@ -10,6 +11,8 @@ type StoredAuth = {
   email?: string;
   username?: string;
   photoUrl?: string;
+  token?: string;
+  expiresAt?: string;
 };
 
 type StatusState = {
@@ -64,30 +67,49 @@ export default function PerfilPage() {
 
   const avatarPreview = useMemo(() => photoUrl.trim() || DEFAULT_AVATAR, [photoUrl]);
 
-  function handlePhotoUpload(event: ChangeEvent<HTMLInputElement>) {
+  async function handlePhotoUpload(event: ChangeEvent<HTMLInputElement>) {
     const file = event.target.files?.[0];
-    if (!file) return;
+    event.target.value = "";
+    if (!file || !auth) return;
     if (!file.type.startsWith("image/")) {
       setStatus({ type: "error", message: "Selecione um arquivo de imagem valido." });
       return;
     }
-    if (file.size > 2_500_000) {
-      setStatus({ type: "error", message: "A imagem deve ter no maximo 2.5 MB." });
+    if (file.size > 5_000_000) {
+      setStatus({ type: "error", message: "A imagem deve ter no maximo 5 MB." });
       return;
     }
 
-    const reader = new FileReader();
-    reader.onload = () => {
-      const result = typeof reader.result === "string" ? reader.result : "";
-      if (!result) {
-        setStatus({ type: "error", message: "Nao foi possivel ler a imagem." });
+    const body = new FormData();
+    body.append("avatar", file);
+
+    setIsSaving(true);
+    setStatus({ type: "idle", message: "" });
+    try {
+      const response = await fetch(`${API_BASE_URL}/api/auth/avatar`, {
+        method: "POST",
+        headers: authHeaders(),
+        body,
+      });
+      const payload = (await response.json().catch(() => ({}))) as { photoUrl?: string; error?: string };
+      if (!response.ok || !payload.photoUrl) {
+        setStatus({ type: "error", message: payload.error || "Nao foi possivel enviar a imagem." });
         return;
       }
-      setPhotoUrl(result);
-      setStatus({ type: "idle", message: "" });
-    };
-    reader.onerror = () => setStatus({ type: "error", message: "Falha ao carregar imagem." });
-    reader.readAsDataURL(file);
+
+      // The photo is saved on upload, so the stored session must follow it.
+      const nextAuth: StoredAuth = { ...auth, photoUrl: payload.photoUrl };
+      localStorage.setItem("tracksm_auth", JSON.stringify(nextAuth));
+      window.dispatchEvent(new Event("tracksm-auth-updated"));
+
+      setAuth(nextAuth);
+      setPhotoUrl(payload.photoUrl);
+      setStatus({ type: "success", message: "Foto atualizada com sucesso." });
+    } catch {
+      setStatus({ type: "error", message: "Erro de conexao com o servidor." });
+    } finally {
+      setIsSaving(false);
+    }
   }
 
   async function handleSubmit(event: FormEvent<HTMLFormElement>) {
@@ -116,7 +138,7 @@ export default function PerfilPage() {
     try {
       const response = await fetch(`${API_BASE_URL}/api/auth/profile`, {
         method: "PATCH",
-        headers: { "Content-Type": "application/json" },
+        headers: authHeaders({ "Content-Type": "application/json" }),
         body: JSON.stringify({
           userId: auth.id,
           name: nextName,
@@ -145,6 +167,8 @@ export default function PerfilPage() {
         name: payload.name,
         username: payload.username || nextUsername,
         photoUrl: payload.photoUrl || "",
+        token: auth.token,
+        expiresAt: auth.expiresAt,
       };
       localStorage.setItem("tracksm_auth", JSON.stringify(nextAuth));
       window.dispatchEvent(new Event("tracksm-auth-updated"));
@@ -186,8 +210,18 @@ export default function PerfilPage() {
           <img className="profile-avatar-preview" src={avatarPreview} alt={`Foto de ${name || "usuario"}`} />
           <label className="profile-file-label">
             Enviar foto
-            <input type="file" accept="image/*" onChange={handlePhotoUpload} />
+            <input
+              type="file"
+              accept="image/jpeg,image/png,image/gif,image/webp"
+              onChange={handlePhotoUpload}
+              disabled={isSaving}
+            />
           </label>
+          {photoUrl ? (
+            <button type="button" className="secondary" onClick={() => setPhotoUrl("")} disabled={isSaving}>
+              Remover foto
+            </button>
+          ) : null}
         </div>
 
         <form className="profile-form" onSubmit={handleSubmit}>
@@ -216,16 +250,6 @@ export default function PerfilPage() {
             />
           </div>
 
-          <label htmlFor="profile-photo-url">Foto (URL ou upload)</label>
-          <input
-            id="profile-photo-url"
-            name="photoUrl"
-            type="url"
-            value={photoUrl}
-            onChange={(event) => setPhotoUrl(event.target.value)}
-            placeholder="https://..."
-          />
-
           <button type="submit" disabled={isSaving}>
             {isSaving ? "Salvando..." : "Salvar alteracoes"}
           </button>
@@ -0,0 +1,160 @@
+"use client";
+
+import Link from "next/link";
+import { useRouter } from "next/navigation";
+import { FormEvent, useEffect, useState } from "react";
+import { getApiBaseUrl } from "../lib/api-base-url";
+
+type StatusState = {
+  type: "idle" | "success" | "error";
+  message: string;
+};
+
+const API_BASE_URL = getApiBaseUrl();
+
+export default function RedefinirSenhaPage() {
+  const router = useRouter();
+  const [token, setToken] = useState("");
+  const [isSubmitting, setIsSubmitting] = useState(false);
+  const [status, setStatus] = useState<StatusState>({ type: "idle", message: "" });
+
+  useEffect(() => {
+    setToken(new URLSearchParams(window.location.search).get("token") || "");
+  }, []);
+
+  async function handleForgot(event: FormEvent<HTMLFormElement>) {
+    event.preventDefault();
+
+    const formData = new FormData(event.currentTarget);
+    const email = String(formData.get("email") || "").trim();
+    if (!email) {
+      setStatus({ type: "error", message: "Informe seu email." });
+      return;
+    }
+
+    setIsSubmitting(true);
+    setStatus({ type: "idle", message: "" });
+
+    try {
+      const response = await fetch(`${API_BASE_URL}/api/auth/password/forgot`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ email }),
+      });
+      const payload = (await response.json().catch(() => ({}))) as { error?: string };
+      if (!response.ok) {
+        setStatus({ type: "error", message: payload.error || "Nao foi possivel enviar o email." });
+        return;
+      }
+      setStatus({ type: "success", message: "Se o email estiver cadastrado, voce recebera um link em instantes." });
+    } catch {
+      setStatus({ type: "error", message: "Erro de conexao com o servidor." });
+    } finally {
+      setIsSubmitting(false);
+    }
+  }
+
+  async function handleReset(event: FormEvent<HTMLFormElement>) {
+    event.preventDefault();
+
+    const formData = new FormData(event.currentTarget);
+    const password = String(formData.get("password") || "");
+    const confirmPassword = String(formData.get("confirmPassword") || "");
+
+    if (password !== confirmPassword) {
+      setStatus({ type: "error", message: "As senhas nao conferem." });
+      return;
+    }
+
+    if (password.length < 6) {
+      setStatus({ type: "error", message: "A senha precisa ter pelo menos 6 caracteres." });
+      return;
+    }
+
+    setIsSubmitting(true);
+    setStatus({ type: "idle", message: "" });
+
+    try {
+      const response = await fetch(`${API_BASE_URL}/api/auth/password/reset`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ token, password }),
+      });
+      const payload = (await response.json().catch(() => ({}))) as { error?: string };
+      if (!response.ok) {
+        setStatus({ type: "error", message: payload.error || "Nao foi possivel redefinir a senha." });
+        return;
+      }
+
+      localStorage.removeItem("tracksm_auth");
+      window.dispatchEvent(new Event("tracksm-auth-updated"));
+      setStatus({ type: "success", message: "Senha redefinida. Agora voce pode entrar." });
+      setTimeout(() => {
+        router.push("/login");
+      }, 900);
+    } catch {
+      setStatus({ type: "error", message: "Erro de conexao com o servidor." });
+    } finally {
+      setIsSubmitting(false);
+    }
+  }
+
+  return (
+    <main className="login-page">
+      <section className="login-card" aria-label="Redefinicao de senha">
+        <p className="login-kicker">Senha</p>
+        <h1 className="login-title">{token ? "Escolha uma nova senha" : "Esqueceu a senha?"}</h1>
+        <p className="login-subtitle">
+          {token ? "Defina a nova senha da sua conta." : "Enviaremos um link de redefinicao para o seu email."}
+        </p>
+
+        {token ? (
+          <form className="login-form" onSubmit={handleReset}>
+            <label className="login-label" htmlFor="password">
+              Nova senha
+            </label>
+            <input id="password" name="password" type="password" placeholder="Sua nova senha" autoComplete="new-password" required />
+
+            <label className="login-label" htmlFor="confirmPassword">
+              Confirmar senha
+            </label>
+            <input
+              id="confirmPassword"
+              name="confirmPassword"
+              type="password"
+              placeholder="Repita a nova senha"
+              autoComplete="new-password"
+              required
+            />
+
+            <button type="submit" className="login-submit" disabled={isSubmitting}>
+              {isSubmitting ? "Salvando..." : "Redefinir senha"}
+            </button>
+          </form>
+        ) : (
+          <form className="login-form" onSubmit={handleForgot}>
+            <label className="login-label" htmlFor="email">
+              Email
+            </label>
+            <input id="email" name="email" type="email" placeholder="voce@email.com" autoComplete="email" required />
+
+            <button type="submit" className="login-submit" disabled={isSubmitting}>
+              {isSubmitting ? "Enviando..." : "Enviar link"}
+            </button>
+          </form>
+        )}
+
+        {status.type !== "idle" ? (
+          <p className={`auth-feedback ${status.type === "success" ? "is-success" : "is-error"}`}>{status.message}</p>
+        ) : null}
+
+        <p className="login-footer">
+          Lembrou a senha?{" "}
+          <Link href="/login" className="login-footer-link">
+            Entrar
+          </Link>
+        </p>
+      </section>
+    </main>
+  );
+}
@@ -0,0 +1,71 @@
+"use client";
+
+import Link from "next/link";
+import { useEffect, useState } from "react";
+import { getApiBaseUrl } from "../lib/api-base-url";
+
+type StatusState = {
+  type: "idle" | "success" | "error";
+  message: string;
+};
+
+const API_BASE_URL = getApiBaseUrl();
+
+export default function VerificarEmailPage() {
+  const [status, setStatus] = useState<StatusState>({ type: "idle", message: "Confirmando seu email..." });
+
+  useEffect(() => {
+    const token = new URLSearchParams(window.location.search).get("token") || "";
+    if (!token) {
+      setStatus({ type: "error", message: "Link de confirmacao invalido." });
+      return;
+    }
+
+    async function verify() {
+      try {
+        const response = await fetch(`${API_BASE_URL}/api/auth/email/verify`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ token }),
+        });
+        const payload = (await response.json().catch(() => ({}))) as { email?: string; error?: string };
+        if (!response.ok) {
+          setStatus({ type: "error", message: payload.error || "Nao foi possivel confirmar o email." });
+          return;
+        }
+
+        const raw = localStorage.getItem("tracksm_auth");
+        if (raw) {
+          const parsed = JSON.parse(raw) as Record<string, unknown>;
+          localStorage.setItem(
+            "tracksm_auth",
+            JSON.stringify({ ...parsed, email: payload.email || parsed.email, emailVerified: true }),
+          );
+          window.dispatchEvent(new Event("tracksm-auth-updated"));
+        }
+        setStatus({ type: "success", message: "Email confirmado com sucesso." });
+      } catch {
+        setStatus({ type: "error", message: "Erro de conexao com o servidor." });
+      }
+    }
+
+    void verify();
+  }, []);
+
+  return (
+    <main className="login-page">
+      <section className="login-card" aria-label="Confirmacao de email">
+        <p className="login-kicker">Email</p>
+        <h1 className="login-title">Confirmacao de email</h1>
+
+        <p className={`auth-feedback ${status.type === "error" ? "is-error" : "is-success"}`}>{status.message}</p>
+
+        <p className="login-footer">
+          <Link href="/" className="login-footer-link">
+            Voltar para o inicio
+          </Link>
+        </p>
+      </section>
+    </main>
+  );
+}